@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"raft/config"
+)
+
+func initAuth(t *testing.T, secret string) {
+	t.Helper()
+	if err := Init(config.AuthConfig{TokenSecret: secret, TokenTTLMinutes: 60, BcryptCost: 4}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	initAuth(t, "secret")
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == "correct horse" || !strings.HasPrefix(hash, "$2") {
+		t.Fatalf("password stored as %q", hash)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Fatal("the password does not match its hash")
+	}
+	if CheckPassword(hash, "battery staple") {
+		t.Fatal("another password matches the hash")
+	}
+	// passwords stored in clear are rehashed by the leader, until then they do not match
+	if CheckPassword("legacy", "legacy") {
+		t.Fatal("a password stored in clear matches")
+	}
+}
+
+func TestInitRequiresTokenSecret(t *testing.T) {
+	err := Init(config.AuthConfig{TokenTTLMinutes: 60, BcryptCost: 4})
+	if !errors.Is(err, ErrNoTokenSecret) {
+		t.Fatalf("init without a token secret: %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	initAuth(t, "secret")
+	token, expires, err := IssueToken(RoleAdmin, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expires); until < 59*time.Minute || until > time.Hour {
+		t.Fatalf("token expires in %v, want an hour", until)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Role != RoleAdmin || claims.ID != 7 {
+		t.Fatalf("claims %+v, want admin 7", claims)
+	}
+
+	encoded, signature, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"role":"admin","id":1,"exp":9999999999}`))
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no signature", encoded},
+		{"signature changed", encoded + "." + strings.ToUpper(signature)},
+		{"claims changed", forged + "." + signature},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		if _, err := ParseToken(tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: %v, want an invalid token", tt.name, err)
+		}
+	}
+
+	// another cluster signs with another key
+	initAuth(t, "other secret")
+	if _, err := ParseToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token of another key: %v, want an invalid token", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	initAuth(t, "secret")
+	tokenTTL = -time.Second
+	token, _, err := IssueToken(RoleUser, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseToken(token); !errors.Is(err, ErrExpiredToken) {
+		t.Fatalf("expired token: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import "github.com/gin-gonic/gin"
+
+const (
+	claimsKey  = "auth_claims"
+	checkedKey = "auth_checked_"
+)
+
+// SetClaims attaches the claims of a verified token to the request
+func SetClaims(c *gin.Context, claims *Claims) {
+	c.Set(claimsKey, claims)
+}
+
+// ClaimsOf returns the claims of the request, false for anonymous callers
+func ClaimsOf(c *gin.Context) (*Claims, bool) {
+	value, ok := c.Get(claimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
+
+// UserID returns the id of the signed in user
+func UserID(c *gin.Context) (int, bool) {
+	return idOf(c, RoleUser)
+}
+
+// AdminID returns the id of the signed in admin, read only admins sign in as auditors
+func AdminID(c *gin.Context) (int, bool) {
+	if id, ok := idOf(c, RoleAuditor); ok {
+		return id, true
+	}
+	return idOf(c, RoleAdmin)
+}
+
+func idOf(c *gin.Context, role Role) (int, bool) {
+	claims, ok := ClaimsOf(c)
+	if !ok || claims.Role != role {
+		return 0, false
+	}
+	return claims.ID, true
+}
+
+// SetCheckedID records the id of field the caller was found to own
+func SetCheckedID(c *gin.Context, field string, id int) {
+	c.Set(checkedKey+field, id)
+}
+
+// CheckedID returns the id of field the caller was found to own, false when ownership was not checked
+func CheckedID(c *gin.Context, field string) (int, bool) {
+	value, ok := c.Get(checkedKey + field)
+	if !ok {
+		return 0, false
+	}
+	id, ok := value.(int)
+	return id, ok
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"raft/api_server/auth"
 	"raft/state"
 	sm "raft/state/stateMachine"
 	"raft/utils"
@@ -12,12 +13,34 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// callerAdmin returns the admin signed in on the request, anonymous requests are answered with 401
+func callerAdmin(c *gin.Context) (int, bool) {
+	adminID, ok := auth.AdminID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "admin authentication required"})
+	}
+	return adminID, ok
+}
+
+// callerAdminFor returns the admin signed in on the request if its role allows action. The role is
+// checked again when the operation is applied, this only spares proposals that are bound to fail
+func callerAdminFor(c *gin.Context, action utils.AdminAction) (int, bool) {
+	adminID, ok := callerAdmin(c)
+	if !ok {
+		return 0, false
+	}
+	admin, err := sm.GetAdminInfo(adminID)
+	if err != nil || !sm.AdminAllowed(admin.Role, action) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "your admin role does not allow this action"})
+		return 0, false
+	}
+	return adminID, true
+}
+
 // READS
 func GetAdminInfo(c *gin.Context) {
-	aid := c.Query("admin_id")
-	adminID, err := strconv.Atoi(aid)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"message": "invalid admin ID"})
+	adminID, ok := callerAdmin(c)
+	if !ok {
 		return
 	}
 	admin, err := sm.GetAdminInfo(adminID)
@@ -39,20 +62,32 @@ func GetAllUsers(c *gin.Context) {
 
 }
 
+// AdminSignin checks the credentials of an admin and returns a session token
 func AdminSignin(c *gin.Context) {
-	email := c.Query("email")
-	password := c.Query("password")
-
-	admin, err := sm.GetAdminByEmail(email)
-	if err != nil {
-		c.JSON(http.StatusNotAcceptable, gin.H{"message": err})
+	var req struct {
+		Email    string `json:"email" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
-	if admin.HashedPassword != password {
-		c.JSON(http.StatusNotAcceptable, gin.H{"message": "invalid credentials"})
+	admin, err := sm.GetAdminByEmail(req.Email)
+	if err != nil || !auth.CheckPassword(admin.HashedPassword, req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid credentials"})
 		return
 	}
-	c.JSON(http.StatusAccepted, gin.H{"admin": admin})
+	// read only admins get the auditor role of the api
+	role := auth.RoleAdmin
+	if admin.Role == utils.RoleReadOnly {
+		role = auth.RoleAuditor
+	}
+	token, expiresAt, err := auth.IssueToken(role, admin.AdminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"admin": admin, "token": token, "expires_at": expiresAt})
 }
 
 // CountActiveUsers returns the total number of validated (active) users
@@ -120,7 +155,82 @@ func GetRecentTransactions(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"recent_transactions": operations})
 }
 
+// SumFeesForMonth returns the total fees collected into the treasury for a given month (YYYY-MM)
+func SumFeesForMonth(c *gin.Context) {
+	month := c.Query("month") // expected format: YYYY-MM
+	start, end, err := ParseMonth(month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month format"})
+		return
+	}
+
+	sum, err := sm.SumFeesBetween(start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sum fees"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total_fees": sum})
+}
+
+// GetTreasury returns the fee collection wallet, its balance is zero until the first fee is charged
+func GetTreasury(c *gin.Context) {
+	treasury, err := sm.GetTreasuryWallet()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"treasury": nil, "balance": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"treasury": treasury, "balance": treasury.Balance})
+}
+
+// GetFeeSchedules returns the fee schedules currently in force
+func GetFeeSchedules(c *gin.Context) {
+	schedules, err := sm.GetFeeSchedules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fee schedules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"fee_schedules": schedules})
+}
+
+// GetSpendingLimits returns the spending limits currently in force
+func GetSpendingLimits(c *gin.Context) {
+	limits, err := sm.GetSpendingLimits()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch spending limits"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"spending_limits": limits})
+}
+
 // parseMonth parses "YYYY-MM" into start and end time.Time objects
+// GetAdminAudit returns the latest admin operations, optionally those of a single admin
+func GetAdminAudit(c *gin.Context) {
+	adminID := 0
+	if aid := c.Query("admin_id"); aid != "" {
+		id, err := strconv.Atoi(aid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid admin ID"})
+			return
+		}
+		adminID = id
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+	audit, err := sm.GetAdminAudit(adminID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"audit": audit})
+}
+
 func ParseMonth(month string) (time.Time, time.Time, error) {
 	start, err := time.Parse("2006-01", month)
 	if err != nil {
@@ -131,27 +241,47 @@ func ParseMonth(month string) (time.Time, time.Time, error) {
 }
 
 // MODIFICATIONS
+// AdminSignup lets a super admin create another admin, read only unless a role is given
 func AdminSignup(c *gin.Context) {
+	adminID, ok := callerAdminFor(c, utils.AdminCreateAccount)
+	if !ok {
+		return
+	}
 	type AdminSignupPayload struct {
-		FirstName      string `json:"first_name" binding:"required"`
-		LastName       string `json:"last_name" binding:"required"`
-		HashedPassword string `json:"hashed_password" binding:"required"`
-		Email          string `json:"email" binding:"required"`
-		PollID         string `json:"poll_id" binding:"required"`
+		FirstName string `json:"first_name" binding:"required"`
+		LastName  string `json:"last_name" binding:"required"`
+		Password  string `json:"password" binding:"required"`
+		Email     string `json:"email" binding:"required"`
+		Role      string `json:"role"`
+		PollID    string `json:"poll_id" binding:"required"`
 	}
 	var req AdminSignupPayload
-	if err := c.ShouldBind(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err})
 		return
 	}
+	role := utils.AdminRole(req.Role)
+	if role == "" {
+		role = utils.RoleReadOnly
+	}
+	if !sm.ValidAdminRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown admin role"})
+		return
+	}
 	ct, err := state.GetCurrentTermFromAPI()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 		return
 	}
+	// only the hash is replicated
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	payload := utils.AdminPayload{
-		FirstName: req.FirstName, LastName: req.LastName, HashedPassword: req.HashedPassword, Email: req.Email,
-		AdminID: -1, UserId: -1, Action: utils.AdminCreateAccount, PollID: req.PollID, Term: ct,
+		FirstName: req.FirstName, LastName: req.LastName, HashedPassword: hashedPassword, Email: req.Email,
+		AdminID: adminID, UserId: -1, Role: role, Action: utils.AdminCreateAccount, PollID: req.PollID, Term: ct,
 	}
 	err = utils.AppendRedisPayload(payload)
 	if err != nil {
@@ -161,14 +291,253 @@ func AdminSignup(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "operation pending"})
 }
 
+// SetAdminRole lets a super admin change the role of another admin
+func SetAdminRole(c *gin.Context) {
+	adminID, ok := callerAdminFor(c, utils.AdminSetRole)
+	if !ok {
+		return
+	}
+	type AdminRolePayload struct {
+		TargetAdminID int    `json:"target_admin_id" binding:"required"`
+		Role          string `json:"role" binding:"required"`
+		PollID        string `json:"poll_id" binding:"required"`
+	}
+	var req AdminRolePayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	if !sm.ValidAdminRole(utils.AdminRole(req.Role)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown admin role"})
+		return
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.AdminPayload{
+		FirstName: "", LastName: "", HashedPassword: "", Email: "", Term: ct,
+		AdminID: adminID, UserId: -1, TargetAdminID: req.TargetAdminID, Role: utils.AdminRole(req.Role),
+		Action: utils.AdminSetRole, PollID: req.PollID,
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
+
 func ValidateUser(c *gin.Context) {
+	adminID, ok := callerAdminFor(c, utils.AdminValidateUser)
+	if !ok {
+		return
+	}
 	type AdminValidationPayload struct {
-		AdminId int    `json:"admin_id" binding:"required"`
-		UserID  int    `json:"user_id" binding:"required"`
-		PollID  string `json:"poll_id" binding:"required"`
+		UserID int    `json:"user_id" binding:"required"`
+		PollID string `json:"poll_id" binding:"required"`
 	}
 	var req AdminValidationPayload
-	if err := c.ShouldBind(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.AdminPayload{
+		FirstName: "", LastName: "", HashedPassword: "", Email: "", Term: ct,
+		AdminID: adminID, UserId: req.UserID, Action: utils.AdminValidateUser, PollID: req.PollID,
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
+
+func SetFeeSchedule(c *gin.Context) {
+	adminID, ok := callerAdminFor(c, utils.AdminSetFeeSchedule)
+	if !ok {
+		return
+	}
+	type FeeSchedulePayload struct {
+		Action      string          `json:"action" binding:"required"`
+		Kind        string          `json:"kind" binding:"required"`
+		Flat        int64           `json:"flat"`
+		BasisPoints int64           `json:"basis_points"`
+		Tiers       []utils.FeeTier `json:"tiers"`
+		PollID      string          `json:"poll_id" binding:"required"`
+	}
+	var req FeeSchedulePayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	action, kind := utils.WalletAction(req.Action), utils.FeeKind(req.Kind)
+	if err := sm.ValidateFeeSchedule(action, kind, req.Flat, req.BasisPoints, req.Tiers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.AdminPayload{
+		FirstName: "", LastName: "", HashedPassword: "", Email: "", Term: ct,
+		AdminID: adminID, UserId: -1, Action: utils.AdminSetFeeSchedule, PollID: req.PollID,
+		FeeAction: action, FeeKind: kind, FeeFlat: req.Flat, FeeBasisPoints: req.BasisPoints, FeeTiers: req.Tiers,
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
+
+func SetSpendingLimit(c *gin.Context) {
+	adminID, ok := callerAdminFor(c, utils.AdminSetSpendingLimit)
+	if !ok {
+		return
+	}
+	type SpendingLimitPayload struct {
+		Scope          string `json:"scope" binding:"required"`
+		Tier           string `json:"tier"`
+		UserID         int    `json:"user_id"`
+		WalletID       int    `json:"wallet_id"`
+		PerTransaction int64  `json:"per_transaction"`
+		Daily          int64  `json:"daily"`
+		Monthly        int64  `json:"monthly"`
+		PollID         string `json:"poll_id" binding:"required"`
+	}
+	var req SpendingLimitPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	scope, tier := utils.LimitScope(req.Scope), utils.UserTier(req.Tier)
+	if err := sm.ValidateSpendingLimit(scope, tier, req.UserID, req.WalletID, req.PerTransaction, req.Daily, req.Monthly); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.AdminPayload{
+		FirstName: "", LastName: "", HashedPassword: "", Email: "", Term: ct,
+		AdminID: adminID, UserId: req.UserID, WalletID: req.WalletID, Action: utils.AdminSetSpendingLimit, PollID: req.PollID,
+		LimitScope: scope, Tier: tier, LimitPerTransaction: req.PerTransaction, LimitDaily: req.Daily, LimitMonthly: req.Monthly,
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
+
+func SetUserTier(c *gin.Context) {
+	adminID, ok := callerAdminFor(c, utils.AdminSetUserTier)
+	if !ok {
+		return
+	}
+	type UserTierPayload struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Tier   string `json:"tier" binding:"required"`
+		PollID string `json:"poll_id" binding:"required"`
+	}
+	var req UserTierPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	if !sm.ValidTier(utils.UserTier(req.Tier)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown user tier"})
+		return
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.AdminPayload{
+		FirstName: "", LastName: "", HashedPassword: "", Email: "", Term: ct,
+		AdminID: adminID, UserId: req.UserID, Tier: utils.UserTier(req.Tier), Action: utils.AdminSetUserTier, PollID: req.PollID,
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
+
+func FreezeUser(c *gin.Context) {
+	setUserFrozen(c, utils.AdminFreezeUser)
+}
+
+func UnfreezeUser(c *gin.Context) {
+	setUserFrozen(c, utils.AdminUnfreezeUser)
+}
+
+func FreezeWallet(c *gin.Context) {
+	setWalletFrozen(c, utils.AdminFreezeWallet)
+}
+
+func UnfreezeWallet(c *gin.Context) {
+	setWalletFrozen(c, utils.AdminUnfreezeWallet)
+}
+
+func setUserFrozen(c *gin.Context, action utils.AdminAction) {
+	adminID, ok := callerAdminFor(c, action)
+	if !ok {
+		return
+	}
+	type UserStatusPayload struct {
+		UserID int    `json:"user_id" binding:"required"`
+		PollID string `json:"poll_id" binding:"required"`
+	}
+	var req UserStatusPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.AdminPayload{
+		FirstName: "", LastName: "", HashedPassword: "", Email: "", Term: ct,
+		AdminID: adminID, UserId: req.UserID, Action: action, PollID: req.PollID,
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
+
+func setWalletFrozen(c *gin.Context, action utils.AdminAction) {
+	adminID, ok := callerAdminFor(c, action)
+	if !ok {
+		return
+	}
+	type WalletStatusPayload struct {
+		WalletID int    `json:"wallet_id" binding:"required"`
+		PollID   string `json:"poll_id" binding:"required"`
+	}
+	var req WalletStatusPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 		return
 	}
@@ -179,7 +548,7 @@ func ValidateUser(c *gin.Context) {
 	}
 	payload := utils.AdminPayload{
 		FirstName: "", LastName: "", HashedPassword: "", Email: "", Term: ct,
-		AdminID: req.AdminId, UserId: req.UserID, Action: utils.AdminValidateUser, PollID: req.PollID,
+		AdminID: adminID, UserId: -1, WalletID: req.WalletID, Action: action, PollID: req.PollID,
 	}
 	err = utils.AppendRedisPayload(payload)
 	if err != nil {
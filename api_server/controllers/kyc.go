@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"raft/blobstore"
+	"raft/state"
+	sm "raft/state/stateMachine"
+	"raft/utils"
+)
+
+// MaxDocumentSize bounds the size of an uploaded identification document
+const MaxDocumentSize = 5 << 20
+
+// content types accepted for identification documents
+var documentTypes = map[string]bool{"image/jpeg": true, "image/png": true, "application/pdf": true}
+
+// UploadDocument stores an identification document on the leader and returns its hash, the hash is what
+// the signup request carries in id_image_front and id_image_back
+func UploadDocument(node *state.Node) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// leaves room for the multipart envelope around the file
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxDocumentSize+1<<20)
+		header, err := c.FormFile("file")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || (err == nil && header.Size > MaxDocumentSize) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("documents are limited to %d bytes", MaxDocumentSize)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a document in the file field"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, MaxDocumentSize+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(data) > MaxDocumentSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("documents are limited to %d bytes", MaxDocumentSize)})
+			return
+		}
+		contentType := http.DetectContentType(data)
+		if !documentTypes[contentType] {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "documents must be jpeg, png or pdf files"})
+			return
+		}
+		hash, err := node.Blobs.Put(data)
+		if err != nil {
+			fmt.Println("failed to store document:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store document"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"hash": hash, "content_type": contentType, "size": len(data)})
+	}
+}
+
+// GetPendingKYC lists the users waiting for their documents to be reviewed
+func GetPendingKYC(c *gin.Context) {
+	users, err := sm.GetUsersByStatus(utils.AccountPendingKYC)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// GetUserDocument serves one of the identification documents of a user to a KYC admin, the document is
+// fetched from a peer if this node does not hold it yet
+func GetUserDocument(node *state.Node) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := callerAdminFor(c, utils.AdminValidateUser); !ok {
+			return
+		}
+		userID, err := strconv.Atoi(c.Query("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		user, err := sm.GetUserByID(userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		var hash string
+		switch c.Query("side") {
+		case "front":
+			hash = user.IdentificationImageFront
+		case "back":
+			hash = user.IdentificationImageBack
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "side must be front or back"})
+			return
+		}
+		if !blobstore.ValidHash(hash) {
+			// accounts created before documents were uploaded only hold a reference
+			c.JSON(http.StatusNotFound, gin.H{"error": "no document stored for this user", "reference": hash})
+			return
+		}
+		if err := node.EnsureBlob(hash); err != nil {
+			if errors.Is(err, blobstore.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "document not available on any node"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := node.Blobs.Get(hash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, http.DetectContentType(data), data)
+	}
+}
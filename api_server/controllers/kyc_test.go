@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"raft/blobstore"
+	"raft/state"
+)
+
+// upload posts content as the file field of a form, or an empty form when field is empty
+func upload(r *gin.Engine, field string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if field != "" {
+		part, _ := form.CreateFormFile(field, "document")
+		part.Write(content)
+	}
+	form.Close()
+	req := httptest.NewRequest("POST", "/api/user/kyc/document", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUploadDocument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	blobs, err := blobstore.Open(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/api/user/kyc/document", UploadDocument(&state.Node{Blobs: blobs}))
+
+	pdf := []byte("%PDF-1.4 passport")
+	w := upload(r, "file", pdf)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload of a pdf: status %d %s", w.Code, w.Body.String())
+	}
+	var res struct {
+		Hash        string `json:"hash"`
+		ContentType string `json:"content_type"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Hash != blobstore.Hash(pdf) || res.ContentType != "application/pdf" || !blobs.Has(res.Hash) {
+		t.Fatalf("stored %+v", res)
+	}
+
+	tests := []struct {
+		name    string
+		field   string
+		content []byte
+		want    int
+	}{
+		{"no document", "", nil, http.StatusBadRequest},
+		{"another field", "image", pdf, http.StatusBadRequest},
+		{"text file", "file", []byte("name,passport\n"), http.StatusUnsupportedMediaType},
+		{"too large", "file", append([]byte("%PDF-1.4 "), make([]byte, MaxDocumentSize)...), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := upload(r, tt.field, tt.content); w.Code != tt.want {
+				t.Fatalf("status %d %s, want %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,19 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"raft/api_server/auth"
+	"raft/blobstore"
 	"raft/state"
 	sm "raft/state/stateMachine"
 	"raft/utils"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // READS
@@ -16,14 +21,41 @@ func Pong(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Pong"})
 }
 
+// GetLogEntry returns the entry proposed with poll_id, failed entries carry the code and reason of the failure
 func GetLogEntry(c *gin.Context) {
 	poll_id := c.Query("poll_id")
 	logEntry, err := state.GetLogEntryForApi(poll_id)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "no log entry for this poll id"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"Entry": logEntry})
+	response := gin.H{"Entry": logEntry}
+	if logEntry.Status == utils.TxFailed {
+		response["error"] = gin.H{
+			"code":        logEntry.ErrorCode,
+			"message":     logEntry.ErrorMessage,
+			"description": utils.ErrorCatalog[logEntry.ErrorCode],
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetErrorCatalog lists the error codes a failed operation can report
+func GetErrorCatalog(c *gin.Context) {
+	codes := make([]string, 0, len(utils.ErrorCatalog))
+	for code := range utils.ErrorCatalog {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	catalog := make([]gin.H, 0, len(codes))
+	for _, code := range codes {
+		catalog = append(catalog, gin.H{"code": code, "description": utils.ErrorCatalog[utils.ErrorCode(code)]})
+	}
+	c.JSON(http.StatusOK, gin.H{"errors": catalog})
 }
 
 func GetWalletsCount(c *gin.Context) {
@@ -119,11 +151,18 @@ func GetTransactionsSum(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"sum": sum})
 }
 
+// callerUser returns the user signed in on the request, anonymous requests are answered with 401
+func callerUser(c *gin.Context) (int, bool) {
+	userID, ok := auth.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "user authentication required"})
+	}
+	return userID, ok
+}
+
 func GetUserInfo(c *gin.Context) {
-	uid := c.Query("user_id")
-	userID, err := strconv.Atoi(uid)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid user ID"})
+	userID, ok := callerUser(c)
+	if !ok {
 		return
 	}
 	user, err := sm.GetUserByID(userID)
@@ -134,55 +173,136 @@ func GetUserInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
+// UserSignin checks the credentials of a user and returns a session token
 func UserSignin(c *gin.Context) {
-	email := c.Query("email")
-	password := c.Query("password")
-
-	user, err := sm.GetUserByEmail(email)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"message": err})
+	var req struct {
+		Email    string `json:"email" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
-	if user.HashedPassword != password {
-		c.JSON(http.StatusNotFound, gin.H{"message": "invalid user credentials"})
+	user, err := sm.GetUserByEmail(req.Email)
+	if err != nil || !auth.CheckPassword(user.HashedPassword, req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid user credentials"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	token, expiresAt, err := auth.IssueToken(auth.RoleUser, user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user, "token": token, "expires_at": expiresAt})
 }
 
 // WRITES,DELETES AND UPDATES
-func UserSignup(c *gin.Context) {
-	type UserSignupRequest struct {
-		FirstName                string    `json:"first_name" binding:"required"`
-		LastName                 string    `json:"last_name" binding:"required"`
-		HashedPassword           string    `json:"password" binding:"required"`
-		Email                    string    `json:"email" binding:"required"`
-		DateOfBirth              time.Time `json:"dob" binding:"required"`
-		IdentificationNumber     string    `json:"id_number" binding:"required"`
-		IdentificationImageFront string    `json:"id_image_front" binding:"required"`
-		IdentificationImageBack  string    `json:"id_image_back" binding:"required"`
-		PollID                   string    `json:"poll_id" binding:"required"`
-	}
-	var req UserSignupRequest
-	if err := c.ShouldBind(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+// UserSignup proposes a new account. The identification documents must have been uploaded first, the
+// request carries their hashes
+func UserSignup(node *state.Node) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		type UserSignupRequest struct {
+			FirstName                string    `json:"first_name" binding:"required"`
+			LastName                 string    `json:"last_name" binding:"required"`
+			Password                 string    `json:"password" binding:"required"`
+			Email                    string    `json:"email" binding:"required"`
+			DateOfBirth              time.Time `json:"dob" binding:"required"`
+			IdentificationNumber     string    `json:"id_number" binding:"required"`
+			IdentificationImageFront string    `json:"id_image_front" binding:"required"`
+			IdentificationImageBack  string    `json:"id_image_back" binding:"required"`
+			PollID                   string    `json:"poll_id" binding:"required"`
+		}
+		var req UserSignupRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err})
+			return
+		}
+		for _, hash := range []string{req.IdentificationImageFront, req.IdentificationImageBack} {
+			if !blobstore.ValidHash(hash) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "id_image_front and id_image_back must be hashes of uploaded documents"})
+				return
+			}
+			// the document may have been uploaded to a previous leader
+			if err := node.EnsureBlob(hash); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown document " + hash})
+				return
+			}
+		}
+		// get the term
+		ct, err := state.GetCurrentTermFromAPI()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err})
+			return
+		}
+		// only the hash is replicated
+		hashedPassword, err := auth.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// Marshall
+		payload := utils.UserPayload{
+			FirstName: req.FirstName, LastName: req.LastName, HashedPassword: hashedPassword, Email: req.Email,
+			DateOfBirth:          req.DateOfBirth,
+			IdentificationNumber: req.IdentificationNumber, IdentificationImageFront: req.IdentificationImageFront,
+			IdentificationImageBack: req.IdentificationImageBack, PrevPW: "", NewPW: "",
+			UserID: -1, Action: utils.UserCreateAccount, PollID: req.PollID, Term: ct,
+		}
+		//add payload to redis queue
+		err = utils.AppendRedisPayload(payload)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+	}
+}
+
+// UpdatePassword checks the old password on the leader and replicates the new hash. The current hash
+// travels with the proposal so the update is rejected if the password changed in the meantime
+func UpdatePassword(c *gin.Context) {
+	userID, ok := callerUser(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		OldPassword string `json:"old_password" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+		PollID      string `json:"poll_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	user, err := sm.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "user not found"})
 		return
 	}
+	if !auth.CheckPassword(user.HashedPassword, req.OldPassword) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid password supplied"})
+		return
+	}
+	newHash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// get the term
 	ct, err := state.GetCurrentTermFromAPI()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 		return
 	}
+
 	// Marshall
 	payload := utils.UserPayload{
-		FirstName: req.FirstName, LastName: req.LastName, HashedPassword: req.HashedPassword, Email: req.Email,
-		DateOfBirth:          req.DateOfBirth,
-		IdentificationNumber: req.IdentificationNumber, IdentificationImageFront: req.IdentificationImageFront,
-		IdentificationImageBack: req.IdentificationImageBack, PrevPW: "", NewPW: "",
-		UserID: -1, Action: utils.UserCreateAccount, PollID: req.PollID, Term: ct,
+		FirstName: "", LastName: "", HashedPassword: "", Email: "", DateOfBirth: time.Now(),
+		IdentificationNumber: "", IdentificationImageFront: "", IdentificationImageBack: "", Term: ct,
+		PrevPW: user.HashedPassword, NewPW: newHash, UserID: userID, Action: utils.UserUpdatePassword, PollID: req.PollID,
 	}
-	//add payload to redis queue
 	err = utils.AppendRedisPayload(payload)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
@@ -191,31 +311,31 @@ func UserSignup(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
 }
 
-func UpdatePassword(c *gin.Context) {
+// DeleteUser closes the account, remaining funds are moved to sweep_wallet_id if given
+func DeleteUser(c *gin.Context) {
+	userID, ok := callerUser(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		UserID      int    `json:"user_id" binding:"required"`
-		OldPassword string `json:"old_password" binding:"required"`
-		NewPassword string `json:"new_password" binding:"required"`
-		PollID      string `json:"poll_id" binding:"required"`
+		SweepWalletID int    `json:"sweep_wallet_id"`
+		PollID        string `json:"poll_id" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
-
 	// get the term
 	ct, err := state.GetCurrentTermFromAPI()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 		return
 	}
-
-	// Marshall
 	payload := utils.UserPayload{
 		FirstName: "", LastName: "", HashedPassword: "", Email: "", DateOfBirth: time.Now(),
 		IdentificationNumber: "", IdentificationImageFront: "", IdentificationImageBack: "", Term: ct,
-		PrevPW: req.OldPassword, NewPW: req.NewPassword, UserID: req.UserID, Action: utils.UserUpdatePassword, PollID: req.PollID,
+		PrevPW: "", NewPW: "", UserID: userID, SweepWalletID: req.SweepWalletID, Action: utils.UserDeleteAccount, PollID: req.PollID,
 	}
 	err = utils.AppendRedisPayload(payload)
 	if err != nil {
@@ -225,29 +345,31 @@ func UpdatePassword(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
 }
 
-func DeleteUser(c *gin.Context) {
+// EraseUser closes the account of the caller if needed and erases its personal data. Balances left on the
+// account are swept to sweep_wallet_id, the wallets and their operations are kept under a pseudonym
+func EraseUser(c *gin.Context) {
+	userID, ok := callerUser(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		UserID int    `json:"user_id" binding:"required"`
-		PollID string `json:"poll_id" binding:"required"`
+		SweepWalletID int    `json:"sweep_wallet_id"`
+		PollID        string `json:"poll_id" binding:"required"`
 	}
-
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
-	// get the term
 	ct, err := state.GetCurrentTermFromAPI()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 		return
 	}
 	payload := utils.UserPayload{
-		FirstName: "", LastName: "", HashedPassword: "", Email: "", DateOfBirth: time.Now(),
-		IdentificationNumber: "", IdentificationImageFront: "", IdentificationImageBack: "", Term: ct,
-		PrevPW: "", NewPW: "", UserID: req.UserID, Action: utils.UserDeleteAccount, PollID: req.PollID,
+		Term: ct, UserID: userID, SweepWalletID: req.SweepWalletID,
+		Action: utils.UserEraseAccount, PollID: req.PollID,
 	}
-	err = utils.AppendRedisPayload(payload)
-	if err != nil {
+	if err := utils.AppendRedisPayload(payload); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 		return
 	}
@@ -255,8 +377,11 @@ func DeleteUser(c *gin.Context) {
 }
 
 func CreateWallet(c *gin.Context) {
+	userID, ok := callerUser(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		UserID int    `json:"user_id" binding:"required"`
 		PollID string `json:"poll_id" binding:"required"`
 	}
 
@@ -274,7 +399,7 @@ func CreateWallet(c *gin.Context) {
 	payload := utils.UserPayload{
 		FirstName: "", LastName: "", HashedPassword: "", Email: "", DateOfBirth: time.Now(),
 		IdentificationNumber: "", IdentificationImageFront: "", IdentificationImageBack: "", Term: ct,
-		PrevPW: "", NewPW: "", UserID: req.UserID, Action: utils.UserCreateWallet, PollID: req.PollID,
+		PrevPW: "", NewPW: "", UserID: userID, Action: utils.UserCreateWallet, PollID: req.PollID,
 	}
 	err = utils.AppendRedisPayload(payload)
 	if err != nil {
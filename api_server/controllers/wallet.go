@@ -2,10 +2,12 @@ package controllers
 
 import (
 	"net/http"
+	"raft/api_server/auth"
 	"raft/state"
 	sm "raft/state/stateMachine"
 	"raft/utils"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -50,7 +52,53 @@ func GetWalletsByUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"wallets": wallets})
 }
 
+func GetSchedules(c *gin.Context) {
+	wid := c.Query("wallet_id")
+	walletID, err := strconv.Atoi(wid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid wallet ID"})
+		return
+	}
+	schedules, err := sm.GetSchedulesByWallet(walletID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func GetScheduleExecutions(c *gin.Context) {
+	sid := c.Query("schedule_id")
+	scheduleID, err := strconv.Atoi(sid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid schedule ID"})
+		return
+	}
+	schedule, err := sm.GetSchedule(scheduleID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "schedule not found"})
+		return
+	}
+	executions, err := sm.GetScheduleExecutions(scheduleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedule": schedule, "executions": executions})
+}
+
 // MODIFICATIONS
+
+// matchesChecked refuses the request when the id bound from its body is not the one the authorization
+// found the caller owns
+func matchesChecked(c *gin.Context, field string, id int) bool {
+	if checked, ok := auth.CheckedID(c, field); ok && checked != id {
+		c.JSON(http.StatusForbidden, gin.H{"error": field + " does not belong to the caller"})
+		return false
+	}
+	return true
+}
+
 func Transfer(c *gin.Context) {
 	type transferData struct {
 		Sender_wallet_id   int    `json:"sender_wallet_id" binding:"required"`
@@ -61,10 +109,13 @@ func Transfer(c *gin.Context) {
 
 	var req transferData
 
-	if err := c.ShouldBind(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !matchesChecked(c, "sender_wallet_id", req.Sender_wallet_id) {
+		return
+	}
 	ct, err := state.GetCurrentTermFromAPI()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
@@ -93,10 +144,13 @@ func Withdraw(c *gin.Context) {
 		PollID    string `json:"poll_id" binding:"required"`
 	}
 	var req withdrawData
-	if err := c.ShouldBind(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !matchesChecked(c, "sender_wallet_id", req.Wallet_id) {
+		return
+	}
 	ct, err := state.GetCurrentTermFromAPI()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
@@ -125,10 +179,13 @@ func Deposit(c *gin.Context) {
 		PollID    string `json:"poll_id" binding:"required"`
 	}
 	var req depositData
-	if err := c.ShouldBind(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !matchesChecked(c, "sender_wallet_id", req.Wallet_id) {
+		return
+	}
 	ct, err := state.GetCurrentTermFromAPI()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
@@ -149,3 +206,111 @@ func Deposit(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
 }
+
+// CreateSchedule registers a standing order, the first transfer happens at start_at (now by default)
+func CreateSchedule(c *gin.Context) {
+	type scheduleData struct {
+		Sender_wallet_id    int                    `json:"sender_wallet_id" binding:"required"`
+		Receiver_wallet_id  int                    `json:"receiver_wallet_id" binding:"required"`
+		Amount              int64                  `json:"amount" binding:"required"`
+		Interval            utils.ScheduleInterval `json:"interval" binding:"required"`
+		StartAt             *time.Time             `json:"start_at"`
+		MaxRetries          int                    `json:"max_retries"`
+		Retry_delay_seconds int64                  `json:"retry_delay_seconds"`
+		PollID              string                 `json:"poll_id" binding:"required"`
+	}
+	var req scheduleData
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !matchesChecked(c, "sender_wallet_id", req.Sender_wallet_id) {
+		return
+	}
+	startAt := time.Now()
+	if req.StartAt != nil {
+		startAt = *req.StartAt
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.WalletOperationPayload{
+		Wallet1:           req.Sender_wallet_id,
+		Wallet2:           req.Receiver_wallet_id,
+		Amount:            req.Amount,
+		Interval:          req.Interval,
+		StartAt:           startAt,
+		MaxRetries:        req.MaxRetries,
+		RetryDelaySeconds: req.Retry_delay_seconds,
+		PollID:            req.PollID,
+		Action:            utils.WalletScheduleCreate,
+		Term:              ct,
+	}
+	if err := sm.ValidateSchedule(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
+
+func PauseSchedule(c *gin.Context) {
+	updateSchedule(c, utils.WalletSchedulePause)
+}
+
+func ResumeSchedule(c *gin.Context) {
+	updateSchedule(c, utils.WalletScheduleResume)
+}
+
+func CancelSchedule(c *gin.Context) {
+	updateSchedule(c, utils.WalletScheduleCancel)
+}
+
+func updateSchedule(c *gin.Context, action utils.WalletAction) {
+	type scheduleStatusData struct {
+		ScheduleID int    `json:"schedule_id" binding:"required"`
+		PollID     string `json:"poll_id" binding:"required"`
+	}
+	var req scheduleStatusData
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !matchesChecked(c, "schedule_id", req.ScheduleID) {
+		return
+	}
+	schedule, err := sm.GetSchedule(req.ScheduleID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "schedule not found"})
+		return
+	}
+	if schedule.Status == utils.ScheduleCancelled {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "schedule is cancelled"})
+		return
+	}
+	ct, err := state.GetCurrentTermFromAPI()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		return
+	}
+	payload := utils.WalletOperationPayload{
+		Wallet1:    schedule.Wallet1,
+		Wallet2:    schedule.Wallet2,
+		ScheduleID: schedule.ID,
+		PollID:     req.PollID,
+		Action:     action,
+		Term:       ct,
+	}
+	err = utils.AppendRedisPayload(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "operation pending"})
+}
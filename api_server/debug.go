@@ -0,0 +1,97 @@
+package api_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"raft/state"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DebugServer serves the fault injection endpoints of a node. It has no authentication and must only
+// listen on localhost, in staging
+type DebugServer struct {
+	Router *gin.Engine
+	server *http.Server
+}
+
+func NewDebugServer(faults *state.Faults) *DebugServer {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	f := r.Group("/debug/faults")
+	{
+		f.GET("", func(c *gin.Context) {
+			c.JSON(http.StatusOK, faults.Status())
+		})
+		// removes every fault
+		f.DELETE("", func(c *gin.Context) {
+			faults.Clear()
+			c.JSON(http.StatusOK, faults.Status())
+		})
+		f.PUT("/link", func(c *gin.Context) {
+			var lf state.LinkFault
+			if err := c.ShouldBindJSON(&lf); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := faults.SetLink(lf); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, faults.Status())
+		})
+		// ?peer= names the link, none for the fault on every peer
+		f.DELETE("/link", func(c *gin.Context) {
+			faults.ClearLink(c.Query("peer"))
+			c.JSON(http.StatusOK, faults.Status())
+		})
+		f.PUT("/disk", func(c *gin.Context) {
+			var d state.DiskFault
+			if err := c.ShouldBindJSON(&d); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := faults.SetDisk(d); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, faults.Status())
+		})
+		f.POST("/apply/pause", func(c *gin.Context) {
+			faults.PauseApply()
+			c.JSON(http.StatusOK, faults.Status())
+		})
+		f.POST("/apply/resume", func(c *gin.Context) {
+			faults.ResumeApply()
+			c.JSON(http.StatusOK, faults.Status())
+		})
+	}
+	return &DebugServer{Router: r}
+}
+
+// Run listens on add and serves the endpoints in the background
+func (s *DebugServer) Run(add string) error {
+	listener, err := net.Listen("tcp", add)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", add, err)
+	}
+	s.server = &http.Server{Handler: s.Router, ReadHeaderTimeout: seconds(5)}
+	go func() {
+		err := s.server.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("debug server on %s stopped: %v\n", add, err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for the ones in flight until ctx is done
+func (s *DebugServer) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
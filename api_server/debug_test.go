@@ -0,0 +1,57 @@
+package api_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"raft/state"
+)
+
+func TestDebugServer(t *testing.T) {
+	ps, err := state.InitPersistentState(filepath.Join(t.TempDir(), "log.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	faults, err := (&state.Node{State: ps}).EnableFaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewDebugServer(faults).Router
+	const jsonType = "application/json"
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"PUT", "/debug/faults/link", `{"peer":"7001","drop_rate":1}`, http.StatusOK},
+		{"PUT", "/debug/faults/link", `{"peer":"7002","direction":"out","delay_ms":200}`, http.StatusOK},
+		{"PUT", "/debug/faults/link", `{"drop_rate":2}`, http.StatusBadRequest},
+		{"PUT", "/debug/faults/disk", `{"writes":true,"rate":0.5}`, http.StatusOK},
+		{"PUT", "/debug/faults/disk", `{"rate":"often"}`, http.StatusBadRequest},
+		{"POST", "/debug/faults/apply/pause", "", http.StatusOK},
+		{"DELETE", "/debug/faults/link?peer=7001", "", http.StatusOK},
+	}
+	for _, s := range steps {
+		if w := serve(r, s.method, s.path, jsonType, s.body); w.Code != s.want {
+			t.Fatalf("%s %s %s: status %d %s, want %d", s.method, s.path, s.body, w.Code, w.Body.String(), s.want)
+		}
+	}
+	w := serve(r, "GET", "/debug/faults", "", "")
+	var status state.FaultStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Links) != 1 || status.Links[0].Peer != "7002" || status.Links[0].Direction != state.Outbound ||
+		!status.ApplyPaused || status.Disk.Rate != 0.5 {
+		t.Fatalf("faults in place %+v", status)
+	}
+	w = serve(r, "DELETE", "/debug/faults", "", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Links) != 0 || status.ApplyPaused || status.Disk.Rate != 0 {
+		t.Fatalf("faults left after clearing them all %+v", status)
+	}
+}
@@ -1,9 +1,15 @@
 package api_server
 
 import (
+	"fmt"
 	"net/http"
+	"raft/api_server/auth"
+	"raft/config"
 	"raft/state"
+	"strings"
+	"time"
 
+	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,7 +18,7 @@ func LeaderOnly(node *state.Node) gin.HandlerFunc {
 		if c.Request.Method != http.MethodGet {
 			node.Mu.RLock()
 			defer node.Mu.RUnlock()
-			isLeader := node.Status == "leader"
+			isLeader := node.Status == state.Leader
 
 			if !isLeader {
 				c.JSON(http.StatusPermanentRedirect, gin.H{
@@ -26,3 +32,62 @@ func LeaderOnly(node *state.Node) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Authenticate verifies the bearer token sent with a request, requests without one stay anonymous
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.Next()
+			return
+		}
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "expected a bearer token"})
+			return
+		}
+		claims, err := auth.ParseToken(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		auth.SetClaims(c, claims)
+		c.Next()
+	}
+}
+
+// routes enforcing a body limit of their own
+var ownBodyLimit = map[string]bool{"/api/user/kyc/document": true}
+
+// MaxBodySize refuses request bodies larger than limit, announced ones up front and streamed ones once
+// the handler reads past the limit
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ownBodyLimit[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request bodies are limited to %d bytes", limit)})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// CORS allows the configured origins to call the api from a browser, nil when no origin is allowed
+func CORS(cfg config.HTTPConfig) gin.HandlerFunc {
+	if len(cfg.AllowedOrigins) == 0 {
+		return nil
+	}
+	return cors.New(cors.Config{
+		AllowOrigins:     cfg.AllowedOrigins,
+		AllowWildcard:    true,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After"},
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           12 * time.Hour,
+	})
+}
@@ -0,0 +1,132 @@
+package api_server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"raft/api_server/auth"
+	"raft/config"
+)
+
+func TestAuthenticate(t *testing.T) {
+	if err := auth.Init(config.AuthConfig{TokenSecret: "secret", TokenTTLMinutes: 60, BcryptCost: 4}); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := auth.IssueToken(auth.RoleUser, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(Authenticate())
+	r.GET("/whoami", func(c *gin.Context) {
+		if claims, ok := auth.ClaimsOf(c); ok {
+			c.String(http.StatusOK, "%s %d", claims.Role, claims.ID)
+			return
+		}
+		c.String(http.StatusOK, "anonymous")
+	})
+	tests := []struct {
+		name   string
+		header string
+		want   int
+		body   string
+	}{
+		{"no token", "", http.StatusOK, "anonymous"},
+		{"bearer token", "Bearer " + token, http.StatusOK, "user 3"},
+		{"another scheme", "Basic " + token, http.StatusUnauthorized, ""},
+		{"forged token", "Bearer " + token + "x", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/whoami", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want || (tt.body != "" && w.Body.String() != tt.body) {
+				t.Fatalf("status %d %q, want %d %q", w.Code, w.Body.String(), tt.want, tt.body)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	if CORS(config.HTTPConfig{}) != nil {
+		t.Fatal("cross origin requests allowed without any origin configured")
+	}
+	r := gin.New()
+	r.Use(CORS(config.HTTPConfig{AllowedOrigins: []string{"https://wallet.test.invalid"}, AllowCredentials: true}))
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	tests := []struct {
+		name, method, origin string
+		want                 int
+		allowed              string
+	}{
+		{"same origin", "GET", "", http.StatusOK, ""},
+		{"allowed origin", "GET", "https://wallet.test.invalid", http.StatusOK, "https://wallet.test.invalid"},
+		{"preflight", "OPTIONS", "https://wallet.test.invalid", http.StatusNoContent, "https://wallet.test.invalid"},
+		{"other origin", "GET", "https://evil.test.invalid", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/ping", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want || w.Header().Get("Access-Control-Allow-Origin") != tt.allowed {
+				t.Fatalf("status %d allowing %q, want %d allowing %q", w.Code,
+					w.Header().Get("Access-Control-Allow-Origin"), tt.want, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	r := gin.New()
+	r.Use(MaxBodySize(16))
+	read := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	r.POST("/api/wallet/deposit", read)
+	r.POST("/api/user/kyc/document", read)
+	large := strings.Repeat("x", 64)
+	tests := []struct {
+		name, path, body string
+		// the length is not announced, the body is streamed
+		streamed bool
+		want     int
+	}{
+		{"small body", "/api/wallet/deposit", "{}", false, http.StatusOK},
+		{"announced large body", "/api/wallet/deposit", large, false, http.StatusRequestEntityTooLarge},
+		{"streamed large body", "/api/wallet/deposit", large, true, http.StatusRequestEntityTooLarge},
+		{"document upload", "/api/user/kyc/document", large, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			if tt.streamed {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
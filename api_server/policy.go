@@ -0,0 +1,319 @@
+package api_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"raft/api_server/auth"
+	sm "raft/state/stateMachine"
+)
+
+// resource kinds a user can own
+type resource int
+
+const (
+	ownUser resource = iota
+	ownWallet
+	ownSchedule
+)
+
+// where a request carries the id of the resource it acts on
+type source int
+
+const (
+	fromQuery source = iota
+	fromBody
+)
+
+// ownership names a request field that must point to a resource of the calling user
+type ownership struct {
+	kind  resource
+	from  source
+	field string
+}
+
+// policy lists the roles allowed on a route. Users are further restricted to the resources they own,
+// admins and auditors are not. A policy without roles is public
+type policy struct {
+	roles []auth.Role
+	owns  []ownership
+}
+
+var (
+	public      = policy{}
+	userOnly    = policy{roles: []auth.Role{auth.RoleUser}}
+	adminOnly   = policy{roles: []auth.Role{auth.RoleAdmin}}
+	staffReader = policy{roles: []auth.Role{auth.RoleAdmin, auth.RoleAuditor}}
+)
+
+// ownedBy allows users on their own resources on top of admins and auditors
+func ownedBy(owns ...ownership) policy {
+	return policy{roles: []auth.Role{auth.RoleUser, auth.RoleAdmin, auth.RoleAuditor}, owns: owns}
+}
+
+// userOwning only allows users, on their own resources
+func userOwning(owns ...ownership) policy {
+	return policy{roles: []auth.Role{auth.RoleUser}, owns: owns}
+}
+
+// policies holds the rule of every route, keyed by method and route path. Routes missing from the table are denied
+var policies = map[string]policy{
+	"GET /ping":   public,
+	"GET /log":    public,
+	"GET /errors": public,
+
+	"GET /api/user/":              userOnly,
+	"POST /api/user/sign-in":      public,
+	"GET /api/user/transactions":  ownedBy(ownership{ownUser, fromQuery, "id"}),
+	"POST /api/user/signup":       public,
+	"POST /api/user/kyc/document": public,
+	"PATCH /api/user/":            userOnly,
+	"DELETE /api/user/":           userOnly,
+	"POST /api/user/erase":        userOnly,
+
+	"GET /api/user/stats/wallets":            ownedBy(ownership{ownUser, fromQuery, "id"}),
+	"GET /api/user/stats/cumulative/balance": ownedBy(ownership{ownUser, fromQuery, "id"}),
+	"GET /api/user/stats/transactions/count": ownedBy(ownership{ownUser, fromQuery, "id"}),
+	"GET /api/user/stats/transaction/sum":    ownedBy(ownership{ownUser, fromQuery, "id"}),
+
+	"POST /api/admin/signin":           public,
+	"POST /api/admin/signup":           adminOnly,
+	"POST /api/admin/role":             adminOnly,
+	"GET /api/admin/audit":             staffReader,
+	"GET /api/admin/":                  staffReader,
+	"GET /api/admin/users":             staffReader,
+	"POST /api/admin/validate/user":    adminOnly,
+	"GET /api/admin/kyc/pending":       staffReader,
+	"GET /api/admin/kyc/document":      adminOnly,
+	"GET /api/admin/fees":              staffReader,
+	"POST /api/admin/fees":             adminOnly,
+	"GET /api/admin/limits":            staffReader,
+	"POST /api/admin/limits":           adminOnly,
+	"POST /api/admin/users/tier":       adminOnly,
+	"POST /api/admin/users/freeze":     adminOnly,
+	"POST /api/admin/users/unfreeze":   adminOnly,
+	"POST /api/admin/wallets/freeze":   adminOnly,
+	"POST /api/admin/wallets/unfreeze": adminOnly,
+
+	"GET /api/admin/stats/active-users":        staffReader,
+	"GET /api/admin/stats/count/transactions/": staffReader,
+	"GET /api/admin/stats/sum/transactions/":   staffReader,
+	"GET /api/admin/stats/wallets/count":       staffReader,
+	"GET /api/admin/stats/transactions/recent": staffReader,
+	"GET /api/admin/stats/sum/fees/":           staffReader,
+	"GET /api/admin/stats/treasury":            staffReader,
+
+	"GET /api/wallet/":                    ownedBy(ownership{ownWallet, fromQuery, "wallet_id"}),
+	"GET /api/wallet/user":                ownedBy(ownership{ownUser, fromQuery, "user_id"}),
+	"GET /api/wallet/all":                 staffReader,
+	"POST /api/wallet/create":             userOnly,
+	"POST /api/wallet/transfer":           userOwning(ownership{ownWallet, fromBody, "sender_wallet_id"}),
+	"POST /api/wallet/deposit":            userOwning(ownership{ownWallet, fromBody, "sender_wallet_id"}),
+	"POST /api/wallet/withdraw":           userOwning(ownership{ownWallet, fromBody, "sender_wallet_id"}),
+	"GET /api/wallet/schedules":           ownedBy(ownership{ownWallet, fromQuery, "wallet_id"}),
+	"GET /api/wallet/schedule/executions": ownedBy(ownership{ownSchedule, fromQuery, "schedule_id"}),
+	"POST /api/wallet/schedule":           userOwning(ownership{ownWallet, fromBody, "sender_wallet_id"}),
+	"POST /api/wallet/schedule/pause":     userOwning(ownership{ownSchedule, fromBody, "schedule_id"}),
+	"POST /api/wallet/schedule/resume":    userOwning(ownership{ownSchedule, fromBody, "schedule_id"}),
+	"DELETE /api/wallet/schedule":         userOwning(ownership{ownSchedule, fromBody, "schedule_id"}),
+}
+
+// Authorize enforces the policy of the matched route, it runs after Authenticate
+func Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.FullPath() == "" {
+			// no route matched, let gin answer 404
+			c.Next()
+			return
+		}
+		p, ok := policies[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		if len(p.roles) == 0 {
+			c.Next()
+			return
+		}
+		claims, ok := auth.ClaimsOf(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		if !hasRole(p.roles, claims.Role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		if claims.Role == auth.RoleUser {
+			// the handlers bind the body as json, an id checked in any other encoding could differ
+			if p.readsBody() && c.ContentType() != gin.MIMEJSON {
+				c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "request body must be json"})
+				return
+			}
+			for _, o := range p.owns {
+				if err := checkOwnership(c, o, claims.ID); err != nil {
+					c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// readsBody tells whether an ownership of p is read from the body
+func (p policy) readsBody() bool {
+	for _, o := range p.owns {
+		if o.from == fromBody {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRole(roles []auth.Role, role auth.Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOwnership verifies that the resource named by the request belongs to userID
+func checkOwnership(c *gin.Context, o ownership, userID int) error {
+	id, err := requestID(c, o)
+	if err != nil {
+		return err
+	}
+	// the handler refuses to act on another id than the one checked here
+	auth.SetCheckedID(c, o.field, id)
+	switch o.kind {
+	case ownUser:
+		if id != userID {
+			return fmt.Errorf("%s does not belong to the caller", o.field)
+		}
+	case ownWallet:
+		wallet, err := sm.GetWallet(id)
+		if err != nil || wallet.UserID != userID || wallet.IsTreasury {
+			return fmt.Errorf("wallet %d does not belong to the caller", id)
+		}
+	case ownSchedule:
+		schedule, err := sm.GetSchedule(id)
+		if err != nil {
+			return fmt.Errorf("schedule %d does not belong to the caller", id)
+		}
+		wallet, err := sm.GetWallet(schedule.Wallet1)
+		if err != nil || wallet.UserID != userID {
+			return fmt.Errorf("schedule %d does not belong to the caller", id)
+		}
+	}
+	return nil
+}
+
+// requestID reads the id named by o from the query or the json body, the body is restored for the handler
+func requestID(c *gin.Context, o ownership) (int, error) {
+	if o.from == fromQuery {
+		id, err := strconv.Atoi(c.Query(o.field))
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s", o.field)
+		}
+		return id, nil
+	}
+	data, err := readBody(c)
+	if err != nil {
+		return 0, err
+	}
+	return bodyID(data, o.field)
+}
+
+// errMissingField is returned by bodyField for a body without the field in any case
+var errMissingField = errors.New("missing field")
+
+// bodyID reads the integer at field of a json object
+func bodyID(data []byte, field string) (int, error) {
+	var id int
+	if err := bodyField(data, field, &id); err != nil {
+		return 0, fmt.Errorf("invalid %s", field)
+	}
+	return id, nil
+}
+
+// bodyField decodes the value at field of a json object into v. The handlers bind the body into structs,
+// whose fields also match keys of another case: a body naming field twice, in any case, is refused so the
+// value read is the one bound
+func bodyField(data []byte, field string, v interface{}) error {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return fmt.Errorf("invalid request body")
+	}
+	keys, err := objectKeys(data)
+	if err != nil {
+		return fmt.Errorf("invalid request body")
+	}
+	matches := 0
+	for _, key := range keys {
+		if strings.EqualFold(key, field) {
+			if key != field {
+				return fmt.Errorf("invalid %s", field)
+			}
+			matches++
+		}
+	}
+	if matches == 0 {
+		return errMissingField
+	}
+	if matches > 1 {
+		return fmt.Errorf("invalid %s", field)
+	}
+	if err := json.Unmarshal(body[field], v); err != nil {
+		return fmt.Errorf("invalid %s", field)
+	}
+	return nil
+}
+
+// objectKeys lists the keys of a json object in order, repeated keys included
+func objectKeys(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("not an object")
+		}
+		keys = append(keys, key)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// readBody reads the body of a request for the middlewares, the body is restored for the handler
+func readBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, fmt.Errorf("missing request body")
+	}
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request body")
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
@@ -0,0 +1,175 @@
+package api_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"raft/api_server/auth"
+	"raft/config"
+	"raft/state"
+	sm "raft/state/stateMachine"
+	"raft/state/stateMachine/models"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestPoliciesCoverEveryRoute(t *testing.T) {
+	r := gin.New()
+	SetupRoutes(r, &state.Node{}, config.Default())
+	for _, route := range r.Routes() {
+		if _, ok := policies[route.Method+" "+route.Path]; !ok {
+			t.Errorf("%s %s has no policy and is denied", route.Method, route.Path)
+		}
+	}
+}
+
+// openWallets creates a state machine where user 1 owns wallet 1, user 2 owns wallet 99 and wallet 5
+// is the treasury
+func openWallets(t *testing.T) {
+	t.Helper()
+	machine, err := sm.InitStateMachine(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { machine.Close() })
+	for _, u := range []models.User{{UserID: 1, Email: "one@test.invalid"}, {UserID: 2, Email: "two@test.invalid"}} {
+		if err := machine.DB.Create(&u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	wallets := []models.Wallet{{WalletID: 1, UserID: 1}, {WalletID: 99, UserID: 2}, {WalletID: 5, IsTreasury: true}}
+	for _, w := range wallets {
+		if err := machine.DB.Create(&w).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// authorized is an engine running Authorize as the router does, the caller signed in with claims.
+// Its handlers bind the body as the wallet controllers do and answer with the wallet they bound
+func authorized(claims *auth.Claims) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if claims != nil {
+			auth.SetClaims(c, claims)
+		}
+	})
+	r.Use(Authorize())
+	bound := func(c *gin.Context) {
+		var req struct {
+			Sender int `json:"sender_wallet_id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if checked, ok := auth.CheckedID(c, "sender_wallet_id"); ok && checked != req.Sender {
+			c.JSON(http.StatusForbidden, gin.H{"error": "mismatch"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"wallet": req.Sender})
+	}
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.POST("/api/wallet/transfer", bound)
+	r.GET("/api/wallet/", ok)
+	r.GET("/api/wallet/all", ok)
+	r.GET("/ping", ok)
+	r.POST("/api/admin/fees", ok)
+	r.POST("/unlisted", ok)
+	return r
+}
+
+func serve(r *gin.Engine, method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthorize(t *testing.T) {
+	openWallets(t)
+	user := &auth.Claims{Role: auth.RoleUser, ID: 1}
+	admin := &auth.Claims{Role: auth.RoleAdmin, ID: 1}
+	auditor := &auth.Claims{Role: auth.RoleAuditor, ID: 1}
+	const jsonType = "application/json"
+	tests := []struct {
+		name         string
+		claims       *auth.Claims
+		method, path string
+		contentType  string
+		body         string
+		want         int
+		wantBody     string
+	}{
+		{"public route", nil, "GET", "/ping", "", "", http.StatusOK, ""},
+		{"route missing from the table", admin, "POST", "/unlisted", "", "", http.StatusForbidden, ""},
+		{"anonymous on a protected route", nil, "GET", "/api/wallet/all", "", "", http.StatusUnauthorized, ""},
+		{"user on a staff route", user, "GET", "/api/wallet/all", "", "", http.StatusForbidden, ""},
+		{"auditor reads", auditor, "GET", "/api/wallet/all", "", "", http.StatusOK, ""},
+		{"auditor writes", auditor, "POST", "/api/admin/fees", "", "", http.StatusForbidden, ""},
+		{"admin writes", admin, "POST", "/api/admin/fees", "", "", http.StatusOK, ""},
+		{"user reads own wallet", user, "GET", "/api/wallet/?wallet_id=1", "", "", http.StatusOK, ""},
+		{"user reads another wallet", user, "GET", "/api/wallet/?wallet_id=99", "", "", http.StatusForbidden, ""},
+		{"user reads the treasury", user, "GET", "/api/wallet/?wallet_id=5", "", "", http.StatusForbidden, ""},
+		{"admin reads any wallet", admin, "GET", "/api/wallet/?wallet_id=99", "", "", http.StatusOK, ""},
+		{"user spends from own wallet", user, "POST", "/api/wallet/transfer", jsonType,
+			`{"sender_wallet_id":1}`, http.StatusOK, `{"wallet":1}`},
+		{"user spends from another wallet", user, "POST", "/api/wallet/transfer", jsonType,
+			`{"sender_wallet_id":99}`, http.StatusForbidden, ""},
+		{"key in another case", user, "POST", "/api/wallet/transfer", jsonType,
+			`{"sender_wallet_id":1,"SENDER_WALLET_ID":99}`, http.StatusForbidden, ""},
+		{"key in another case first", user, "POST", "/api/wallet/transfer", jsonType,
+			`{"Sender_Wallet_Id":99,"sender_wallet_id":1}`, http.StatusForbidden, ""},
+		{"key folded by unicode", user, "POST", "/api/wallet/transfer", jsonType,
+			"{\"sender_wallet_id\":1,\"ſender_wallet_id\":99}", http.StatusForbidden, ""},
+		{"key repeated", user, "POST", "/api/wallet/transfer", jsonType,
+			`{"sender_wallet_id":1,"sender_wallet_id":99}`, http.StatusForbidden, ""},
+		{"key missing", user, "POST", "/api/wallet/transfer", jsonType, `{"amount":1}`, http.StatusForbidden, ""},
+		{"form body", user, "POST", "/api/wallet/transfer", "application/x-www-form-urlencoded",
+			"sender_wallet_id=99", http.StatusUnsupportedMediaType, ""},
+		{"body without content type", user, "POST", "/api/wallet/transfer", "",
+			`{"sender_wallet_id":1}`, http.StatusUnsupportedMediaType, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(authorized(tt.claims), tt.method, tt.path, tt.contentType, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Fatalf("body %s, want %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestBodyID(t *testing.T) {
+	tests := []struct {
+		body   string
+		want   int
+		wantOK bool
+	}{
+		{`{"schedule_id":7,"poll_id":"p"}`, 7, true},
+		{`{"schedule_id":7.5}`, 0, false},
+		{`{"schedule_id":"7"}`, 0, false},
+		{`{"schedule_id":7,"Schedule_ID":8}`, 0, false},
+		{`[{"schedule_id":7}]`, 0, false},
+		{`{"nested":{"schedule_id":8},"schedule_id":7}`, 7, true},
+	}
+	for _, tt := range tests {
+		got, err := bodyID([]byte(tt.body), "schedule_id")
+		if (err == nil) != tt.wantOK || got != tt.want {
+			t.Errorf("bodyID(%s) = %d, %v, want %d, ok %v", tt.body, got, err, tt.want, tt.wantOK)
+		}
+	}
+}
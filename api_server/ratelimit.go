@@ -0,0 +1,165 @@
+package api_server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"raft/api_server/auth"
+	"raft/config"
+	"raft/state"
+)
+
+// buckets idle for longer than this are dropped
+const bucketIdleTimeout = 10 * time.Minute
+
+type rateGroup struct {
+	prefix string
+	rule   config.RateLimitGroup
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter holds a token bucket per route group and caller
+type rateLimiter struct {
+	groups    []rateGroup // longest prefix first
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	rl := &rateLimiter{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+	for prefix, rule := range cfg.Groups {
+		rl.groups = append(rl.groups, rateGroup{prefix: strings.TrimSuffix(prefix, "/"), rule: rule})
+	}
+	sort.Slice(rl.groups, func(i, j int) bool { return len(rl.groups[i].prefix) > len(rl.groups[j].prefix) })
+	return rl
+}
+
+// group returns the rule of the longest prefix matching path
+func (rl *rateLimiter) group(path string) (rateGroup, bool) {
+	for _, g := range rl.groups {
+		if g.prefix == "" || path == g.prefix || strings.HasPrefix(path, g.prefix+"/") {
+			return g, true
+		}
+	}
+	return rateGroup{}, false
+}
+
+// allow takes a token from the bucket of key, it returns how long to wait when the bucket is empty
+func (rl *rateLimiter) allow(key string, b config.Bucket) (bool, time.Duration) {
+	if b.Rate <= 0 {
+		return true, 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > time.Minute {
+		for k, entry := range rl.buckets {
+			if now.Sub(entry.lastSeen) > bucketIdleTimeout {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+	entry, ok := rl.buckets[key]
+	if !ok {
+		entry = &bucket{limiter: rate.NewLimiter(rate.Limit(b.Rate), b.Burst)}
+		rl.buckets[key] = entry
+	}
+	entry.lastSeen = now
+	if entry.limiter.AllowN(now, 1) {
+		return true, 0
+	}
+	return false, time.Duration(float64(time.Second) / b.Rate)
+}
+
+// RateLimit applies the token buckets of the route group to the caller's ip and, once authenticated, to the
+// caller itself. It runs after Authenticate
+func RateLimit(cfg config.RateLimitConfig) gin.HandlerFunc {
+	rl := newRateLimiter(cfg)
+	return func(c *gin.Context) {
+		g, ok := rl.group(c.Request.URL.Path)
+		if !ok {
+			c.Next()
+			return
+		}
+		allowed, wait := rl.allow(g.prefix+"|ip|"+c.ClientIP(), g.rule.PerIP)
+		if allowed {
+			if claims, ok := auth.ClaimsOf(c); ok {
+				allowed, wait = rl.allow(fmt.Sprintf("%s|%s|%d", g.prefix, claims.Role, claims.ID), g.rule.PerUser)
+			}
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// routes taking a multipart upload, they store a document and propose nothing
+var uploadRoutes = map[string]bool{"/api/user/kyc/document": true}
+
+// PendingLimit refuses a proposal while its caller has too many proposals waiting for a commit, or the
+// same one already, so a single caller cannot fill the log. Write requests carrying a poll id are
+// proposals, their body must be json so the poll id counted is the one the handler queues
+func PendingLimit(node *state.Node) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.ContentLength == 0 || uploadRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if c.ContentType() != gin.MIMEJSON {
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "request body must be json"})
+			return
+		}
+		data, err := readBody(c)
+		if err != nil {
+			// the handler reports the invalid body
+			c.Next()
+			return
+		}
+		var pollID string
+		err = bodyField(data, "poll_id", &pollID)
+		if errors.Is(err, errMissingField) {
+			c.Next()
+			return
+		}
+		if err != nil || pollID == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid poll_id"})
+			return
+		}
+		caller := "ip:" + c.ClientIP()
+		if claims, ok := auth.ClaimsOf(c); ok {
+			caller = fmt.Sprintf("%s:%d", claims.Role, claims.ID)
+		}
+		switch err := node.Pending.Acquire(caller, pollID); {
+		case errors.Is(err, state.ErrAlreadyPending):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "operation already pending, poll its status"})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many operations pending, wait for them to complete"})
+			return
+		}
+		c.Next()
+		// the proposal was refused before reaching the queue
+		if c.Writer.Status() != http.StatusOK {
+			node.Pending.ReleaseCaller(caller, pollID)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package api_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"raft/api_server/auth"
+	"raft/config"
+	"raft/state"
+)
+
+func TestPendingLimit(t *testing.T) {
+	node := &state.Node{Pending: &state.PendingProposals{}}
+	node.SetPendingLimit(1, time.Minute)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { auth.SetClaims(c, &auth.Claims{Role: auth.RoleUser, ID: 1}) })
+	r.Use(PendingLimit(node))
+	queue := func(c *gin.Context) {
+		var req struct {
+			PollID string `json:"poll_id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"poll_id": req.PollID})
+	}
+	r.POST("/api/wallet/transfer", queue)
+	r.POST("/api/user/kyc/document", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/refused", func(c *gin.Context) { c.Status(http.StatusBadRequest) })
+
+	const jsonType = "application/json"
+	steps := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"form body", "/api/wallet/transfer", "application/x-www-form-urlencoded", "poll_id=p0", http.StatusUnsupportedMediaType},
+		{"poll id in another case", "/api/wallet/transfer", jsonType, `{"poll_id":"p1","POLL_ID":"p2"}`, http.StatusBadRequest},
+		{"poll id repeated", "/api/wallet/transfer", jsonType, `{"poll_id":"p1","poll_id":"p2"}`, http.StatusBadRequest},
+		{"refused proposal frees its slot", "/refused", jsonType, `{"poll_id":"p1"}`, http.StatusBadRequest},
+		{"first proposal", "/api/wallet/transfer", jsonType, `{"poll_id":"p1"}`, http.StatusOK},
+		{"same poll id again", "/api/wallet/transfer", jsonType, `{"poll_id":"p1"}`, http.StatusConflict},
+		{"over the limit", "/api/wallet/transfer", jsonType, `{"poll_id":"p2"}`, http.StatusTooManyRequests},
+		{"upload", "/api/user/kyc/document", "multipart/form-data; boundary=x", "--x--", http.StatusOK},
+	}
+	for _, step := range steps {
+		w := serve(r, "POST", step.path, step.contentType, step.body)
+		if w.Code != step.want {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.want, w.Body.String())
+		}
+	}
+	node.Pending.Release("p1")
+	if w := serve(r, "POST", "/api/wallet/transfer", jsonType, `{"poll_id":"p2"}`); w.Code != http.StatusOK {
+		t.Fatalf("after the entry was applied: status %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// slow refills, so no token comes back during the test
+	cfg := config.RateLimitConfig{Groups: map[string]config.RateLimitGroup{
+		"":            {PerIP: config.Bucket{Rate: 0.001, Burst: 5}},
+		"/api/wallet": {PerIP: config.Bucket{Rate: 0.001, Burst: 3}, PerUser: config.Bucket{Rate: 0.001, Burst: 1}},
+	}}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if id, err := strconv.Atoi(c.GetHeader("X-User")); err == nil {
+			auth.SetClaims(c, &auth.Claims{Role: auth.RoleUser, ID: id})
+		}
+	})
+	r.Use(RateLimit(cfg))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/api/wallet/", ok)
+	r.GET("/api/walletsx", ok)
+	r.GET("/ping", ok)
+	get := func(path, ip, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	steps := []struct {
+		name, path, ip, user string
+		want                 int
+	}{
+		{"first call of user 1", "/api/wallet/", "10.0.0.1", "1", http.StatusOK},
+		{"user 1 again", "/api/wallet/", "10.0.0.2", "1", http.StatusTooManyRequests},
+		{"user 2 from the same ip", "/api/wallet/", "10.0.0.1", "2", http.StatusOK},
+		{"anonymous from the same ip", "/api/wallet/", "10.0.0.1", "", http.StatusOK},
+		// the three calls from 10.0.0.1 spent its bucket of the wallet routes
+		{"ip bucket spent", "/api/wallet/", "10.0.0.1", "3", http.StatusTooManyRequests},
+		{"another route group", "/ping", "10.0.0.1", "", http.StatusOK},
+		{"a prefix of the name only", "/api/walletsx", "10.0.0.1", "", http.StatusOK},
+	}
+	for _, step := range steps {
+		w := get(step.path, step.ip, step.user)
+		if w.Code != step.want {
+			t.Fatalf("%s: status %d, want %d", step.name, w.Code, step.want)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1000" {
+			t.Fatalf("%s: retry after %q", step.name, w.Header().Get("Retry-After"))
+		}
+	}
+}
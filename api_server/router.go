@@ -1,36 +1,38 @@
 package api_server
 
 import (
+	"fmt"
 	"raft/api_server/controllers"
+	"raft/config"
 	"raft/state"
-	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, node *state.Node) {
-
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"}, // or "*" in development
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+func SetupRoutes(r *gin.Engine, node *state.Node, cfg *config.Config) {
 
+	if corsHandler := CORS(cfg.HTTP); corsHandler != nil {
+		r.Use(corsHandler)
+	}
+	r.Use(MaxBodySize(cfg.HTTP.MaxBodyBytes))
 	r.Use(LeaderOnly(node))
+	r.Use(Authenticate())
+	r.Use(RateLimit(cfg.RateLimit))
+	r.Use(Authorize())
+	r.Use(PendingLimit(node))
 	r.GET("/ping", controllers.Pong)
 	r.GET("/log", controllers.GetLogEntry)
+	r.GET("/errors", controllers.GetErrorCatalog)
 	user := r.Group("/api/user")
 	{
 		user.GET("/", controllers.GetUserInfo)
-		user.GET("/sign-in", controllers.UserSignin)
+		user.POST("/sign-in", controllers.UserSignin)
 		user.GET("/transactions", controllers.GetUserTransactions)
-		user.POST("/signup", controllers.UserSignup)
+		user.POST("/signup", controllers.UserSignup(node))
+		user.POST("/kyc/document", controllers.UploadDocument(node))
 		user.PATCH("/", controllers.UpdatePassword)
 		user.DELETE("/", controllers.DeleteUser)
+		user.POST("/erase", controllers.EraseUser)
 	}
 
 	user_stats := r.Group("/api/user/stats")
@@ -43,11 +45,24 @@ func SetupRoutes(r *gin.Engine, node *state.Node) {
 
 	admin := r.Group("/api/admin")
 	{
+		admin.POST("/signin", controllers.AdminSignin)
+		admin.POST("/signup", controllers.AdminSignup)
+		admin.POST("/role", controllers.SetAdminRole)
+		admin.GET("/audit", controllers.GetAdminAudit)
 		admin.GET("/", controllers.GetAdminInfo)
 		admin.GET("/users", controllers.GetAllUsers)
-		admin.GET("/signin", controllers.AdminSignin)
-		admin.POST("/signup", controllers.AdminSignup)
 		admin.POST("/validate/user", controllers.ValidateUser)
+		admin.GET("/kyc/pending", controllers.GetPendingKYC)
+		admin.GET("/kyc/document", controllers.GetUserDocument(node))
+		admin.GET("/fees", controllers.GetFeeSchedules)
+		admin.POST("/fees", controllers.SetFeeSchedule)
+		admin.GET("/limits", controllers.GetSpendingLimits)
+		admin.POST("/limits", controllers.SetSpendingLimit)
+		admin.POST("/users/tier", controllers.SetUserTier)
+		admin.POST("/users/freeze", controllers.FreezeUser)
+		admin.POST("/users/unfreeze", controllers.UnfreezeUser)
+		admin.POST("/wallets/freeze", controllers.FreezeWallet)
+		admin.POST("/wallets/unfreeze", controllers.UnfreezeWallet)
 	}
 
 	stats := r.Group("/api/admin/stats")
@@ -57,6 +72,8 @@ func SetupRoutes(r *gin.Engine, node *state.Node) {
 		stats.GET("/sum/transactions/", controllers.SumTransactionsForMonth)
 		stats.GET("/wallets/count", controllers.CountWallets)
 		stats.GET("/transactions/recent", controllers.GetRecentTransactions)
+		stats.GET("/sum/fees/", controllers.SumFeesForMonth)
+		stats.GET("/treasury", controllers.GetTreasury)
 	}
 
 	wallet := r.Group("/api/wallet")
@@ -68,6 +85,18 @@ func SetupRoutes(r *gin.Engine, node *state.Node) {
 		wallet.POST("/transfer", controllers.Transfer)
 		wallet.POST("/deposit", controllers.Deposit)
 		wallet.POST("/withdraw", controllers.Withdraw)
+		wallet.GET("/schedules", controllers.GetSchedules)
+		wallet.GET("/schedule/executions", controllers.GetScheduleExecutions)
+		wallet.POST("/schedule", controllers.CreateSchedule)
+		wallet.POST("/schedule/pause", controllers.PauseSchedule)
+		wallet.POST("/schedule/resume", controllers.ResumeSchedule)
+		wallet.DELETE("/schedule", controllers.CancelSchedule)
+	}
+
+	for _, route := range r.Routes() {
+		if _, ok := policies[route.Method+" "+route.Path]; !ok {
+			fmt.Printf("no authorization policy for %s %s, every call will be denied\n", route.Method, route.Path)
+		}
 	}
 
 }
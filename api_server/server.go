@@ -1,7 +1,15 @@
 package api_server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"raft/config"
 	"raft/state"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,20 +17,60 @@ import (
 type APIServer struct {
 	Router *gin.Engine
 	Node   *state.Node
+	cfg    config.HTTPConfig
+	server *http.Server
 }
 
-func NewApiServer(n *state.Node) *APIServer {
+func NewApiServer(n *state.Node, cfg *config.Config) *APIServer {
 	r := gin.Default()
-	s := &APIServer{Router: r, Node: n}
-	SetupRoutes(r, n)
+	// without trusted proxies the client ip is the address of the connection
+	if err := r.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		panic(fmt.Errorf("invalid http.trusted_proxies: %w", err))
+	}
+	s := &APIServer{Router: r, Node: n, cfg: cfg.HTTP}
+	SetupRoutes(r, n, cfg)
 	return s
 }
 
+// Run listens on add and serves the api in the background, over tls when a certificate is configured
 func (s *APIServer) Run(add string) error {
+	listener, err := net.Listen("tcp", add)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", add, err)
+	}
+	s.server = &http.Server{
+		Handler:           s.Router,
+		ReadHeaderTimeout: seconds(s.cfg.ReadTimeoutSeconds),
+		ReadTimeout:       seconds(s.cfg.ReadTimeoutSeconds),
+		WriteTimeout:      seconds(s.cfg.WriteTimeoutSeconds),
+		IdleTimeout:       seconds(s.cfg.IdleTimeoutSeconds),
+	}
+	if s.cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+		s.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		listener = tls.NewListener(listener, s.server.TLSConfig)
+	}
 	go func() {
-		if err := s.Router.Run(add); err != nil {
-			panic(err)
+		err := s.server.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("api server on %s stopped: %v\n", add, err)
 		}
 	}()
 	return nil
 }
+
+// Shutdown stops accepting requests and waits for the ones in flight until ctx is done
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
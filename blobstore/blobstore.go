@@ -0,0 +1,150 @@
+// content addressed storage of the documents uploaded by users, local to each node. Only the sha256 of a
+// document goes through the log, nodes missing a document fetch it from their peers
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"raft/pii"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Store struct {
+	dir string
+}
+
+// Open creates the store directory if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create blob store %s: %w", dir, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Hash returns the address of content
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidHash reports whether hash is a blob address, references stored before the store existed are not
+func ValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+// Has reports whether the blob is stored on this node
+func (s *Store) Has(hash string) bool {
+	if !ValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+// Put stores data, sealed with the pii keys, and returns its address. Storing the same content twice is a no-op
+func (s *Store) Put(data []byte) (string, error) {
+	hash := Hash(data)
+	if s.Has(hash) {
+		return hash, nil
+	}
+	sealed, err := pii.SealBytes(data)
+	if err != nil {
+		return "", err
+	}
+	return hash, s.write(hash, sealed)
+}
+
+// Get returns the content of a blob after checking it against its address
+func (s *Store) Get(hash string) ([]byte, error) {
+	raw, err := s.GetRaw(hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := pii.OpenBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s: %w", hash, err)
+	}
+	if Hash(data) != hash {
+		return nil, fmt.Errorf("blob %s is corrupted", hash)
+	}
+	return data, nil
+}
+
+// GetRaw returns a blob as stored, sealed, it is what peers exchange
+func (s *Store) GetRaw(hash string) ([]byte, error) {
+	if !ValidHash(hash) {
+		return nil, fmt.Errorf("invalid blob address %q", hash)
+	}
+	raw, err := os.ReadFile(s.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	return raw, nil
+}
+
+// PutRaw stores a blob received from a peer once its content matches hash
+func (s *Store) PutRaw(hash string, raw []byte) error {
+	if !ValidHash(hash) {
+		return fmt.Errorf("invalid blob address %q", hash)
+	}
+	data, err := pii.OpenBytes(raw)
+	if err != nil {
+		return fmt.Errorf("failed to open blob %s: %w", hash, err)
+	}
+	if Hash(data) != hash {
+		return fmt.Errorf("blob does not match address %s", hash)
+	}
+	return s.write(hash, raw)
+}
+
+// Delete removes a blob from this node, removing a missing blob is not an error
+func (s *Store) Delete(hash string) error {
+	if !ValidHash(hash) {
+		return fmt.Errorf("invalid blob address %q", hash)
+	}
+	if err := os.Remove(s.path(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+// write goes through a temporary file so a crash never leaves a truncated blob behind
+func (s *Store) write(hash string, raw []byte) error {
+	tmp, err := os.CreateTemp(s.dir, hash+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", hash, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store blob %s: %w", hash, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store blob %s: %w", hash, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", hash, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(hash)); err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", hash, err)
+	}
+	return nil
+}
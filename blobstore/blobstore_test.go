@@ -0,0 +1,122 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"raft/config"
+	"raft/pii"
+)
+
+func open(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPutAndGet(t *testing.T) {
+	s := open(t)
+	document := []byte("%PDF passport")
+	hash, err := s.Put(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != Hash(document) || !ValidHash(hash) || !s.Has(hash) {
+		t.Fatalf("stored at %s", hash)
+	}
+	if again, err := s.Put(document); err != nil || again != hash {
+		t.Fatalf("stored again at %s, %v", again, err)
+	}
+	got, err := s.Get(hash)
+	if err != nil || !bytes.Equal(got, document) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	if _, err := s.Get(Hash([]byte("other"))); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("read of a missing blob: %v", err)
+	}
+	if err := s.Delete(hash); err != nil || s.Has(hash) {
+		t.Fatalf("blob still there after delete, %v", err)
+	}
+	if err := s.Delete(hash); err != nil {
+		t.Fatalf("delete of a missing blob: %v", err)
+	}
+}
+
+func TestSealedAtRest(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	if err := pii.Init(config.PIIConfig{ActiveKey: "k1", Keys: map[string]string{"k1": key}, IndexKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pii.Init(config.PIIConfig{}) })
+	s := open(t)
+	document := []byte("%PDF passport")
+	hash, err := s.Put(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(s.path(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, document) {
+		t.Fatal("document stored in clear")
+	}
+	// peers copy the sealed file as it is
+	if got, err := s.GetRaw(hash); err != nil || !bytes.Equal(got, raw) {
+		t.Fatalf("raw read %q, %v", got, err)
+	}
+	if got, err := s.Get(hash); err != nil || !bytes.Equal(got, document) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
+
+func TestCorruptedBlob(t *testing.T) {
+	s := open(t)
+	hash, err := s.Put([]byte("%PDF passport"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.path(hash), []byte("%PDF forged"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(hash); err == nil {
+		t.Fatal("read a blob that does not match its address")
+	}
+}
+
+func TestPutRaw(t *testing.T) {
+	s := open(t)
+	document := []byte("%PDF passport")
+	hash := Hash(document)
+	if err := s.PutRaw(hash, []byte("%PDF forged")); err == nil || s.Has(hash) {
+		t.Fatalf("stored content of a peer that does not match its address, %v", err)
+	}
+	if err := s.PutRaw(hash, document); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Get(hash); err != nil || !bytes.Equal(got, document) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
+
+func TestInvalidAddresses(t *testing.T) {
+	s := open(t)
+	for _, hash := range []string{"", "../../etc/passwd", Hash(nil)[:10], Hash(nil)[:63] + "g"} {
+		if ValidHash(hash) || s.Has(hash) {
+			t.Errorf("%q taken for an address", hash)
+		}
+		if _, err := s.GetRaw(hash); err == nil {
+			t.Errorf("read blob %q", hash)
+		}
+		if err := s.Delete(hash); err == nil {
+			t.Errorf("deleted blob %q", hash)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Command bootstrap creates a cluster from the nodes of the configuration. It gives each data directory
+// a node id and the cluster id, then writes the same configuration entry at the start of every log,
+// listing the members by id with their address. The nodes start from there, and refuse to start on a
+// directory that was not bootstrapped.
+//
+// The logs of nodes that ran before they had an id are kept: their members are recorded without a
+// configuration entry, which would not be the same on every node
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"raft/config"
+	"raft/datadir"
+	"raft/state"
+	"raft/utils"
+
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	configPath := flag.String("config", "config.json", "path to the cluster configuration")
+	dataDir := flag.String("data-dir", "", "directory holding a data directory per node, as given to the nodes")
+	flag.Parse()
+	logger.Default = logger.Discard
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := bootstrap(cfg, *dataDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// node is a node of the configuration, its data directory locked
+type node struct {
+	cfg config.NodeConfig
+	dir *datadir.Dir
+	log *state.PersistentState
+}
+
+func (n *node) close() {
+	if n.log != nil {
+		n.log.Close()
+	}
+	n.dir.Close()
+}
+
+func bootstrap(cfg *config.Config, root string) error {
+	nodes := make([]*node, len(cfg.Nodes))
+	defer func() {
+		for _, n := range nodes {
+			if n != nil {
+				n.close()
+			}
+		}
+	}()
+	// directories given an identity by an earlier attempt keep it and tell the cluster
+	clusterID := cfg.ClusterID
+	var missing []int
+	for i, nc := range cfg.Nodes {
+		dir, err := datadir.Open(datadir.Of(root, nc.Name), clusterID)
+		if errors.Is(err, datadir.ErrNotBootstrapped) {
+			missing = append(missing, i)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("node %s: %w", nc.Name, err)
+		}
+		nodes[i] = &node{cfg: nc, dir: dir}
+		clusterID = dir.Identity.ClusterID
+	}
+	if clusterID == "" {
+		clusterID = datadir.NewID()
+	}
+	for _, i := range missing {
+		nc := cfg.Nodes[i]
+		dir, err := datadir.Init(datadir.Of(root, nc.Name), datadir.Identity{NodeID: datadir.NewID(), ClusterID: clusterID})
+		if err != nil {
+			return fmt.Errorf("node %s: %w", nc.Name, err)
+		}
+		nodes[i] = &node{cfg: nc, dir: dir}
+	}
+
+	// a cluster is new when no node has written anything yet
+	fresh := true
+	members := make([]utils.Member, len(nodes))
+	for i, n := range nodes {
+		ps, err := state.InitPersistentState(n.dir.Layout.Log)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", n.cfg.Name, err)
+		}
+		n.log = ps
+		known, err := ps.Members()
+		if err != nil {
+			return fmt.Errorf("node %s: %w", n.cfg.Name, err)
+		}
+		if len(known) > 0 {
+			return fmt.Errorf("node %s: %w", n.cfg.Name, state.ErrBootstrapped)
+		}
+		last, _, err := ps.LastIndexAndTerm()
+		if err != nil {
+			return fmt.Errorf("node %s: %w", n.cfg.Name, err)
+		}
+		term, err := ps.GetCurrentTerm()
+		if err != nil {
+			return fmt.Errorf("node %s: %w", n.cfg.Name, err)
+		}
+		if last > 0 || term > 0 {
+			fresh = false
+		}
+		members[i] = utils.Member{NodeID: n.dir.Identity.NodeID, Address: n.cfg.Address}
+	}
+	for _, n := range nodes {
+		var err error
+		if fresh {
+			err = n.log.Bootstrap(members)
+		} else {
+			// a vote of the current term names a former address and no member, it is kept so the node
+			// does not vote twice in that term
+			err = n.log.RecordMembers(members)
+		}
+		if err != nil {
+			return fmt.Errorf("node %s: %w", n.cfg.Name, err)
+		}
+	}
+
+	fmt.Printf("cluster %s\n", clusterID)
+	for _, n := range nodes {
+		fmt.Printf("  %-12s node %s at %s\n", n.cfg.Name, n.dir.Identity.NodeID, n.cfg.Address)
+	}
+	if fresh {
+		fmt.Println("wrote the configuration entry of every node")
+	} else {
+		fmt.Println("kept the existing logs and recorded the members")
+	}
+	return nil
+}
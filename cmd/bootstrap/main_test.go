@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"raft/config"
+	"raft/datadir"
+	"raft/state"
+
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	logger.Default = logger.Discard
+}
+
+// silence drops what bootstrap prints for the operator
+func silence(t *testing.T) {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+func cluster() *config.Config {
+	return &config.Config{Nodes: []config.NodeConfig{{Name: "a", Address: "7000"}, {Name: "b", Address: "7001"},
+		{Name: "c", Address: "7002"}}}
+}
+
+func TestBootstrap(t *testing.T) {
+	silence(t)
+	root := t.TempDir()
+	cfg := cluster()
+	if err := bootstrap(cfg, root); err != nil {
+		t.Fatal(err)
+	}
+	var clusterID string
+	ids := make(map[string]bool)
+	var first []byte
+	for _, nc := range cfg.Nodes {
+		dir, err := datadir.Open(datadir.Of(root, nc.Name), clusterID)
+		if err != nil {
+			t.Fatalf("node %s: %v", nc.Name, err)
+		}
+		clusterID, ids[dir.Identity.NodeID] = dir.Identity.ClusterID, true
+		ps, err := state.InitPersistentState(dir.Layout.Log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := ps.GetLogEntry(state.ConfigurationIndex)
+		ps.Close()
+		dir.Close()
+		if err != nil {
+			t.Fatalf("node %s: %v", nc.Name, err)
+		}
+		// every log starts with the same configuration entry
+		if first == nil {
+			first = entry.Command
+		} else if string(entry.Command) != string(first) {
+			t.Fatalf("node %s starts with another configuration entry", nc.Name)
+		}
+	}
+	if len(ids) != len(cfg.Nodes) {
+		t.Fatalf("%d node ids for %d nodes", len(ids), len(cfg.Nodes))
+	}
+	if err := bootstrap(cfg, root); !errors.Is(err, state.ErrBootstrapped) {
+		t.Fatalf("second bootstrap: %v", err)
+	}
+}
+
+func TestBootstrapKeepsWrittenLogs(t *testing.T) {
+	silence(t)
+	root := t.TempDir()
+	cfg := cluster()
+	// node a ran before nodes had an id
+	layout := datadir.Of(root, "a")
+	if err := os.MkdirAll(layout.Dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := state.InitPersistentState(layout.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.AdvanceTerm(4); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+	if err := bootstrap(cfg, root); err != nil {
+		t.Fatal(err)
+	}
+	for _, nc := range cfg.Nodes {
+		ps, err := state.InitPersistentState(datadir.Of(root, nc.Name).Log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members, _ := ps.Members()
+		last, _, _ := ps.LastIndexAndTerm()
+		ps.Close()
+		if len(members) != len(cfg.Nodes) || last != 0 {
+			t.Fatalf("node %s: %d members and last entry %d, want the members and no configuration entry",
+				nc.Name, len(members), last)
+		}
+	}
+}
+
+func TestBootstrapAdoptsFlatData(t *testing.T) {
+	silence(t)
+	root := t.TempDir()
+	// the nodes kept their files side by side in the working directory before they had an id
+	ps, err := state.InitPersistentState(datadir.Flat(root, "a").Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.AdvanceTerm(4); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if _, err := state.OpenNode(datadir.Of("", "a"), "", "7000", nil); !errors.Is(err, datadir.ErrNotBootstrapped) {
+		t.Fatalf("node started before the bootstrap: %v", err)
+	}
+	if err := bootstrap(cluster(), ""); err != nil {
+		t.Fatal(err)
+	}
+	n, err := state.OpenNode(datadir.Of("", "a"), "", "7000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if term, err := n.State.GetCurrentTerm(); err != nil || term != 4 {
+		t.Fatalf("term %d after the bootstrap, %v, want the term of the flat log", term, err)
+	}
+	if len(n.Peers) != 2 {
+		t.Fatalf("%d peers, want the other nodes of the configuration", len(n.Peers))
+	}
+}
+
+func TestBootstrapRefusesOtherCluster(t *testing.T) {
+	silence(t)
+	root := t.TempDir()
+	cfg := cluster()
+	d, err := datadir.Init(datadir.Of(root, "b"), datadir.Identity{NodeID: "b", ClusterID: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	cfg.ClusterID = "test"
+	if err := bootstrap(cfg, root); !errors.Is(err, datadir.ErrWrongCluster) {
+		t.Fatalf("bootstrap over a directory of another cluster: %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+// Command crashtest checks that the sqlite log keeps what a node acknowledged when the process dies. A
+// writer process appends batches of entries and moves to new terms with a vote, as a follower does on
+// AppendEntries and RequestVote, and reports each write once it returned, where the node would reply to
+// its peer. With -mode rpc the writer is a follower serving the raft rpcs, and this process is the
+// leader whose rpcs it acknowledges by replying. The writer is killed with SIGKILL at a random point,
+// most often in the middle of an append or of a reply, then the log is opened again and must hold every
+// acknowledged entry, term and vote, with valid checksums and no gap. Rounds go on over the same log.
+//
+// SIGKILL loses what the process holds, not what the kernel did not write yet: losing power is covered
+// by the fsync sqlite runs on every commit with synchronous=FULL, which this tool cannot observe
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"raft/datadir"
+	pb "raft/raft"
+	"raft/rpc_server"
+	"raft/state"
+	"raft/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"gorm.io/gorm/logger"
+)
+
+// what the writer does
+const (
+	modeAppend = "append" // appends to the log directly
+	modeRPC    = "rpc"    // serves the raft rpcs of a leader
+)
+
+// the cluster of the follower in rpc mode, node-0 is the follower and the others lead in turn
+const (
+	clusterID = "crashtest"
+	members   = 5
+)
+
+func main() {
+	rounds := flag.Int("rounds", 20, "number of crashes")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed of the crash points")
+	dir := flag.String("dir", "", "directory of the log, a temporary one by default")
+	maxDelay := flag.Duration("max-delay", 300*time.Millisecond, "longest run of the writer before it is killed")
+	mode := flag.String("mode", modeAppend, "append to the log, or serve the raft rpcs (rpc)")
+	writer := flag.Bool("writer", false, "run as the writer process")
+	flag.Parse()
+	logger.Default = logger.Discard
+
+	if *mode != modeAppend && *mode != modeRPC {
+		fmt.Fprintln(os.Stderr, "invalid mode: append or rpc")
+		os.Exit(2)
+	}
+	if *writer {
+		if err := write(*dir, *seed, *mode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", "crashtest-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(tmp)
+		*dir = tmp
+	}
+	o := options{dir: *dir, mode: *mode, rounds: *rounds, seed: *seed, maxDelay: *maxDelay,
+		spawn: func(args ...string) *exec.Cmd {
+			return exec.Command(os.Args[0], append([]string{"-writer"}, args...)...)
+		}}
+	if err := run(os.Stdout, o); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type options struct {
+	dir      string
+	mode     string
+	rounds   int
+	seed     int64
+	maxDelay time.Duration
+	// spawn returns the command of the writer process, given its flags
+	spawn func(args ...string) *exec.Cmd
+}
+
+// run kills the writer o.rounds times and checks the log after each crash
+func run(out io.Writer, o options) error {
+	if o.mode == modeRPC {
+		if err := bootstrap(o.dir); err != nil {
+			return err
+		}
+	}
+	rng := rand.New(rand.NewSource(o.seed))
+	fmt.Fprintf(out, "seed %d, %s mode, log in %s\n", o.seed, o.mode, o.dir)
+	for round := 1; round <= o.rounds; round++ {
+		delay := time.Duration(rng.Int63n(int64(o.maxDelay)) + 1)
+		acked, err := crash(o, rng.Int63(), delay)
+		if err != nil {
+			return fmt.Errorf("round %d: %w", round, err)
+		}
+		last, err := check(o.dir, acked)
+		if err != nil {
+			return fmt.Errorf("round %d: killed after %v: %w", round, delay, err)
+		}
+		fmt.Fprintf(out, "round %d: killed after %v, %d entries acknowledged up to index %d, log ends at %d, term %d\n",
+			round, delay.Round(time.Millisecond), acked.entries, acked.index, last, acked.term)
+	}
+	fmt.Fprintf(out, "%d rounds, no acknowledged write lost\n", o.rounds)
+	return nil
+}
+
+// acknowledged is what the writer reported as written before it was killed
+type acknowledged struct {
+	entries int   // acknowledged in the round
+	index   int32 // last acknowledged entry, or the end of the log when the round started
+	term    int32 // term of that entry
+	// last acknowledged term and vote
+	voteTerm int32
+	vote     string
+}
+
+// crash runs the writer for delay, kills it and returns what it acknowledged
+func crash(o options, seed int64, delay time.Duration) (acknowledged, error) {
+	var acked acknowledged
+	cmd := o.spawn("-dir", o.dir, "-seed", strconv.FormatInt(seed, 10), "-mode", o.mode)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return acked, err
+	}
+	if err := cmd.Start(); err != nil {
+		return acked, fmt.Errorf("failed to start the writer: %w", err)
+	}
+	lines := bufio.NewScanner(stdout)
+	// the clock starts once the writer opened the log, it tells where the log ends, and in rpc mode its
+	// term and where it listens
+	fields := []string{}
+	if lines.Scan() {
+		fields = strings.Fields(lines.Text())
+	}
+	want := 3
+	if o.mode == modeRPC {
+		want = 5
+	}
+	if len(fields) != want || fields[0] != "ready" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return acked, fmt.Errorf("writer did not start")
+	}
+	start, _ := strconv.Atoi(fields[1])
+	startTerm, _ := strconv.Atoi(fields[2])
+	acked.index, acked.term = int32(start), int32(startTerm)
+	var killed atomic.Bool
+	timer := time.AfterFunc(delay, func() {
+		killed.Store(true)
+		cmd.Process.Signal(syscall.SIGKILL)
+	})
+	defer timer.Stop()
+	var leadErr error
+	if o.mode == modeRPC {
+		term, _ := strconv.Atoi(fields[3])
+		leadErr = lead(fields[4], int32(term), seed, &acked)
+		// an rpc fails once the follower is killed, any other failure is the follower's
+		if !killed.Load() {
+			timer.Stop()
+			cmd.Process.Kill()
+		} else {
+			leadErr = nil
+		}
+	}
+	for lines.Scan() {
+		fields := strings.Fields(lines.Text())
+		switch {
+		case len(fields) == 3 && fields[0] == "entries":
+			index, _ := strconv.Atoi(fields[1])
+			term, _ := strconv.Atoi(fields[2])
+			acked.entries += index - int(acked.index)
+			acked.index, acked.term = int32(index), int32(term)
+		case len(fields) == 3 && fields[0] == "vote":
+			term, _ := strconv.Atoi(fields[1])
+			acked.voteTerm, acked.vote = int32(term), fields[2]
+		}
+	}
+	err = cmd.Wait()
+	if leadErr != nil {
+		return acked, leadErr
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || !status.Signaled() {
+		return acked, fmt.Errorf("writer stopped before it was killed: %v", err)
+	}
+	return acked, nil
+}
+
+// lead sends the rpcs of a leader to the follower at address until one fails, a new leader wins a vote
+// first. What the follower replied to is acknowledged
+func lead(address string, term int32, seed int64, acked *acknowledged) error {
+	rng := rand.New(rand.NewSource(seed))
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := pb.NewRaftClient(conn)
+	last, lastTerm := acked.index, acked.term
+	leader := ""
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if leader == "" || rng.Intn(8) == 0 {
+			term = max(term, lastTerm) + 1
+			candidate := fmt.Sprintf("node-%d", 1+rng.Intn(members-1))
+			resp, err := client.RequestVote(ctx, &pb.RequestVoteRequest{Term: term, CandidateId: candidate,
+				LastLogIndex: last, LastLogTerm: lastTerm, ClusterId: clusterID})
+			if err != nil {
+				cancel()
+				return fmt.Errorf("request vote of term %d: %w", term, err)
+			}
+			if !resp.GetVoteGranted() {
+				cancel()
+				return fmt.Errorf("follower refused its vote to %s in term %d", candidate, term)
+			}
+			leader = candidate
+			acked.voteTerm, acked.vote = term, candidate
+		}
+		entries := make([]*pb.LogEntry, 1+rng.Intn(32))
+		for i := range entries {
+			if entries[i], err = walletEntry(rng, last+int32(i)+1, term); err != nil {
+				cancel()
+				return err
+			}
+		}
+		resp, err := client.AppendEntries(ctx, &pb.AppendEntriesRequest{Term: term, LeaderId: leader,
+			PrevLogIndex: last, PrevLogTerm: lastTerm, Entries: entries, ClusterId: clusterID})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("append entries after %d: %w", last, err)
+		}
+		if !resp.GetSuccess() {
+			return fmt.Errorf("follower refused the entries after %d of term %d", last, lastTerm)
+		}
+		last, lastTerm = last+int32(len(entries)), term
+		acked.entries += len(entries)
+		acked.index, acked.term = last, term
+	}
+}
+
+// bootstrap makes dir the data directory of node-0, in a cluster of members nodes, unless it is already
+func bootstrap(dir string) error {
+	layout := datadir.In(dir)
+	if _, err := os.Stat(layout.Identity); err == nil {
+		return nil
+	}
+	d, err := datadir.Init(layout, datadir.Identity{NodeID: "node-0", ClusterID: clusterID})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	ps, err := state.InitPersistentState(layout.Log)
+	if err != nil {
+		return err
+	}
+	defer ps.Close()
+	nodes := make([]utils.Member, members)
+	for i := range nodes {
+		nodes[i] = utils.Member{NodeID: fmt.Sprintf("node-%d", i), Address: fmt.Sprintf("node-%d", i)}
+	}
+	return ps.Bootstrap(nodes)
+}
+
+// check opens the log the writer left and compares it with what it acknowledged, it returns the last index
+func check(dir string, acked acknowledged) (int32, error) {
+	ps, err := state.InitPersistentState(filepath.Join(dir, "log.db"))
+	if err != nil {
+		return 0, fmt.Errorf("cannot open the log: %w", err)
+	}
+	defer ps.Close()
+	if err := ps.VerifyLog(); err != nil {
+		return 0, err
+	}
+	last, _, err := ps.LastIndexAndTerm()
+	if err != nil {
+		return 0, err
+	}
+	if last < acked.index {
+		return last, fmt.Errorf("log ends at %d, entry %d was acknowledged", last, acked.index)
+	}
+	count, err := ps.GetLogLength()
+	if err != nil {
+		return last, err
+	}
+	if count != int64(last) {
+		return last, fmt.Errorf("log ends at %d but holds %d entries", last, count)
+	}
+	if acked.index > 0 {
+		// entries are never removed, the one at the end of the log when the round started keeps its term
+		entry, err := ps.GetLogEntry(int(acked.index))
+		if err != nil {
+			return last, fmt.Errorf("cannot read acknowledged entry %d: %w", acked.index, err)
+		}
+		if entry.Term != acked.term {
+			return last, fmt.Errorf("entry %d has term %d, %d was acknowledged", acked.index, entry.Term, acked.term)
+		}
+	}
+	term, err := ps.GetCurrentTerm()
+	if err != nil {
+		return last, err
+	}
+	vote, err := ps.GetVotedFor()
+	if err != nil {
+		return last, err
+	}
+	if term < acked.voteTerm || (term == acked.voteTerm && vote != acked.vote) {
+		return last, fmt.Errorf("term %d with vote %q, term %d with vote %q was acknowledged", term, vote,
+			acked.voteTerm, acked.vote)
+	}
+	return last, nil
+}
+
+// write runs the writer until killed
+func write(dir string, seed int64, mode string) error {
+	if mode == modeRPC {
+		return serve(dir)
+	}
+	return appendEntries(dir, seed)
+}
+
+// appendEntries appends until killed. Terms only grow, each one starts with a vote and its entries follow
+func appendEntries(dir string, seed int64) error {
+	rng := rand.New(rand.NewSource(seed))
+	ps, err := state.InitPersistentState(filepath.Join(dir, "log.db"))
+	if err != nil {
+		return err
+	}
+	last, lastTerm, err := ps.LastIndexAndTerm()
+	if err != nil {
+		return err
+	}
+	term, err := ps.GetCurrentTerm()
+	if err != nil {
+		return err
+	}
+	fmt.Println("ready", last, lastTerm)
+	for {
+		if term == 0 || rng.Intn(8) == 0 {
+			term++
+			candidate := fmt.Sprintf("node-%d", rng.Intn(5))
+			if err := ps.SetTermAndVote(term, candidate); err != nil {
+				return err
+			}
+			fmt.Println("vote", term, candidate)
+		}
+		entries := make([]*pb.LogEntry, 1+rng.Intn(32))
+		for i := range entries {
+			last++
+			if entries[i], err = walletEntry(rng, last, term); err != nil {
+				return err
+			}
+		}
+		if err := ps.Append(entries); err != nil {
+			return err
+		}
+		fmt.Println("entries", last, term)
+	}
+}
+
+// serve opens node-0 on dir and serves the raft rpcs until killed
+func serve(dir string) error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	address := lis.Addr().String()
+	// the node prints its state on every rpc, only the ready line goes to the parent
+	stdout := os.Stdout
+	if os.Stdout, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+		return err
+	}
+	log.SetOutput(io.Discard)
+	node, err := state.OpenNode(datadir.In(dir), clusterID, address, nil)
+	if err != nil {
+		return err
+	}
+	last, lastTerm, err := node.Log.LastIndexAndTerm()
+	if err != nil {
+		return err
+	}
+	term, err := node.State.GetCurrentTerm()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "ready", last, lastTerm, term, address)
+	server := grpc.NewServer()
+	pb.RegisterRaftServer(server, rpc_server.NewServer(node))
+	if err := server.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+func walletEntry(rng *rand.Rand, index, term int32) (*pb.LogEntry, error) {
+	command, err := state.EncodeCommand(utils.WalletOperationPayload{
+		Wallet1: rng.Intn(100) + 1,
+		Wallet2: rng.Intn(100) + 1,
+		Amount:  rng.Int63n(1000),
+		Action:  utils.WalletTransfer,
+		PollID:  fmt.Sprintf("crash-%d", index),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LogEntry{Index: int64(index), Term: term, Command: command}, nil
+}
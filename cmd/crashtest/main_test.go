@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "raft/raft"
+	"raft/state"
+
+	"gorm.io/gorm/logger"
+)
+
+// writerEnv makes the test binary run as the writer process, with the flags it is given
+const writerEnv = "CRASHTEST_WRITER"
+
+func TestMain(m *testing.M) {
+	logger.Default = logger.Discard
+	if os.Getenv(writerEnv) == "" {
+		os.Exit(m.Run())
+	}
+	flags := flag.NewFlagSet("writer", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory of the log")
+	seed := flags.Int64("seed", 0, "seed of the writes")
+	mode := flags.String("mode", modeAppend, "append or rpc")
+	flags.Parse(os.Args[1:])
+	if err := write(*dir, *seed, *mode); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// spawn runs the test binary again as the writer
+func spawn(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), writerEnv+"=1")
+	return cmd
+}
+
+func TestCrash(t *testing.T) {
+	rounds := 10
+	if testing.Short() {
+		rounds = 3
+	}
+	for _, mode := range []string{modeAppend, modeRPC} {
+		t.Run(mode, func(t *testing.T) {
+			var out strings.Builder
+			o := options{dir: t.TempDir(), mode: mode, rounds: rounds, seed: 1, maxDelay: 200 * time.Millisecond,
+				spawn: spawn}
+			if err := run(&out, o); err != nil {
+				t.Fatalf("%v\n%s", err, out.String())
+			}
+			t.Log(out.String())
+		})
+	}
+}
+
+func TestCheckFindsLostWrites(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := state.InitPersistentState(filepath.Join(dir, "log.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	var entries []*pb.LogEntry
+	for i := int32(1); i <= 3; i++ {
+		entry, err := walletEntry(rng, i, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := ps.Append(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetTermAndVote(2, "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	tests := []struct {
+		name  string
+		acked acknowledged
+		want  string
+	}{
+		{"all kept", acknowledged{index: 3, term: 2, voteTerm: 2, vote: "node-1"}, ""},
+		{"entry lost", acknowledged{index: 4, term: 2}, "entry 4 was acknowledged"},
+		{"entry of another term", acknowledged{index: 3, term: 1}, "entry 3 has term 2"},
+		{"vote lost", acknowledged{index: 3, term: 2, voteTerm: 2, vote: "node-2"}, `vote "node-2" was acknowledged`},
+		{"term lost", acknowledged{index: 3, term: 2, voteTerm: 3, vote: "node-1"}, "term 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := check(dir, tt.acked)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("check: %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
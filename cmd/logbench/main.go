@@ -0,0 +1,119 @@
+// Command logbench compares the throughput of the log stores: the sqlite tables the nodes run on and
+// the append only files of package wal. Each store appends batches of wallet operations, as a leader
+// does at every heartbeat, then reads them back in ranges as it does to replicate them
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "raft/raft"
+	"raft/state"
+	"raft/utils"
+	"raft/wal"
+
+	"gorm.io/gorm/logger"
+)
+
+type backend struct {
+	name string
+	open func(dir string) (state.LogStore, error)
+}
+
+var backends = []backend{
+	{"sqlite", func(dir string) (state.LogStore, error) {
+		return state.InitPersistentState(filepath.Join(dir, "log.db"))
+	}},
+	{"wal", func(dir string) (state.LogStore, error) {
+		return wal.Open(filepath.Join(dir, "wal"), wal.Options{})
+	}},
+	{"wal-nosync", func(dir string) (state.LogStore, error) {
+		return wal.Open(filepath.Join(dir, "wal"), wal.Options{NoSync: true})
+	}},
+}
+
+func main() {
+	batch := flag.Int("batch", 16, "entries per append")
+	readRange := flag.Int("range", 64, "entries per read")
+	benchtime := flag.Duration("benchtime", time.Second, "approximate duration of each benchmark")
+	flag.Parse()
+	if *batch < 1 || *readRange < 1 {
+		fmt.Fprintln(os.Stderr, "batch and range must be positive")
+		os.Exit(2)
+	}
+	testing.Init()
+	if err := flag.Set("test.benchtime", benchtime.String()); err != nil {
+		panic(err)
+	}
+	logger.Default = logger.Discard
+
+	fmt.Printf("%-12s %-8s %14s %14s\n", "store", "op", "ns/entry", "entries/s")
+	for _, b := range backends {
+		dir, err := os.MkdirTemp("", "logbench-")
+		if err != nil {
+			panic(err)
+		}
+		store, err := b.open(dir)
+		if err != nil {
+			panic(err)
+		}
+		var last int64
+		appends := testing.Benchmark(func(tb *testing.B) {
+			for i := 0; i < tb.N; i++ {
+				entries := make([]*pb.LogEntry, *batch)
+				for k := range entries {
+					last++
+					entries[k] = walletEntry(last)
+				}
+				if err := store.Append(entries); err != nil {
+					tb.Fatal(err)
+				}
+			}
+		})
+		report(b.name, "append", appends, *batch)
+		reads := testing.Benchmark(func(tb *testing.B) {
+			for i := 0; i < tb.N; i++ {
+				lo := 1 + (int64(i)*int64(*readRange))%max(last-int64(*readRange), 1)
+				entries, err := store.Entries(int32(lo), int32(lo)+int32(*readRange)-1)
+				if err != nil {
+					tb.Fatal(err)
+				}
+				if len(entries) == 0 {
+					tb.Fatalf("no entry from %d", lo)
+				}
+			}
+		})
+		report(b.name, "read", reads, *readRange)
+		if err := store.Close(); err != nil {
+			panic(err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func walletEntry(index int64) *pb.LogEntry {
+	command, err := state.EncodeCommand(utils.WalletOperationPayload{
+		Wallet1: int(index%100 + 1),
+		Wallet2: int(index%97 + 1),
+		Amount:  index % 1000,
+		Action:  utils.WalletTransfer,
+		PollID:  fmt.Sprintf("bench-%d", index),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &pb.LogEntry{Index: index, Term: 1, Command: command}
+}
+
+func report(store, op string, r testing.BenchmarkResult, perOp int) {
+	if r.N == 0 {
+		fmt.Printf("%-12s %-8s %14s %14s\n", store, op, "failed", "")
+		return
+	}
+	nsPerEntry := float64(r.T.Nanoseconds()) / float64(r.N*perOp)
+	fmt.Printf("%-12s %-8s %14.0f %14.0f\n", store, op, nsPerEntry, 1e9/nsPerEntry)
+}
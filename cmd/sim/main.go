@@ -0,0 +1,112 @@
+// Command sim runs the raft simulation scenarios. A failing run prints its seed, running the same
+// scenario with that seed replays it step by step
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"raft/config"
+	"raft/datadir"
+	"raft/sim"
+	"raft/state"
+	"raft/wal"
+
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	seed := flag.Int64("seed", 1, "seed of the first run")
+	runs := flag.Int("runs", 1, "number of runs per scenario, with consecutive seeds")
+	scenario := flag.String("scenario", "all", "scenario to run, or all")
+	quiet := flag.Bool("quiet", true, "hide the logs of the nodes")
+	trace := flag.Bool("trace", false, "print every step of the simulation")
+	replay := flag.Bool("replay", false, "run every seed twice and fail when the traces differ")
+	minDelay := flag.Duration("min-delay", sim.DefaultFaults.MinDelay, "shortest delay of a message")
+	maxDelay := flag.Duration("max-delay", sim.DefaultFaults.MaxDelay, "longest delay of a message")
+	drop := flag.Float64("drop", 0, "probability that a message is lost")
+	duplicate := flag.Float64("duplicate", 0, "probability that a request is delivered twice")
+	reads := flag.String("reads", "leader", "how the clients read balances: leader, through a read barrier, or local, "+
+		"from the state machine of any node as the api does")
+	logBackend := flag.String("log", config.LogBackendSQLite, "log store of the nodes: sqlite or wal")
+	list := flag.Bool("list", false, "list the scenarios")
+	flag.Parse()
+
+	out := os.Stdout
+	if *list {
+		for _, s := range sim.Scenarios {
+			fmt.Fprintf(out, "%-15s %d nodes, %s\n", s.Name, s.Nodes, s.Description)
+		}
+		return
+	}
+	if *minDelay > *maxDelay || *drop < 0 || *drop >= 1 || *duplicate < 0 || *duplicate >= 1 {
+		fmt.Fprintln(os.Stderr, "invalid faults: min-delay must not exceed max-delay, rates must be in [0, 1)")
+		os.Exit(2)
+	}
+	if *reads != "leader" && *reads != "local" {
+		fmt.Fprintln(os.Stderr, "invalid reads: leader or local")
+		os.Exit(2)
+	}
+	if *logBackend != config.LogBackendSQLite && *logBackend != config.LogBackendWAL {
+		fmt.Fprintln(os.Stderr, "invalid log: sqlite or wal")
+		os.Exit(2)
+	}
+	scenarios := sim.Scenarios
+	if *scenario != "all" {
+		s, ok := sim.Lookup(*scenario)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown scenario %q, -list shows them\n", *scenario)
+			os.Exit(2)
+		}
+		scenarios = []sim.Scenario{s}
+	}
+	if *quiet {
+		// the nodes print to stdout and to the standard logger
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			panic(err)
+		}
+		os.Stdout = devNull
+		log.SetOutput(io.Discard)
+		logger.Default = logger.Discard
+	}
+
+	cfg := sim.Config{Faults: sim.Faults{MinDelay: *minDelay, MaxDelay: *maxDelay, DropRate: *drop,
+		DuplicateRate: *duplicate}, LocalReads: *reads == "local"}
+	if *logBackend == config.LogBackendWAL {
+		cfg.Log = func(layout datadir.Layout) (state.LogStore, error) {
+			return wal.Open(layout.WAL, wal.Options{})
+		}
+	}
+	if *trace {
+		cfg.Trace = out
+	}
+	failed := 0
+	start := time.Now()
+	for _, s := range scenarios {
+		for i := 0; i < *runs; i++ {
+			cfg.Seed = *seed + int64(i)
+			report, err := sim.RunScenario(s, cfg)
+			report.Print(out)
+			if err == nil && *replay {
+				var again sim.Report
+				if again, err = sim.RunScenario(s, cfg); err == nil && again.TraceHash != report.TraceHash {
+					err = fmt.Errorf("%s with seed %d: replay diverged, trace %s then %s", s.Name, cfg.Seed,
+						report.TraceHash, again.TraceHash)
+				}
+			}
+			if err != nil {
+				failed++
+				fmt.Fprintf(out, "FAIL %v\n", err)
+			}
+		}
+	}
+	fmt.Fprintf(out, "%d runs, %d failed in %v\n", len(scenarios)**runs, failed, time.Since(start).Round(time.Millisecond))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,285 @@
+// node configuration, read from a json file shared by the nodes of a cluster
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+type Config struct {
+	// cluster the data directories of the nodes must belong to, empty to take the one they record
+	ClusterID string `json:"cluster_id"`
+	// nodes run by this process
+	Nodes []NodeConfig `json:"nodes"`
+	Auth  AuthConfig   `json:"auth"`
+	// first super admin, proposed by the leader while the cluster has no admin
+	BootstrapAdmin *BootstrapAdminConfig `json:"bootstrap_admin"`
+	PII            PIIConfig             `json:"pii"`
+	// number of applied entries between two snapshots of a node
+	SnapshotInterval int             `json:"snapshot_interval"`
+	RateLimit        RateLimitConfig `json:"rate_limit"`
+	HTTP             HTTPConfig      `json:"http"`
+	Raft             RaftConfig      `json:"raft"`
+	Debug            DebugConfig     `json:"debug"`
+}
+
+// NodeConfig places a node. Its name picks its data directory, so the node keeps its identity and its
+// votes when its address changes
+type NodeConfig struct {
+	Name string `json:"name"`
+	// where the node serves the raft rpcs and its peers reach it, a port alone listens on every interface
+	// and is reached on localhost
+	Address string `json:"address"`
+	API     string `json:"api"`
+	// fault injection endpoints, used when enabled
+	Debug string `json:"debug"`
+}
+
+type AuthConfig struct {
+	// key signing the session tokens, every node of the cluster needs the same one
+	TokenSecret     string `json:"token_secret"`
+	TokenTTLMinutes int    `json:"token_ttl_minutes"`
+	BcryptCost      int    `json:"bcrypt_cost"`
+}
+
+type BootstrapAdminConfig struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+}
+
+// PIIConfig holds the master keys sealing personal data, every node of the cluster needs the same ones.
+// Keys are base64 encoded 32 byte values, retired keys stay listed until no value is sealed with them
+type PIIConfig struct {
+	// json file with the same fields, it takes precedence over the inline keys
+	KeyFile   string            `json:"key_file,omitempty"`
+	ActiveKey string            `json:"active_key"`
+	Keys      map[string]string `json:"keys"`
+	// key of the digests used to look up and deduplicate sealed values
+	IndexKey string `json:"index_key"`
+}
+
+// RateLimitConfig bounds how fast callers may use the api and how many of their proposals may wait for
+// a commit at once
+type RateLimitConfig struct {
+	// token buckets by route prefix, the longest matching prefix applies and "" matches every route
+	Groups map[string]RateLimitGroup `json:"groups"`
+	// proposals of a caller accepted by the leader and not yet committed, 0 disables the check
+	MaxPendingPerUser int `json:"max_pending_per_user"`
+	// proposals that never commit, lost with a leader, stop counting after this delay
+	PendingTTLSeconds int `json:"pending_ttl_seconds"`
+}
+
+type RateLimitGroup struct {
+	PerIP   Bucket `json:"per_ip"`
+	PerUser Bucket `json:"per_user"`
+}
+
+// Bucket refills Rate tokens per second up to Burst, a zero rate disables the bucket
+type Bucket struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// HTTPConfig sets up the api server of every node
+type HTTPConfig struct {
+	// origins allowed to call the api from a browser, "*" allows any origin but without credentials.
+	// Without origins cross origin requests are refused
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	// the api is served over https when both files are given
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// zero disables a timeout
+	ReadTimeoutSeconds  int `json:"read_timeout_seconds"`
+	WriteTimeoutSeconds int `json:"write_timeout_seconds"`
+	IdleTimeoutSeconds  int `json:"idle_timeout_seconds"`
+	// size limit of a request body, document uploads have their own limit
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// proxies whose X-Forwarded-For header is trusted to tell the client ip, none by default
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// RaftConfig paces elections and replication, in milliseconds. Failover takes between the minimum and
+// the maximum election timeout
+type RaftConfig struct {
+	ElectionTimeoutMinMS int `json:"election_timeout_min_ms"`
+	ElectionTimeoutMaxMS int `json:"election_timeout_max_ms"`
+	HeartbeatIntervalMS  int `json:"heartbeat_interval_ms"`
+	// deadline of a request vote or append entries rpc
+	RPCTimeoutMS int `json:"rpc_timeout_ms"`
+	// where the nodes keep their log entries, LogBackendSQLite or LogBackendWAL
+	LogBackend string `json:"log_backend"`
+}
+
+// log backends of RaftConfig
+const (
+	// the entries are kept in the sqlite log beside the term and the vote
+	LogBackendSQLite = "sqlite"
+	// the entries are kept in the append only segment files of package wal
+	LogBackendWAL = "wal"
+)
+
+// DebugConfig enables tools meant for staging, never for production
+type DebugConfig struct {
+	// serves the fault injection endpoints of every node on localhost, see the README
+	FaultInjection bool `json:"fault_injection"`
+}
+
+// Default returns the configuration used when no file is given
+func Default() *Config {
+	return &Config{
+		Nodes: []NodeConfig{
+			{Name: "9001", Address: "9001", API: ":8001", Debug: "127.0.0.1:8101"},
+			{Name: "9002", Address: "9002", API: ":8002", Debug: "127.0.0.1:8102"},
+			{Name: "9003", Address: "9003", API: ":8003", Debug: "127.0.0.1:8103"},
+		},
+		Auth: AuthConfig{
+			TokenTTLMinutes: 60,
+			BcryptCost:      10,
+		},
+		SnapshotInterval: 1000,
+		RateLimit: RateLimitConfig{
+			Groups: map[string]RateLimitGroup{
+				"":                  {PerIP: Bucket{Rate: 20, Burst: 40}, PerUser: Bucket{Rate: 10, Burst: 20}},
+				"/api/wallet":       {PerIP: Bucket{Rate: 10, Burst: 20}, PerUser: Bucket{Rate: 2, Burst: 5}},
+				"/api/user/sign-in": {PerIP: Bucket{Rate: 0.2, Burst: 5}},
+				"/api/admin/signin": {PerIP: Bucket{Rate: 0.2, Burst: 5}},
+				"/api/user/signup":  {PerIP: Bucket{Rate: 0.1, Burst: 3}},
+				"/api/user/kyc":     {PerIP: Bucket{Rate: 0.2, Burst: 4}},
+			},
+			MaxPendingPerUser: 10,
+			PendingTTLSeconds: 60,
+		},
+		HTTP: HTTPConfig{
+			ReadTimeoutSeconds:  15,
+			WriteTimeoutSeconds: 30,
+			IdleTimeoutSeconds:  120,
+			MaxBodyBytes:        1 << 20,
+		},
+		Raft: RaftConfig{
+			ElectionTimeoutMinMS: 1500,
+			ElectionTimeoutMaxMS: 3000,
+			HeartbeatIntervalMS:  300,
+			RPCTimeoutMS:         1000,
+			LogBackend:           LogBackendSQLite,
+		},
+	}
+}
+
+// Load reads the configuration at path, a missing file falls back to the defaults
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("no configuration found at %s, using defaults\n", path)
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate rejects settings the nodes cannot run with
+func (c *Config) Validate() error {
+	if err := c.validateNodes(); err != nil {
+		return err
+	}
+	if c.Auth.TokenSecret == "" {
+		return fmt.Errorf("auth.token_secret is required, every node of the cluster needs the same one")
+	}
+	if c.Auth.TokenTTLMinutes <= 0 {
+		return fmt.Errorf("auth.token_ttl_minutes must be positive")
+	}
+	if c.Auth.BcryptCost < 4 || c.Auth.BcryptCost > 31 {
+		return fmt.Errorf("auth.bcrypt_cost must be between 4 and 31")
+	}
+	if c.SnapshotInterval <= 0 {
+		return fmt.Errorf("snapshot_interval must be positive")
+	}
+	for prefix, group := range c.RateLimit.Groups {
+		for _, b := range []Bucket{group.PerIP, group.PerUser} {
+			if b.Rate < 0 || (b.Rate > 0 && b.Burst < 1) {
+				return fmt.Errorf("rate_limit.groups[%q]: rates cannot be negative and need a burst of at least 1", prefix)
+			}
+		}
+	}
+	if c.RateLimit.MaxPendingPerUser < 0 {
+		return fmt.Errorf("rate_limit.max_pending_per_user cannot be negative")
+	}
+	if c.RateLimit.MaxPendingPerUser > 0 && c.RateLimit.PendingTTLSeconds <= 0 {
+		return fmt.Errorf("rate_limit.pending_ttl_seconds must be positive")
+	}
+	if h := c.HTTP; h.AllowCredentials && slices.Contains(h.AllowedOrigins, "*") {
+		return fmt.Errorf("http.allowed_origins cannot contain \"*\" when http.allow_credentials is set")
+	}
+	if h := c.HTTP; (h.TLSCertFile == "") != (h.TLSKeyFile == "") {
+		return fmt.Errorf("http.tls_cert_file and http.tls_key_file go together")
+	}
+	if h := c.HTTP; h.ReadTimeoutSeconds < 0 || h.WriteTimeoutSeconds < 0 || h.IdleTimeoutSeconds < 0 {
+		return fmt.Errorf("http timeouts cannot be negative")
+	}
+	if c.HTTP.MaxBodyBytes <= 0 {
+		return fmt.Errorf("http.max_body_bytes must be positive")
+	}
+	if err := c.Raft.validate(); err != nil {
+		return err
+	}
+	if b := c.BootstrapAdmin; b != nil && (b.Email == "" || b.Password == "") {
+		return fmt.Errorf("bootstrap_admin needs an email and a password")
+	}
+	return nil
+}
+
+// validateNodes makes sure each node has its own name and addresses
+func (c *Config) validateNodes() error {
+	if len(c.Nodes) == 0 {
+		return fmt.Errorf("nodes cannot be empty")
+	}
+	names, addresses := map[string]bool{}, map[string]bool{}
+	for i, n := range c.Nodes {
+		if n.Name == "" || n.Address == "" || n.API == "" {
+			return fmt.Errorf("nodes[%d] needs a name, an address and an api address", i)
+		}
+		if c.Debug.FaultInjection && n.Debug == "" {
+			return fmt.Errorf("nodes[%d] needs a debug address when debug.fault_injection is set", i)
+		}
+		if names[n.Name] || addresses[n.Address] {
+			return fmt.Errorf("nodes[%d] shares its name or its address with another node", i)
+		}
+		names[n.Name], addresses[n.Address] = true, true
+	}
+	return nil
+}
+
+// validate makes sure followers hear from a healthy leader before they time out: a round of heartbeats
+// waits for the slowest peer, up to the rpc timeout, before the next one is scheduled
+func (r RaftConfig) validate() error {
+	if r.ElectionTimeoutMinMS <= 0 || r.HeartbeatIntervalMS <= 0 || r.RPCTimeoutMS <= 0 {
+		return fmt.Errorf("raft timings must be positive")
+	}
+	if r.ElectionTimeoutMaxMS < r.ElectionTimeoutMinMS {
+		return fmt.Errorf("raft.election_timeout_max_ms cannot be below raft.election_timeout_min_ms")
+	}
+	if 3*r.HeartbeatIntervalMS > r.ElectionTimeoutMinMS {
+		return fmt.Errorf("raft.heartbeat_interval_ms must be at most a third of raft.election_timeout_min_ms")
+	}
+	if r.HeartbeatIntervalMS+r.RPCTimeoutMS >= r.ElectionTimeoutMinMS {
+		return fmt.Errorf("raft.heartbeat_interval_ms plus raft.rpc_timeout_ms must stay below raft.election_timeout_min_ms")
+	}
+	if r.LogBackend != LogBackendSQLite && r.LogBackend != LogBackendWAL {
+		return fmt.Errorf("raft.log_backend must be %q or %q, not %q", LogBackendSQLite, LogBackendWAL, r.LogBackend)
+	}
+	return nil
+}
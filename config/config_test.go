@@ -0,0 +1,118 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// valid returns the defaults with the settings that have none
+func valid() *Config {
+	cfg := Default()
+	cfg.Auth.TokenSecret = "test-secret"
+	return cfg
+}
+
+func TestTokenSecretRequired(t *testing.T) {
+	cfg := valid()
+	cfg.Auth.TokenSecret = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "auth.token_secret") {
+		t.Fatalf("validated without a token secret: %v", err)
+	}
+}
+
+func TestRaftTimings(t *testing.T) {
+	tests := []struct {
+		name string
+		raft RaftConfig
+		// part of the error, empty when the timings are valid
+		err string
+	}{
+		{"defaults", Default().Raft, ""},
+		{"fast cluster", RaftConfig{ElectionTimeoutMinMS: 150, ElectionTimeoutMaxMS: 300, HeartbeatIntervalMS: 50,
+			RPCTimeoutMS: 90, LogBackend: LogBackendWAL}, ""},
+		{"fixed election timeout", RaftConfig{ElectionTimeoutMinMS: 300, ElectionTimeoutMaxMS: 300,
+			HeartbeatIntervalMS: 100, RPCTimeoutMS: 100, LogBackend: LogBackendSQLite}, ""},
+		{"no heartbeat", RaftConfig{ElectionTimeoutMinMS: 300, ElectionTimeoutMaxMS: 600, RPCTimeoutMS: 100,
+			LogBackend: LogBackendSQLite}, "must be positive"},
+		{"maximum below minimum", RaftConfig{ElectionTimeoutMinMS: 600, ElectionTimeoutMaxMS: 300,
+			HeartbeatIntervalMS: 100, RPCTimeoutMS: 100, LogBackend: LogBackendSQLite}, "cannot be below"},
+		{"heartbeat above a third of the election timeout", RaftConfig{ElectionTimeoutMinMS: 300,
+			ElectionTimeoutMaxMS: 600, HeartbeatIntervalMS: 101, RPCTimeoutMS: 100, LogBackend: LogBackendSQLite},
+			"at most a third"},
+		{"heartbeat round longer than the election timeout", RaftConfig{ElectionTimeoutMinMS: 300,
+			ElectionTimeoutMaxMS: 600, HeartbeatIntervalMS: 100, RPCTimeoutMS: 200, LogBackend: LogBackendSQLite},
+			"must stay below"},
+		{"unknown log backend", RaftConfig{ElectionTimeoutMinMS: 300, ElectionTimeoutMaxMS: 600,
+			HeartbeatIntervalMS: 100, RPCTimeoutMS: 100, LogBackend: "rocksdb"}, "raft.log_backend"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			cfg.Raft = tt.raft
+			err := cfg.Validate()
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("validated with %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadKeepsDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"auth": {"token_secret": "test-secret"}, "raft": {"heartbeat_interval_ms": 100}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default().Raft
+	want.HeartbeatIntervalMS = 100
+	if cfg.Raft != want {
+		t.Fatalf("loaded %+v, want %+v", cfg.Raft, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"auth": {"token_secret": "test-secret"}, "raft": {"heartbeat_interval_ms": 1000}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("loaded a heartbeat interval above a third of the election timeout")
+	}
+}
+
+func TestHTTPHardening(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(h *HTTPConfig)
+		err  string
+	}{
+		{"defaults", func(h *HTTPConfig) {}, ""},
+		{"origins with credentials", func(h *HTTPConfig) {
+			h.AllowedOrigins, h.AllowCredentials = []string{"https://wallet.test.invalid"}, true
+		}, ""},
+		{"any origin with credentials", func(h *HTTPConfig) {
+			h.AllowedOrigins, h.AllowCredentials = []string{"*"}, true
+		}, "cannot contain"},
+		{"certificate without key", func(h *HTTPConfig) { h.TLSCertFile = "cert.pem" }, "go together"},
+		{"negative timeout", func(h *HTTPConfig) { h.IdleTimeoutSeconds = -1 }, "cannot be negative"},
+		{"no body limit", func(h *HTTPConfig) { h.MaxBodyBytes = 0 }, "max_body_bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.edit(&cfg.HTTP)
+			err := cfg.Validate()
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("validated with %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Package datadir lays out the files of a node in its data directory: the raft log, the state machine,
+// the documents, the snapshots and the identity of the node. The identity is written once, when the
+// cluster is bootstrapped. A lock file keeps a second process out of a directory in use
+package datadir
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ErrLocked is returned when another process holds the data directory
+	ErrLocked = errors.New("data directory in use")
+	// ErrWrongCluster is returned when the data directory belongs to another cluster than the expected one
+	ErrWrongCluster = errors.New("data directory belongs to another cluster")
+	// ErrNotBootstrapped is returned when the data directory has no identity yet
+	ErrNotBootstrapped = errors.New("data directory not bootstrapped")
+	// ErrBootstrapped is returned when an identity is written in a data directory that has one
+	ErrBootstrapped = errors.New("data directory already bootstrapped")
+)
+
+// Layout names the files of a node
+type Layout struct {
+	Dir          string // holds the files, created on first use
+	Log          string
+	WAL          string // log entries, when the node runs on the wal log backend
+	StateMachine string
+	Blobs        string // documents, content addressed
+	Snapshots    string
+	Identity     string
+	Lock         string
+}
+
+// In is the layout of a node owning dir
+func In(dir string) Layout {
+	return Layout{
+		Dir:          dir,
+		Log:          filepath.Join(dir, "log.db"),
+		WAL:          filepath.Join(dir, "wal"),
+		StateMachine: filepath.Join(dir, "state.db"),
+		Blobs:        filepath.Join(dir, "blobs"),
+		Snapshots:    filepath.Join(dir, "snapshots"),
+		Identity:     filepath.Join(dir, "identity.json"),
+		Lock:         filepath.Join(dir, "LOCK"),
+	}
+}
+
+// Flat is the layout of nodes sharing dir, their files named after their address as the nodes wrote
+// them before they had a data directory
+func Flat(dir, address string) Layout {
+	return Layout{
+		Dir:          dir,
+		Log:          filepath.Join(dir, address+".db"),
+		WAL:          filepath.Join(dir, address+".wal"),
+		StateMachine: filepath.Join(dir, address+".sm.db"),
+		Blobs:        filepath.Join(dir, address+".blobs"),
+		Snapshots:    filepath.Join(dir, address+".snapshots"),
+		Identity:     filepath.Join(dir, address+".identity.json"),
+		Lock:         filepath.Join(dir, address+".lock"),
+	}
+}
+
+// Of is the layout of the node named name under root, or the flat layout of the working directory when
+// root is empty
+func Of(root, name string) Layout {
+	if root == "" {
+		return Flat(".", name)
+	}
+	return In(filepath.Join(root, name))
+}
+
+// Identity is who the node of a data directory is, written once when the cluster is bootstrapped
+type Identity struct {
+	NodeID    string    `json:"node_id"`
+	ClusterID string    `json:"cluster_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Dir is a data directory in use, locked until closed
+type Dir struct {
+	Layout   Layout
+	Identity Identity
+	lock     *os.File
+}
+
+// Open locks the data directory of layout and reads the identity of its node. A directory of another
+// cluster than a non empty clusterID is refused
+func Open(layout Layout, clusterID string) (*Dir, error) {
+	d, err := lock(layout)
+	if err != nil {
+		return nil, err
+	}
+	if d.Identity, err = loadIdentity(layout, clusterID); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Init locks the data directory of layout and writes the identity of its node, the directory must not
+// have one yet
+func Init(layout Layout, id Identity) (*Dir, error) {
+	if id.NodeID == "" || id.ClusterID == "" {
+		return nil, fmt.Errorf("identity of %s needs a node id and a cluster id", layout.Dir)
+	}
+	d, err := lock(layout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(layout.Identity); err == nil {
+		d.Close()
+		return nil, fmt.Errorf("%w: %s exists", ErrBootstrapped, layout.Identity)
+	}
+	if id.CreatedAt.IsZero() {
+		id.CreatedAt = time.Now().UTC()
+	}
+	if err := writeIdentity(layout, id); err != nil {
+		d.Close()
+		return nil, err
+	}
+	d.Identity = id
+	return d, nil
+}
+
+// lock creates the directories of layout and takes its lock
+func lock(layout Layout) (*Dir, error) {
+	for _, dir := range []string{layout.Dir, layout.Snapshots} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	lock, err := acquire(layout.Lock)
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{Layout: layout, lock: lock}, nil
+}
+
+// Close releases the lock of the directory
+func (d *Dir) Close() error {
+	if d.lock == nil {
+		return nil
+	}
+	err := release(d.lock)
+	d.lock = nil
+	return err
+}
+
+func loadIdentity(layout Layout, clusterID string) (Identity, error) {
+	var id Identity
+	data, err := os.ReadFile(layout.Identity)
+	if errors.Is(err, os.ErrNotExist) {
+		return id, fmt.Errorf("%w: no identity at %s, bootstrap the cluster first", ErrNotBootstrapped, layout.Identity)
+	}
+	if err != nil {
+		return id, fmt.Errorf("failed to read node identity: %w", err)
+	}
+	if err := json.Unmarshal(data, &id); err != nil || id.NodeID == "" || id.ClusterID == "" {
+		return id, fmt.Errorf("invalid node identity %s", layout.Identity)
+	}
+	if clusterID != "" && id.ClusterID != clusterID {
+		return id, fmt.Errorf("%w: %s holds node %s of cluster %s, cluster %s was expected", ErrWrongCluster,
+			layout.Dir, id.NodeID, id.ClusterID, clusterID)
+	}
+	return id, nil
+}
+
+// writeIdentity writes the identity of a node at once through a rename
+func writeIdentity(layout Layout, id Identity) error {
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(layout.Dir, filepath.Base(layout.Identity)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write node identity: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write node identity: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write node identity: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write node identity: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), layout.Identity); err != nil {
+		return fmt.Errorf("failed to write node identity: %w", err)
+	}
+	return syncDir(layout.Dir)
+}
+
+// NewID returns a random identifier for a node or a cluster
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,79 @@
+package datadir
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLockRefusesSecondOpen(t *testing.T) {
+	layout := In(t.TempDir())
+	d, err := Init(layout, Identity{NodeID: "node-0", ClusterID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(layout, "test"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second open of a directory in use: %v", err)
+	}
+	holder, err := os.ReadFile(layout.Lock)
+	if err != nil || strings.TrimSpace(string(holder)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("lock file holds %q, %v", holder, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the file stays behind, the lock does not
+	again, err := Open(layout, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+}
+
+func TestLayouts(t *testing.T) {
+	if got := Of("", "7000"); got != Flat(".", "7000") || got.Log != "7000.db" {
+		t.Fatalf("layout without a root: %+v", got)
+	}
+	got := Of("/data", "node-0")
+	if got != In("/data/node-0") || got.Log != "/data/node-0/log.db" || got.Lock != "/data/node-0/LOCK" {
+		t.Fatalf("layout under a root: %+v", got)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	layout := In(t.TempDir())
+	if _, err := Open(layout, ""); !errors.Is(err, ErrNotBootstrapped) {
+		t.Fatalf("open of a directory without identity: %v", err)
+	}
+	d, err := Init(layout, Identity{NodeID: "node-0", ClusterID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	if _, err := Init(layout, Identity{NodeID: "node-1", ClusterID: "test"}); !errors.Is(err, ErrBootstrapped) {
+		t.Fatalf("second bootstrap of a directory: %v", err)
+	}
+	if _, err := Open(layout, "other"); !errors.Is(err, ErrWrongCluster) {
+		t.Fatalf("open of a directory of another cluster: %v", err)
+	}
+	d, err = Open(layout, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.Identity.NodeID != "node-0" || d.Identity.ClusterID != "test" || d.Identity.CreatedAt.IsZero() {
+		t.Fatalf("read identity %+v", d.Identity)
+	}
+}
+
+func TestInitNeedsIds(t *testing.T) {
+	layout := In(t.TempDir())
+	if _, err := Init(layout, Identity{NodeID: "node-0"}); err == nil {
+		t.Fatal("bootstrapped a node without a cluster id")
+	}
+	if _, err := os.Stat(layout.Identity); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("identity written for a refused bootstrap: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !unix
+
+package datadir
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// acquire creates path with the pid of the process, the file only exists while a node runs. A crashed
+// node leaves it behind, it must then be removed by hand
+func acquire(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		holder, _ := os.ReadFile(path)
+		return nil, fmt.Errorf("%w: %s was left by process %s", ErrLocked, path, string(holder))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+	if _, err := f.WriteString(strconv.Itoa(os.Getpid())); err != nil {
+		release(f)
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return f, nil
+}
+
+func release(f *os.File) error {
+	f.Close()
+	return os.Remove(f.Name())
+}
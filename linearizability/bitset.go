@@ -0,0 +1,43 @@
+package linearizability
+
+import (
+	"hash/fnv"
+	"slices"
+)
+
+// bitset is the set of operations linearized so far
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int) bitset {
+	b[i/64] |= 1 << (i % 64)
+	return b
+}
+
+func (b bitset) clear(i int) bitset {
+	b[i/64] &^= 1 << (i % 64)
+	return b
+}
+
+func (b bitset) clone() bitset {
+	return slices.Clone(b)
+}
+
+func (b bitset) equal(other bitset) bool {
+	return slices.Equal(b, other)
+}
+
+func (b bitset) hash() uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, w := range b {
+		for i := range buf {
+			buf[i] = byte(w >> (8 * i))
+		}
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
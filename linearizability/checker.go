@@ -0,0 +1,219 @@
+// Package linearizability checks recorded client histories against a sequential model, in the manner
+// of Porcupine: a depth first search for an order of the operations that respects both real time and
+// the model, pruned by a cache of the states already reached with the same set of operations
+package linearizability
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Pending is the return time of an operation whose outcome the client never learned. It may take
+// effect at any point after its call, or never
+const Pending = math.MaxInt64
+
+// Operation is one call of a client, with its outcome
+type Operation struct {
+	ClientID int
+	Input    any
+	// Output is nil for an operation whose outcome is unknown
+	Output any
+	// Call and Return are times in a common clock, Return is Pending for an unknown outcome
+	Call, Return int64
+}
+
+// Model is the sequential specification a history is checked against
+type Model struct {
+	Init func() any
+	// Step applies input to state and reports whether output is a possible outcome, with the state after
+	Step  func(state, input, output any) (bool, any)
+	Equal func(a, b any) bool
+	// Describe prints an operation, optional
+	Describe func(input, output any) string
+}
+
+// Result of a check. When the history is not linearizable, Longest is the longest sequence of
+// operations that could be ordered and Stuck the operations none of which could follow it
+type Result struct {
+	Ok            bool
+	Linearization []int
+	Longest       []int
+	Stuck         []int
+}
+
+type entry struct {
+	id         int
+	call       bool
+	time       int64
+	match      *entry // the return of a call
+	prev, next *entry
+}
+
+// Check searches for a linearization of history under model
+func Check(model Model, history []Operation) Result {
+	head := makeEntries(history)
+	var (
+		state      = model.Init()
+		linearized = newBitset(len(history))
+		cache      = map[uint64][]cached{}
+		calls      []frame
+		longest    []int
+		stuck      []int
+	)
+	e := head.next
+	for head.next != nil {
+		if e.match != nil {
+			op := history[e.id]
+			ok, next := model.Step(state, op.Input, op.Output)
+			if ok {
+				done := linearized.clone().set(e.id)
+				if !seen(cache, done, next, model.Equal) {
+					cache[done.hash()] = append(cache[done.hash()], cached{done, next})
+					calls = append(calls, frame{e, state})
+					state = next
+					linearized.set(e.id)
+					lift(e)
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+		// a return is reached before its call could be placed, undo the last choice
+		if len(calls) >= len(longest) {
+			longest = order(calls)
+			stuck = pendingCalls(head, e)
+		}
+		if len(calls) == 0 {
+			return Result{Longest: longest, Stuck: stuck}
+		}
+		top := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		e, state = top.entry, top.state
+		linearized.clear(e.id)
+		unlift(e)
+		e = e.next
+	}
+	return Result{Ok: true, Linearization: order(calls)}
+}
+
+type frame struct {
+	entry *entry
+	state any
+}
+
+type cached struct {
+	linearized bitset
+	state      any
+}
+
+func seen(cache map[uint64][]cached, linearized bitset, state any, equal func(a, b any) bool) bool {
+	for _, c := range cache[linearized.hash()] {
+		if c.linearized.equal(linearized) && equal(c.state, state) {
+			return true
+		}
+	}
+	return false
+}
+
+func order(calls []frame) []int {
+	ids := make([]int, len(calls))
+	for i, f := range calls {
+		ids[i] = f.entry.id
+	}
+	return ids
+}
+
+// pendingCalls lists the calls still in the list before the return e, the call of e among them, one
+// of them had to come next
+func pendingCalls(head, e *entry) []int {
+	var ids []int
+	for c := head.next; c != nil && c != e; c = c.next {
+		if c.match != nil {
+			ids = append(ids, c.id)
+		}
+	}
+	return ids
+}
+
+// makeEntries lists the calls and returns of history by time, a call before a return at the same time
+// so that operations touching at an instant count as concurrent
+func makeEntries(history []Operation) *entry {
+	entries := make([]*entry, 0, 2*len(history))
+	for i, op := range history {
+		ret := &entry{id: i, time: op.Return}
+		entries = append(entries, &entry{id: i, call: true, time: op.Call, match: ret}, ret)
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].time != entries[b].time {
+			return entries[a].time < entries[b].time
+		}
+		return entries[a].call && !entries[b].call
+	})
+	head := &entry{id: -1}
+	prev := head
+	for _, e := range entries {
+		prev.next, e.prev = e, prev
+		prev = e
+	}
+	return head
+}
+
+// lift takes a call and its return out of the list
+func lift(call *entry) {
+	call.prev.next = call.next
+	if call.next != nil {
+		call.next.prev = call.prev
+	}
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// unlift puts back a call and its return lifted last
+func unlift(call *entry) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	if call.next != nil {
+		call.next.prev = call
+	}
+}
+
+// Explain prints the outcome of a check of history
+func Explain(model Model, history []Operation, res Result) string {
+	describe := model.Describe
+	if describe == nil {
+		describe = func(input, output any) string { return fmt.Sprintf("%v -> %v", input, output) }
+	}
+	line := func(id int) string {
+		op := history[id]
+		ret := "pending"
+		if op.Return != Pending {
+			ret = fmt.Sprint(op.Return)
+		}
+		return fmt.Sprintf("  #%d client %d [%d, %s] %s\n", id, op.ClientID, op.Call, ret, describe(op.Input, op.Output))
+	}
+	var b strings.Builder
+	if res.Ok {
+		fmt.Fprintf(&b, "linearizable, %d operations\n", len(history))
+		return b.String()
+	}
+	fmt.Fprintf(&b, "not linearizable, %d of %d operations could be ordered:\n", len(res.Longest), len(history))
+	for _, id := range res.Longest {
+		b.WriteString(line(id))
+	}
+	b.WriteString("none of these could follow:\n")
+	for _, id := range res.Stuck {
+		b.WriteString(line(id))
+	}
+	return b.String()
+}
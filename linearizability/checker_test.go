@@ -0,0 +1,148 @@
+package linearizability
+
+import (
+	"strings"
+	"testing"
+)
+
+func deposit(client, wallet int, amount int64, call, ret int64) Operation {
+	return Operation{ClientID: client, Input: WalletInput{Kind: Deposit, Wallet: wallet, Amount: amount},
+		Output: WalletOutput{OK: true}, Call: call, Return: ret}
+}
+
+func transfer(client, from, to int, amount int64, ok bool, call, ret int64) Operation {
+	return Operation{ClientID: client, Input: WalletInput{Kind: Transfer, Wallet: from, To: to, Amount: amount},
+		Output: WalletOutput{OK: ok}, Call: call, Return: ret}
+}
+
+func balance(client, wallet int, read int64, call, ret int64) Operation {
+	return Operation{ClientID: client, Input: WalletInput{Kind: Balance, Wallet: wallet},
+		Output: WalletOutput{Balance: read}, Call: call, Return: ret}
+}
+
+// pending is op whose outcome the client never learned
+func pending(op Operation) Operation {
+	op.Output, op.Return = nil, Pending
+	return op
+}
+
+func TestCheckWalletHistories(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []Operation
+		want    bool
+	}{
+		{"empty", nil, true},
+		{"sequential", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			transfer(1, 1, 2, 40, true, 2, 3),
+			balance(2, 1, 60, 4, 5),
+			balance(2, 2, 40, 6, 7),
+		}, true},
+		{"stale read after an acknowledged transfer", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			transfer(1, 1, 2, 40, true, 2, 3),
+			balance(2, 1, 100, 4, 5),
+		}, false},
+		{"read concurrent with the transfer sees either balance", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			transfer(1, 1, 2, 40, true, 2, 8),
+			balance(2, 1, 100, 3, 4),
+			balance(3, 1, 60, 5, 6),
+		}, true},
+		{"reads going back in time", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			transfer(1, 1, 2, 40, true, 2, 8),
+			balance(2, 1, 60, 3, 4),
+			balance(3, 1, 100, 5, 6),
+		}, false},
+		{"transfer applied twice", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			transfer(1, 1, 2, 40, true, 2, 3),
+			balance(2, 2, 80, 4, 5),
+		}, false},
+		{"two withdrawals of the same funds", []Operation{
+			deposit(1, 1, 50, 0, 1),
+			transfer(1, 1, 2, 40, true, 2, 3),
+			transfer(2, 1, 3, 40, true, 4, 5),
+		}, false},
+		{"concurrent withdrawals, one rejected", []Operation{
+			deposit(1, 1, 50, 0, 1),
+			transfer(1, 1, 2, 40, true, 2, 6),
+			transfer(2, 1, 3, 40, false, 3, 5),
+			balance(3, 3, 0, 7, 8),
+		}, true},
+		{"transfer rejected with the funds available", []Operation{
+			deposit(1, 1, 50, 0, 1),
+			transfer(1, 1, 2, 40, false, 2, 3),
+		}, false},
+		{"deposit rejected", []Operation{
+			{ClientID: 1, Input: WalletInput{Kind: Deposit, Wallet: 1, Amount: 10}, Output: WalletOutput{OK: false},
+				Call: 0, Return: 1},
+		}, false},
+		{"unknown transfer applied late", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			pending(transfer(1, 1, 2, 40, true, 2, 0)),
+			balance(2, 2, 0, 3, 4),
+			balance(2, 2, 40, 5, 6),
+		}, true},
+		{"unknown transfer never applied", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			pending(transfer(1, 1, 2, 40, true, 2, 0)),
+			balance(2, 1, 100, 3, 4),
+		}, true},
+		{"unknown transfer undone", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			pending(transfer(1, 1, 2, 40, true, 2, 0)),
+			balance(2, 2, 40, 3, 4),
+			balance(2, 2, 0, 5, 6),
+		}, false},
+		{"read of a balance no history reaches", []Operation{
+			deposit(1, 1, 100, 0, 1),
+			deposit(2, 1, 50, 0, 1),
+			balance(3, 1, 120, 2, 3),
+		}, false},
+	}
+	model := WalletModel(3)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Check(model, tt.history)
+			if res.Ok != tt.want {
+				t.Fatalf("linearizable %v, want %v\n%s", res.Ok, tt.want, Explain(model, tt.history, res))
+			}
+			if res.Ok {
+				checkLinearization(t, model, tt.history, res.Linearization)
+				return
+			}
+			if len(res.Stuck) == 0 {
+				t.Fatal("no operation reported stuck")
+			}
+			if explained := Explain(model, tt.history, res); !strings.HasPrefix(explained, "not linearizable") {
+				t.Fatalf("explained as %s", explained)
+			}
+		})
+	}
+}
+
+// checkLinearization replays order on model and checks it holds every operation once and respects real
+// time
+func checkLinearization(t *testing.T, model Model, history []Operation, order []int) {
+	t.Helper()
+	if len(order) != len(history) {
+		t.Fatalf("linearization of %d operations out of %d", len(order), len(history))
+	}
+	state := model.Init()
+	placed := make(map[int]bool)
+	for _, id := range order {
+		for other, op := range history {
+			if !placed[other] && other != id && op.Return < history[id].Call {
+				t.Fatalf("#%d placed before #%d, which returned before its call", id, other)
+			}
+		}
+		ok, next := model.Step(state, history[id].Input, history[id].Output)
+		if !ok {
+			t.Fatalf("#%d does not follow the operations placed before it", id)
+		}
+		state, placed[id] = next, true
+	}
+}
@@ -0,0 +1,94 @@
+package linearizability
+
+import (
+	"fmt"
+	"slices"
+)
+
+// kinds of wallet operations
+const (
+	Deposit  = "deposit"
+	Transfer = "transfer"
+	Balance  = "balance"
+)
+
+// WalletInput is an operation on the wallets: a deposit to Wallet, a transfer from Wallet to To, or a
+// read of the balance of Wallet
+type WalletInput struct {
+	Kind   string
+	Wallet int
+	To     int
+	Amount int64
+}
+
+// WalletOutput is the outcome of a wallet operation: whether a deposit or a transfer was applied, or
+// the balance read
+type WalletOutput struct {
+	OK      bool
+	Balance int64
+}
+
+// WalletModel is a ledger of wallets 1 to wallets, all starting empty. A deposit always succeeds, a
+// transfer succeeds when the source holds the amount and changes nothing otherwise
+func WalletModel(wallets int) Model {
+	return Model{
+		Init: func() any {
+			return make([]int64, wallets+1)
+		},
+		Step: func(state, input, output any) (bool, any) {
+			balances := state.([]int64)
+			in := input.(WalletInput)
+			out, known := output.(WalletOutput)
+			switch in.Kind {
+			case Deposit:
+				if known && !out.OK {
+					return false, state
+				}
+				next := slices.Clone(balances)
+				next[in.Wallet] += in.Amount
+				return true, next
+			case Transfer:
+				funded := balances[in.Wallet] >= in.Amount
+				if known && out.OK != funded {
+					return false, state
+				}
+				if !funded {
+					return true, state
+				}
+				next := slices.Clone(balances)
+				next[in.Wallet] -= in.Amount
+				next[in.To] += in.Amount
+				return true, next
+			case Balance:
+				// a read without answer tells nothing
+				return !known || balances[in.Wallet] == out.Balance, state
+			}
+			return false, state
+		},
+		Equal: func(a, b any) bool {
+			return slices.Equal(a.([]int64), b.([]int64))
+		},
+		Describe: func(input, output any) string {
+			in := input.(WalletInput)
+			var op string
+			switch in.Kind {
+			case Transfer:
+				op = fmt.Sprintf("transfer %d from %d to %d", in.Amount, in.Wallet, in.To)
+			case Deposit:
+				op = fmt.Sprintf("deposit %d to %d", in.Amount, in.Wallet)
+			default:
+				op = fmt.Sprintf("balance of %d", in.Wallet)
+			}
+			out, known := output.(WalletOutput)
+			switch {
+			case !known:
+				return op + " -> unknown"
+			case in.Kind == Balance:
+				return fmt.Sprintf("%s -> %d", op, out.Balance)
+			case out.OK:
+				return op + " -> ok"
+			}
+			return op + " -> rejected"
+		},
+	}
+}
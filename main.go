@@ -1,160 +1,171 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"raft/api_server"
+	"raft/api_server/auth"
+	"raft/config"
+	"raft/datadir"
+	"raft/pii"
 	"raft/rpc_server"
 	"raft/state"
-	"sync"
+	"raft/wal"
+	"syscall"
 	"time"
 )
 
+// time left to the servers and nodes to stop once a signal is received
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	peers := []string{"9001", "9002", "9003"}
-	wg := sync.WaitGroup{}
-	wg.Add(3)
-	// create nodes
-	n1, err1 := state.NewNode("9001", peers)
-	if err1 != nil {
-		panic(err1)
-	}
-	n1APIServer := api_server.NewApiServer(n1)
-	if apiErr := n1APIServer.Run(":8001"); apiErr != nil {
-		panic(apiErr)
+	configPath := flag.String("config", "config.json", "path to the cluster configuration")
+	dataDir := flag.String("data-dir", "", "directory holding a data directory per node, named after the "+
+		"node. Without it the nodes keep their files side by side in the working directory")
+	flag.Parse()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		panic(err)
 	}
-
-	n1.PrintDetails()
-
-	n2, err2 := state.NewNode("9002", peers)
-	if err2 != nil {
-		panic(err2)
+	if err := auth.Init(cfg.Auth); err != nil {
+		panic(err)
 	}
-	n2APIServer := api_server.NewApiServer(n2)
-	if apiErr := n2APIServer.Run(":8002"); apiErr != nil {
-		panic(apiErr)
+	if err := pii.Init(cfg.PII); err != nil {
+		panic(err)
 	}
-	n2.PrintDetails()
-
-	n3, err3 := state.NewNode("9003", peers)
-	if err3 != nil {
-		panic(err3)
+	var bootstrapHash string
+	if b := cfg.BootstrapAdmin; b != nil {
+		if bootstrapHash, err = auth.HashPassword(b.Password); err != nil {
+			panic(err)
+		}
 	}
-	n3APIServer := api_server.NewApiServer(n3)
-	if apiErr := n3APIServer.Run(":8003"); apiErr != nil {
-		panic(apiErr)
+	configure := func(n *state.Node) {
+		n.SetSnapshotInterval(cfg.SnapshotInterval)
+		n.SetTiming(state.Timing{
+			ElectionTimeoutMin: time.Duration(cfg.Raft.ElectionTimeoutMinMS) * time.Millisecond,
+			ElectionTimeoutMax: time.Duration(cfg.Raft.ElectionTimeoutMaxMS) * time.Millisecond,
+			HeartbeatInterval:  time.Duration(cfg.Raft.HeartbeatIntervalMS) * time.Millisecond,
+			RPCTimeout:         time.Duration(cfg.Raft.RPCTimeoutMS) * time.Millisecond,
+		})
+		n.SetPendingLimit(cfg.RateLimit.MaxPendingPerUser, time.Duration(cfg.RateLimit.PendingTTLSeconds)*time.Second)
+		if b := cfg.BootstrapAdmin; b != nil {
+			n.SetBootstrapAdmin(b.FirstName, b.LastName, b.Email, bootstrapHash)
+		}
+		n.SetPasswordHasher(auth.HashPassword)
 	}
-	n3.PrintDetails()
-
-	go rpc_server.StartRPCServerListener(n1, &wg)
-	go rpc_server.StartRPCServerListener(n2, &wg)
-	go rpc_server.StartRPCServerListener(n3, &wg)
-
-	//start various timers
-	n1.StartTimer(&wg)
-	n2.StartTimer(&wg)
-	n3.StartTimer(&wg)
 
-	go func() {
-		for {
-			select {
-			case <-n1.StartElectionChan:
-				n1.BeginElection()
-			}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	nodes := make([]*state.Node, 0, len(cfg.Nodes))
+	apiServers := make([]*api_server.APIServer, 0, len(cfg.Nodes))
+	listeners := make([]*rpc_server.Listener, 0, len(cfg.Nodes))
+	debugServers := make([]*api_server.DebugServer, 0, len(cfg.Nodes))
+	// nodes started together belong to the same cluster, the first one tells which when the
+	// configuration does not
+	clusterID := cfg.ClusterID
+	// create nodes, their data directories are written by cmd/bootstrap
+	openLog := logOpener(cfg.Raft.LogBackend)
+	for _, node := range cfg.Nodes {
+		n, err := openNode(node, *configPath, *dataDir, clusterID, openLog)
+		if errors.Is(err, datadir.ErrNotBootstrapped) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	}()
-	go func() {
-		for {
-			select {
-			case <-n2.StartElectionChan:
-				n2.BeginElection()
-			}
+		if err != nil {
+			panic(err)
 		}
-	}()
-	go func() {
-		for {
-			select {
-			case <-n3.StartElectionChan:
-				n3.BeginElection()
+		clusterID = n.ClusterID
+		configure(n)
+		if cfg.Debug.FaultInjection {
+			faults, err := n.EnableFaults()
+			if err != nil {
+				panic(err)
 			}
-		}
-	}()
-
-	// Wait to become leader and erform leader duties
-	go func() {
-		for {
-			select {
-			case <-n1.BecomeLeaderChan:
-				fmt.Printf("%s became leader. Starting heartbeat loop.\n", n1.Address)
-
-			heartbeatLoop:
-				for {
-					select {
-					default:
-						time.Sleep(300 * time.Millisecond)
-						n1.AppendEntry()
-					case <-n1.RevertToFollowerChan:
-						fmt.Printf("Reverting %v to follower\n", n1.Address)
-						n1.ResetTimerChan <- true
-						break heartbeatLoop
-					}
-				}
-
-			case <-n1.RevertToFollowerChan:
-				// fallback just in case Revert is received when not leader
-				n1.ResetTimerChan <- true
+			debugServer := api_server.NewDebugServer(faults)
+			// without authentication, the debug address must never be reachable from outside
+			if err := debugServer.Run(node.Debug); err != nil {
+				panic(err)
 			}
+			fmt.Printf("fault injection enabled on %s\n", node.Debug)
+			debugServers = append(debugServers, debugServer)
 		}
-	}()
-	go func() {
-		for {
-			select {
-			case <-n2.BecomeLeaderChan:
-				fmt.Printf("%s became leader. Starting heartbeat loop.\n", n2.Address)
-			heartbeatLoop:
-				for {
-					select {
-					default:
-						time.Sleep(300 * time.Millisecond)
-						n2.AppendEntry()
-
-					case <-n2.RevertToFollowerChan:
-						fmt.Printf("Reverting %v to follower\n", n2.Address)
-						n2.ResetTimerChan <- true
-						break heartbeatLoop
-					}
-				}
-
-			case <-n2.RevertToFollowerChan:
-				// fallback just in case Revert is received when not leader
-				n2.ResetTimerChan <- true
-			}
+		apiServer := api_server.NewApiServer(n, cfg)
+		if apiErr := apiServer.Run(node.API); apiErr != nil {
+			panic(apiErr)
 		}
-	}()
-
-	go func() {
-		for {
-			select {
-			case <-n3.BecomeLeaderChan:
-				fmt.Printf("%s became leader. Starting heartbeat loop.\n", n3.Address)
+		n.PrintDetails()
+		nodes = append(nodes, n)
+		apiServers = append(apiServers, apiServer)
+	}
+	for _, n := range nodes {
+		listener, err := rpc_server.Listen(n)
+		if err != nil {
+			panic(err)
+		}
+		listeners = append(listeners, listener)
+	}
+	//start various timers, they run until the nodes are stopped
+	for _, n := range nodes {
+		n.Start(context.Background())
+	}
 
-			heartbeatLoop:
-				for {
-					select {
-					default:
-						time.Sleep(300 * time.Millisecond)
-						n3.AppendEntry()
-					case <-n3.RevertToFollowerChan:
-						fmt.Printf("Reverting %v to follower\n", n3.Address)
-						n3.ResetTimerChan <- true
-						break heartbeatLoop
-					}
-				}
+	<-ctx.Done()
+	stop()
+	fmt.Println("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// no write gets in once the api servers are down, the leader then replicates what is queued while
+	// the followers still answer its rpcs
+	for _, apiServer := range apiServers {
+		if err := apiServer.Shutdown(shutdownCtx); err != nil {
+			fmt.Println("api server shutdown:", err)
+		}
+	}
+	for _, debugServer := range debugServers {
+		if err := debugServer.Shutdown(shutdownCtx); err != nil {
+			fmt.Println("debug server shutdown:", err)
+		}
+	}
+	for _, n := range nodes {
+		if err := n.Stop(shutdownCtx); err != nil {
+			fmt.Println(err)
+		}
+	}
+	for _, listener := range listeners {
+		listener.Stop(shutdownCtx)
+	}
+	for _, n := range nodes {
+		if err := n.Close(); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
 
-			case <-n3.RevertToFollowerChan:
-				// fallback just in case Revert is received when not leader
-				n3.ResetTimerChan <- true
-			}
+// openNode opens the node of the configuration. A data directory never bootstrapped, such as the files
+// nodes kept side by side before they had an id, is refused with the command that bootstraps it
+func openNode(node config.NodeConfig, configPath, dataDir, clusterID string, openLog state.LogOpener) (*state.Node, error) {
+	n, err := state.OpenNode(datadir.Of(dataDir, node.Name), clusterID, node.Address, openLog)
+	if errors.Is(err, datadir.ErrNotBootstrapped) {
+		command := "go run ./cmd/bootstrap -config " + configPath
+		if dataDir != "" {
+			command += " -data-dir " + dataDir
 		}
-	}()
-	wg.Wait()
+		return nil, fmt.Errorf("%w, with %s, which keeps the existing logs", err, command)
+	}
+	return n, err
+}
+
+// logOpener opens the log store of the configured backend, nil for the sqlite log
+func logOpener(backend string) state.LogOpener {
+	if backend != config.LogBackendWAL {
+		return nil
+	}
+	return func(layout datadir.Layout) (state.LogStore, error) {
+		return wal.Open(layout.WAL, wal.Options{})
+	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"raft/config"
+	"raft/datadir"
+	"raft/state"
+
+	"gorm.io/gorm/logger"
+)
+
+func TestOpenNodeNamesTheBootstrap(t *testing.T) {
+	logger.Default = logger.Discard
+	root := t.TempDir()
+	// the log of a node that ran before nodes had an id, beside those of the other nodes
+	ps, err := state.InitPersistentState(datadir.Flat(root, "9001").Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.AdvanceTerm(4); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	node := config.NodeConfig{Name: "9001", Address: "9001"}
+	for dataDir, command := range map[string]string{
+		"":                          "go run ./cmd/bootstrap -config config.json,",
+		filepath.Join(root, "data"): "go run ./cmd/bootstrap -config config.json -data-dir " + filepath.Join(root, "data"),
+	} {
+		_, err := openNode(node, "config.json", dataDir, "", nil)
+		if !errors.Is(err, datadir.ErrNotBootstrapped) || !strings.Contains(err.Error(), command) {
+			t.Fatalf("node of data dir %q: %v, want the bootstrap command %q", dataDir, err, command)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// field level envelope encryption of the personal data kept in the log and in the state machine
+package pii
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"raft/config"
+)
+
+// sealed values look like pii:v1:<key id>:<wrapped data key>:<ciphertext>, anything else is clear text
+const prefix = "pii:v1:"
+
+type keyring struct {
+	active   string
+	keys     map[string][]byte // master keys wrapping the data keys, by id
+	indexKey []byte
+}
+
+var ring *keyring
+
+// Init loads the master keys, it must run once before the databases are opened. Without keys
+// the personal data is kept in clear
+func Init(cfg config.PIIConfig) error {
+	if cfg.KeyFile != "" {
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read pii key file %s: %w", cfg.KeyFile, err)
+		}
+		cfg = config.PIIConfig{}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("invalid pii key file: %w", err)
+		}
+	}
+	if len(cfg.Keys) == 0 {
+		fmt.Println("no pii keys configured, personal data is stored in clear")
+		ring = nil
+		return nil
+	}
+	r := &keyring{active: cfg.ActiveKey, keys: make(map[string][]byte)}
+	for id, encoded := range cfg.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return fmt.Errorf("invalid pii key id %q", id)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return fmt.Errorf("pii key %s: %w", id, err)
+		}
+		r.keys[id] = key
+	}
+	if _, ok := r.keys[r.active]; !ok {
+		return fmt.Errorf("active pii key %q is not in the keyring", r.active)
+	}
+	indexKey, err := decodeKey(cfg.IndexKey)
+	if err != nil {
+		return fmt.Errorf("pii index key: %w", err)
+	}
+	r.indexKey = indexKey
+	ring = r
+	return nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("keys must be base64 encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("keys must be 32 bytes long, got %d", len(key))
+	}
+	return key, nil
+}
+
+// Enabled reports whether personal data is encrypted
+func Enabled() bool {
+	return ring != nil
+}
+
+// IsSealed reports whether value was produced by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts value under a fresh data key wrapped by the active master key, empty values are kept empty
+func Seal(value string) (string, error) {
+	if ring == nil || value == "" || IsSealed(value) {
+		return value, nil
+	}
+	return seal([]byte(value))
+}
+
+// Open decrypts a sealed value, clear text written before encryption was enabled is returned as is
+func Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	plaintext, err := open(value)
+	return string(plaintext), err
+}
+
+// SealBytes is Seal for binary content such as documents
+func SealBytes(data []byte) ([]byte, error) {
+	if ring == nil {
+		return data, nil
+	}
+	sealed, err := seal(data)
+	return []byte(sealed), err
+}
+
+// OpenBytes is Open for binary content, content stored in clear is returned as is
+func OpenBytes(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return data, nil
+	}
+	return open(string(data))
+}
+
+func seal(plaintext []byte) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := encrypt(ring.keys[ring.active], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := encrypt(dataKey, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return prefix + ring.active + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+func open(value string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed sealed value")
+	}
+	if ring == nil {
+		return nil, fmt.Errorf("sealed value found but no pii key is configured")
+	}
+	masterKey, ok := ring.keys[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown pii key %s", parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed data key: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	dataKey, err := decrypt(masterKey, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := decrypt(dataKey, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// NeedsReseal reports whether a stored value is in clear or sealed with a retired key
+func NeedsReseal(value string) bool {
+	if ring == nil {
+		return false
+	}
+	if !IsSealed(value) {
+		return value != ""
+	}
+	return !strings.HasPrefix(value, prefix+ring.active+":")
+}
+
+// BlindIndex returns a deterministic digest of value, unique constraints and lookups use it
+// in place of the sealed value
+func BlindIndex(value string) string {
+	if ring == nil {
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, ring.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IndexKeyID identifies the key blind indexes are computed with, the stored ones must be computed again
+// when it changes
+func IndexKeyID() string {
+	if ring == nil {
+		return "sha256"
+	}
+	mac := hmac.New(sha256.New, ring.indexKey)
+	mac.Write([]byte("index key id"))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// encrypt returns the nonce followed by the AES-GCM ciphertext
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,236 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"raft/config"
+)
+
+// key returns a 32 byte key made of b, base64 encoded
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// initKeys loads the keys of ids, active is the active one
+func initKeys(t *testing.T, active string, ids ...string) {
+	t.Helper()
+	keys := make(map[string]string)
+	for i, id := range ids {
+		keys[id] = key(byte(i + 1))
+	}
+	if err := Init(config.PIIConfig{ActiveKey: active, Keys: keys, IndexKey: key(0xff)}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ring = nil })
+}
+
+func TestSealAndOpen(t *testing.T) {
+	ring = nil
+	if sealed, _ := Seal("ada@test.invalid"); sealed != "ada@test.invalid" {
+		t.Fatalf("sealed %q without keys", sealed)
+	}
+	initKeys(t, "k1", "k1")
+	sealed, err := Seal("ada@test.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "ada") {
+		t.Fatalf("sealed as %q", sealed)
+	}
+	if again, _ := Seal("ada@test.invalid"); again == sealed {
+		t.Fatal("the same value sealed twice gives the same ciphertext")
+	}
+	if resealed, _ := Seal(sealed); resealed != sealed {
+		t.Fatal("a sealed value was sealed again")
+	}
+	if opened, err := Open(sealed); err != nil || opened != "ada@test.invalid" {
+		t.Fatalf("opened %q, %v", opened, err)
+	}
+	// clear text written before encryption was enabled
+	if opened, err := Open("bob@test.invalid"); err != nil || opened != "bob@test.invalid" {
+		t.Fatalf("opened clear text as %q, %v", opened, err)
+	}
+	if empty, _ := Seal(""); empty != "" {
+		t.Fatalf("sealed an empty value as %q", empty)
+	}
+
+	document := []byte("%PDF passport")
+	sealedDoc, err := SealBytes(document)
+	if err != nil || bytes.Contains(sealedDoc, []byte("passport")) {
+		t.Fatalf("sealed document %q, %v", sealedDoc, err)
+	}
+	if opened, err := OpenBytes(sealedDoc); err != nil || !bytes.Equal(opened, document) {
+		t.Fatalf("opened document %q, %v", opened, err)
+	}
+}
+
+func TestOpenRefusesTamperedValues(t *testing.T) {
+	initKeys(t, "k1", "k1")
+	sealed, err := Seal("ada@test.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a character in the middle of the ciphertext, the last one may only carry padding bits
+	mid := len(sealed) - 8
+	flipped := byte('A')
+	if sealed[mid] == 'A' {
+		flipped = 'B'
+	}
+	tests := map[string]string{
+		"ciphertext changed": sealed[:mid] + string(flipped) + sealed[mid+1:],
+		"unknown key":        strings.Replace(sealed, prefix+"k1:", prefix+"k9:", 1),
+		"malformed":          prefix + "k1:abc",
+	}
+	for name, value := range tests {
+		if _, err := Open(value); err == nil {
+			t.Errorf("%s: opened", name)
+		}
+	}
+	ring = nil
+	if _, err := Open(sealed); err == nil {
+		t.Fatal("opened a sealed value without keys")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	initKeys(t, "k1", "k1")
+	old, _ := Seal("ada@test.invalid")
+	if NeedsReseal(old) {
+		t.Fatal("a value sealed with the active key needs a reseal")
+	}
+	initKeys(t, "k2", "k1", "k2")
+	if !NeedsReseal(old) || !NeedsReseal("clear") || NeedsReseal("") {
+		t.Fatal("values of a retired key or in clear need a reseal, empty ones do not")
+	}
+	if opened, err := Open(old); err != nil || opened != "ada@test.invalid" {
+		t.Fatalf("value of the retired key opened as %q, %v", opened, err)
+	}
+	current, _ := Seal("ada@test.invalid")
+	if NeedsReseal(current) || !strings.HasPrefix(current, prefix+"k2:") {
+		t.Fatalf("sealed as %q after the rotation", current)
+	}
+	// the retired key dropped from the keyring too early
+	initKeys(t, "k2", "k2")
+	if _, err := Open(old); err == nil {
+		t.Fatal("opened a value of a key no longer in the keyring")
+	}
+}
+
+func TestReseal(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "pii.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE TABLE people (id INTEGER PRIMARY KEY, email TEXT, name TEXT)").Error; err != nil {
+		t.Fatal(err)
+	}
+	initKeys(t, "k1", "k1")
+	old, _ := Seal("ada@test.invalid")
+	initKeys(t, "k2", "k1", "k2")
+	current, _ := Seal("Bob")
+	rows := [][]any{{1, "clear@test.invalid", ""}, {2, old, current}, {3, "", current}}
+	for _, row := range rows {
+		if err := db.Exec("INSERT INTO people VALUES (?, ?, ?)", row...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	resealed, err := Reseal(db, "people", "id", []string{"email", "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resealed != 2 {
+		t.Fatalf("resealed %d rows, want the rows in clear or of the retired key", resealed)
+	}
+	var stored []struct {
+		ID          int
+		Email, Name string
+	}
+	if err := db.Raw("SELECT id, email, name FROM people ORDER BY id").Scan(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"clear@test.invalid", "ada@test.invalid", ""}
+	for i, row := range stored {
+		if NeedsReseal(row.Email) || NeedsReseal(row.Name) {
+			t.Fatalf("row %d still needs a reseal: %+v", row.ID, row)
+		}
+		if email, _ := Open(row.Email); email != want[i] {
+			t.Fatalf("row %d holds %q, want %q", row.ID, email, want[i])
+		}
+	}
+	if stored[1].Name != current {
+		t.Fatal("a value of the active key was sealed again")
+	}
+}
+
+func TestSerializer(t *testing.T) {
+	type person struct {
+		ID    int
+		Email string    `gorm:"serializer:pii;type:text"`
+		Born  time.Time `gorm:"serializer:pii;type:text"`
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "pii.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&person{}); err != nil {
+		t.Fatal(err)
+	}
+	initKeys(t, "k1", "k1")
+	born := time.Date(1815, 12, 10, 0, 0, 0, 0, time.UTC)
+	if err := db.Create(&person{ID: 1, Email: "ada@test.invalid", Born: born}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var raw struct{ Email, Born string }
+	if err := db.Raw("SELECT email, born FROM people WHERE id = 1").Scan(&raw).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(raw.Email) || !IsSealed(raw.Born) {
+		t.Fatalf("stored in clear: %+v", raw)
+	}
+	var got person
+	if err := db.First(&got, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "ada@test.invalid" || !got.Born.Equal(born) {
+		t.Fatalf("read %+v", got)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	initKeys(t, "k1", "k1")
+	index := BlindIndex("ada@test.invalid")
+	if index != BlindIndex("ada@test.invalid") || index == BlindIndex("bob@test.invalid") {
+		t.Fatal("the blind index is not a function of the value")
+	}
+	if err := Init(config.PIIConfig{ActiveKey: "k1", Keys: map[string]string{"k1": key(1)}, IndexKey: key(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if BlindIndex("ada@test.invalid") == index {
+		t.Fatal("the blind index does not depend on the index key")
+	}
+}
+
+func TestInitRejectsBadKeys(t *testing.T) {
+	t.Cleanup(func() { ring = nil })
+	tests := map[string]config.PIIConfig{
+		"short key":         {ActiveKey: "k1", Keys: map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))}, IndexKey: key(2)},
+		"not base64":        {ActiveKey: "k1", Keys: map[string]string{"k1": "***"}, IndexKey: key(2)},
+		"active key absent": {ActiveKey: "k2", Keys: map[string]string{"k1": key(1)}, IndexKey: key(2)},
+		"id with a colon":   {ActiveKey: "k:1", Keys: map[string]string{"k:1": key(1)}, IndexKey: key(2)},
+		"no index key":      {ActiveKey: "k1", Keys: map[string]string{"k1": key(1)}},
+	}
+	for name, cfg := range tests {
+		if err := Init(cfg); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
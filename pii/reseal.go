@@ -0,0 +1,46 @@
+package pii
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Reseal seals again, under the active key, the given columns of the rows of table selected by db that are
+// still in clear or sealed with a retired key. It returns the number of rows rewritten
+func Reseal(db *gorm.DB, table, primaryKey string, columns []string) (int, error) {
+	if ring == nil {
+		return 0, nil
+	}
+	// rows are read through the table, not the model, so the sealed values are not opened by the serializer
+	var rows []map[string]interface{}
+	if err := db.Table(table).Select(append([]string{primaryKey}, columns...)).Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("failed to read sealed columns: %w", err)
+	}
+	resealed := 0
+	for _, row := range rows {
+		updates := make(map[string]interface{})
+		for _, column := range columns {
+			stored, ok := row[column].(string)
+			if !ok || !NeedsReseal(stored) {
+				continue
+			}
+			value, err := Open(stored)
+			if err != nil {
+				return resealed, fmt.Errorf("failed to open %s: %w", column, err)
+			}
+			if updates[column], err = Seal(value); err != nil {
+				return resealed, err
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := db.Session(&gorm.Session{NewDB: true}).Table(table).
+			Where(primaryKey+" = ?", row[primaryKey]).UpdateColumns(updates).Error; err != nil {
+			return resealed, fmt.Errorf("failed to reseal row %v: %w", row[primaryKey], err)
+		}
+		resealed++
+	}
+	return resealed, nil
+}
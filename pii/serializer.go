@@ -0,0 +1,107 @@
+package pii
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+// Serializer seals string and time fields tagged `gorm:"serializer:pii"` on write and opens them on read,
+// the models keep handling clear values
+type Serializer struct{}
+
+func init() {
+	schema.RegisterSerializer("pii", Serializer{})
+}
+
+// layouts of the dates written before encryption, the sqlite driver stores times as text
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// FormatTime is the clear form of a sealed date
+func FormatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// ParseTime reads a date written by FormatTime or by the sqlite driver
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+		return nil
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	case time.Time:
+		// column not yet migrated to text
+		stored = FormatTime(v)
+	default:
+		return fmt.Errorf("unsupported pii value %T for %s", dbValue, field.Name)
+	}
+	value, err := Open(stored)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", field.Name, err)
+	}
+
+	fieldValue := reflect.New(field.FieldType)
+	target := fieldValue.Elem()
+	if target.Kind() == reflect.Ptr {
+		target.Set(reflect.New(target.Type().Elem()))
+		target = target.Elem()
+	}
+	switch target.Interface().(type) {
+	case string:
+		target.SetString(value)
+	case time.Time:
+		t, err := ParseTime(value)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", field.Name, err)
+		}
+		target.Set(reflect.ValueOf(t))
+	default:
+		return fmt.Errorf("unsupported pii field type %s for %s", field.FieldType, field.Name)
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	v := reflect.ValueOf(fieldValue)
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	var value string
+	switch t := v.Interface().(type) {
+	case string:
+		value = t
+	case time.Time:
+		value = FormatTime(t)
+	default:
+		return nil, fmt.Errorf("unsupported pii field type %T for %s", fieldValue, field.Name)
+	}
+	return Seal(value)
+}
@@ -23,13 +23,15 @@ const (
 )
 
 type RequestVoteRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Term          int32                  `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
-	CandidateId   string                 `protobuf:"bytes,2,opt,name=candidateId,proto3" json:"candidateId,omitempty"`
-	LastLogIndex  int32                  `protobuf:"varint,3,opt,name=lastLogIndex,proto3" json:"lastLogIndex,omitempty"`
-	LastLogTerm   int32                  `protobuf:"varint,4,opt,name=lastLogTerm,proto3" json:"lastLogTerm,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Term             int32                  `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	CandidateId      string                 `protobuf:"bytes,2,opt,name=candidateId,proto3" json:"candidateId,omitempty"`
+	LastLogIndex     int32                  `protobuf:"varint,3,opt,name=lastLogIndex,proto3" json:"lastLogIndex,omitempty"`
+	LastLogTerm      int32                  `protobuf:"varint,4,opt,name=lastLogTerm,proto3" json:"lastLogTerm,omitempty"`
+	ClusterId        string                 `protobuf:"bytes,5,opt,name=clusterId,proto3" json:"clusterId,omitempty"`               // cluster of the candidate, a node of another cluster refuses the rpc
+	CandidateAddress string                 `protobuf:"bytes,6,opt,name=candidateAddress,proto3" json:"candidateAddress,omitempty"` // where the peers reach the candidate, it may have moved since they last did
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *RequestVoteRequest) Reset() {
@@ -90,6 +92,20 @@ func (x *RequestVoteRequest) GetLastLogTerm() int32 {
 	return 0
 }
 
+func (x *RequestVoteRequest) GetClusterId() string {
+	if x != nil {
+		return x.ClusterId
+	}
+	return ""
+}
+
+func (x *RequestVoteRequest) GetCandidateAddress() string {
+	if x != nil {
+		return x.CandidateAddress
+	}
+	return ""
+}
+
 type RequestVoteResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Term          int32                  `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
@@ -158,6 +174,9 @@ type UserPayload struct {
 	UserID                   int64                  `protobuf:"varint,11,opt,name=userID,proto3" json:"userID,omitempty"`
 	Action                   string                 `protobuf:"bytes,12,opt,name=action,proto3" json:"action,omitempty"` // create, update, delete
 	PollID                   string                 `protobuf:"bytes,13,opt,name=PollID,proto3" json:"PollID,omitempty"`
+	SweepWalletID            int64                  `protobuf:"varint,14,opt,name=sweepWalletID,proto3" json:"sweepWalletID,omitempty"`
+	SealedDateOfBirth        string                 `protobuf:"bytes,15,opt,name=sealedDateOfBirth,proto3" json:"sealedDateOfBirth,omitempty"` // replaces dateOfBirth once personal data is sealed on the wire
+	Time                     *timestamppb.Timestamp `protobuf:"bytes,16,opt,name=time,proto3" json:"time,omitempty"`
 	unknownFields            protoimpl.UnknownFields
 	sizeCache                protoimpl.SizeCache
 }
@@ -283,18 +302,53 @@ func (x *UserPayload) GetPollID() string {
 	return ""
 }
 
+func (x *UserPayload) GetSweepWalletID() int64 {
+	if x != nil {
+		return x.SweepWalletID
+	}
+	return 0
+}
+
+func (x *UserPayload) GetSealedDateOfBirth() string {
+	if x != nil {
+		return x.SealedDateOfBirth
+	}
+	return ""
+}
+
+func (x *UserPayload) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
 type AdminPayload struct {
-	state          protoimpl.MessageState `protogen:"open.v1"`
-	FirstName      string                 `protobuf:"bytes,1,opt,name=firstName,proto3" json:"firstName,omitempty"`
-	LastName       string                 `protobuf:"bytes,2,opt,name=lastName,proto3" json:"lastName,omitempty"`
-	HashedPassword string                 `protobuf:"bytes,3,opt,name=hashedPassword,proto3" json:"hashedPassword,omitempty"`
-	Email          string                 `protobuf:"bytes,4,opt,name=email,proto3" json:"email,omitempty"`
-	AdminID        int64                  `protobuf:"varint,5,opt,name=adminID,proto3" json:"adminID,omitempty"`
-	UserId         int64                  `protobuf:"varint,6,opt,name=userId,proto3" json:"userId,omitempty"`
-	Action         string                 `protobuf:"bytes,7,opt,name=action,proto3" json:"action,omitempty"`
-	PollID         string                 `protobuf:"bytes,8,opt,name=PollID,proto3" json:"PollID,omitempty"`
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	FirstName           string                 `protobuf:"bytes,1,opt,name=firstName,proto3" json:"firstName,omitempty"`
+	LastName            string                 `protobuf:"bytes,2,opt,name=lastName,proto3" json:"lastName,omitempty"`
+	HashedPassword      string                 `protobuf:"bytes,3,opt,name=hashedPassword,proto3" json:"hashedPassword,omitempty"`
+	Email               string                 `protobuf:"bytes,4,opt,name=email,proto3" json:"email,omitempty"`
+	AdminID             int64                  `protobuf:"varint,5,opt,name=adminID,proto3" json:"adminID,omitempty"`
+	UserId              int64                  `protobuf:"varint,6,opt,name=userId,proto3" json:"userId,omitempty"`
+	Action              string                 `protobuf:"bytes,7,opt,name=action,proto3" json:"action,omitempty"`
+	PollID              string                 `protobuf:"bytes,8,opt,name=PollID,proto3" json:"PollID,omitempty"`
+	FeeAction           string                 `protobuf:"bytes,9,opt,name=feeAction,proto3" json:"feeAction,omitempty"`
+	FeeKind             string                 `protobuf:"bytes,10,opt,name=feeKind,proto3" json:"feeKind,omitempty"`
+	FeeFlat             int64                  `protobuf:"varint,11,opt,name=feeFlat,proto3" json:"feeFlat,omitempty"`
+	FeeBasisPoints      int64                  `protobuf:"varint,12,opt,name=feeBasisPoints,proto3" json:"feeBasisPoints,omitempty"`
+	FeeTiers            []*FeeTier             `protobuf:"bytes,13,rep,name=feeTiers,proto3" json:"feeTiers,omitempty"`
+	WalletID            int64                  `protobuf:"varint,14,opt,name=walletID,proto3" json:"walletID,omitempty"`
+	Tier                string                 `protobuf:"bytes,15,opt,name=tier,proto3" json:"tier,omitempty"`
+	LimitScope          string                 `protobuf:"bytes,16,opt,name=limitScope,proto3" json:"limitScope,omitempty"`
+	LimitPerTransaction int64                  `protobuf:"varint,17,opt,name=limitPerTransaction,proto3" json:"limitPerTransaction,omitempty"`
+	LimitDaily          int64                  `protobuf:"varint,18,opt,name=limitDaily,proto3" json:"limitDaily,omitempty"`
+	LimitMonthly        int64                  `protobuf:"varint,19,opt,name=limitMonthly,proto3" json:"limitMonthly,omitempty"`
+	Role                string                 `protobuf:"bytes,20,opt,name=role,proto3" json:"role,omitempty"`
+	TargetAdminID       int64                  `protobuf:"varint,21,opt,name=targetAdminID,proto3" json:"targetAdminID,omitempty"`
+	Time                *timestamppb.Timestamp `protobuf:"bytes,22,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
 }
 
 func (x *AdminPayload) Reset() {
@@ -383,20 +437,186 @@ func (x *AdminPayload) GetPollID() string {
 	return ""
 }
 
-type WalletOperationPayload struct {
+func (x *AdminPayload) GetFeeAction() string {
+	if x != nil {
+		return x.FeeAction
+	}
+	return ""
+}
+
+func (x *AdminPayload) GetFeeKind() string {
+	if x != nil {
+		return x.FeeKind
+	}
+	return ""
+}
+
+func (x *AdminPayload) GetFeeFlat() int64 {
+	if x != nil {
+		return x.FeeFlat
+	}
+	return 0
+}
+
+func (x *AdminPayload) GetFeeBasisPoints() int64 {
+	if x != nil {
+		return x.FeeBasisPoints
+	}
+	return 0
+}
+
+func (x *AdminPayload) GetFeeTiers() []*FeeTier {
+	if x != nil {
+		return x.FeeTiers
+	}
+	return nil
+}
+
+func (x *AdminPayload) GetWalletID() int64 {
+	if x != nil {
+		return x.WalletID
+	}
+	return 0
+}
+
+func (x *AdminPayload) GetTier() string {
+	if x != nil {
+		return x.Tier
+	}
+	return ""
+}
+
+func (x *AdminPayload) GetLimitScope() string {
+	if x != nil {
+		return x.LimitScope
+	}
+	return ""
+}
+
+func (x *AdminPayload) GetLimitPerTransaction() int64 {
+	if x != nil {
+		return x.LimitPerTransaction
+	}
+	return 0
+}
+
+func (x *AdminPayload) GetLimitDaily() int64 {
+	if x != nil {
+		return x.LimitDaily
+	}
+	return 0
+}
+
+func (x *AdminPayload) GetLimitMonthly() int64 {
+	if x != nil {
+		return x.LimitMonthly
+	}
+	return 0
+}
+
+func (x *AdminPayload) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+func (x *AdminPayload) GetTargetAdminID() int64 {
+	if x != nil {
+		return x.TargetAdminID
+	}
+	return 0
+}
+
+func (x *AdminPayload) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+type FeeTier struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Wallet1       int64                  `protobuf:"varint,1,opt,name=wallet1,proto3" json:"wallet1,omitempty"`
-	Wallet2       int64                  `protobuf:"varint,2,opt,name=wallet2,proto3" json:"wallet2,omitempty"`
-	Amount        int64                  `protobuf:"varint,3,opt,name=amount,proto3" json:"amount,omitempty"`
-	Action        string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
-	PollID        string                 `protobuf:"bytes,5,opt,name=PollID,proto3" json:"PollID,omitempty"`
+	UpTo          int64                  `protobuf:"varint,1,opt,name=upTo,proto3" json:"upTo,omitempty"`
+	Flat          int64                  `protobuf:"varint,2,opt,name=flat,proto3" json:"flat,omitempty"`
+	BasisPoints   int64                  `protobuf:"varint,3,opt,name=basisPoints,proto3" json:"basisPoints,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
+func (x *FeeTier) Reset() {
+	*x = FeeTier{}
+	mi := &file_raft_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FeeTier) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeeTier) ProtoMessage() {}
+
+func (x *FeeTier) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeeTier.ProtoReflect.Descriptor instead.
+func (*FeeTier) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FeeTier) GetUpTo() int64 {
+	if x != nil {
+		return x.UpTo
+	}
+	return 0
+}
+
+func (x *FeeTier) GetFlat() int64 {
+	if x != nil {
+		return x.Flat
+	}
+	return 0
+}
+
+func (x *FeeTier) GetBasisPoints() int64 {
+	if x != nil {
+		return x.BasisPoints
+	}
+	return 0
+}
+
+type WalletOperationPayload struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Wallet1           int64                  `protobuf:"varint,1,opt,name=wallet1,proto3" json:"wallet1,omitempty"`
+	Wallet2           int64                  `protobuf:"varint,2,opt,name=wallet2,proto3" json:"wallet2,omitempty"`
+	Amount            int64                  `protobuf:"varint,3,opt,name=amount,proto3" json:"amount,omitempty"`
+	Action            string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
+	PollID            string                 `protobuf:"bytes,5,opt,name=PollID,proto3" json:"PollID,omitempty"`
+	ScheduleID        int64                  `protobuf:"varint,6,opt,name=scheduleID,proto3" json:"scheduleID,omitempty"`
+	Occurrence        int64                  `protobuf:"varint,7,opt,name=occurrence,proto3" json:"occurrence,omitempty"`
+	Attempt           int64                  `protobuf:"varint,8,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Interval          string                 `protobuf:"bytes,9,opt,name=interval,proto3" json:"interval,omitempty"`
+	StartAt           *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=startAt,proto3" json:"startAt,omitempty"`
+	MaxRetries        int64                  `protobuf:"varint,11,opt,name=maxRetries,proto3" json:"maxRetries,omitempty"`
+	RetryDelaySeconds int64                  `protobuf:"varint,12,opt,name=retryDelaySeconds,proto3" json:"retryDelaySeconds,omitempty"`
+	Time              *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
 func (x *WalletOperationPayload) Reset() {
 	*x = WalletOperationPayload{}
-	mi := &file_raft_proto_msgTypes[4]
+	mi := &file_raft_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -408,7 +628,7 @@ func (x *WalletOperationPayload) String() string {
 func (*WalletOperationPayload) ProtoMessage() {}
 
 func (x *WalletOperationPayload) ProtoReflect() protoreflect.Message {
-	mi := &file_raft_proto_msgTypes[4]
+	mi := &file_raft_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -421,7 +641,7 @@ func (x *WalletOperationPayload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WalletOperationPayload.ProtoReflect.Descriptor instead.
 func (*WalletOperationPayload) Descriptor() ([]byte, []int) {
-	return file_raft_proto_rawDescGZIP(), []int{4}
+	return file_raft_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *WalletOperationPayload) GetWallet1() int64 {
@@ -459,6 +679,62 @@ func (x *WalletOperationPayload) GetPollID() string {
 	return ""
 }
 
+func (x *WalletOperationPayload) GetScheduleID() int64 {
+	if x != nil {
+		return x.ScheduleID
+	}
+	return 0
+}
+
+func (x *WalletOperationPayload) GetOccurrence() int64 {
+	if x != nil {
+		return x.Occurrence
+	}
+	return 0
+}
+
+func (x *WalletOperationPayload) GetAttempt() int64 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *WalletOperationPayload) GetInterval() string {
+	if x != nil {
+		return x.Interval
+	}
+	return ""
+}
+
+func (x *WalletOperationPayload) GetStartAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartAt
+	}
+	return nil
+}
+
+func (x *WalletOperationPayload) GetMaxRetries() int64 {
+	if x != nil {
+		return x.MaxRetries
+	}
+	return 0
+}
+
+func (x *WalletOperationPayload) GetRetryDelaySeconds() int64 {
+	if x != nil {
+		return x.RetryDelaySeconds
+	}
+	return 0
+}
+
+func (x *WalletOperationPayload) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
 type AppendEntriesRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Term          int32                  `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
@@ -467,13 +743,15 @@ type AppendEntriesRequest struct {
 	PrevLogTerm   int32                  `protobuf:"varint,4,opt,name=prevLogTerm,proto3" json:"prevLogTerm,omitempty"`
 	Entries       []*LogEntry            `protobuf:"bytes,5,rep,name=entries,proto3" json:"entries,omitempty"`
 	LeaderCommit  int32                  `protobuf:"varint,6,opt,name=leaderCommit,proto3" json:"leaderCommit,omitempty"`
+	ClusterId     string                 `protobuf:"bytes,7,opt,name=clusterId,proto3" json:"clusterId,omitempty"`
+	LeaderAddress string                 `protobuf:"bytes,8,opt,name=leaderAddress,proto3" json:"leaderAddress,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *AppendEntriesRequest) Reset() {
 	*x = AppendEntriesRequest{}
-	mi := &file_raft_proto_msgTypes[5]
+	mi := &file_raft_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -485,7 +763,7 @@ func (x *AppendEntriesRequest) String() string {
 func (*AppendEntriesRequest) ProtoMessage() {}
 
 func (x *AppendEntriesRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_raft_proto_msgTypes[5]
+	mi := &file_raft_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -498,7 +776,7 @@ func (x *AppendEntriesRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AppendEntriesRequest.ProtoReflect.Descriptor instead.
 func (*AppendEntriesRequest) Descriptor() ([]byte, []int) {
-	return file_raft_proto_rawDescGZIP(), []int{5}
+	return file_raft_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *AppendEntriesRequest) GetTerm() int32 {
@@ -543,36 +821,43 @@ func (x *AppendEntriesRequest) GetLeaderCommit() int32 {
 	return 0
 }
 
-type LogEntry struct {
-	state          protoimpl.MessageState `protogen:"open.v1"`
-	Index          int64                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
-	Term           int32                  `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
-	ReferenceTable string                 `protobuf:"bytes,3,opt,name=referenceTable,proto3" json:"referenceTable,omitempty"`
-	// Types that are valid to be assigned to Payload:
-	//
-	//	*LogEntry_UserPayload
-	//	*LogEntry_AdminPayload
-	//	*LogEntry_WalletOperationPayload
-	Payload       isLogEntry_Payload `protobuf_oneof:"payload"`
+func (x *AppendEntriesRequest) GetClusterId() string {
+	if x != nil {
+		return x.ClusterId
+	}
+	return ""
+}
+
+func (x *AppendEntriesRequest) GetLeaderAddress() string {
+	if x != nil {
+		return x.LeaderAddress
+	}
+	return ""
+}
+
+// members of the cluster, written by the bootstrap as the first entry of the log
+type ConfigurationPayload struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Members       []*Member              `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *LogEntry) Reset() {
-	*x = LogEntry{}
-	mi := &file_raft_proto_msgTypes[6]
+func (x *ConfigurationPayload) Reset() {
+	*x = ConfigurationPayload{}
+	mi := &file_raft_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *LogEntry) String() string {
+func (x *ConfigurationPayload) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LogEntry) ProtoMessage() {}
+func (*ConfigurationPayload) ProtoMessage() {}
 
-func (x *LogEntry) ProtoReflect() protoreflect.Message {
-	mi := &file_raft_proto_msgTypes[6]
+func (x *ConfigurationPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -583,87 +868,199 @@ func (x *LogEntry) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LogEntry.ProtoReflect.Descriptor instead.
-func (*LogEntry) Descriptor() ([]byte, []int) {
-	return file_raft_proto_rawDescGZIP(), []int{6}
+// Deprecated: Use ConfigurationPayload.ProtoReflect.Descriptor instead.
+func (*ConfigurationPayload) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{7}
 }
 
-func (x *LogEntry) GetIndex() int64 {
+func (x *ConfigurationPayload) GetMembers() []*Member {
 	if x != nil {
-		return x.Index
+		return x.Members
 	}
-	return 0
+	return nil
 }
 
-func (x *LogEntry) GetTerm() int32 {
+type Member struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NodeId        string                 `protobuf:"bytes,1,opt,name=nodeId,proto3" json:"nodeId,omitempty"`
+	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"` // address when the configuration was written, nodes keep track of later ones
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Member) Reset() {
+	*x = Member{}
+	mi := &file_raft_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Member) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Member) ProtoMessage() {}
+
+func (x *Member) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[8]
 	if x != nil {
-		return x.Term
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	return 0
+	return mi.MessageOf(x)
 }
 
-func (x *LogEntry) GetReferenceTable() string {
+// Deprecated: Use Member.ProtoReflect.Descriptor instead.
+func (*Member) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Member) GetNodeId() string {
 	if x != nil {
-		return x.ReferenceTable
+		return x.NodeId
 	}
 	return ""
 }
 
-func (x *LogEntry) GetPayload() isLogEntry_Payload {
+func (x *Member) GetAddress() string {
 	if x != nil {
-		return x.Payload
+		return x.Address
 	}
-	return nil
+	return ""
+}
+
+// Command is the operation of a log entry, stored and replicated as encoded by the leader. body is the
+// payload message of type, version tells which encoding of that message it is
+type Command struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       uint32                 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	PollID        string                 `protobuf:"bytes,3,opt,name=pollID,proto3" json:"pollID,omitempty"`
+	Body          []byte                 `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Command) Reset() {
+	*x = Command{}
+	mi := &file_raft_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Command) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func (x *LogEntry) GetUserPayload() *UserPayload {
+func (*Command) ProtoMessage() {}
+
+func (x *Command) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[9]
 	if x != nil {
-		if x, ok := x.Payload.(*LogEntry_UserPayload); ok {
-			return x.UserPayload
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
 		}
+		return ms
 	}
-	return nil
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Command.ProtoReflect.Descriptor instead.
+func (*Command) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{9}
 }
 
-func (x *LogEntry) GetAdminPayload() *AdminPayload {
+func (x *Command) GetVersion() uint32 {
 	if x != nil {
-		if x, ok := x.Payload.(*LogEntry_AdminPayload); ok {
-			return x.AdminPayload
-		}
+		return x.Version
 	}
-	return nil
+	return 0
 }
 
-func (x *LogEntry) GetWalletOperationPayload() *WalletOperationPayload {
+func (x *Command) GetType() string {
 	if x != nil {
-		if x, ok := x.Payload.(*LogEntry_WalletOperationPayload); ok {
-			return x.WalletOperationPayload
-		}
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Command) GetPollID() string {
+	if x != nil {
+		return x.PollID
+	}
+	return ""
+}
+
+func (x *Command) GetBody() []byte {
+	if x != nil {
+		return x.Body
 	}
 	return nil
 }
 
-type isLogEntry_Payload interface {
-	isLogEntry_Payload()
+type LogEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int64                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Term          int32                  `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	Command       *Command               `protobuf:"bytes,7,opt,name=command,proto3" json:"command,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-type LogEntry_UserPayload struct {
-	UserPayload *UserPayload `protobuf:"bytes,4,opt,name=userPayload,proto3,oneof"`
+func (x *LogEntry) Reset() {
+	*x = LogEntry{}
+	mi := &file_raft_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-type LogEntry_AdminPayload struct {
-	AdminPayload *AdminPayload `protobuf:"bytes,5,opt,name=adminPayload,proto3,oneof"`
+func (x *LogEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-type LogEntry_WalletOperationPayload struct {
-	WalletOperationPayload *WalletOperationPayload `protobuf:"bytes,6,opt,name=walletOperationPayload,proto3,oneof"`
+func (*LogEntry) ProtoMessage() {}
+
+func (x *LogEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-func (*LogEntry_UserPayload) isLogEntry_Payload() {}
+// Deprecated: Use LogEntry.ProtoReflect.Descriptor instead.
+func (*LogEntry) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{10}
+}
 
-func (*LogEntry_AdminPayload) isLogEntry_Payload() {}
+func (x *LogEntry) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
 
-func (*LogEntry_WalletOperationPayload) isLogEntry_Payload() {}
+func (x *LogEntry) GetTerm() int32 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *LogEntry) GetCommand() *Command {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
 
 type AppendEntriesResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -675,7 +1072,7 @@ type AppendEntriesResponse struct {
 
 func (x *AppendEntriesResponse) Reset() {
 	*x = AppendEntriesResponse{}
-	mi := &file_raft_proto_msgTypes[7]
+	mi := &file_raft_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -687,7 +1084,7 @@ func (x *AppendEntriesResponse) String() string {
 func (*AppendEntriesResponse) ProtoMessage() {}
 
 func (x *AppendEntriesResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_raft_proto_msgTypes[7]
+	mi := &file_raft_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -700,7 +1097,7 @@ func (x *AppendEntriesResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AppendEntriesResponse.ProtoReflect.Descriptor instead.
 func (*AppendEntriesResponse) Descriptor() ([]byte, []int) {
-	return file_raft_proto_rawDescGZIP(), []int{7}
+	return file_raft_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *AppendEntriesResponse) GetTerm() int32 {
@@ -717,20 +1114,111 @@ func (x *AppendEntriesResponse) GetSuccess() bool {
 	return false
 }
 
+// documents are content addressed, a node missing one asks its peers by hash
+type FetchBlobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          string                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FetchBlobRequest) Reset() {
+	*x = FetchBlobRequest{}
+	mi := &file_raft_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FetchBlobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchBlobRequest) ProtoMessage() {}
+
+func (x *FetchBlobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchBlobRequest.ProtoReflect.Descriptor instead.
+func (*FetchBlobRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *FetchBlobRequest) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+type FetchBlobResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"` // the blob as stored by the peer, sealed
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FetchBlobResponse) Reset() {
+	*x = FetchBlobResponse{}
+	mi := &file_raft_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FetchBlobResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchBlobResponse) ProtoMessage() {}
+
+func (x *FetchBlobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchBlobResponse.ProtoReflect.Descriptor instead.
+func (*FetchBlobResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *FetchBlobResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 var File_raft_proto protoreflect.FileDescriptor
 
 const file_raft_proto_rawDesc = "" +
 	"\n" +
 	"\n" +
-	"raft.proto\x12\x04raft\x1a\x1fgoogle/protobuf/timestamp.proto\"\x90\x01\n" +
+	"raft.proto\x12\x04raft\x1a\x1fgoogle/protobuf/timestamp.proto\"\xda\x01\n" +
 	"\x12RequestVoteRequest\x12\x12\n" +
 	"\x04term\x18\x01 \x01(\x05R\x04term\x12 \n" +
 	"\vcandidateId\x18\x02 \x01(\tR\vcandidateId\x12\"\n" +
 	"\flastLogIndex\x18\x03 \x01(\x05R\flastLogIndex\x12 \n" +
-	"\vlastLogTerm\x18\x04 \x01(\x05R\vlastLogTerm\"K\n" +
+	"\vlastLogTerm\x18\x04 \x01(\x05R\vlastLogTerm\x12\x1c\n" +
+	"\tclusterId\x18\x05 \x01(\tR\tclusterId\x12*\n" +
+	"\x10candidateAddress\x18\x06 \x01(\tR\x10candidateAddress\"K\n" +
 	"\x13RequestVoteResponse\x12\x12\n" +
 	"\x04term\x18\x01 \x01(\x05R\x04term\x12 \n" +
-	"\vvoteGranted\x18\x02 \x01(\bR\vvoteGranted\"\xe3\x03\n" +
+	"\vvoteGranted\x18\x02 \x01(\bR\vvoteGranted\"\xe7\x04\n" +
 	"\vUserPayload\x12\x1c\n" +
 	"\tfirstName\x18\x01 \x01(\tR\tfirstName\x12\x1a\n" +
 	"\blastName\x18\x02 \x01(\tR\blastName\x12&\n" +
@@ -745,7 +1233,10 @@ const file_raft_proto_rawDesc = "" +
 	" \x01(\tR\x05newPW\x12\x16\n" +
 	"\x06userID\x18\v \x01(\x03R\x06userID\x12\x16\n" +
 	"\x06action\x18\f \x01(\tR\x06action\x12\x16\n" +
-	"\x06PollID\x18\r \x01(\tR\x06PollID\"\xe8\x01\n" +
+	"\x06PollID\x18\r \x01(\tR\x06PollID\x12$\n" +
+	"\rsweepWalletID\x18\x0e \x01(\x03R\rsweepWalletID\x12,\n" +
+	"\x11sealedDateOfBirth\x18\x0f \x01(\tR\x11sealedDateOfBirth\x12.\n" +
+	"\x04time\x18\x10 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\"\xbd\x05\n" +
 	"\fAdminPayload\x12\x1c\n" +
 	"\tfirstName\x18\x01 \x01(\tR\tfirstName\x12\x1a\n" +
 	"\blastName\x18\x02 \x01(\tR\blastName\x12&\n" +
@@ -754,34 +1245,85 @@ const file_raft_proto_rawDesc = "" +
 	"\aadminID\x18\x05 \x01(\x03R\aadminID\x12\x16\n" +
 	"\x06userId\x18\x06 \x01(\x03R\x06userId\x12\x16\n" +
 	"\x06action\x18\a \x01(\tR\x06action\x12\x16\n" +
-	"\x06PollID\x18\b \x01(\tR\x06PollID\"\x94\x01\n" +
+	"\x06PollID\x18\b \x01(\tR\x06PollID\x12\x1c\n" +
+	"\tfeeAction\x18\t \x01(\tR\tfeeAction\x12\x18\n" +
+	"\afeeKind\x18\n" +
+	" \x01(\tR\afeeKind\x12\x18\n" +
+	"\afeeFlat\x18\v \x01(\x03R\afeeFlat\x12&\n" +
+	"\x0efeeBasisPoints\x18\f \x01(\x03R\x0efeeBasisPoints\x12)\n" +
+	"\bfeeTiers\x18\r \x03(\v2\r.raft.FeeTierR\bfeeTiers\x12\x1a\n" +
+	"\bwalletID\x18\x0e \x01(\x03R\bwalletID\x12\x12\n" +
+	"\x04tier\x18\x0f \x01(\tR\x04tier\x12\x1e\n" +
+	"\n" +
+	"limitScope\x18\x10 \x01(\tR\n" +
+	"limitScope\x120\n" +
+	"\x13limitPerTransaction\x18\x11 \x01(\x03R\x13limitPerTransaction\x12\x1e\n" +
+	"\n" +
+	"limitDaily\x18\x12 \x01(\x03R\n" +
+	"limitDaily\x12\"\n" +
+	"\flimitMonthly\x18\x13 \x01(\x03R\flimitMonthly\x12\x12\n" +
+	"\x04role\x18\x14 \x01(\tR\x04role\x12$\n" +
+	"\rtargetAdminID\x18\x15 \x01(\x03R\rtargetAdminID\x12.\n" +
+	"\x04time\x18\x16 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\"S\n" +
+	"\aFeeTier\x12\x12\n" +
+	"\x04upTo\x18\x01 \x01(\x03R\x04upTo\x12\x12\n" +
+	"\x04flat\x18\x02 \x01(\x03R\x04flat\x12 \n" +
+	"\vbasisPoints\x18\x03 \x01(\x03R\vbasisPoints\"\xbe\x03\n" +
 	"\x16WalletOperationPayload\x12\x18\n" +
 	"\awallet1\x18\x01 \x01(\x03R\awallet1\x12\x18\n" +
 	"\awallet2\x18\x02 \x01(\x03R\awallet2\x12\x16\n" +
 	"\x06amount\x18\x03 \x01(\x03R\x06amount\x12\x16\n" +
 	"\x06action\x18\x04 \x01(\tR\x06action\x12\x16\n" +
-	"\x06PollID\x18\x05 \x01(\tR\x06PollID\"\xda\x01\n" +
+	"\x06PollID\x18\x05 \x01(\tR\x06PollID\x12\x1e\n" +
+	"\n" +
+	"scheduleID\x18\x06 \x01(\x03R\n" +
+	"scheduleID\x12\x1e\n" +
+	"\n" +
+	"occurrence\x18\a \x01(\x03R\n" +
+	"occurrence\x12\x18\n" +
+	"\aattempt\x18\b \x01(\x03R\aattempt\x12\x1a\n" +
+	"\binterval\x18\t \x01(\tR\binterval\x124\n" +
+	"\astartAt\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\astartAt\x12\x1e\n" +
+	"\n" +
+	"maxRetries\x18\v \x01(\x03R\n" +
+	"maxRetries\x12,\n" +
+	"\x11retryDelaySeconds\x18\f \x01(\x03R\x11retryDelaySeconds\x12.\n" +
+	"\x04time\x18\r \x01(\v2\x1a.google.protobuf.TimestampR\x04time\"\x9e\x02\n" +
 	"\x14AppendEntriesRequest\x12\x12\n" +
 	"\x04term\x18\x01 \x01(\x05R\x04term\x12\x1a\n" +
 	"\bleaderId\x18\x02 \x01(\tR\bleaderId\x12\"\n" +
 	"\fprevLogIndex\x18\x03 \x01(\x05R\fprevLogIndex\x12 \n" +
 	"\vprevLogTerm\x18\x04 \x01(\x05R\vprevLogTerm\x12(\n" +
 	"\aentries\x18\x05 \x03(\v2\x0e.raft.LogEntryR\aentries\x12\"\n" +
-	"\fleaderCommit\x18\x06 \x01(\x05R\fleaderCommit\"\xb0\x02\n" +
+	"\fleaderCommit\x18\x06 \x01(\x05R\fleaderCommit\x12\x1c\n" +
+	"\tclusterId\x18\a \x01(\tR\tclusterId\x12$\n" +
+	"\rleaderAddress\x18\b \x01(\tR\rleaderAddress\">\n" +
+	"\x14ConfigurationPayload\x12&\n" +
+	"\amembers\x18\x01 \x03(\v2\f.raft.MemberR\amembers\":\n" +
+	"\x06Member\x12\x16\n" +
+	"\x06nodeId\x18\x01 \x01(\tR\x06nodeId\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\"c\n" +
+	"\aCommand\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x16\n" +
+	"\x06pollID\x18\x03 \x01(\tR\x06pollID\x12\x12\n" +
+	"\x04body\x18\x04 \x01(\fR\x04body\"c\n" +
 	"\bLogEntry\x12\x14\n" +
 	"\x05index\x18\x01 \x01(\x03R\x05index\x12\x12\n" +
-	"\x04term\x18\x02 \x01(\x05R\x04term\x12&\n" +
-	"\x0ereferenceTable\x18\x03 \x01(\tR\x0ereferenceTable\x125\n" +
-	"\vuserPayload\x18\x04 \x01(\v2\x11.raft.UserPayloadH\x00R\vuserPayload\x128\n" +
-	"\fadminPayload\x18\x05 \x01(\v2\x12.raft.AdminPayloadH\x00R\fadminPayload\x12V\n" +
-	"\x16walletOperationPayload\x18\x06 \x01(\v2\x1c.raft.WalletOperationPayloadH\x00R\x16walletOperationPayloadB\t\n" +
-	"\apayload\"E\n" +
+	"\x04term\x18\x02 \x01(\x05R\x04term\x12'\n" +
+	"\acommand\x18\a \x01(\v2\r.raft.CommandR\acommandJ\x04\b\x03\x10\a\"E\n" +
 	"\x15AppendEntriesResponse\x12\x12\n" +
 	"\x04term\x18\x01 \x01(\x05R\x04term\x12\x18\n" +
-	"\asuccess\x18\x02 \x01(\bR\asuccess2\x94\x01\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\"&\n" +
+	"\x10FetchBlobRequest\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\tR\x04hash\"'\n" +
+	"\x11FetchBlobResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data2\xd2\x01\n" +
 	"\x04Raft\x12B\n" +
 	"\vRequestVote\x12\x18.raft.RequestVoteRequest\x1a\x19.raft.RequestVoteResponse\x12H\n" +
-	"\rAppendEntries\x12\x1a.raft.AppendEntriesRequest\x1a\x1b.raft.AppendEntriesResponseB%Z#github.com/IndraS1998/DBL/raft/raftb\x06proto3"
+	"\rAppendEntries\x12\x1a.raft.AppendEntriesRequest\x1a\x1b.raft.AppendEntriesResponse\x12<\n" +
+	"\tFetchBlob\x12\x16.raft.FetchBlobRequest\x1a\x17.raft.FetchBlobResponseB%Z#github.com/IndraS1998/DBL/raft/raftb\x06proto3"
 
 var (
 	file_raft_proto_rawDescOnce sync.Once
@@ -795,33 +1337,45 @@ func file_raft_proto_rawDescGZIP() []byte {
 	return file_raft_proto_rawDescData
 }
 
-var file_raft_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_raft_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
 var file_raft_proto_goTypes = []any{
 	(*RequestVoteRequest)(nil),     // 0: raft.RequestVoteRequest
 	(*RequestVoteResponse)(nil),    // 1: raft.RequestVoteResponse
 	(*UserPayload)(nil),            // 2: raft.UserPayload
 	(*AdminPayload)(nil),           // 3: raft.AdminPayload
-	(*WalletOperationPayload)(nil), // 4: raft.WalletOperationPayload
-	(*AppendEntriesRequest)(nil),   // 5: raft.AppendEntriesRequest
-	(*LogEntry)(nil),               // 6: raft.LogEntry
-	(*AppendEntriesResponse)(nil),  // 7: raft.AppendEntriesResponse
-	(*timestamppb.Timestamp)(nil),  // 8: google.protobuf.Timestamp
+	(*FeeTier)(nil),                // 4: raft.FeeTier
+	(*WalletOperationPayload)(nil), // 5: raft.WalletOperationPayload
+	(*AppendEntriesRequest)(nil),   // 6: raft.AppendEntriesRequest
+	(*ConfigurationPayload)(nil),   // 7: raft.ConfigurationPayload
+	(*Member)(nil),                 // 8: raft.Member
+	(*Command)(nil),                // 9: raft.Command
+	(*LogEntry)(nil),               // 10: raft.LogEntry
+	(*AppendEntriesResponse)(nil),  // 11: raft.AppendEntriesResponse
+	(*FetchBlobRequest)(nil),       // 12: raft.FetchBlobRequest
+	(*FetchBlobResponse)(nil),      // 13: raft.FetchBlobResponse
+	(*timestamppb.Timestamp)(nil),  // 14: google.protobuf.Timestamp
 }
 var file_raft_proto_depIdxs = []int32{
-	8, // 0: raft.UserPayload.dateOfBirth:type_name -> google.protobuf.Timestamp
-	6, // 1: raft.AppendEntriesRequest.entries:type_name -> raft.LogEntry
-	2, // 2: raft.LogEntry.userPayload:type_name -> raft.UserPayload
-	3, // 3: raft.LogEntry.adminPayload:type_name -> raft.AdminPayload
-	4, // 4: raft.LogEntry.walletOperationPayload:type_name -> raft.WalletOperationPayload
-	0, // 5: raft.Raft.RequestVote:input_type -> raft.RequestVoteRequest
-	5, // 6: raft.Raft.AppendEntries:input_type -> raft.AppendEntriesRequest
-	1, // 7: raft.Raft.RequestVote:output_type -> raft.RequestVoteResponse
-	7, // 8: raft.Raft.AppendEntries:output_type -> raft.AppendEntriesResponse
-	7, // [7:9] is the sub-list for method output_type
-	5, // [5:7] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	14, // 0: raft.UserPayload.dateOfBirth:type_name -> google.protobuf.Timestamp
+	14, // 1: raft.UserPayload.time:type_name -> google.protobuf.Timestamp
+	4,  // 2: raft.AdminPayload.feeTiers:type_name -> raft.FeeTier
+	14, // 3: raft.AdminPayload.time:type_name -> google.protobuf.Timestamp
+	14, // 4: raft.WalletOperationPayload.startAt:type_name -> google.protobuf.Timestamp
+	14, // 5: raft.WalletOperationPayload.time:type_name -> google.protobuf.Timestamp
+	10, // 6: raft.AppendEntriesRequest.entries:type_name -> raft.LogEntry
+	8,  // 7: raft.ConfigurationPayload.members:type_name -> raft.Member
+	9,  // 8: raft.LogEntry.command:type_name -> raft.Command
+	0,  // 9: raft.Raft.RequestVote:input_type -> raft.RequestVoteRequest
+	6,  // 10: raft.Raft.AppendEntries:input_type -> raft.AppendEntriesRequest
+	12, // 11: raft.Raft.FetchBlob:input_type -> raft.FetchBlobRequest
+	1,  // 12: raft.Raft.RequestVote:output_type -> raft.RequestVoteResponse
+	11, // 13: raft.Raft.AppendEntries:output_type -> raft.AppendEntriesResponse
+	13, // 14: raft.Raft.FetchBlob:output_type -> raft.FetchBlobResponse
+	12, // [12:15] is the sub-list for method output_type
+	9,  // [9:12] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
 }
 
 func init() { file_raft_proto_init() }
@@ -829,18 +1383,13 @@ func file_raft_proto_init() {
 	if File_raft_proto != nil {
 		return
 	}
-	file_raft_proto_msgTypes[6].OneofWrappers = []any{
-		(*LogEntry_UserPayload)(nil),
-		(*LogEntry_AdminPayload)(nil),
-		(*LogEntry_WalletOperationPayload)(nil),
-	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_raft_proto_rawDesc), len(file_raft_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   14,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"sync"
+	"strings"
+	"time"
 
+	"raft/blobstore"
 	pb "raft/raft"
 	"raft/state"
-	"raft/utils"
 
 	"google.golang.org/grpc"
-	"gorm.io/gorm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type server struct {
@@ -25,36 +27,90 @@ func NewServer(node *state.Node) *server {
 	return &server{node: node}
 }
 
+// checkPeer refuses the rpcs of a node of another cluster, peers that do not tell theirs are trusted, and
+// of a node that is no member. It records the address a member calls from
+func (s *server) checkPeer(clusterID, from, address string) error {
+	if clusterID != "" && clusterID != s.node.ClusterID {
+		log.Printf("%s refused an rpc of %s from cluster %s, this node is in cluster %s", s.node.Address, from,
+			clusterID, s.node.ClusterID)
+		return status.Errorf(codes.FailedPrecondition, "node %s is in cluster %s, not %s", s.node.Address,
+			s.node.ClusterID, clusterID)
+	}
+	if !s.node.ObservePeer(from, address) {
+		log.Printf("%s refused an rpc of %s at %s, not a member of cluster %s", s.node.Address, from, address,
+			s.node.ClusterID)
+		return status.Errorf(codes.FailedPrecondition, "node %s is not a member of cluster %s", from,
+			s.node.ClusterID)
+	}
+	return nil
+}
+
 func (s *server) RequestVote(_ context.Context, vr *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error) {
-	ct, e := s.node.Log.GetCurrentTerm()
+	if err := s.checkPeer(vr.GetClusterId(), vr.GetCandidateId(), vr.GetCandidateAddress()); err != nil {
+		return nil, err
+	}
+	ct, e := s.node.State.GetCurrentTerm()
 	if e != nil {
 		log.Printf("could not get current term: %v", e)
 		return nil, e
 	}
-	s.node.ResetTimerChan <- true
+	if vr.GetTerm() < ct {
+		return &pb.RequestVoteResponse{Term: ct, VoteGranted: false}, nil
+	}
 	if vr.GetTerm() > ct {
-		er := s.node.Log.SetCurrentTerm(vr.GetTerm())
-		if er != nil {
-			log.Printf("could not set current term: %v", er)
-			return nil, er
-		}
-		err := s.node.Log.SetVotedFor(vr.GetCandidateId())
-		if err != nil {
-			log.Printf("could not set voted for: %v", err)
+		// a new term starts without a vote
+		if err := s.advanceTerm(vr.GetTerm()); err != nil {
 			return nil, err
 		}
-		s.node.PrintDetails()
-		return &pb.RequestVoteResponse{Term: ct, VoteGranted: true}, nil
-	} else {
+		ct = vr.GetTerm()
+	}
+	votedFor, err := s.node.State.GetVotedFor()
+	if err != nil {
+		log.Printf("could not get voted for: %v", err)
+		return nil, err
+	}
+	lastIndex, lastTerm, err := s.node.Log.LastIndexAndTerm()
+	if err != nil {
+		log.Printf("could not read last log entry: %v", err)
+		return nil, err
+	}
+	// the candidate must hold every entry this node may have acknowledged
+	upToDate := vr.GetLastLogTerm() > lastTerm || (vr.GetLastLogTerm() == lastTerm && vr.GetLastLogIndex() >= lastIndex)
+	if (votedFor != "" && votedFor != vr.GetCandidateId()) || !upToDate {
+		s.node.ObserveTerm(ct)
+		return &pb.RequestVoteResponse{Term: ct, VoteGranted: false}, nil
+	}
+	// another candidate or the node's own campaign may have taken the vote since it was read
+	granted, err := s.node.State.GrantVote(ct, vr.GetCandidateId())
+	if err != nil {
+		log.Printf("could not set voted for: %v", err)
+		return nil, err
+	}
+	if !granted {
+		s.node.ObserveTerm(ct)
 		return &pb.RequestVoteResponse{Term: ct, VoteGranted: false}, nil
 	}
+	s.node.ObserveRPC(ct, false)
+	s.node.PrintDetails()
+	return &pb.RequestVoteResponse{Term: ct, VoteGranted: true}, nil
 }
 
-func (s *server) AppendEntries(_ context.Context, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
-	s.node.ResetTimerChan <- true
+// advanceTerm moves the node to a later term in which it has not voted yet. A node that reached the term
+// in the meantime keeps its vote
+func (s *server) advanceTerm(term int32) error {
+	if _, err := s.node.State.AdvanceTerm(term); err != nil {
+		log.Printf("could not set current term: %v", err)
+		return err
+	}
+	return nil
+}
 
+func (s *server) AppendEntries(_ context.Context, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	if err := s.checkPeer(req.GetClusterId(), req.GetLeaderId(), req.GetLeaderAddress()); err != nil {
+		return nil, err
+	}
 	// Check if the term is less than the current term
-	ct, e := s.node.Log.GetCurrentTerm()
+	ct, e := s.node.State.GetCurrentTerm()
 	if e != nil {
 		log.Printf("could not get current term: %v", e)
 		return nil, e
@@ -62,111 +118,158 @@ func (s *server) AppendEntries(_ context.Context, req *pb.AppendEntriesRequest)
 	if req.Term < ct {
 		return &pb.AppendEntriesResponse{Term: ct, Success: false}, nil
 	}
+	// Update term and become follower if necessary
+	if req.Term > ct {
+		if err := s.advanceTerm(req.Term); err != nil {
+			return nil, err
+		}
+	}
+	s.node.ObserveRPC(req.GetTerm(), true)
+	leaderAddress := s.node.PeerAddress(req.GetLeaderId())
+	s.node.Mu.Lock()
+	s.node.LeaderAddress = leaderAddress
+	s.node.Mu.Unlock()
 
 	// validating prevLogIndex and term
-
-	// if prevLogIndex = 0? i still need to check the log lengh and ensure it is also 0
-	if req.PrevLogIndex == 0 {
-		logLength, _ := s.node.Log.GetLogLength()
-		if logLength != 0 {
-			if err := s.node.Log.DeleteLogEntriesFrom(1); err != nil {
-				return nil, err
-			}
-		}
-	} else {
+	if req.PrevLogIndex > 0 {
 		// get log entry at point prevLogIndex
-		logEntry, err := s.node.Log.GetLogEntry(int(req.PrevLogIndex))
-		// if there is no entry at that point, return false [hint use gorm.RecordNotFound]
-		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		logEntry, err := state.EntryAt(s.node.Log, req.PrevLogIndex)
+		// if there is no entry at that point, return false
+		if errors.Is(err, state.ErrNoEntry) {
 			log.Printf("no such record exists with the index %v\n", req.PrevLogIndex)
-			ent, e2 := s.node.Log.GetAllLogEntries()
-			if e2 != nil {
-				log.Printf("could not get all log entries: %v", e2)
-				return nil, e2
-			}
-			log.Printf("log entries after append for %v: %v", s.node.Address, ent)
-
-			return &pb.AppendEntriesResponse{Term: ct, Success: false}, nil
+			return &pb.AppendEntriesResponse{Term: req.Term, Success: false}, nil
+		}
+		if err != nil {
+			return nil, err
 		}
 		// if there is an entry at that index but its term does not match prevLogTerm, return false
-		if logEntry.Term != req.PrevLogTerm {
-			log.Printf("the entry at index : %v, has term: %v but term : %v was provided", req.PrevLogIndex, logEntry.Term, req.PrevLogTerm)
-			return &pb.AppendEntriesResponse{Term: ct, Success: false}, nil
-		} else {
-			// if previous logs match i need to delete any eventual next log
-			if _, err := s.node.Log.GetLogEntry(int(req.PrevLogIndex) + 1); err != nil {
-				if !errors.Is(err, gorm.ErrRecordNotFound) {
-					if delEr := s.node.Log.DeleteLogEntriesFrom(int(req.PrevLogIndex) + 1); delEr != nil {
-						return nil, delEr
-					}
-				} else {
-					fmt.Println("prev log match and there is no next log. Everything ok")
-				}
-			}
+		if logEntry.GetTerm() != req.PrevLogTerm {
+			log.Printf("the entry at index : %v, has term: %v but term : %v was provided", req.PrevLogIndex, logEntry.GetTerm(), req.PrevLogTerm)
+			return &pb.AppendEntriesResponse{Term: req.Term, Success: false}, nil
 		}
 	}
 
-	// Update term and become follower if necessary
-	er := s.node.Log.SetCurrentTerm(req.Term)
-	if er != nil {
-		log.Printf("could not set current term: %v", er)
-		return nil, er
-	}
-	s.node.Mu.Lock()
-	s.node.LeaderAddress = req.LeaderId
-	s.node.Mu.Unlock()
-
-	// Append new entries to the log
-	payloads := []utils.Payload{}
-	for _, entry := range req.Entries {
-		payload, err := state.ProtoToLogEntry(entry, entry.ReferenceTable, entry.Term)
-		if err != nil {
+	// skip the entries already in the log, the first one that conflicts and all that follow it are
+	// replaced. A late or duplicated rpc thus never removes entries the leader sent since
+	fresh := req.Entries[:0:0]
+	for i, entry := range req.Entries {
+		index := req.PrevLogIndex + int32(i) + 1
+		existing, err := state.EntryAt(s.node.Log, index)
+		if err == nil && existing.GetTerm() == entry.Term {
+			continue
+		}
+		if err == nil {
+			if err := s.node.TruncateLog(index); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, state.ErrNoEntry) {
 			return nil, err
 		}
-		payloads = append(payloads, payload)
+		fresh = req.Entries[i:]
+		break
 	}
 
-	err := s.node.Log.AppendLogEntry(payloads)
-	if err != nil {
+	if err := s.node.Log.Append(fresh); err != nil {
 		log.Printf("could not insert log entry: %v", err)
 		return nil, err
 	}
 
-	// Update commit index
-	if req.LeaderCommit > s.node.CommitIndex {
-		logLength, _ := s.node.Log.GetLogLength()
+	// Update commit index, up to the last entry the leader vouched for
+	lastNew := req.PrevLogIndex + int32(len(req.Entries))
+	s.node.Mu.RLock()
+	commitIndex := s.node.CommitIndex
+	s.node.Mu.RUnlock()
+	if req.LeaderCommit > commitIndex && lastNew > commitIndex {
 		s.node.Mu.Lock()
-		s.node.CommitIndex = min(req.LeaderCommit, int32(logLength))
+		s.node.CommitIndex = min(req.LeaderCommit, lastNew)
 		s.node.Mu.Unlock()
 		s.node.Commit() // commit entries here by comparing last applied with actual commit
 	}
 	s.node.PrintDetails()
-	// Print log entries after appending just for testing purposes
-	ent, e2 := s.node.Log.GetAllLogEntries()
-	if e2 != nil {
-		log.Printf("could not get all log entries: %v", e2)
-		return nil, e2
-	}
-	fmt.Printf("log entries after append for %v: %v", s.node.Address, ent)
-	uct, e1 := s.node.Log.GetCurrentTerm()
-	if e1 != nil {
-		log.Printf("could not get current term: %v", e1)
-		return nil, e1
-	}
-	return &pb.AppendEntriesResponse{Term: uct, Success: true}, nil
+	return &pb.AppendEntriesResponse{Term: req.Term, Success: true}, nil
 }
 
-func StartRPCServerListener(node *state.Node, wg *sync.WaitGroup) {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%v", node.Address))
+// FetchBlob hands a document stored on this node to a peer missing it
+func (s *server) FetchBlob(_ context.Context, req *pb.FetchBlobRequest) (*pb.FetchBlobResponse, error) {
+	if !blobstore.ValidHash(req.GetHash()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid blob address %q", req.GetHash())
+	}
+	raw, err := s.node.Blobs.GetRaw(req.GetHash())
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "blob %s not found", req.GetHash())
+	}
 	if err != nil {
-		log.Fatalf("failed to listed: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.FetchBlobResponse{Data: raw}, nil
+}
+
+// injectFaults applies the inbound link faults of node, when fault injection is enabled. A dropped rpc
+// is answered with an error once the deadline of the caller passed, as if it never arrived
+func injectFaults(node *state.Node) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		faults := node.Faults()
+		if faults == nil {
+			return handler(ctx, req)
+		}
+		// blob fetches do not tell who asks, only a fault on every peer applies to them
+		var peer string
+		switch r := req.(type) {
+		case *pb.RequestVoteRequest:
+			peer = r.GetCandidateAddress()
+		case *pb.AppendEntriesRequest:
+			peer = r.GetLeaderAddress()
+		}
+		drop, delay := faults.Link(peer, state.Inbound)
+		if drop {
+			<-ctx.Done()
+			return nil, status.Error(codes.Unavailable, state.ErrInjectedDrop.Error())
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return handler(ctx, req)
 	}
+}
 
-	grpcServer := grpc.NewServer()
+// Listener serves the raft rpcs of a node
+type Listener struct {
+	grpc *grpc.Server
+}
 
+// Listen serves the rpcs of node on its address in the background, a port alone listens on every interface
+func Listen(node *state.Node) (*Listener, error) {
+	address := node.Address
+	if !strings.Contains(address, ":") {
+		address = ":" + address
+	}
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %v: %w", node.Address, err)
+	}
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(injectFaults(node)))
 	pb.RegisterRaftServer(grpcServer, NewServer(node))
 	log.Printf("server listening at %v", lis.Addr())
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Printf("rpc server of %v stopped: %v", node.Address, err)
+		}
+	}()
+	return &Listener{grpc: grpcServer}, nil
+}
+
+// Stop refuses new rpcs and waits for the ones in flight, they are cut once ctx is done
+func (l *Listener) Stop(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		l.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		l.grpc.Stop()
 	}
 }
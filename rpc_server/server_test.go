@@ -0,0 +1,226 @@
+package rpc_server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raft/datadir"
+	pb "raft/raft"
+	"raft/state"
+	"raft/utils"
+)
+
+// openServer serves node-0 of a cluster of five, its log holds the configuration entry only
+func openServer(t *testing.T) *server {
+	t.Helper()
+	layout := datadir.In(t.TempDir())
+	dir, err := datadir.Init(layout, datadir.Identity{NodeID: "node-0", ClusterID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := state.InitPersistentState(layout.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := make([]utils.Member, 5)
+	for i := range members {
+		members[i] = utils.Member{NodeID: fmt.Sprintf("node-%d", i), Address: fmt.Sprintf("700%d", i)}
+	}
+	err = ps.Bootstrap(members)
+	ps.Close()
+	dir.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := state.OpenNode(layout, "test", "7000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return NewServer(node)
+}
+
+// entries are deposits told apart by their poll ids, the first one sits at index prev+1
+func entries(t *testing.T, prev int64, term int32, polls ...string) []*pb.LogEntry {
+	t.Helper()
+	out := make([]*pb.LogEntry, len(polls))
+	for i, poll := range polls {
+		command, err := state.EncodeCommand(utils.WalletOperationPayload{Wallet1: 1, Amount: 10,
+			Action: utils.WalletDeposit, PollID: poll})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = &pb.LogEntry{Index: prev + int64(i) + 1, Term: term, Command: command}
+	}
+	return out
+}
+
+// checkLog fails unless the configuration entry is followed by exactly the entries of the given poll ids
+// and terms
+func checkLog(t *testing.T, s *server, polls []string, terms []int32) {
+	t.Helper()
+	length, err := s.node.State.GetLogLength()
+	if err != nil || int(length) != len(polls)+1 {
+		t.Fatalf("log of %d entries, %v, want %d", length, err, len(polls)+1)
+	}
+	for i, poll := range polls {
+		entry, err := s.node.State.GetLogEntry(i + 2)
+		if err != nil || entry.PollID != poll || entry.Term != terms[i] {
+			t.Fatalf("entry %d is %+v, %v, want %s of term %d", i+2, entry, err, poll, terms[i])
+		}
+	}
+}
+
+func TestRequestVote(t *testing.T) {
+	s := openServer(t)
+	if err := s.node.State.SetCurrentTerm(2); err != nil {
+		t.Fatal(err)
+	}
+	// the node holds two entries of term 2 after the configuration entry
+	if err := s.node.Log.Append(entries(t, 1, 2, "e1", "e2")); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name      string
+		candidate string
+		term      int32
+		lastIndex int32
+		lastTerm  int32
+		granted   bool
+		// term of the node once it answered
+		wantTerm int32
+	}{
+		{"stale term", "node-1", 1, 3, 2, false, 2},
+		{"log of an earlier term", "node-2", 3, 5, 1, false, 3},
+		{"shorter log", "node-2", 3, 2, 2, false, 3},
+		{"up to date log", "node-3", 3, 3, 2, true, 3},
+		{"already voted in the term", "node-4", 3, 9, 3, false, 3},
+		{"same candidate again", "node-3", 3, 3, 2, true, 3},
+		// a later term starts without a vote, the vote still needs an up to date log
+		{"later term, log not up to date", "node-4", 4, 2, 2, false, 4},
+		{"later term, longer log", "node-4", 4, 4, 2, true, 4},
+	}
+	for _, step := range steps {
+		res, err := s.RequestVote(context.Background(), &pb.RequestVoteRequest{Term: step.term,
+			CandidateId: step.candidate, LastLogIndex: step.lastIndex, LastLogTerm: step.lastTerm})
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if res.VoteGranted != step.granted || res.Term != step.wantTerm {
+			t.Fatalf("%s: granted %v in term %d, want %v in term %d", step.name, res.VoteGranted, res.Term,
+				step.granted, step.wantTerm)
+		}
+	}
+	if votedFor, _ := s.node.State.GetVotedFor(); votedFor != "node-4" {
+		t.Fatalf("voted for %q in term 4, want node-4", votedFor)
+	}
+}
+
+func TestAppendEntries(t *testing.T) {
+	s := openServer(t)
+	if err := s.node.State.SetCurrentTerm(2); err != nil {
+		t.Fatal(err)
+	}
+	appendEntries := func(term, prevIndex, prevTerm, leaderCommit int32, entries []*pb.LogEntry) *pb.AppendEntriesResponse {
+		t.Helper()
+		res, err := s.AppendEntries(context.Background(), &pb.AppendEntriesRequest{Term: term, LeaderId: "node-1",
+			PrevLogIndex: prevIndex, PrevLogTerm: prevTerm, Entries: entries, LeaderCommit: leaderCommit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := appendEntries(1, 1, 1, 0, entries(t, 1, 1, "stale")); res.Success || res.Term != 2 {
+		t.Fatalf("rpc of a stale term: %+v", res)
+	}
+	checkLog(t, s, nil, nil)
+	if res := appendEntries(2, 1, 1, 0, entries(t, 1, 2, "e1", "e2", "e3")); !res.Success {
+		t.Fatalf("first entries refused: %+v", res)
+	}
+	checkLog(t, s, []string{"e1", "e2", "e3"}, []int32{2, 2, 2})
+	if res := appendEntries(2, 6, 2, 0, entries(t, 6, 2, "e7")); res.Success {
+		t.Fatal("entries after a gap were accepted")
+	}
+	if res := appendEntries(2, 4, 1, 0, entries(t, 4, 2, "e5")); res.Success {
+		t.Fatal("entries after an entry of another term were accepted")
+	}
+
+	// a duplicated rpc and a late one carrying fewer entries change nothing
+	if res := appendEntries(2, 1, 1, 0, entries(t, 1, 2, "e1", "e2", "e3")); !res.Success {
+		t.Fatalf("duplicated rpc refused: %+v", res)
+	}
+	if res := appendEntries(2, 1, 1, 0, entries(t, 1, 2, "e1")); !res.Success {
+		t.Fatalf("late rpc refused: %+v", res)
+	}
+	checkLog(t, s, []string{"e1", "e2", "e3"}, []int32{2, 2, 2})
+
+	// the leader of term 3 replaces the entries that conflict with its own
+	if res := appendEntries(3, 2, 2, 0, entries(t, 2, 3, "f2")); !res.Success || res.Term != 3 {
+		t.Fatalf("rpc of a new leader: %+v", res)
+	}
+	checkLog(t, s, []string{"e1", "f2"}, []int32{2, 3})
+
+	// the commit index only covers the entries the leader vouched for in this rpc
+	if res := appendEntries(3, 2, 2, 3, nil); !res.Success {
+		t.Fatalf("heartbeat refused: %+v", res)
+	}
+	s.node.Mu.RLock()
+	commitIndex := s.node.CommitIndex
+	s.node.Mu.RUnlock()
+	if commitIndex != 2 {
+		t.Fatalf("commit index %d after a heartbeat matching entry 2, want 2", commitIndex)
+	}
+	if res := appendEntries(3, 3, 3, 3, nil); !res.Success {
+		t.Fatalf("heartbeat refused: %+v", res)
+	}
+	s.node.Mu.RLock()
+	commitIndex = s.node.CommitIndex
+	s.node.Mu.RUnlock()
+	if commitIndex != 3 {
+		t.Fatalf("commit index %d, want 3", commitIndex)
+	}
+}
+
+func TestInboundFaults(t *testing.T) {
+	ps, err := state.InitPersistentState(filepath.Join(t.TempDir(), "log.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	node := &state.Node{State: ps}
+	faults, err := node.EnableFaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := faults.SetLink(state.LinkFault{Peer: "7001", Direction: state.Inbound, DropRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	intercept := injectFaults(node)
+	handled := func(context.Context, any) (any, error) { return &pb.AppendEntriesResponse{Success: true}, nil }
+	call := func(leader string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := intercept(ctx, &pb.AppendEntriesRequest{LeaderAddress: leader}, nil, handled)
+		return err
+	}
+	start := time.Now()
+	if err := call("7001"); status.Code(err) != codes.Unavailable || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("dropped rpc answered with %v after %v, want unavailable once the deadline passed", err,
+			time.Since(start))
+	}
+	if err := call("7002"); err != nil {
+		t.Fatalf("rpc from a healthy link: %v", err)
+	}
+	if err := faults.SetLink(state.LinkFault{Peer: "7002", Direction: state.Inbound, DelayMS: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := call("7002"); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("rpc delayed past its deadline: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package sim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"raft/state"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Violation is a broken raft guarantee, with the virtual time and step it was found at
+type Violation struct {
+	At   string
+	Step int
+	What string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("sim: violation at %s (step %d): %s", v.At, v.Step, v.What)
+}
+
+// checker verifies the safety of the cluster after every step:
+//   - election safety, at most one leader per term
+//   - state machine safety, an index once committed on a node holds the same entry on every node and
+//     forever
+type checker struct {
+	leaders   map[int32]int
+	committed map[int32]string
+	// highest index checked per node, entries below it were compared already
+	checked []int32
+}
+
+func newChecker(nodes int) *checker {
+	return &checker{leaders: map[int32]int{}, committed: map[int32]string{}, checked: make([]int32, nodes)}
+}
+
+// restarted forgets what was checked on node i, a node comes back with a commit index of 0
+func (ch *checker) restarted(i int) {
+	ch.checked[i] = 0
+}
+
+func (ch *checker) check(c *Cluster) error {
+	for i, n := range c.nodes {
+		if c.down[i] {
+			continue
+		}
+		if isLeader(n) {
+			term, err := n.State.GetCurrentTerm()
+			if err != nil {
+				return err
+			}
+			if other, ok := ch.leaders[term]; ok && other != i {
+				return c.violation("nodes %d and %d both lead in term %d", other, i, term)
+			}
+			ch.leaders[term] = i
+		}
+		commitIndex := c.CommitIndex(i)
+		if commitIndex < ch.checked[i] {
+			return c.violation("commit index of node %d went back from %d to %d", i, ch.checked[i], commitIndex)
+		}
+		for index := ch.checked[i] + 1; index <= commitIndex; index++ {
+			if err := ch.compare(c, i, index); err != nil {
+				return err
+			}
+		}
+		ch.checked[i] = commitIndex
+	}
+	return nil
+}
+
+// compare checks the entry at index on node i against the one committed first at that index
+func (ch *checker) compare(c *Cluster, i int, index int32) error {
+	digest, err := entryDigest(c.nodes[i], index)
+	if err != nil {
+		return c.violation("node %d committed index %d but cannot read it: %v", i, index, err)
+	}
+	if first, ok := ch.committed[index]; !ok {
+		ch.committed[index] = digest
+	} else if first != digest {
+		return c.violation("node %d holds entry %s at committed index %d, %s was committed there", i, digest,
+			index, first)
+	}
+	return nil
+}
+
+// Verify checks again the whole committed log of every node up, entries checked at a step could have
+// been rewritten since
+func (c *Cluster) Verify() error {
+	if err := c.settle(); err != nil {
+		return err
+	}
+	for i := range c.nodes {
+		if c.down[i] {
+			continue
+		}
+		for index := int32(1); index <= c.CommitIndex(i); index++ {
+			if err := c.checker.compare(c, i, index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LeaderOf returns the node that led in term, if any did
+func (c *Cluster) LeaderOf(term int32) (int, bool) {
+	leader, ok := c.checker.leaders[term]
+	return leader, ok
+}
+
+// Committed returns the number of indexes committed by some node
+func (c *Cluster) Committed() int {
+	return len(c.checker.committed)
+}
+
+func (c *Cluster) violation(format string, args ...any) error {
+	v := &Violation{At: c.Now().String(), Step: c.steps, What: fmt.Sprintf(format, args...)}
+	c.record("violation %s", v.What)
+	return v
+}
+
+// entryDigest sums the entry at index as the leader replicates it, with its term
+func entryDigest(n *state.Node, index int32) (string, error) {
+	entries, err := n.Log.Entries(index, index)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no entry at index %d", index)
+	}
+	msg := entries[0]
+	raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return fmt.Sprintf("%d/%s", msg.GetTerm(), hex.EncodeToString(sum[:8])), nil
+}
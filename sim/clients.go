@@ -0,0 +1,265 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"raft/linearizability"
+	"raft/state"
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+const (
+	// a client gives up on an operation after this long, its outcome stays unknown
+	clientTimeout = 3 * time.Second
+	// delay between two polls of the status of a proposal, and before retrying a refused read
+	clientPoll = 10 * time.Millisecond
+	// delay before asking again for a leader
+	clientBackoff = 50 * time.Millisecond
+)
+
+// history records the operations of the clients, in a common virtual clock
+type history struct {
+	mu  sync.Mutex
+	ops []linearizability.Operation
+}
+
+func (h *history) add(op linearizability.Operation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ops = append(h.ops, op)
+}
+
+// History returns the operations the clients completed or gave up on so far
+func (c *Cluster) History() []linearizability.Operation {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+	return append([]linearizability.Operation(nil), c.history.ops...)
+}
+
+// SetupWallets creates an admin and count validated users owning one empty wallet each, wallets 1 to
+// count. It runs before the clients and expects a cluster without faults
+func (c *Cluster) SetupWallets(count int) error {
+	admin := utils.AdminPayload{FirstName: "sim", LastName: "admin", Email: "admin@sim.invalid", HashedPassword: "x",
+		Action: utils.AdminCreateAccount, PollID: "setup-admin"}
+	steps := [][]utils.Payload{{admin}, nil, nil}
+	for k := 1; k <= count; k++ {
+		steps[0] = append(steps[0], utils.UserPayload{FirstName: "user", LastName: strconv.Itoa(k), HashedPassword: "x",
+			Email: fmt.Sprintf("wallet%d@sim.invalid", k), UserID: -1, Action: utils.UserCreateAccount,
+			PollID: fmt.Sprintf("setup-user-%d", k)})
+		steps[1] = append(steps[1], utils.AdminPayload{AdminID: 1, UserId: k, Action: utils.AdminValidateUser,
+			PollID: fmt.Sprintf("setup-validate-%d", k)})
+		steps[2] = append(steps[2], utils.UserPayload{UserID: k, Action: utils.UserCreateWallet,
+			PollID: fmt.Sprintf("setup-wallet-%d", k)})
+	}
+	for _, payloads := range steps {
+		leader, err := c.WaitForLeader(5 * time.Second)
+		if err != nil {
+			return err
+		}
+		if err := c.Propose(leader, payloads...); err != nil {
+			return err
+		}
+		last := pollOf(payloads[len(payloads)-1])
+		err = c.WaitFor(5*time.Second, "setup of the wallets", func() bool {
+			entry, err := c.nodes[leader].State.GetLogEntryByPoll(last)
+			return err == nil && entry.Applied
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.record("setup %d wallets", count)
+	// every wallet must be usable, or the model would not match the ledger
+	n := c.Node(c.Leader())
+	if n == nil {
+		return fmt.Errorf("sim: no leader after the setup")
+	}
+	for k := 1; k <= count; k++ {
+		var wallet models.Wallet
+		if err := n.StateMachine.DB.First(&wallet, "wallet_id = ?", k).Error; err != nil {
+			return fmt.Errorf("sim: wallet %d was not created: %w", k, err)
+		}
+		var user models.User
+		if err := n.StateMachine.DB.First(&user, "user_id = ?", wallet.UserID).Error; err != nil {
+			return fmt.Errorf("sim: owner of wallet %d was not created: %w", k, err)
+		}
+		if user.Status != utils.AccountActive {
+			return fmt.Errorf("sim: owner of wallet %d is %s", k, user.Status)
+		}
+	}
+	return nil
+}
+
+func pollOf(p utils.Payload) string {
+	switch p := p.(type) {
+	case utils.UserPayload:
+		return p.PollID
+	case utils.AdminPayload:
+		return p.PollID
+	case utils.WalletOperationPayload:
+		return p.PollID
+	}
+	return ""
+}
+
+// RandomOps draws count deposits, transfers and reads over wallets 1 to wallets
+func RandomOps(rng *rand.Rand, count, wallets int) []linearizability.WalletInput {
+	ops := make([]linearizability.WalletInput, count)
+	for i := range ops {
+		wallet := 1 + rng.Intn(wallets)
+		switch r := rng.Intn(10); {
+		case r < 4:
+			ops[i] = linearizability.WalletInput{Kind: linearizability.Deposit, Wallet: wallet, Amount: 1 + rng.Int63n(100)}
+		case r < 8:
+			to := 1 + rng.Intn(wallets-1)
+			if to >= wallet {
+				to++
+			}
+			ops[i] = linearizability.WalletInput{Kind: linearizability.Transfer, Wallet: wallet, To: to,
+				Amount: 1 + rng.Int63n(150)}
+		default:
+			ops[i] = linearizability.WalletInput{Kind: linearizability.Balance, Wallet: wallet}
+		}
+	}
+	return ops
+}
+
+// StartClient runs a client issuing ops one after the other in the background, its operations are
+// added to the history. ClientsDone reports when every client is done
+func (c *Cluster) StartClient(ops []linearizability.WalletInput) {
+	cl := &client{c: c, id: c.clientCount, rng: rand.New(rand.NewSource(c.rng.Int63())), node: c.rng.Intn(len(c.nodes))}
+	c.clientCount++
+	c.clientsLeft.Add(1)
+	go func() {
+		defer c.clientsLeft.Add(-1)
+		cl.run(ops)
+	}()
+}
+
+// ClientsDone reports whether every client started went through its operations
+func (c *Cluster) ClientsDone() bool {
+	return c.clientsLeft.Load() == 0
+}
+
+// client calls the cluster like a user of the api: writes go to the leader and complete once applied,
+// reads either go through a read barrier on the leader or read the state machine of any node
+type client struct {
+	c    *Cluster
+	id   int
+	rng  *rand.Rand
+	node int // the node the client talks to
+}
+
+func (cl *client) run(ops []linearizability.WalletInput) {
+	for k, in := range ops {
+		call := cl.now()
+		var out any
+		if in.Kind == linearizability.Balance {
+			out = cl.read(in)
+		} else {
+			out = cl.write(in, fmt.Sprintf("client-%d-%d", cl.id, k))
+		}
+		ret := cl.now()
+		if out == nil {
+			ret = linearizability.Pending
+		}
+		cl.c.history.add(linearizability.Operation{ClientID: cl.id, Input: in, Output: out, Call: call, Return: ret})
+		cl.sleep(time.Duration(cl.rng.Int63n(int64(clientBackoff))))
+	}
+}
+
+func (cl *client) now() int64 {
+	return int64(cl.c.clock.Elapsed())
+}
+
+// sleep waits for d of virtual time
+func (cl *client) sleep(d time.Duration) {
+	<-cl.c.clock.add(-1-cl.id, d, false).ch
+}
+
+// leader returns the node the client believes leads, following the redirection of a follower, or -1
+func (cl *client) leader() int {
+	for hops := 0; hops < 2; hops++ {
+		n := cl.c.Node(cl.node)
+		if n == nil {
+			cl.node = cl.rng.Intn(len(cl.c.nodes))
+			return -1
+		}
+		n.Mu.RLock()
+		status, leaderAddress := n.Status, n.LeaderAddress
+		n.Mu.RUnlock()
+		if status == state.Leader {
+			return cl.node
+		}
+		next, ok := cl.c.net.addresses[leaderAddress]
+		if !ok || next == cl.node {
+			cl.node = cl.rng.Intn(len(cl.c.nodes))
+			return -1
+		}
+		cl.node = next
+	}
+	return -1
+}
+
+// write proposes a deposit or a transfer and waits until the node it was proposed to applied it
+func (cl *client) write(in linearizability.WalletInput, poll string) any {
+	payload := utils.WalletOperationPayload{Wallet1: in.Wallet, Wallet2: -1, Amount: in.Amount, PollID: poll,
+		Action: utils.WalletDeposit}
+	if in.Kind == linearizability.Transfer {
+		payload.Wallet2, payload.Action = in.To, utils.WalletTransfer
+	}
+	deadline := cl.now() + int64(clientTimeout)
+	target := -1
+	for target < 0 {
+		if leader := cl.leader(); leader >= 0 && cl.c.Propose(leader, payload) == nil {
+			target = leader
+			break
+		}
+		if cl.now() > deadline {
+			return nil
+		}
+		cl.sleep(clientBackoff)
+	}
+	for cl.now() <= deadline {
+		cl.sleep(clientPoll)
+		n := cl.c.Node(target)
+		if n == nil {
+			// the proposal may have been replicated before the crash, or not
+			return nil
+		}
+		entry, err := n.State.GetLogEntryByPoll(poll)
+		if err == nil && entry.Applied {
+			return linearizability.WalletOutput{OK: entry.Status == utils.TxSuccess}
+		}
+	}
+	return nil
+}
+
+// read returns the balance of a wallet
+func (cl *client) read(in linearizability.WalletInput) any {
+	deadline := cl.now() + int64(clientTimeout)
+	for {
+		target := cl.leader()
+		if cl.c.cfg.LocalReads {
+			// as the api serves a get, from whatever node it reached
+			target = cl.node
+		}
+		if target >= 0 {
+			if n := cl.c.Node(target); n != nil && (cl.c.cfg.LocalReads || n.ReadBarrier() == nil) {
+				var wallet models.Wallet
+				if err := n.StateMachine.DB.First(&wallet, "wallet_id = ?", in.Wallet).Error; err == nil {
+					return linearizability.WalletOutput{Balance: wallet.Balance}
+				}
+			}
+		}
+		if cl.now() > deadline {
+			return nil
+		}
+		cl.sleep(clientPoll)
+	}
+}
@@ -0,0 +1,197 @@
+package sim
+
+import (
+	"sync"
+	"time"
+
+	"raft/state"
+)
+
+// epoch is the virtual time a simulation starts at
+var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is the virtual clock of a simulation. Time only moves when the cluster fires the next timer, so
+// a run does not depend on how fast the host is
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers []*timer
+}
+
+func newClock() *Clock {
+	return &Clock{now: epoch}
+}
+
+// Now returns the virtual time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Elapsed returns the virtual time since the start of the simulation
+func (c *Clock) Elapsed() time.Duration {
+	return c.Now().Sub(epoch)
+}
+
+// nextSeq orders the timers and the messages scheduled at the same instant
+func (c *Clock) nextSeq() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	return c.seq
+}
+
+// For returns the clock handed to the node at index owner
+func (c *Clock) For(owner int) state.Clock {
+	return nodeClock{clock: c, owner: owner}
+}
+
+type nodeClock struct {
+	clock *Clock
+	owner int
+}
+
+// Now returns the virtual time, the same for every node
+func (nc nodeClock) Now() time.Time {
+	return nc.clock.Now()
+}
+
+// NewTimer is only used for the election timer of a node
+func (nc nodeClock) NewTimer(d time.Duration) state.Timer {
+	return nc.clock.add(nc.owner, d, true)
+}
+
+func (nc nodeClock) After(d time.Duration) <-chan time.Time {
+	return nc.clock.add(nc.owner, d, false).ch
+}
+
+type timer struct {
+	clock    *Clock
+	owner    int
+	election bool
+	when     time.Time
+	seq      uint64
+	active   bool
+	ch       chan time.Time
+}
+
+func (c *Clock) add(owner int, d time.Duration, election bool) *timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &timer{clock: c, owner: owner, election: election, when: c.now.Add(d), seq: c.seq, active: true,
+		ch: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+// Reset discards a tick not yet received, like time.Timer does
+func (t *timer) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.drain()
+	t.clock.seq++
+	t.when = t.clock.now.Add(d)
+	t.seq = t.clock.seq
+	if !t.active {
+		t.active = true
+		t.clock.timers = append(t.clock.timers, t)
+	}
+}
+
+func (t *timer) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.drain()
+	t.active = false
+	t.clock.remove(t)
+}
+
+func (t *timer) drain() {
+	select {
+	case <-t.ch:
+	default:
+	}
+}
+
+func (c *Clock) remove(t *timer) {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// next returns the timer due first, ties are broken by the order the timers were set in
+func (c *Clock) next() (*timer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var first *timer
+	for _, t := range c.timers {
+		if first == nil || t.when.Before(first.when) || (t.when.Equal(first.when) && t.seq < first.seq) {
+			first = t
+		}
+	}
+	return first, first != nil
+}
+
+// advance moves the clock forward to at, never backward
+func (c *Clock) advance(at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if at.After(c.now) {
+		c.now = at
+	}
+}
+
+// fire delivers the tick of t, which must be due
+func (c *Clock) fire(t *timer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !t.active {
+		return
+	}
+	t.active = false
+	c.remove(t)
+	select {
+	case t.ch <- c.now:
+	default:
+	}
+}
+
+// expire makes the election timer of owner due now
+func (c *Clock) expire(owner int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.timers {
+		if t.owner == owner && t.election {
+			c.seq++
+			t.when = c.now
+			t.seq = c.seq
+			return true
+		}
+	}
+	return false
+}
+
+// forget drops the timers of a crashed node
+func (c *Clock) forget(owner int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.timers[:0]
+	for _, t := range c.timers {
+		if t.owner == owner {
+			t.active = false
+			continue
+		}
+		kept = append(kept, t)
+	}
+	c.timers = kept
+}
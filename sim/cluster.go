@@ -0,0 +1,701 @@
+// Package sim runs several raft nodes in one process over an in-memory network and a virtual clock.
+// Every delay, loss, duplication and election timeout is drawn from one seeded generator and the
+// cluster delivers one message or fires one timer at a time, once every goroutine of the nodes is
+// blocked. A run is thus replayed exactly from its seed
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"raft/datadir"
+	pb "raft/raft"
+	"raft/rpc_server"
+	"raft/state"
+	"raft/utils"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// cluster of every simulated node
+const simClusterID = "sim"
+
+// DefaultTiming paces the simulated nodes, faster than the defaults of a real deployment to keep the
+// runs short
+var DefaultTiming = state.Timing{
+	ElectionTimeoutMin: 300 * time.Millisecond,
+	ElectionTimeoutMax: 600 * time.Millisecond,
+	HeartbeatInterval:  50 * time.Millisecond,
+	RPCTimeout:         100 * time.Millisecond,
+}
+
+// Config describes a simulated cluster
+type Config struct {
+	Nodes  int
+	Seed   int64
+	Faults Faults
+	Timing state.Timing
+	// Dir keeps the databases of the nodes, a temporary directory removed on Close when empty
+	Dir string
+	// Trace receives one line per step when set
+	Trace io.Writer
+	// LocalReads makes the clients read the state machine of the node they reach, as the api does,
+	// instead of going through a read barrier on the leader
+	LocalReads bool
+	// Log opens the log store of each node, the sqlite log when nil
+	Log state.LogOpener
+}
+
+// Cluster is a simulated raft cluster, it is driven from a single goroutine
+type Cluster struct {
+	cfg       Config
+	rng       *rand.Rand
+	clock     *Clock
+	net       *network
+	dir       string
+	ownDir    bool
+	addresses []string
+	nodes     []*state.Node
+	servers   []pb.RaftServer
+	queues    []*queue
+	down      []bool
+	items     schedule
+	delays    map[link]time.Duration
+	cut       map[link]bool
+	trace     hash.Hash
+	steps     int
+	submitted int
+	checker   *checker
+	// nodes and down are written by the driver under mu, clients read them through Node and Up
+	mu          sync.Mutex
+	history     history
+	clientCount int
+	clientsLeft atomic.Int32
+}
+
+type link struct{ from, to int }
+
+// NewCluster creates and starts the nodes of cfg
+func NewCluster(cfg Config) (*Cluster, error) {
+	if cfg.Nodes < 1 {
+		return nil, fmt.Errorf("sim: a cluster needs at least one node")
+	}
+	if cfg.Faults == (Faults{}) {
+		cfg.Faults = DefaultFaults
+	}
+	if cfg.Timing == (state.Timing{}) {
+		cfg.Timing = DefaultTiming
+	}
+	c := &Cluster{
+		cfg:    cfg,
+		rng:    rand.New(rand.NewSource(cfg.Seed)),
+		clock:  newClock(),
+		dir:    cfg.Dir,
+		delays: map[link]time.Duration{},
+		cut:    map[link]bool{},
+		trace:  sha256.New(),
+	}
+	if c.dir == "" {
+		dir, err := os.MkdirTemp("", "raft-sim-")
+		if err != nil {
+			return nil, err
+		}
+		c.dir, c.ownDir = dir, true
+	}
+	for i := 0; i < cfg.Nodes; i++ {
+		c.addresses = append(c.addresses, strconv.Itoa(7001+i))
+	}
+	c.net = newNetwork(c.addresses)
+	c.nodes = make([]*state.Node, cfg.Nodes)
+	c.servers = make([]pb.RaftServer, cfg.Nodes)
+	c.queues = make([]*queue, cfg.Nodes)
+	c.down = make([]bool, cfg.Nodes)
+	c.checker = newChecker(cfg.Nodes)
+	if err := c.bootstrap(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	for i := range c.nodes {
+		c.queues[i] = &queue{}
+		if err := c.start(i); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// bootstrap writes the identity and the configuration entry of every node, node i is node-i
+func (c *Cluster) bootstrap() error {
+	members := make([]utils.Member, len(c.addresses))
+	for i, address := range c.addresses {
+		members[i] = utils.Member{NodeID: fmt.Sprintf("node-%d", i), Address: address}
+	}
+	for i, m := range members {
+		layout := datadir.Flat(c.dir, c.addresses[i])
+		dir, err := datadir.Init(layout, datadir.Identity{NodeID: m.NodeID, ClusterID: simClusterID})
+		if err != nil {
+			return err
+		}
+		ps, err := state.InitPersistentState(layout.Log)
+		if err != nil {
+			dir.Close()
+			return err
+		}
+		err = ps.Bootstrap(members)
+		ps.Close()
+		dir.Close()
+		if err != nil {
+			return fmt.Errorf("sim: failed to bootstrap node %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// start opens the databases of node i and runs it
+func (c *Cluster) start(i int) error {
+	n, err := state.OpenNode(datadir.Flat(c.dir, c.addresses[i]), simClusterID, c.addresses[i], c.cfg.Log)
+	if err != nil {
+		return err
+	}
+	n.SetTiming(c.cfg.Timing)
+	n.SetTransport(endpoint{nw: c.net, from: i})
+	n.SetClock(c.clock.For(i), rand.New(rand.NewSource(c.rng.Int63())))
+	n.SetProposalQueue(c.queues[i])
+	c.mu.Lock()
+	c.nodes[i] = n
+	c.down[i] = false
+	c.mu.Unlock()
+	c.servers[i] = rpc_server.NewServer(n)
+	c.net.restart(i)
+	n.Start(context.Background())
+	return nil
+}
+
+// Close stops the nodes still up and removes the temporary directory of the cluster
+func (c *Cluster) Close() error {
+	var first error
+	for i, n := range c.nodes {
+		if n == nil || c.down[i] {
+			continue
+		}
+		if err := c.stop(i); err != nil && first == nil {
+			first = err
+		}
+	}
+	if c.ownDir {
+		os.RemoveAll(c.dir)
+	}
+	return first
+}
+
+// stop takes node i down, the calls it waits on fail at once
+func (c *Cluster) stop(i int) error {
+	c.mu.Lock()
+	c.down[i] = true
+	c.mu.Unlock()
+	c.net.crash(i)
+	// what the api had queued on the node is lost with it
+	c.queues[i].Clear()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.nodes[i].Stop(ctx); err != nil {
+		return err
+	}
+	c.clock.forget(i)
+	return c.nodes[i].Close()
+}
+
+// Nodes returns the number of nodes of the cluster
+func (c *Cluster) Nodes() int {
+	return len(c.nodes)
+}
+
+// Node returns node i, nil once it crashed until it restarts
+func (c *Cluster) Node(i int) *state.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.down[i] {
+		return nil
+	}
+	return c.nodes[i]
+}
+
+// Up reports whether node i runs
+func (c *Cluster) Up(i int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.down[i]
+}
+
+// Now returns the virtual time elapsed since the start of the run
+func (c *Cluster) Now() time.Duration {
+	return c.clock.Elapsed()
+}
+
+// Steps returns the number of messages delivered and timers fired so far
+func (c *Cluster) Steps() int {
+	return c.steps
+}
+
+// TraceHash sums every step of the run, two runs with the same seed and scenario have the same hash
+func (c *Cluster) TraceHash() string {
+	return hex.EncodeToString(c.trace.Sum(nil))
+}
+
+// Rand returns the generator of the cluster, scenarios draw their own choices from it
+func (c *Cluster) Rand() *rand.Rand {
+	return c.rng
+}
+
+func (c *Cluster) record(format string, args ...any) {
+	line := fmt.Sprintf("%v %s\n", c.Now(), fmt.Sprintf(format, args...))
+	c.trace.Write([]byte(line))
+	if c.cfg.Trace != nil {
+		io.WriteString(c.cfg.Trace, line)
+	}
+}
+
+// Crash stops node i as if its process died: its pending calls fail and its queued proposals are lost,
+// its databases are kept
+func (c *Cluster) Crash(i int) error {
+	if c.down[i] {
+		return nil
+	}
+	if err := c.settle(); err != nil {
+		return err
+	}
+	c.record("crash %d", i)
+	return c.stop(i)
+}
+
+// Restart runs node i again from its databases
+func (c *Cluster) Restart(i int) error {
+	if !c.down[i] {
+		return nil
+	}
+	c.record("restart %d", i)
+	c.checker.restarted(i)
+	return c.start(i)
+}
+
+// Partition cuts the links between nodes of different groups, nodes in no group keep all their links
+func (c *Cluster) Partition(groups ...[]int) {
+	c.record("partition %v", groups)
+	group := map[int]int{}
+	for g, nodes := range groups {
+		for _, n := range nodes {
+			group[n] = g
+		}
+	}
+	for a, ga := range group {
+		for b, gb := range group {
+			if ga != gb {
+				c.cut[link{a, b}] = true
+			}
+		}
+	}
+}
+
+// Heal restores every link
+func (c *Cluster) Heal() {
+	c.record("heal")
+	c.cut = map[link]bool{}
+}
+
+// SetDelay fixes the delay of the messages from node from to node to
+func (c *Cluster) SetDelay(from, to int, d time.Duration) {
+	c.delays[link{from, to}] = d
+}
+
+// ClearDelays draws the delay of every message from the faults again
+func (c *Cluster) ClearDelays() {
+	c.delays = map[link]time.Duration{}
+}
+
+// ExpireElection makes the election timer of node i fire now
+func (c *Cluster) ExpireElection(i int) error {
+	if err := c.settle(); err != nil {
+		return err
+	}
+	if !c.clock.expire(i) {
+		return fmt.Errorf("sim: node %d has no election timer", i)
+	}
+	c.record("expire election %d", i)
+	return nil
+}
+
+// Submit queues count operations on node i as its api server would, the leader appends them at its
+// next heartbeat. It returns the poll ids of the operations
+func (c *Cluster) Submit(i, count int) ([]string, error) {
+	payloads := make([]utils.Payload, 0, count)
+	polls := make([]string, 0, count)
+	for k := 0; k < count; k++ {
+		c.submitted++
+		p := utils.UserPayload{
+			FirstName:      "user",
+			LastName:       strconv.Itoa(c.submitted),
+			HashedPassword: "x",
+			Email:          fmt.Sprintf("user%d@sim.invalid", c.submitted),
+			Action:         utils.UserCreateAccount,
+			UserID:         -1,
+			PollID:         fmt.Sprintf("sim-%d", c.submitted),
+		}
+		payloads = append(payloads, p)
+		polls = append(polls, p.PollID)
+	}
+	if err := c.Propose(i, payloads...); err != nil {
+		return nil, err
+	}
+	c.record("submit %d to %d", count, i)
+	return polls, nil
+}
+
+// Propose queues payloads on node i, stamped with its current term as the api server does
+func (c *Cluster) Propose(i int, payloads ...utils.Payload) error {
+	n := c.Node(i)
+	if n == nil {
+		return fmt.Errorf("sim: node %d is down", i)
+	}
+	term, err := n.State.GetCurrentTerm()
+	if err != nil {
+		return err
+	}
+	for _, p := range payloads {
+		switch p := p.(type) {
+		case utils.UserPayload:
+			p.Term = term
+			c.queues[i].push(p)
+		case utils.AdminPayload:
+			p.Term = term
+			c.queues[i].push(p)
+		case utils.WalletOperationPayload:
+			p.Term = term
+			c.queues[i].push(p)
+		default:
+			return fmt.Errorf("sim: unknown payload %T", p)
+		}
+	}
+	return nil
+}
+
+// Leader returns the node leading in the latest term, -1 when no node leads
+func (c *Cluster) Leader() int {
+	leader, best := -1, int32(-1)
+	for i, n := range c.nodes {
+		if c.down[i] || !isLeader(n) {
+			continue
+		}
+		term, err := n.State.GetCurrentTerm()
+		if err == nil && term > best {
+			leader, best = i, term
+		}
+	}
+	return leader
+}
+
+func isLeader(n *state.Node) bool {
+	n.Mu.RLock()
+	defer n.Mu.RUnlock()
+	return n.Status == state.Leader
+}
+
+// CommitIndex returns the commit index of node i
+func (c *Cluster) CommitIndex(i int) int32 {
+	n := c.nodes[i]
+	n.Mu.RLock()
+	defer n.Mu.RUnlock()
+	return n.CommitIndex
+}
+
+// RunFor steps the cluster for d of virtual time
+func (c *Cluster) RunFor(d time.Duration) error {
+	until := c.clock.Now().Add(d)
+	for {
+		when, ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if !ok || when.After(until) {
+			c.clock.advance(until)
+			return nil
+		}
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+}
+
+// WaitFor steps the cluster until cond holds, for at most within of virtual time
+func (c *Cluster) WaitFor(within time.Duration, what string, cond func() bool) error {
+	deadline := c.clock.Now().Add(within)
+	for {
+		if err := c.settle(); err != nil {
+			return err
+		}
+		if cond() {
+			return nil
+		}
+		when, ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if !ok || when.After(deadline) {
+			return fmt.Errorf("sim: %s did not happen within %v (at %v)", what, within, c.Now())
+		}
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+}
+
+// WaitForLeader steps the cluster until a node leads
+func (c *Cluster) WaitForLeader(within time.Duration) (int, error) {
+	leader := -1
+	err := c.WaitFor(within, "election of a leader", func() bool {
+		leader = c.Leader()
+		return leader >= 0
+	})
+	return leader, err
+}
+
+// WaitForCommit steps the cluster until each of nodes has committed index
+func (c *Cluster) WaitForCommit(index int32, within time.Duration, nodes ...int) error {
+	return c.WaitFor(within, fmt.Sprintf("commit of index %d on %v", index, nodes), func() bool {
+		for _, i := range nodes {
+			if c.down[i] || c.CommitIndex(i) < index {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// next returns the time of the next message or timer
+func (c *Cluster) next() (time.Time, bool, error) {
+	if err := c.settle(); err != nil {
+		return time.Time{}, false, err
+	}
+	c.dispatch()
+	t, hasTimer := c.clock.next()
+	if len(c.items) == 0 {
+		if !hasTimer {
+			return time.Time{}, false, nil
+		}
+		return t.when, true, nil
+	}
+	first := c.items[0]
+	if hasTimer && t.when.Before(first.when) {
+		return t.when, true, nil
+	}
+	return first.when, true, nil
+}
+
+// Step delivers the next message or fires the next timer, whichever is due first, and checks the
+// cluster once the nodes are done with it
+func (c *Cluster) Step() error {
+	if err := c.settle(); err != nil {
+		return err
+	}
+	c.dispatch()
+	t, hasTimer := c.clock.next()
+	if len(c.items) == 0 && !hasTimer {
+		return fmt.Errorf("sim: nothing left to run at %v", c.Now())
+	}
+	c.steps++
+	if hasTimer && (len(c.items) == 0 || t.when.Before(c.items[0].when) ||
+		(t.when.Equal(c.items[0].when) && t.seq < c.items[0].seq)) {
+		c.clock.advance(t.when)
+		kind := "tick"
+		if t.election {
+			kind = "election timeout"
+		}
+		c.record("%s %d", kind, t.owner)
+		c.clock.fire(t)
+	} else {
+		it := heap.Pop(&c.items).(*item)
+		c.clock.advance(it.when)
+		c.run(it)
+	}
+	if err := c.settle(); err != nil {
+		return err
+	}
+	return c.checker.check(c)
+}
+
+// dispatch schedules the rpcs the nodes sent since the last step, in a canonical order so the draws
+// do not depend on the order the goroutines ran in
+func (c *Cluster) dispatch() {
+	calls := c.net.collect()
+	sort.Slice(calls, func(a, b int) bool { return calls[a].key < calls[b].key })
+	for _, call := range calls {
+		c.record("send %s %d->%d", call.kind, call.from, call.to)
+		c.push(&item{when: c.clock.Now().Add(call.timeout), kind: deadline, call: call})
+		c.transmit(call, request, call.from, call.to, result{})
+		if c.rng.Float64() < c.cfg.Faults.DuplicateRate {
+			c.record("duplicate %s %d->%d", call.kind, call.from, call.to)
+			c.transmit(call, request, call.from, call.to, result{})
+		}
+	}
+}
+
+// transmit schedules the delivery of a message unless the network loses it
+func (c *Cluster) transmit(call *call, kind itemKind, from, to int, r result) {
+	if c.cut[link{from, to}] {
+		c.record("cut %s %s %d->%d", kind, call.kind, from, to)
+		return
+	}
+	if c.rng.Float64() < c.cfg.Faults.DropRate {
+		c.record("drop %s %s %d->%d", kind, call.kind, from, to)
+		return
+	}
+	c.push(&item{when: c.clock.Now().Add(c.delay(from, to)), kind: kind, call: call, result: r})
+}
+
+func (c *Cluster) delay(from, to int) time.Duration {
+	if d, ok := c.delays[link{from, to}]; ok {
+		return d
+	}
+	spread := c.cfg.Faults.MaxDelay - c.cfg.Faults.MinDelay
+	return c.cfg.Faults.MinDelay + time.Duration(c.rng.Int63n(int64(spread)+1))
+}
+
+func (c *Cluster) push(it *item) {
+	it.seq = c.clock.nextSeq()
+	heap.Push(&c.items, it)
+}
+
+// run delivers a request or a response, or times a call out
+func (c *Cluster) run(it *item) {
+	call := it.call
+	switch it.kind {
+	case request:
+		if c.down[call.to] || c.cut[link{call.from, call.to}] {
+			c.record("lost request %s %d->%d", call.kind, call.from, call.to)
+			return
+		}
+		resp, err := c.handle(call)
+		c.record("handled %s %d->%d %s", call.kind, call.from, call.to, digestMessage(resp, err))
+		c.transmit(call, response, call.to, call.from, result{resp: resp, err: err})
+	case response:
+		if c.down[call.from] {
+			return
+		}
+		c.record("response %s %d->%d", call.kind, call.to, call.from)
+		c.net.answer(call, it.result)
+	case deadline:
+		if c.down[call.from] {
+			return
+		}
+		if c.net.answer(call, result{err: ErrDeadline}) {
+			c.record("deadline %s %d->%d", call.kind, call.from, call.to)
+		}
+	}
+}
+
+// handle runs the rpc server of the callee inline, the other goroutines are blocked meanwhile
+func (c *Cluster) handle(call *call) (proto.Message, error) {
+	srv := c.servers[call.to]
+	ctx := context.Background()
+	switch req := call.req.(type) {
+	case *pb.RequestVoteRequest:
+		return srv.RequestVote(ctx, req)
+	case *pb.AppendEntriesRequest:
+		return srv.AppendEntries(ctx, req)
+	case *pb.FetchBlobRequest:
+		return srv.FetchBlob(ctx, req)
+	}
+	return nil, fmt.Errorf("sim: unknown request %T", call.req)
+}
+
+func digestMessage(m proto.Message, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	raw, _ := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+type itemKind int
+
+const (
+	request itemKind = iota
+	response
+	deadline
+)
+
+func (k itemKind) String() string {
+	switch k {
+	case request:
+		return "request"
+	case response:
+		return "response"
+	}
+	return "deadline"
+}
+
+// item is a message in flight or the deadline of a call
+type item struct {
+	when   time.Time
+	seq    uint64
+	kind   itemKind
+	call   *call
+	result result
+}
+
+// schedule orders the items by time, then by the order they were scheduled in
+type schedule []*item
+
+func (s schedule) Len() int { return len(s) }
+func (s schedule) Less(a, b int) bool {
+	if s[a].when.Equal(s[b].when) {
+		return s[a].seq < s[b].seq
+	}
+	return s[a].when.Before(s[b].when)
+}
+func (s schedule) Swap(a, b int) { s[a], s[b] = s[b], s[a] }
+func (s *schedule) Push(x any)   { *s = append(*s, x.(*item)) }
+func (s *schedule) Pop() any {
+	old := *s
+	it := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return it
+}
+
+// queue replaces the redis proposal queue of a node
+type queue struct {
+	mu       sync.Mutex
+	payloads []utils.Payload
+}
+
+func (q *queue) push(p utils.Payload) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.payloads = append(q.payloads, p)
+}
+
+func (q *queue) Retrieve() ([]utils.Payload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]utils.Payload(nil), q.payloads...), nil
+}
+
+func (q *queue) Clear() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.payloads = nil
+	return nil
+}
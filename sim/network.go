@@ -0,0 +1,188 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	pb "raft/raft"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrUnreachable is returned to a node calling from or to a crashed node
+	ErrUnreachable = errors.New("sim: node unreachable")
+	// ErrDeadline is returned when no response came back before the timeout of the call
+	ErrDeadline = errors.New("sim: deadline exceeded")
+)
+
+// kinds of rpcs
+const (
+	requestVote   = "RequestVote"
+	appendEntries = "AppendEntries"
+	fetchBlob     = "FetchBlob"
+)
+
+// Faults shapes the network of a simulation, every decision is drawn from the seeded generator of the
+// cluster
+type Faults struct {
+	// a message takes a delay drawn in this range
+	MinDelay, MaxDelay time.Duration
+	// probability that a message is lost, and that a request is delivered twice
+	DropRate, DuplicateRate float64
+}
+
+// DefaultFaults is a network that delays messages but loses none
+var DefaultFaults = Faults{MinDelay: time.Millisecond, MaxDelay: 20 * time.Millisecond}
+
+// call is an rpc on its way, the calling goroutine waits on reply
+type call struct {
+	from, to int
+	kind     string
+	req      proto.Message
+	timeout  time.Duration
+	reply    chan result
+	done     bool
+	key      string
+}
+
+type result struct {
+	resp proto.Message
+	err  error
+}
+
+// network holds the rpcs the nodes sent since the last step of the cluster. The cluster alone delivers
+// them, in an order only its generator decides
+type network struct {
+	mu        sync.Mutex
+	addresses map[string]int
+	down      map[int]bool
+	outbox    []*call
+	waiting   []*call
+}
+
+func newNetwork(addresses []string) *network {
+	nw := &network{addresses: map[string]int{}, down: map[int]bool{}}
+	for i, address := range addresses {
+		nw.addresses[address] = i
+	}
+	return nw
+}
+
+// send queues an rpc and waits for its outcome
+func (nw *network) send(from int, peer, kind string, req proto.Message, timeout time.Duration) (proto.Message, error) {
+	nw.mu.Lock()
+	to, ok := nw.addresses[peer]
+	if !ok {
+		nw.mu.Unlock()
+		return nil, fmt.Errorf("sim: unknown peer %s", peer)
+	}
+	if nw.down[from] {
+		nw.mu.Unlock()
+		return nil, ErrUnreachable
+	}
+	raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		nw.mu.Unlock()
+		return nil, err
+	}
+	c := &call{from: from, to: to, kind: kind, req: req, timeout: timeout, reply: make(chan result, 1),
+		key: fmt.Sprintf("%04d %04d %s %x", from, to, kind, raw)}
+	nw.outbox = append(nw.outbox, c)
+	nw.mu.Unlock()
+	r := <-c.reply
+	return r.resp, r.err
+}
+
+// collect returns the rpcs sent since the last call, they now wait for a response
+func (nw *network) collect() []*call {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	calls := nw.outbox
+	nw.outbox = nil
+	nw.waiting = append(nw.waiting, calls...)
+	return calls
+}
+
+// answer ends a call, later responses to it are ignored. It reports whether the call was still waiting
+func (nw *network) answer(c *call, r result) bool {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if c.done {
+		return false
+	}
+	c.done = true
+	c.reply <- r
+	for i, other := range nw.waiting {
+		if other == c {
+			nw.waiting = append(nw.waiting[:i], nw.waiting[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// crash makes node unreachable and fails the calls it is waiting on, so its goroutines can return
+func (nw *network) crash(node int) {
+	nw.mu.Lock()
+	nw.down[node] = true
+	var pending []*call
+	for _, c := range slices.Concat(nw.waiting, nw.outbox) {
+		if c.from == node {
+			pending = append(pending, c)
+		}
+	}
+	nw.mu.Unlock()
+	for _, c := range pending {
+		nw.answer(c, result{err: ErrUnreachable})
+	}
+	// calls still in the outbox were answered, they must not be delivered
+	nw.mu.Lock()
+	kept := nw.outbox[:0]
+	for _, c := range nw.outbox {
+		if c.from != node {
+			kept = append(kept, c)
+		}
+	}
+	nw.outbox = kept
+	nw.mu.Unlock()
+}
+
+func (nw *network) restart(node int) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	delete(nw.down, node)
+}
+
+// endpoint is the transport of one node
+type endpoint struct {
+	nw   *network
+	from int
+}
+
+func (e endpoint) RequestVote(peer string, req *pb.RequestVoteRequest, timeout time.Duration) (*pb.RequestVoteResponse, error) {
+	resp, err := e.nw.send(e.from, peer, requestVote, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.RequestVoteResponse), nil
+}
+
+func (e endpoint) AppendEntries(peer string, req *pb.AppendEntriesRequest, timeout time.Duration) (*pb.AppendEntriesResponse, error) {
+	resp, err := e.nw.send(e.from, peer, appendEntries, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.AppendEntriesResponse), nil
+}
+
+func (e endpoint) FetchBlob(peer string, req *pb.FetchBlobRequest, timeout time.Duration) (*pb.FetchBlobResponse, error) {
+	resp, err := e.nw.send(e.from, peer, fetchBlob, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.FetchBlobResponse), nil
+}
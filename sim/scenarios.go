@@ -0,0 +1,365 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"time"
+
+	"raft/linearizability"
+	"raft/state"
+)
+
+// index of the first entry of a scenario, the log of the cluster starts with its configuration entry
+const firstIndex = state.ConfigurationIndex + 1
+
+// Scenario drives a cluster through a sequence of faults and fails when raft misbehaves
+type Scenario struct {
+	Name        string
+	Description string
+	Nodes       int
+	Run         func(c *Cluster) error
+}
+
+// Scenarios lists the scenarios the runner knows
+var Scenarios = []Scenario{
+	{
+		Name:        "leader-crash",
+		Description: "the leader crashes after committing, a new leader commits more and the old one catches up on restart",
+		Nodes:       3,
+		Run:         leaderCrash,
+	},
+	{
+		Name:        "split-vote",
+		Description: "two nodes of four time out together and split the votes, no one leads until a later term",
+		Nodes:       4,
+		Run:         splitVote,
+	},
+	{
+		Name:        "log-divergence",
+		Description: "a partitioned leader appends entries it cannot commit, they are replaced once the partition heals",
+		Nodes:       5,
+		Run:         logDivergence,
+	},
+	{
+		Name:        "chaos",
+		Description: "random crashes, restarts and partitions under a steady load",
+		Nodes:       5,
+		Run:         chaos,
+	},
+	{
+		Name:        "linearizable",
+		Description: "clients deposit, transfer and read balances through crashes and partitions, the history must be linearizable",
+		Nodes:       5,
+		Run:         linearizable,
+	},
+}
+
+// Lookup finds a scenario by name
+func Lookup(name string) (Scenario, bool) {
+	for _, s := range Scenarios {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// Report sums up a run
+type Report struct {
+	Scenario  string
+	Seed      int64
+	Steps     int
+	Elapsed   time.Duration
+	Committed int
+	// Operations is the size of the history of the clients, if any
+	Operations int
+	TraceHash  string
+}
+
+// RunScenario runs s on a new cluster configured by cfg, the number of nodes is the one of the
+// scenario. The report is filled even when the run fails, the seed replays it
+func RunScenario(s Scenario, cfg Config) (Report, error) {
+	cfg.Nodes = s.Nodes
+	report := Report{Scenario: s.Name, Seed: cfg.Seed}
+	c, err := NewCluster(cfg)
+	if err != nil {
+		return report, err
+	}
+	err = s.Run(c)
+	if err == nil {
+		err = c.Verify()
+	}
+	report.Steps, report.Elapsed, report.Committed, report.TraceHash = c.Steps(), c.Now(), c.Committed(), c.TraceHash()
+	report.Operations = len(c.History())
+	if closeErr := c.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return report, fmt.Errorf("%s with seed %d: %w", s.Name, cfg.Seed, err)
+	}
+	return report, nil
+}
+
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%-15s seed %-6d steps %-6d virtual time %-10v committed %-4d trace %s", r.Scenario, r.Seed,
+		r.Steps, r.Elapsed.Round(time.Millisecond), r.Committed, r.TraceHash[:16])
+	if r.Operations > 0 {
+		fmt.Fprintf(w, " operations %d", r.Operations)
+	}
+	fmt.Fprintln(w)
+}
+
+func all(c *Cluster) []int {
+	nodes := make([]int, c.Nodes())
+	for i := range nodes {
+		nodes[i] = i
+	}
+	return nodes
+}
+
+func without(nodes []int, excluded int) []int {
+	return slices.DeleteFunc(slices.Clone(nodes), func(n int) bool { return n == excluded })
+}
+
+func leaderCrash(c *Cluster) error {
+	leader, err := c.WaitForLeader(5 * time.Second)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Submit(leader, 3); err != nil {
+		return err
+	}
+	if err := c.WaitForCommit(firstIndex+2, 2*time.Second, all(c)...); err != nil {
+		return err
+	}
+	if err := c.Crash(leader); err != nil {
+		return err
+	}
+	next, err := c.WaitForLeader(5 * time.Second)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Submit(next, 3); err != nil {
+		return err
+	}
+	if err := c.WaitForCommit(firstIndex+5, 2*time.Second, without(all(c), leader)...); err != nil {
+		return err
+	}
+	if err := c.Restart(leader); err != nil {
+		return err
+	}
+	return c.WaitForCommit(firstIndex+5, 5*time.Second, all(c)...)
+}
+
+func splitVote(c *Cluster) error {
+	// nodes 0 and 1 campaign together, node 2 hears from 0 first and node 3 from 1 first
+	fast, slow := time.Millisecond, 10*time.Millisecond
+	c.SetDelay(0, 2, fast)
+	c.SetDelay(1, 3, fast)
+	c.SetDelay(1, 2, slow)
+	c.SetDelay(0, 3, slow)
+	if err := c.ExpireElection(0); err != nil {
+		return err
+	}
+	if err := c.ExpireElection(1); err != nil {
+		return err
+	}
+	// both elections end within an rpc timeout, two votes of four make no majority
+	if err := c.RunFor(c.cfg.Timing.RPCTimeout + 50*time.Millisecond); err != nil {
+		return err
+	}
+	if leader, ok := c.LeaderOf(state.ConfigurationTerm + 1); ok {
+		return fmt.Errorf("node %d won a split vote in term %d", leader, state.ConfigurationTerm+1)
+	}
+	c.ClearDelays()
+	leader, err := c.WaitForLeader(10 * time.Second)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Submit(leader, 2); err != nil {
+		return err
+	}
+	return c.WaitForCommit(firstIndex+1, 2*time.Second, all(c)...)
+}
+
+func logDivergence(c *Cluster) error {
+	old, err := c.WaitForLeader(5 * time.Second)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Submit(old, 2); err != nil {
+		return err
+	}
+	if err := c.WaitForCommit(firstIndex+1, 2*time.Second, all(c)...); err != nil {
+		return err
+	}
+	majority := without(all(c), old)
+	c.Partition([]int{old}, majority)
+	// the old leader appends entries no one else receives
+	orphans, err := c.Submit(old, 3)
+	if err != nil {
+		return err
+	}
+	if err := c.RunFor(2 * c.cfg.Timing.HeartbeatInterval); err != nil {
+		return err
+	}
+	var next int
+	err = c.WaitFor(5*time.Second, "election of a leader in the majority", func() bool {
+		next = c.Leader()
+		return next >= 0 && next != old
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.Submit(next, 2); err != nil {
+		return err
+	}
+	if err := c.WaitForCommit(firstIndex+3, 2*time.Second, majority...); err != nil {
+		return err
+	}
+	c.Heal()
+	if err := c.WaitForCommit(firstIndex+3, 5*time.Second, all(c)...); err != nil {
+		return err
+	}
+	store := c.Node(old).Log
+	last, _, err := store.LastIndexAndTerm()
+	if err != nil {
+		return err
+	}
+	entries, err := store.Entries(1, last)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if poll := entry.GetCommand().GetPollID(); slices.Contains(orphans, poll) {
+			return fmt.Errorf("node %d still holds entry %d of the minority, %s", old, entry.GetIndex(), poll)
+		}
+	}
+	return nil
+}
+
+func chaos(c *Cluster) error {
+	rng := c.Rand()
+	for round := 0; round < 20; round++ {
+		if err := randomFault(c, rng); err != nil {
+			return err
+		}
+		if leader := c.Leader(); leader >= 0 {
+			if _, err := c.Submit(leader, 1+rng.Intn(3)); err != nil {
+				return err
+			}
+		}
+		if err := c.RunFor(500 * time.Millisecond); err != nil {
+			return err
+		}
+	}
+	// once the faults stop the cluster must converge
+	c.Heal()
+	for i := range c.Nodes() {
+		if err := c.Restart(i); err != nil {
+			return err
+		}
+	}
+	// a leader commits the entries of earlier terms with one of its own, an entry lost with a change of
+	// leader is proposed again to the next one
+	var index int32
+	for attempt := 0; ; attempt++ {
+		leader, err := c.WaitForLeader(10 * time.Second)
+		if err != nil {
+			return err
+		}
+		if _, err := c.Submit(leader, 1); err != nil {
+			return err
+		}
+		err = c.WaitFor(2*time.Second, "commit of a last entry", func() bool {
+			last, _, err := c.Node(leader).Log.LastIndexAndTerm()
+			index = last
+			return err == nil && last > 0 && c.CommitIndex(leader) == last && c.Leader() == leader
+		})
+		if err == nil {
+			break
+		}
+		if attempt == 4 {
+			return err
+		}
+	}
+	return c.WaitForCommit(index, 5*time.Second, all(c)...)
+}
+
+func linearizable(c *Cluster) error {
+	const wallets, clients, ops = 4, 4, 25
+	if err := c.SetupWallets(wallets); err != nil {
+		return err
+	}
+	rng := c.Rand()
+	for range clients {
+		c.StartClient(RandomOps(rng, ops, wallets))
+	}
+	// the faults of chaos, for as long as the clients run
+	for !c.ClientsDone() {
+		if err := randomFault(c, rng); err != nil {
+			return err
+		}
+		if err := c.RunFor(300 * time.Millisecond); err != nil {
+			return err
+		}
+	}
+	// a last client reads every wallet once the cluster is whole again
+	c.Heal()
+	for i := range c.Nodes() {
+		if err := c.Restart(i); err != nil {
+			return err
+		}
+	}
+	audit := make([]linearizability.WalletInput, wallets)
+	for k := range audit {
+		audit[k] = linearizability.WalletInput{Kind: linearizability.Balance, Wallet: k + 1}
+	}
+	c.StartClient(audit)
+	if err := c.WaitFor(time.Duration(wallets)*clientTimeout, "audit of the wallets", c.ClientsDone); err != nil {
+		return err
+	}
+	model := linearizability.WalletModel(wallets)
+	history := c.History()
+	if res := linearizability.Check(model, history); !res.Ok {
+		return fmt.Errorf("history of the clients is not linearizable\n%s", linearizability.Explain(model, history, res))
+	}
+	return nil
+}
+
+// randomFault crashes a node while a quorum stays up, restarts one, partitions the cluster or heals it,
+// or does nothing
+func randomFault(c *Cluster, rng *rand.Rand) error {
+	switch r := rng.Intn(10); {
+	case r < 2:
+		if victim := rng.Intn(c.Nodes()); c.Up(victim) && c.upCount() > c.Nodes()/2+1 {
+			return c.Crash(victim)
+		}
+	case r < 4:
+		for i := range c.Nodes() {
+			if !c.Up(i) {
+				return c.Restart(i)
+			}
+		}
+	case r < 5:
+		nodes := all(c)
+		rng.Shuffle(len(nodes), func(a, b int) { nodes[a], nodes[b] = nodes[b], nodes[a] })
+		cut := 1 + rng.Intn(c.Nodes()/2)
+		c.Partition(nodes[:cut], nodes[cut:])
+	case r < 7:
+		c.Heal()
+	}
+	return nil
+}
+
+func (c *Cluster) upCount() int {
+	up := 0
+	for i := range c.Nodes() {
+		if c.Up(i) {
+			up++
+		}
+	}
+	return up
+}
@@ -0,0 +1,100 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	log.SetOutput(io.Discard)
+	logger.Default = logger.Discard
+}
+
+// longRuns reports whether the runs taking minutes are enabled, they only run with SIM_LONG=1
+func longRuns() bool {
+	return os.Getenv("SIM_LONG") == "1"
+}
+
+// run runs the scenario name with cfg. The nodes print to stdout, it is silenced meanwhile
+func run(t *testing.T, name string, cfg Config) (Report, error) {
+	t.Helper()
+	s, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("no scenario %s", name)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	return RunScenario(s, cfg)
+}
+
+func TestScenarios(t *testing.T) {
+	tests := []struct {
+		scenario string
+		seeds    []int64
+		// runs only with SIM_LONG=1, like the seeds after the first one
+		long bool
+	}{
+		{"leader-crash", []int64{1, 2, 3}, false},
+		{"split-vote", []int64{1, 2, 3}, false},
+		{"log-divergence", []int64{1, 2, 3}, false},
+		{"chaos", []int64{1, 2}, true},
+		{"linearizable", []int64{1}, true},
+	}
+	for _, tt := range tests {
+		for i, seed := range tt.seeds {
+			t.Run(fmt.Sprintf("%s/seed-%d", tt.scenario, seed), func(t *testing.T) {
+				if (tt.long || i > 0) && !longRuns() {
+					t.Skip("long run, set SIM_LONG=1 to run it")
+				}
+				report, err := run(t, tt.scenario, Config{Seed: seed})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Committed == 0 {
+					t.Fatal("nothing was committed")
+				}
+			})
+		}
+	}
+}
+
+func TestScenarioReplays(t *testing.T) {
+	cfg := Config{Seed: 7, Faults: Faults{MinDelay: DefaultFaults.MinDelay, MaxDelay: DefaultFaults.MaxDelay,
+		DropRate: 0.1, DuplicateRate: 0.1}}
+	first, err := run(t, "log-divergence", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := run(t, "log-divergence", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.TraceHash != first.TraceHash || again.Steps != first.Steps {
+		t.Fatalf("seed 7 ran %d steps with trace %s, then %d with trace %s", first.Steps, first.TraceHash,
+			again.Steps, again.TraceHash)
+	}
+}
+
+// TestLinearizableCatchesLocalReads checks the checker in the loop: a follower answering reads from its own
+// state machine returns an old balance
+func TestLinearizableCatchesLocalReads(t *testing.T) {
+	if !longRuns() {
+		t.Skip("long run, set SIM_LONG=1 to run it")
+	}
+	_, err := run(t, "linearizable", Config{Seed: 1, LocalReads: true})
+	if err == nil || !strings.Contains(err.Error(), "not linearizable") {
+		t.Fatalf("local reads with seed 1: %v, want a history that is not linearizable", err)
+	}
+}
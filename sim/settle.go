@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// a goroutine in one of these states waits for the cluster: a message, a timer or another goroutine
+// that waits itself
+var blockedStates = map[string]bool{
+	"chan receive":            true,
+	"chan receive (nil chan)": true,
+	"chan send":               true,
+	"chan send (nil chan)":    true,
+	"select":                  true,
+	"select (no cases)":       true,
+	"sync.WaitGroup.Wait":     true,
+	"sync.Cond.Wait":          true,
+	"IO wait":                 true,
+	"sleep":                   true,
+}
+
+const (
+	// polls in a row that must find the same blocked goroutines
+	settlePolls = 3
+	// the nodes of a healthy cluster never take that long to block
+	settleTimeout = 30 * time.Second
+)
+
+// settle waits until every goroutine but the caller is blocked for good, so the nodes have reacted to
+// the last step and sent every rpc it causes
+func (c *Cluster) settle() error {
+	deadline := time.Now().Add(settleTimeout)
+	var last []byte
+	same := 0
+	for {
+		dump, blocked := goroutines()
+		if blocked && bytes.Equal(dump, last) {
+			same++
+			if same == settlePolls {
+				return nil
+			}
+		} else {
+			same = 0
+		}
+		last = dump
+		if time.Now().After(deadline) {
+			return fmt.Errorf("sim: the nodes did not settle within %v:\n%s", settleTimeout, dump)
+		}
+		runtime.Gosched()
+		time.Sleep(20 * time.Microsecond)
+	}
+}
+
+// goroutines returns the stacks of the goroutines other than the caller and whether all are blocked
+func goroutines() ([]byte, bool) {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := bytes.Split(buf, []byte("\n\n"))
+	// the first stack is the one of the caller
+	others := bytes.Join(stacks[1:], []byte("\n\n"))
+	for _, stack := range stacks[1:] {
+		if !blockedStates[stateOf(stack)] {
+			return others, false
+		}
+	}
+	return others, true
+}
+
+// stateOf reads the state in the header of a stack, "goroutine 7 [chan receive, 2 minutes]:"
+func stateOf(stack []byte) string {
+	header, _, _ := strings.Cut(string(stack), "\n")
+	_, state, ok := strings.Cut(header, "[")
+	if !ok {
+		return ""
+	}
+	state, _, _ = strings.Cut(state, "]")
+	state, _, _ = strings.Cut(state, ",")
+	return state
+}
@@ -0,0 +1,44 @@
+package state
+
+import (
+	"fmt"
+	"log"
+
+	"raft/blobstore"
+)
+
+// EnsureBlob makes sure the blob stored under hash is on this node, fetching it from a peer if needed
+func (n *Node) EnsureBlob(hash string) error {
+	if !blobstore.ValidHash(hash) {
+		return fmt.Errorf("invalid blob address %q", hash)
+	}
+	if n.Blobs.Has(hash) {
+		return nil
+	}
+	for _, peer := range n.Peers {
+		raw, err := fetchBlobRPCStub(n, peer, hash)
+		if err != nil {
+			log.Printf("%s could not fetch blob %s from %s: %v", n.Address, hash, peer, err)
+			continue
+		}
+		if err := n.Blobs.PutRaw(hash, raw); err != nil {
+			log.Printf("%s rejected blob %s from %s: %v", n.Address, hash, peer, err)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("blob %s: %w on any peer", hash, blobstore.ErrNotFound)
+}
+
+// fetchBlobs pulls the documents referenced by a committed entry. References stored before the blob
+// store existed are not addresses and are skipped
+func (n *Node) fetchBlobs(hashes ...string) {
+	for _, hash := range hashes {
+		if !blobstore.ValidHash(hash) {
+			continue
+		}
+		if err := n.EnsureBlob(hash); err != nil {
+			log.Printf("%s is missing a document: %v", n.Address, err)
+		}
+	}
+}
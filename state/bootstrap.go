@@ -0,0 +1,41 @@
+package state
+
+import (
+	"log"
+
+	"raft/utils"
+)
+
+// SetBootstrapAdmin registers the super admin this node proposes when it leads a cluster without admins.
+// The password must already be hashed
+func (node *Node) SetBootstrapAdmin(firstName, lastName, email, hashedPassword string) {
+	node.Mu.Lock()
+	defer node.Mu.Unlock()
+	node.bootstrapAdmin = &utils.AdminPayload{
+		FirstName: firstName, LastName: lastName, Email: email, HashedPassword: hashedPassword,
+		AdminID: -1, UserId: -1, Role: utils.RoleSuperAdmin, Action: utils.AdminCreateAccount, PollID: "bootstrap-admin",
+	}
+}
+
+// bootstrapPayloads proposes the bootstrap admin once per term while no admin exists. The state machine
+// rejects it as soon as one does, so a stale proposal cannot add a second super admin
+func (node *Node) bootstrapPayloads(term int32) []utils.Payload {
+	node.Mu.Lock()
+	defer node.Mu.Unlock()
+	if node.bootstrapAdmin == nil || node.bootstrapTerm == term {
+		return nil
+	}
+	count, err := node.StateMachine.CountAdmins()
+	if err != nil {
+		log.Printf("could not count admins: %v", err)
+		return nil
+	}
+	if count > 0 {
+		node.bootstrapAdmin = nil
+		return nil
+	}
+	node.bootstrapTerm = term
+	payload := *node.bootstrapAdmin
+	payload.Term = term
+	return []utils.Payload{payload}
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"errors"
+	"testing"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+func TestBootstrapPayloadsCountTheLeaderAdmins(t *testing.T) {
+	own, other := openNodeStateMachines(t)
+	// another node of the process already has an admin, the leader has none
+	if err := other.DB.Create(&models.Admin{Email: "root@test.invalid"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	node := &Node{StateMachine: own}
+	node.SetBootstrapAdmin("Root", "Admin", "root@test.invalid", "hash")
+
+	payloads := node.bootstrapPayloads(3)
+	if len(payloads) != 1 {
+		t.Fatalf("proposed %d payloads, want the bootstrap admin", len(payloads))
+	}
+	if got := payloads[0].(utils.AdminPayload); got.Term != 3 || got.Role != utils.RoleSuperAdmin {
+		t.Fatalf("proposed %+v, want a super admin in term 3", got)
+	}
+	if again := node.bootstrapPayloads(3); len(again) != 0 {
+		t.Fatal("proposed the bootstrap admin twice in a term")
+	}
+	if again := node.bootstrapPayloads(4); len(again) != 1 {
+		t.Fatal("did not propose the bootstrap admin again in a later term")
+	}
+
+	if err := own.DB.Create(&models.Admin{Email: "root@test.invalid"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if again := node.bootstrapPayloads(5); len(again) != 0 {
+		t.Fatal("proposed the bootstrap admin once the leader had an admin")
+	}
+}
+
+func TestBootstrapLog(t *testing.T) {
+	ps := openLog(t)
+	if _, _, err := loadMembers(ps, "node-0", "7000"); !errors.Is(err, ErrNotBootstrapped) {
+		t.Fatalf("members of a log never bootstrapped: %v", err)
+	}
+	members := []utils.Member{{NodeID: "node-0", Address: "7000"}, {NodeID: "node-1", Address: "7001"},
+		{NodeID: "node-2", Address: "7002"}}
+	if err := ps.Bootstrap(members); err != nil {
+		t.Fatal(err)
+	}
+	checkLast(t, ps, ConfigurationIndex, ConfigurationTerm)
+	if term, _ := ps.GetCurrentTerm(); term != ConfigurationTerm {
+		t.Fatalf("bootstrapped in term %d", term)
+	}
+	if err := ps.Bootstrap(members); !errors.Is(err, ErrBootstrapped) {
+		t.Fatalf("second bootstrap: %v", err)
+	}
+
+	// node-1 restarts at another address, its peers are known by id
+	peers, addresses, err := loadMembers(ps, "node-1", "8001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || addresses["node-0"] != "7000" || addresses["node-2"] != "7002" {
+		t.Fatalf("peers %v at %v", peers, addresses)
+	}
+	recorded, _ := ps.Members()
+	if len(recorded) != 3 || recorded[1].Address != "8001" {
+		t.Fatalf("members after the move: %+v", recorded)
+	}
+	if _, _, err := loadMembers(ps, "node-9", "7009"); err == nil {
+		t.Fatal("a node outside the cluster loaded its members")
+	}
+}
+
+func TestBootstrapRefusesWrittenLog(t *testing.T) {
+	ps := openLog(t)
+	proposeTransfers(t, ps, 1, "a")
+	if err := ps.Bootstrap([]utils.Member{{NodeID: "node-0", Address: "7000"}}); err == nil {
+		t.Fatal("bootstrapped a log that holds entries")
+	}
+	if members, _ := ps.Members(); len(members) != 0 {
+		t.Fatalf("members recorded by a refused bootstrap: %+v", members)
+	}
+}
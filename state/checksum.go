@@ -0,0 +1,80 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"gorm.io/gorm"
+)
+
+// ErrCorruptEntry is returned for a log entry that no longer matches the checksum written with it
+var ErrCorruptEntry = errors.New("corrupted log entry")
+
+// entries read at once by the startup scan
+const verifyBatch = 1000
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// entryChecksum sums what a peer relies on in an entry: its index, its term and its command
+func entryChecksum(index int, term int32, command []byte) uint32 {
+	var header [12]byte
+	binary.LittleEndian.PutUint64(header[:8], uint64(index))
+	binary.LittleEndian.PutUint32(header[8:], uint32(term))
+	return crc32.Update(crc32.Update(0, castagnoli, header[:]), castagnoli, command)
+}
+
+// verify fails with ErrCorruptEntry when the entry was altered since it was written
+func (e LogEntry) verify() error {
+	if e.Checksum == entryChecksum(e.Index, e.Term, e.Command) {
+		return nil
+	}
+	return fmt.Errorf("%w: entry %d of term %d does not match its checksum, the log on disk was altered. "+
+		"Restore the log of this node from a backup or from a healthy peer", ErrCorruptEntry, e.Index, e.Term)
+}
+
+func verifyEntries(entries []LogEntry) error {
+	for _, entry := range entries {
+		if err := entry.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyLog checks every entry of the log against its checksum, a node runs it before it starts
+func (ps *PersistentState) VerifyLog() error {
+	last := 0
+	for {
+		var entries []LogEntry
+		if err := ps.DB.Where("`index` > ?", last).Order("`index` asc").Limit(verifyBatch).Find(&entries).Error; err != nil {
+			return fmt.Errorf("failed to read the log: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := verifyEntries(entries); err != nil {
+			return err
+		}
+		last = entries[len(entries)-1].Index
+	}
+}
+
+// migrateChecksums sums the entries written before checksums existed
+func migrateChecksums(db *gorm.DB) error {
+	var entries []LogEntry
+	if err := db.Where("checksum IS NULL").Find(&entries).Error; err != nil {
+		return fmt.Errorf("failed to read entries without checksum: %w", err)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range entries {
+			err := tx.Model(&LogEntry{}).Where("`index` = ?", entry.Index).
+				UpdateColumn("checksum", entryChecksum(entry.Index, entry.Term, entry.Command)).Error
+			if err != nil {
+				return fmt.Errorf("failed to sum entry %d: %w", entry.Index, err)
+			}
+		}
+		return nil
+	})
+}
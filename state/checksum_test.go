@@ -0,0 +1,79 @@
+package state
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// corrupt flips a byte of the command of the entry at index, as a bad disk would
+func corrupt(t *testing.T, ps *PersistentState, index int) {
+	t.Helper()
+	var entry LogEntry
+	if err := ps.DB.Where("`index` = ?", index).First(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	entry.Command[len(entry.Command)-1] ^= 0xff
+	if err := ps.DB.Model(&LogEntry{}).Where("`index` = ?", index).UpdateColumn("command", entry.Command).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyLogFindsCorruptedEntry(t *testing.T) {
+	ps := openLog(t)
+	proposeTransfers(t, ps, 1, "a", "b", "c")
+	if err := ps.VerifyLog(); err != nil {
+		t.Fatal(err)
+	}
+	corrupt(t, ps, 2)
+	err := ps.VerifyLog()
+	if !errors.Is(err, ErrCorruptEntry) || !strings.Contains(err.Error(), "entry 2 of term 1") {
+		t.Fatalf("verification of a log with entry 2 corrupted: %v", err)
+	}
+	if _, err := ps.GetEntriesForCommit(0, 3); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("read of the corrupted entry to apply it: %v", err)
+	}
+	if _, err := ps.GetCommandsFromIndex(1); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("read of the corrupted entry to replicate it: %v", err)
+	}
+	// the entries after it are still served
+	if entries, err := ps.GetEntriesForCommit(2, 3); err != nil || len(entries) != 1 {
+		t.Fatalf("read %d entries after the corrupted one, %v", len(entries), err)
+	}
+	if _, err := ps.moveEntries(openLog(t)); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("move of a corrupted log: %v", err)
+	}
+}
+
+func TestOpenNodeRefusesCorruptedLog(t *testing.T) {
+	layout := bootstrapSingleNode(t)
+	ps, err := InitPersistentState(layout.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposeTransfers(t, ps, 1, "a", "b")
+	last, _, _ := ps.LastIndexAndTerm()
+	corrupt(t, ps, int(last))
+	ps.Close()
+	if n, err := OpenNode(layout, "test", "7000", nil); !errors.Is(err, ErrCorruptEntry) {
+		if err == nil {
+			n.Close()
+		}
+		t.Fatalf("open of a node with a corrupted log: %v", err)
+	}
+}
+
+func TestMigrateChecksums(t *testing.T) {
+	ps := openLog(t)
+	proposeTransfers(t, ps, 1, "a", "b")
+	// entries written before checksums existed
+	if err := ps.DB.Model(&LogEntry{}).Where("1 = 1").UpdateColumn("checksum", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateChecksums(ps.DB); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.VerifyLog(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package state
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Clock is the time source of the run loop, the simulation replaces it by a clock it advances itself
+type Clock interface {
+	NewTimer(d time.Duration) Timer
+	After(d time.Duration) <-chan time.Time
+	// Now is the time the leader stamps on the operations it appends
+	Now() time.Time
+}
+
+// Timer is the part of time.Timer the run loop uses
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time   { return t.t.C }
+func (t realTimer) Reset(d time.Duration) { t.t.Reset(d) }
+func (t realTimer) Stop()                 { t.t.Stop() }
+
+// SetClock replaces the clock and the source of the random election timeouts of the node, it must be
+// called before Start
+func (n *Node) SetClock(c Clock, rng *rand.Rand) {
+	n.Mu.Lock()
+	defer n.Mu.Unlock()
+	n.clock = c
+	n.rng = rng
+}
@@ -0,0 +1,205 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	pb "raft/raft"
+	"raft/utils"
+)
+
+// ErrUnknownCommand is returned for a command type no handler is registered for
+var ErrUnknownCommand = errors.New("unknown command")
+
+// CommandHandler is what a node knows of a command type: how its payload is kept in the log and how the
+// state machine applies it. Adding an operation means registering its handler
+type CommandHandler struct {
+	// Version is the encoding of the bodies written, a node refuses bodies of a later version
+	Version uint32
+	// Encode turns a proposed payload into the message stored and replicated, personal data sealed
+	Encode func(p utils.Payload) (proto.Message, error)
+	// Decode reads back a body written under version
+	Decode func(version uint32, body []byte) (utils.Payload, error)
+	// Apply applies the payload of the entry at index to the state machine, the caller holds n.Mu
+	Apply func(n *Node, index int, p utils.Payload) error
+}
+
+var commands = map[utils.RefTable]CommandHandler{}
+
+// RegisterCommand makes a command type known to the node, it panics if the type already has a handler
+func RegisterCommand(kind utils.RefTable, handler CommandHandler) {
+	if _, ok := commands[kind]; ok {
+		panic(fmt.Sprintf("command %s registered twice", kind))
+	}
+	commands[kind] = handler
+}
+
+// EncodeCommand encodes a proposed payload as the command of a log entry
+func EncodeCommand(p utils.Payload) (*pb.Command, error) {
+	handler, ok := commands[p.GetRefTable()]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCommand, p.GetRefTable())
+	}
+	msg, err := handler.Encode(p)
+	if err != nil {
+		return nil, err
+	}
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s command: %w", p.GetRefTable(), err)
+	}
+	return &pb.Command{Version: handler.Version, Type: string(p.GetRefTable()), PollID: p.GetPollID(), Body: body}, nil
+}
+
+// DecodeCommand reads back the payload of a stored command and the handler that applies it
+func DecodeCommand(data []byte) (utils.Payload, CommandHandler, error) {
+	var command pb.Command
+	if err := proto.Unmarshal(data, &command); err != nil {
+		return nil, CommandHandler{}, fmt.Errorf("failed to decode command: %w", err)
+	}
+	handler, ok := commands[utils.RefTable(command.Type)]
+	if !ok {
+		return nil, CommandHandler{}, fmt.Errorf("%w: %q", ErrUnknownCommand, command.Type)
+	}
+	if command.Version > handler.Version {
+		return nil, CommandHandler{}, fmt.Errorf("%s command of version %d, this node reads up to version %d",
+			command.Type, command.Version, handler.Version)
+	}
+	p, err := handler.Decode(command.Version, command.Body)
+	if err != nil {
+		return nil, CommandHandler{}, fmt.Errorf("failed to decode %s command: %w", command.Type, err)
+	}
+	return p, handler, nil
+}
+
+func init() {
+	RegisterCommand(utils.RefUser, CommandHandler{
+		Version: 1,
+		Encode: func(p utils.Payload) (proto.Message, error) {
+			return userToProto(p.(utils.UserPayload))
+		},
+		Decode: func(_ uint32, body []byte) (utils.Payload, error) {
+			var userPayload pb.UserPayload
+			if err := proto.Unmarshal(body, &userPayload); err != nil {
+				return nil, err
+			}
+			return userFromProto(&userPayload)
+		},
+		Apply: applyUserOperation,
+	})
+	RegisterCommand(utils.RefAdmin, CommandHandler{
+		Version: 1,
+		Encode: func(p utils.Payload) (proto.Message, error) {
+			return adminToProto(p.(utils.AdminPayload)), nil
+		},
+		Decode: func(_ uint32, body []byte) (utils.Payload, error) {
+			var adminPayload pb.AdminPayload
+			if err := proto.Unmarshal(body, &adminPayload); err != nil {
+				return nil, err
+			}
+			return adminFromProto(&adminPayload), nil
+		},
+		Apply: func(n *Node, _ int, p utils.Payload) error {
+			return n.StateMachine.ApplyAdminOperations(p.(utils.AdminPayload))
+		},
+	})
+	RegisterCommand(utils.RefWallet, CommandHandler{
+		Version: 1,
+		Encode: func(p utils.Payload) (proto.Message, error) {
+			return walletToProto(p.(utils.WalletOperationPayload)), nil
+		},
+		Decode: func(_ uint32, body []byte) (utils.Payload, error) {
+			var walletPayload pb.WalletOperationPayload
+			if err := proto.Unmarshal(body, &walletPayload); err != nil {
+				return nil, err
+			}
+			return walletFromProto(&walletPayload), nil
+		},
+		Apply: func(n *Node, _ int, p utils.Payload) error {
+			return n.StateMachine.ApplyWalletOperation(p.(utils.WalletOperationPayload))
+		},
+	})
+	RegisterCommand(utils.RefConfiguration, CommandHandler{
+		Version: 1,
+		Encode: func(p utils.Payload) (proto.Message, error) {
+			return configurationToProto(p.(utils.ConfigurationPayload)), nil
+		},
+		Decode: func(_ uint32, body []byte) (utils.Payload, error) {
+			var configurationPayload pb.ConfigurationPayload
+			if err := proto.Unmarshal(body, &configurationPayload); err != nil {
+				return nil, err
+			}
+			return configurationFromProto(&configurationPayload), nil
+		},
+		// the addresses learned since the configuration was written are newer than its own
+		Apply: func(n *Node, _ int, p utils.Payload) error {
+			return n.State.RecordMembers(p.(utils.ConfigurationPayload).Members)
+		},
+	})
+}
+
+// applyUserOperation fetches the documents of a signup and erases the documents of an erased user once the
+// operation is applied
+func applyUserOperation(n *Node, index int, p utils.Payload) error {
+	userPayload := p.(utils.UserPayload)
+	if userPayload.Action == utils.UserCreateAccount {
+		front, back := userPayload.IdentificationImageFront, userPayload.IdentificationImageBack
+		n.goTracked(func() { n.fetchBlobs(front, back) })
+	}
+	erasure, documents := n.prepareErasure(userPayload, index)
+	if err := n.StateMachine.ApplyUserOperation(userPayload); err != nil {
+		return err
+	}
+	if erasure != nil {
+		n.completeErasure(erasure, documents)
+	}
+	return nil
+}
+
+// userCommand is a stored user command with its body, for the maintenance that rewrites personal data
+type userCommand struct {
+	index   int
+	term    int32
+	command *pb.Command
+	payload *pb.UserPayload
+}
+
+// userCommands reads the user commands of the entries selected by db
+func userCommands(db *gorm.DB) ([]userCommand, error) {
+	var entries []LogEntry
+	if err := db.Where("reference_table = ?", utils.RefUser).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to read user entries: %w", err)
+	}
+	result := make([]userCommand, 0, len(entries))
+	for _, entry := range entries {
+		uc := userCommand{index: entry.Index, term: entry.Term, command: &pb.Command{}, payload: &pb.UserPayload{}}
+		if err := proto.Unmarshal(entry.Command, uc.command); err != nil {
+			return nil, fmt.Errorf("failed to decode the command of entry %d: %w", entry.Index, err)
+		}
+		if err := proto.Unmarshal(uc.command.Body, uc.payload); err != nil {
+			return nil, fmt.Errorf("failed to decode the user payload of entry %d: %w", entry.Index, err)
+		}
+		result = append(result, uc)
+	}
+	return result, nil
+}
+
+// save writes back the command with its rewritten payload, and its checksum
+func (uc userCommand) save(db *gorm.DB) error {
+	body, err := proto.Marshal(uc.payload)
+	if err != nil {
+		return err
+	}
+	uc.command.Body = body
+	data, err := proto.Marshal(uc.command)
+	if err != nil {
+		return err
+	}
+	return db.Model(&LogEntry{}).Where("`index` = ?", uc.index).UpdateColumns(map[string]interface{}{
+		"command":  data,
+		"checksum": entryChecksum(uc.index, uc.term, data),
+	}).Error
+}
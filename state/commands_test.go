@@ -0,0 +1,99 @@
+package state
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "raft/raft"
+	"raft/utils"
+)
+
+// bodyOf is p as its body keeps it, the poll id and the term are kept by the command and the entry
+func bodyOf(p utils.Payload) utils.Payload {
+	switch p := p.(type) {
+	case utils.UserPayload:
+		p.PollID, p.Term = "", 0
+		return p
+	case utils.AdminPayload:
+		p.PollID, p.Term = "", 0
+		// no tiers decode as an empty list
+		if p.FeeTiers == nil {
+			p.FeeTiers = []utils.FeeTier{}
+		}
+		return p
+	case utils.WalletOperationPayload:
+		p.PollID, p.Term = "", 0
+		return p
+	case utils.ConfigurationPayload:
+		p.PollID, p.Term = "", 0
+		return p
+	}
+	return p
+}
+
+func TestCommandsRoundTrip(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	payloads := []utils.Payload{
+		utils.UserPayload{FirstName: "Alice", LastName: "Doe", HashedPassword: "hash", Email: "alice@test.invalid",
+			DateOfBirth: time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC), IdentificationNumber: "A1",
+			IdentificationImageFront: "front", IdentificationImageBack: "back", Action: utils.UserCreateAccount,
+			PollID: "signup", Term: 2},
+		utils.UserPayload{UserID: 4, SweepWalletID: 9, Action: utils.UserDeleteAccount, Time: at, PollID: "delete",
+			Term: 2},
+		utils.AdminPayload{AdminID: 1, FeeAction: utils.WalletTransfer, FeeKind: utils.FeeFlat, FeeFlat: 25,
+			FeeTiers: []utils.FeeTier{{UpTo: 1000, Flat: 5}, {BasisPoints: 30}}, Action: utils.AdminSetFeeSchedule,
+			Time: at, PollID: "fees", Term: 3},
+		utils.AdminPayload{AdminID: 1, TargetAdminID: 2, Role: utils.RoleFinance, Action: utils.AdminSetRole,
+			PollID: "role", Term: 3},
+		utils.WalletOperationPayload{Wallet1: 1, Wallet2: 2, Amount: 500, Action: utils.WalletTransfer,
+			ScheduleID: 7, Occurrence: 2, Attempt: 1, Interval: utils.IntervalWeekly, StartAt: at, MaxRetries: 3,
+			RetryDelaySeconds: 60, Time: at.Add(time.Hour), PollID: "transfer", Term: 4},
+		utils.ConfigurationPayload{Members: []utils.Member{{NodeID: "node-0", Address: "7000"},
+			{NodeID: "node-1", Address: "7001"}}, PollID: "bootstrap", Term: 1},
+	}
+	for _, p := range payloads {
+		command, err := EncodeCommand(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if command.Type != string(p.GetRefTable()) || command.PollID != p.GetPollID() {
+			t.Fatalf("%s command %q for poll %q", p.GetRefTable(), command.Type, command.PollID)
+		}
+		data, err := proto.Marshal(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := DecodeCommand(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := bodyOf(p); !reflect.DeepEqual(got, want) {
+			t.Fatalf("decoded %+v\nwant %+v", got, want)
+		}
+	}
+}
+
+func TestDecodeRefusesUnknownCommands(t *testing.T) {
+	command, err := EncodeCommand(utils.WalletOperationPayload{Wallet1: 1, Amount: 10, Action: utils.WalletDeposit,
+		PollID: "deposit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written by a node that knows a later encoding
+	later := proto.Clone(command).(*pb.Command)
+	later.Version++
+	data, _ := proto.Marshal(later)
+	if _, _, err := DecodeCommand(data); err == nil {
+		t.Fatal("decoded a command of a later version")
+	}
+	unknown := proto.Clone(command).(*pb.Command)
+	unknown.Type = "loan"
+	data, _ = proto.Marshal(unknown)
+	if _, _, err := DecodeCommand(data); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("decode of an unknown command: %v", err)
+	}
+}
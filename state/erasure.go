@@ -0,0 +1,195 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"raft/blobstore"
+	"raft/pii"
+	pb "raft/raft"
+	"raft/utils"
+)
+
+// Erasure records, on this node only, a user whose personal data must be scrubbed from the log. The
+// payloads are scrubbed once the erase entry is below the snapshot point
+type Erasure struct {
+	UserID    int    `gorm:"primaryKey"`
+	EmailHash string // digest of the email, it finds the signup payload
+	// salt of the digest, erasures recorded before it existed used the blind index of the email
+	EmailSalt  string
+	LogIndex   int // index of the erase entry
+	ErasedAt   time.Time
+	ScrubbedAt *time.Time
+}
+
+// newErasure records the erasure of a user. The email digest is salted rather than a blind index, so a
+// change of the pii index key before the scrub still finds the signup
+func newErasure(userID int, email string, index int) (*Erasure, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to salt the email digest: %w", err)
+	}
+	erasure := &Erasure{UserID: userID, EmailSalt: hex.EncodeToString(salt), LogIndex: index}
+	erasure.EmailHash = erasure.emailDigest(email)
+	return erasure, nil
+}
+
+// emailDigest is the digest of email EmailHash is compared with
+func (e *Erasure) emailDigest(email string) string {
+	if e.EmailSalt == "" {
+		return pii.BlindIndex(email)
+	}
+	mac := hmac.New(sha256.New, []byte(e.EmailSalt))
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// covers reports whether a stored payload is the signup or an operation of the erased user
+func (e *Erasure) covers(payload *pb.UserPayload) bool {
+	signup := utils.UserAction(payload.Action) == utils.UserCreateAccount && e.emailDigest(payload.Email) == e.EmailHash
+	return signup || int(payload.UserID) == e.UserID
+}
+
+// prepareErasure reads what the node needs to scrub before the erase entry at index is applied
+func (n *Node) prepareErasure(userPayload utils.UserPayload, index int) (*Erasure, []string) {
+	if userPayload.Action != utils.UserEraseAccount {
+		return nil, nil
+	}
+	user, err := n.StateMachine.ErasableUser(userPayload.UserID)
+	if err != nil {
+		return nil, nil
+	}
+	erasure, err := newErasure(user.UserID, user.Email, index)
+	if err != nil {
+		log.Printf("%s could not record the erasure of user %d: %v", n.Address, user.UserID, err)
+		return nil, nil
+	}
+	return erasure, []string{user.IdentificationImageFront, user.IdentificationImageBack}
+}
+
+// completeErasure runs once the erase entry is applied: the documents are deleted right away and the
+// erasure is recorded for the next snapshot
+func (n *Node) completeErasure(erasure *Erasure, documents []string) {
+	for _, hash := range documents {
+		if !blobstore.ValidHash(hash) {
+			continue
+		}
+		if err := n.Blobs.Delete(hash); err != nil {
+			log.Printf("%s could not delete document of erased user %d: %v", n.Address, erasure.UserID, err)
+		}
+	}
+	erasure.ErasedAt = time.Now()
+	if err := n.State.DB.Save(erasure).Error; err != nil {
+		log.Printf("%s could not record the erasure of user %d: %v", n.Address, erasure.UserID, err)
+	}
+}
+
+// ScrubErasures clears the personal data of erased users from the user commands once their erase entry is
+// at or below index. The scrubbed signup keeps the pseudonymous email of the tombstone so replaying the log
+// still ends with the same state. It returns the number of commands scrubbed
+func (ps *PersistentState) ScrubErasures(index int32) (int, error) {
+	var erasures []Erasure
+	if err := ps.DB.Where("scrubbed_at IS NULL AND log_index <= ?", index).Find(&erasures).Error; err != nil {
+		return 0, fmt.Errorf("failed to read erasures: %w", err)
+	}
+	if len(erasures) == 0 {
+		return 0, nil
+	}
+	stored, err := userCommands(ps.DB)
+	if err != nil {
+		return 0, err
+	}
+	scrubbed := 0
+	for _, erasure := range erasures {
+		for _, uc := range stored {
+			if !erasure.covers(uc.payload) {
+				continue
+			}
+			scrubUserPII(uc.payload, erasure.UserID)
+			if err := uc.save(ps.DB); err != nil {
+				return scrubbed, fmt.Errorf("failed to scrub entry %d of user %d: %w", uc.index, erasure.UserID, err)
+			}
+			scrubbed++
+		}
+		if err := ps.markScrubbed(erasure); err != nil {
+			return scrubbed, err
+		}
+	}
+	return scrubbed, nil
+}
+
+// RewritePII reseals the user commands up to index and scrubs those of the users erased at or below it in
+// a log store rewritten in place, as ResealPII and ScrubErasures do in the sqlite log. It returns the
+// number of commands resealed and scrubbed
+func (ps *PersistentState) RewritePII(store LogRewriter, index int32) (int, int, error) {
+	var erasures []Erasure
+	if err := ps.DB.Where("scrubbed_at IS NULL AND log_index <= ?", index).Find(&erasures).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to read erasures: %w", err)
+	}
+	if !pii.Enabled() && len(erasures) == 0 {
+		return 0, 0, nil
+	}
+	resealed, scrubbed := 0, 0
+	_, err := store.Rewrite(func(entry *pb.LogEntry) (bool, error) {
+		command := entry.GetCommand()
+		if utils.RefTable(command.GetType()) != utils.RefUser {
+			return false, nil
+		}
+		var payload pb.UserPayload
+		if err := proto.Unmarshal(command.Body, &payload); err != nil {
+			return false, fmt.Errorf("failed to decode the user payload of entry %d: %w", entry.GetIndex(), err)
+		}
+		changed := false
+		if entry.GetIndex() <= int64(index) {
+			var err error
+			if changed, err = resealUserPII(&payload); err != nil {
+				return false, fmt.Errorf("failed to reseal entry %d: %w", entry.GetIndex(), err)
+			}
+			if changed {
+				resealed++
+			}
+		}
+		for i := range erasures {
+			if erasures[i].covers(&payload) {
+				scrubUserPII(&payload, erasures[i].UserID)
+				scrubbed++
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return false, nil
+		}
+		body, err := proto.Marshal(&payload)
+		if err != nil {
+			return false, err
+		}
+		command.Body = body
+		return true, nil
+	})
+	if err != nil {
+		return resealed, scrubbed, err
+	}
+	for _, erasure := range erasures {
+		if err := ps.markScrubbed(erasure); err != nil {
+			return resealed, scrubbed, err
+		}
+	}
+	return resealed, scrubbed, nil
+}
+
+// markScrubbed records that no command holds the personal data of an erased user anymore
+func (ps *PersistentState) markScrubbed(erasure Erasure) error {
+	now := time.Now()
+	if err := ps.DB.Model(&erasure).Update("scrubbed_at", &now).Error; err != nil {
+		return fmt.Errorf("failed to record scrubbing of user %d: %w", erasure.UserID, err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package state
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"raft/config"
+	"raft/pii"
+	"raft/state/stateMachine"
+	"raft/utils"
+)
+
+func TestScrubErasures(t *testing.T) {
+	ps := openLog(t)
+	payloads := []utils.Payload{
+		utils.UserPayload{FirstName: "Alice", LastName: "Doe", Email: "alice@test.invalid", IdentificationNumber: "A1",
+			Action: utils.UserCreateAccount, PollID: "signup-alice", Term: 1},
+		utils.UserPayload{FirstName: "Bob", LastName: "Roe", Email: "bob@test.invalid", IdentificationNumber: "B1",
+			Action: utils.UserCreateAccount, PollID: "signup-bob", Term: 1},
+		utils.UserPayload{UserID: 1, PrevPW: "old", NewPW: "new", Action: utils.UserUpdatePassword,
+			PollID: "password-alice", Term: 1},
+		utils.UserPayload{UserID: 1, Action: utils.UserEraseAccount, PollID: "erase-alice", Term: 1},
+	}
+	if err := appendPayloads(ps, payloads); err != nil {
+		t.Fatal(err)
+	}
+	erasure, err := newErasure(1, "alice@test.invalid", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.DB.Create(erasure).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the pii keys are configured between the erasure and the scrub, the signup is still found
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	if err := pii.Init(config.PIIConfig{ActiveKey: "k1", Keys: map[string]string{"k1": key}, IndexKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pii.Init(config.PIIConfig{}) })
+
+	// the erase entry is not below the snapshot point yet
+	if scrubbed, err := ps.ScrubErasures(3); err != nil || scrubbed != 0 {
+		t.Fatalf("scrubbed %d commands before the erase entry was snapshotted, %v", scrubbed, err)
+	}
+	scrubbed, err := ps.ScrubErasures(4)
+	if err != nil || scrubbed != 3 {
+		t.Fatalf("scrubbed %d commands, %v, want the signup, the password change and the erasure", scrubbed, err)
+	}
+	stored, err := userCommands(ps.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uc := range stored {
+		p := uc.payload
+		if uc.index == 2 {
+			if p.Email != "bob@test.invalid" || p.FirstName != "Bob" || p.IdentificationNumber != "B1" {
+				t.Fatalf("the signup of another user was scrubbed: %+v", p)
+			}
+			continue
+		}
+		if p.Email != stateMachine.ErasedEmail(1) || p.FirstName != stateMachine.ErasedName ||
+			p.IdentificationNumber != "" || p.PrevPW != "" || p.NewPW != "" {
+			t.Fatalf("entry %d still holds personal data: %+v", uc.index, p)
+		}
+	}
+	// the rewritten entries carry their new checksums
+	if err := ps.VerifyLog(); err != nil {
+		t.Fatal(err)
+	}
+	if scrubbed, err := ps.ScrubErasures(10); err != nil || scrubbed != 0 {
+		t.Fatalf("scrubbed %d commands again, %v", scrubbed, err)
+	}
+}
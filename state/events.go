@@ -0,0 +1,62 @@
+package state
+
+import "fmt"
+
+// roles of a node, held in Node.Status
+const (
+	Follower  = "follower"
+	Candidate = "candidate"
+	Leader    = "leader"
+)
+
+// event is what the run loop of a node reacts to, only the loop changes the role of the node
+type event interface {
+	isEvent()
+}
+
+// rpcReceived reports a request vote or append entries rpc. An rpc of a later term makes a candidate or
+// a leader step down, so does an rpc from the leader of the current term for a candidate. Only the rpcs
+// of a leader and the granted votes restart the election timer
+type rpcReceived struct {
+	term       int32
+	fromLeader bool
+	resetTimer bool
+}
+
+// voteResult ends the election of term
+type voteResult struct {
+	term int32
+	won  bool
+}
+
+// stepDown reports a peer with a term later than term, the term the node campaigned or led in
+type stepDown struct {
+	term int32
+}
+
+func (rpcReceived) isEvent() {}
+func (voteResult) isEvent()  {}
+func (stepDown) isEvent()    {}
+
+// size of the event queue of a node
+const eventQueueSize = 64
+
+// send queues ev for the run loop without ever blocking the sender. An event is only dropped when the
+// queue is full or no loop runs: the election timer still fires, so the node cannot get stuck in a role
+func (n *Node) send(ev event) {
+	select {
+	case n.events <- ev:
+	default:
+		fmt.Printf("%v dropped event %T\n", n.Address, ev)
+	}
+}
+
+// ObserveRPC tells the run loop about an append entries rpc or a granted vote in term
+func (n *Node) ObserveRPC(term int32, fromLeader bool) {
+	n.send(rpcReceived{term: term, fromLeader: fromLeader, resetTimer: true})
+}
+
+// ObserveTerm tells the run loop about a refused request vote in term, a later term still deposes the node
+func (n *Node) ObserveTerm(term int32) {
+	n.send(rpcReceived{term: term})
+}
@@ -0,0 +1,265 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	pb "raft/raft"
+
+	"gorm.io/gorm"
+)
+
+// ErrInjectedDisk is the error of a database operation failed on purpose
+var ErrInjectedDisk = errors.New("injected disk error")
+
+// ErrInjectedDrop is the error of an rpc dropped on purpose
+var ErrInjectedDrop = errors.New("injected rpc drop")
+
+// Direction tells which rpcs of a link a fault applies to
+type Direction string
+
+const (
+	Inbound  Direction = "in"
+	Outbound Direction = "out"
+	Both     Direction = "both"
+)
+
+// LinkFault drops or delays the rpcs exchanged with a peer
+type LinkFault struct {
+	// Peer is the address of the peer, empty for every peer
+	Peer      string    `json:"peer"`
+	Direction Direction `json:"direction"`
+	DropRate  float64   `json:"drop_rate"` // probability that an rpc is lost, 1 cuts the link
+	DelayMS   int       `json:"delay_ms"`
+}
+
+// DiskFault fails the reads or the writes of the persistent state with a probability
+type DiskFault struct {
+	Reads  bool    `json:"reads"`
+	Writes bool    `json:"writes"`
+	Rate   float64 `json:"rate"`
+}
+
+// FaultStatus lists the faults in place on a node
+type FaultStatus struct {
+	Links       []LinkFault `json:"links"`
+	ApplyPaused bool        `json:"apply_paused"`
+	Disk        DiskFault   `json:"disk"`
+}
+
+// Faults rehearses failures on a running node: lost or slow rpcs, a stalled state machine and a
+// failing disk. It only exists once EnableFaults was called, a node without it pays nothing
+type Faults struct {
+	node  *Node
+	mu    sync.Mutex
+	rng   *rand.Rand
+	links map[string]LinkFault // by peer, "" for every peer
+	// a paused node keeps committing but applies nothing until resumed
+	applyPaused bool
+	disk        DiskFault
+}
+
+// EnableFaults turns fault injection on for the node, it must be called before Start. Every fault is
+// off until set
+func (n *Node) EnableFaults() (*Faults, error) {
+	f := &Faults{node: n, rng: rand.New(rand.NewSource(time.Now().UnixNano())), links: map[string]LinkFault{}}
+	if err := f.hookDisk(n.State.DB); err != nil {
+		return nil, err
+	}
+	n.Mu.Lock()
+	defer n.Mu.Unlock()
+	n.faults = f
+	n.transport = faultyTransport{next: n.transport, faults: f}
+	return f, nil
+}
+
+// Faults returns the fault injector of the node, nil unless enabled
+func (n *Node) Faults() *Faults {
+	n.Mu.RLock()
+	defer n.Mu.RUnlock()
+	return n.faults
+}
+
+// SetLink replaces the fault on the link with lf.Peer
+func (f *Faults) SetLink(lf LinkFault) error {
+	if lf.DropRate < 0 || lf.DropRate > 1 || lf.DelayMS < 0 {
+		return fmt.Errorf("drop rate must be in [0, 1] and delay cannot be negative")
+	}
+	switch lf.Direction {
+	case Inbound, Outbound, Both:
+	case "":
+		lf.Direction = Both
+	default:
+		return fmt.Errorf("unknown direction %q", lf.Direction)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.links[lf.Peer] = lf
+	return nil
+}
+
+// ClearLink removes the fault on the link with peer
+func (f *Faults) ClearLink(peer string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.links, peer)
+}
+
+// SetDisk replaces the disk fault, a zero DiskFault heals the disk
+func (f *Faults) SetDisk(d DiskFault) error {
+	if d.Rate < 0 || d.Rate > 1 {
+		return fmt.Errorf("rate must be in [0, 1]")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disk = d
+	return nil
+}
+
+// PauseApply stops the node from applying committed entries
+func (f *Faults) PauseApply() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applyPaused = true
+}
+
+// ResumeApply applies the entries committed during the pause and lets the node apply again
+func (f *Faults) ResumeApply() {
+	f.mu.Lock()
+	f.applyPaused = false
+	f.mu.Unlock()
+	f.node.Commit()
+}
+
+// Clear removes every fault
+func (f *Faults) Clear() {
+	f.mu.Lock()
+	f.links = map[string]LinkFault{}
+	f.disk = DiskFault{}
+	f.mu.Unlock()
+	f.ResumeApply()
+}
+
+// Status returns the faults in place
+func (f *Faults) Status() FaultStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := FaultStatus{Links: make([]LinkFault, 0, len(f.links)), ApplyPaused: f.applyPaused, Disk: f.disk}
+	for _, lf := range f.links {
+		status.Links = append(status.Links, lf)
+	}
+	slices.SortFunc(status.Links, func(a, b LinkFault) int { return strings.Compare(a.Peer, b.Peer) })
+	return status
+}
+
+// paused reports whether the node must not apply entries, false without fault injection
+func (f *Faults) paused() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.applyPaused
+}
+
+// Link decides the fate of an rpc exchanged with peer in direction dir: whether it is dropped, and
+// how long it waits first. A fault on the peer takes precedence over one on every peer
+func (f *Faults) Link(peer string, dir Direction) (drop bool, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lf, ok := f.links[peer]
+	if !ok {
+		lf, ok = f.links[""]
+	}
+	if !ok || (lf.Direction != Both && lf.Direction != dir) {
+		return false, 0
+	}
+	return lf.DropRate > 0 && f.rng.Float64() < lf.DropRate, time.Duration(lf.DelayMS) * time.Millisecond
+}
+
+// diskError returns the error a database operation fails with, nil for most
+func (f *Faults) diskError(write bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if (write && !f.disk.Writes) || (!write && !f.disk.Reads) || f.rng.Float64() >= f.disk.Rate {
+		return nil
+	}
+	return ErrInjectedDisk
+}
+
+// hookDisk fails the statements of db as the disk fault says, before they reach sqlite
+func (f *Faults) hookDisk(db *gorm.DB) error {
+	hook := func(write bool) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if err := f.diskError(write); err != nil {
+				tx.AddError(err)
+			}
+		}
+	}
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Query().Before("gorm:query").Register("faults:query", hook(false)),
+		cb.Row().Before("gorm:row").Register("faults:row", hook(false)),
+		cb.Create().Before("gorm:begin_transaction").Register("faults:create", hook(true)),
+		cb.Update().Before("gorm:begin_transaction").Register("faults:update", hook(true)),
+		cb.Delete().Before("gorm:begin_transaction").Register("faults:delete", hook(true)),
+		cb.Raw().Before("gorm:raw").Register("faults:raw", hook(true)),
+	} {
+		if err != nil {
+			return fmt.Errorf("could not hook the persistent state: %w", err)
+		}
+	}
+	return nil
+}
+
+// faultyTransport applies the outbound link faults before handing an rpc to the real transport. A
+// dropped rpc fails once its timeout is spent, as a lost message would
+type faultyTransport struct {
+	next   Transport
+	faults *Faults
+}
+
+// wait spends the delay of an outbound rpc, it returns the time left to the call or an error
+func (t faultyTransport) wait(peer string, timeout time.Duration) (time.Duration, error) {
+	drop, delay := t.faults.Link(peer, Outbound)
+	if drop {
+		time.Sleep(timeout)
+		return 0, fmt.Errorf("rpc to %s: %w", peer, ErrInjectedDrop)
+	}
+	if delay >= timeout {
+		time.Sleep(timeout)
+		return 0, fmt.Errorf("rpc to %s: %w", peer, context.DeadlineExceeded)
+	}
+	time.Sleep(delay)
+	return timeout - delay, nil
+}
+
+func (t faultyTransport) RequestVote(peer string, req *pb.RequestVoteRequest, timeout time.Duration) (*pb.RequestVoteResponse, error) {
+	left, err := t.wait(peer, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return t.next.RequestVote(peer, req, left)
+}
+
+func (t faultyTransport) AppendEntries(peer string, req *pb.AppendEntriesRequest, timeout time.Duration) (*pb.AppendEntriesResponse, error) {
+	left, err := t.wait(peer, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return t.next.AppendEntries(peer, req, left)
+}
+
+func (t faultyTransport) FetchBlob(peer string, req *pb.FetchBlobRequest, timeout time.Duration) (*pb.FetchBlobResponse, error) {
+	left, err := t.wait(peer, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return t.next.FetchBlob(peer, req, left)
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pb "raft/raft"
+)
+
+// reachable answers every rpc at once
+type reachable struct{}
+
+func (reachable) RequestVote(string, *pb.RequestVoteRequest, time.Duration) (*pb.RequestVoteResponse, error) {
+	return &pb.RequestVoteResponse{VoteGranted: true}, nil
+}
+
+func (reachable) AppendEntries(string, *pb.AppendEntriesRequest, time.Duration) (*pb.AppendEntriesResponse, error) {
+	return &pb.AppendEntriesResponse{Success: true}, nil
+}
+
+func (reachable) FetchBlob(string, *pb.FetchBlobRequest, time.Duration) (*pb.FetchBlobResponse, error) {
+	return &pb.FetchBlobResponse{}, nil
+}
+
+func enableFaults(t *testing.T) (*Node, *Faults) {
+	t.Helper()
+	n := &Node{State: openLog(t), transport: reachable{}}
+	f, err := n.EnableFaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, f
+}
+
+func TestLinkFaults(t *testing.T) {
+	_, f := enableFaults(t)
+	for _, lf := range []LinkFault{{DropRate: 1.5}, {DelayMS: -1}, {Direction: "sideways"}} {
+		if err := f.SetLink(lf); err == nil {
+			t.Fatalf("set link fault %+v", lf)
+		}
+	}
+	if err := f.SetLink(LinkFault{DropRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetLink(LinkFault{Peer: "7001", Direction: Inbound, DelayMS: 20}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		peer  string
+		dir   Direction
+		drop  bool
+		delay time.Duration
+	}{
+		{"7002", Outbound, true, 0},
+		{"7002", Inbound, true, 0},
+		// the fault on the peer takes precedence over the one on every peer
+		{"7001", Inbound, false, 20 * time.Millisecond},
+		{"7001", Outbound, false, 0},
+	}
+	for _, tt := range tests {
+		if drop, delay := f.Link(tt.peer, tt.dir); drop != tt.drop || delay != tt.delay {
+			t.Errorf("rpc %s %s: drop %v after %v, want %v after %v", tt.dir, tt.peer, drop, delay, tt.drop, tt.delay)
+		}
+	}
+	if status := f.Status(); len(status.Links) != 2 || status.Links[0].Direction != Both {
+		t.Fatalf("status %+v", status)
+	}
+	f.ClearLink("")
+	if drop, _ := f.Link("7002", Outbound); drop {
+		t.Fatal("rpc dropped once the fault was cleared")
+	}
+}
+
+func TestFaultyTransport(t *testing.T) {
+	n, f := enableFaults(t)
+	if _, err := n.transport.AppendEntries("7001", &pb.AppendEntriesRequest{}, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetLink(LinkFault{Peer: "7001", Direction: Outbound, DropRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err := n.transport.AppendEntries("7001", &pb.AppendEntriesRequest{}, 10*time.Millisecond)
+	if !errors.Is(err, ErrInjectedDrop) || time.Since(start) < 10*time.Millisecond {
+		t.Fatalf("dropped rpc failed with %v after %v, want a drop once its timeout is spent", err, time.Since(start))
+	}
+	if err := f.SetLink(LinkFault{Peer: "7001", Direction: Outbound, DelayMS: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.transport.RequestVote("7001", &pb.RequestVoteRequest{}, 10*time.Millisecond); err == nil {
+		t.Fatal("an rpc delayed past its timeout succeeded")
+	}
+}
+
+func TestDiskFaults(t *testing.T) {
+	n, f := enableFaults(t)
+	if err := f.SetDisk(DiskFault{Writes: true, Rate: 2}); err == nil {
+		t.Fatal("set a disk fault rate above 1")
+	}
+	if err := f.SetDisk(DiskFault{Writes: true, Rate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.State.AdvanceTerm(2); !errors.Is(err, ErrInjectedDisk) {
+		t.Fatalf("write on a failing disk: %v", err)
+	}
+	if _, err := n.State.GetCurrentTerm(); err != nil {
+		t.Fatalf("read with only the writes failing: %v", err)
+	}
+	f.PauseApply()
+	if !f.Status().ApplyPaused || !f.paused() {
+		t.Fatal("apply not paused")
+	}
+	f.Clear()
+	if status := f.Status(); status.ApplyPaused || status.Disk != (DiskFault{}) || len(status.Links) != 0 {
+		t.Fatalf("faults left after clear: %+v", status)
+	}
+	if _, err := n.State.AdvanceTerm(2); err != nil {
+		t.Fatalf("write on a healed disk: %v", err)
+	}
+}
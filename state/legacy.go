@@ -0,0 +1,227 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	"raft/utils"
+)
+
+// The payloads of the entries were kept in a table per type before they became commands, the tables are
+// only read to migrate the logs written then
+
+type legacyUserPayload struct {
+	ID                       uint `gorm:"primaryKey"`
+	FirstName                *string
+	LastName                 *string
+	HashedPassword           *string
+	Email                    *string
+	DateOfBirth              *time.Time `gorm:"serializer:pii;type:text"`
+	IdentificationNumber     *string    `gorm:"serializer:pii;type:text"`
+	IdentificationImageFront *string    `gorm:"serializer:pii;type:text"`
+	IdentificationImageBack  *string    `gorm:"serializer:pii;type:text"`
+	PrevPW                   *string
+	NewPW                    *string
+	UserID                   *int
+	SweepWalletID            int `gorm:"default:0"`
+	Action                   utils.UserAction
+}
+
+func (legacyUserPayload) TableName() string { return "user_payloads" }
+
+type legacyAdminPayload struct {
+	ID             uint `gorm:"primaryKey"`
+	FirstName      *string
+	LastName       *string
+	HashedPassword *string
+	Email          *string
+	AdminID        *int
+	UserId         *int
+	FeeAction      utils.WalletAction `gorm:"default:''"`
+	FeeKind        utils.FeeKind      `gorm:"default:''"`
+	FeeFlat        int64              `gorm:"default:0"`
+	FeeBasisPoints int64              `gorm:"default:0"`
+	FeeTiers       string             `gorm:"default:''"` // json encoded []utils.FeeTier
+	WalletID       int                `gorm:"default:0"`
+	Tier           utils.UserTier     `gorm:"default:''"`
+	LimitScope     utils.LimitScope   `gorm:"default:''"`
+	LimitPerTx     int64              `gorm:"default:0"`
+	LimitDaily     int64              `gorm:"default:0"`
+	LimitMonthly   int64              `gorm:"default:0"`
+	Role           utils.AdminRole    `gorm:"default:''"`
+	TargetAdminID  int                `gorm:"default:0"`
+	Action         utils.AdminAction
+}
+
+func (legacyAdminPayload) TableName() string { return "admin_payloads" }
+
+type legacyWalletPayload struct {
+	ID                uint `gorm:"primaryKey"`
+	Wallet1           int
+	Wallet2           *int
+	Amount            int64
+	ScheduleID        int                    `gorm:"default:0"`
+	Occurrence        int                    `gorm:"default:0"`
+	Attempt           int                    `gorm:"default:0"`
+	Interval          utils.ScheduleInterval `gorm:"default:''"`
+	StartAt           time.Time
+	MaxRetries        int   `gorm:"default:0"`
+	RetryDelaySeconds int64 `gorm:"default:0"`
+	Action            utils.WalletAction
+}
+
+func (legacyWalletPayload) TableName() string { return "wallet_operation_payloads" }
+
+type legacyLogEntry struct {
+	Index          int
+	Term           int32
+	ReferenceTable utils.RefTable
+	PayloadID      uint
+	PollID         string
+}
+
+// migrateCommands encodes the payloads of the entries written before commands existed as their commands,
+// then drops the payload tables
+func migrateCommands(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&LogEntry{}, "payload_id") {
+		return nil
+	}
+	// payload tables of old logs may miss the columns added since
+	if err := db.AutoMigrate(&legacyUserPayload{}, &legacyAdminPayload{}, &legacyWalletPayload{}); err != nil {
+		return fmt.Errorf("failed to read legacy payloads: %w", err)
+	}
+	var entries []legacyLogEntry
+	if err := db.Table("log_entries").Where("command IS NULL").Find(&entries).Error; err != nil {
+		return fmt.Errorf("failed to read legacy entries: %w", err)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range entries {
+			payload, err := loadLegacyPayload(tx, entry)
+			if errors.Is(err, ErrUnknownCommand) {
+				// left without command, it fails as unknown when applied as it did before
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to migrate entry %d: %w", entry.Index, err)
+			}
+			command, err := EncodeCommand(payload)
+			if err != nil {
+				return fmt.Errorf("failed to migrate entry %d: %w", entry.Index, err)
+			}
+			data, err := proto.Marshal(command)
+			if err != nil {
+				return fmt.Errorf("failed to migrate entry %d: %w", entry.Index, err)
+			}
+			if err := tx.Model(&LogEntry{}).Where("`index` = ?", entry.Index).UpdateColumn("command", data).Error; err != nil {
+				return fmt.Errorf("failed to migrate entry %d: %w", entry.Index, err)
+			}
+		}
+		if err := tx.Migrator().DropColumn(&LogEntry{}, "payload_id"); err != nil {
+			return fmt.Errorf("failed to drop payload ids: %w", err)
+		}
+		for _, table := range []string{"user_payloads", "admin_payloads", "wallet_operation_payloads"} {
+			if err := tx.Migrator().DropTable(table); err != nil {
+				return fmt.Errorf("failed to drop %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+// loadLegacyPayload reads the payload of a legacy entry, with its personal data opened
+func loadLegacyPayload(db *gorm.DB, entry legacyLogEntry) (utils.Payload, error) {
+	switch entry.ReferenceTable {
+	case utils.RefUser:
+		var payload legacyUserPayload
+		if err := db.First(&payload, entry.PayloadID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load user payload: %w", err)
+		}
+		return utils.UserPayload{
+			FirstName:                deref(payload.FirstName),
+			LastName:                 deref(payload.LastName),
+			HashedPassword:           deref(payload.HashedPassword),
+			Email:                    deref(payload.Email),
+			DateOfBirth:              deref(payload.DateOfBirth),
+			IdentificationNumber:     deref(payload.IdentificationNumber),
+			IdentificationImageFront: deref(payload.IdentificationImageFront),
+			IdentificationImageBack:  deref(payload.IdentificationImageBack),
+			PrevPW:                   deref(payload.PrevPW),
+			NewPW:                    deref(payload.NewPW),
+			UserID:                   deref(payload.UserID),
+			SweepWalletID:            payload.SweepWalletID,
+			Action:                   payload.Action,
+			PollID:                   entry.PollID,
+			Term:                     entry.Term,
+		}, nil
+	case utils.RefAdmin:
+		var payload legacyAdminPayload
+		if err := db.First(&payload, entry.PayloadID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load admin payload: %w", err)
+		}
+		var tiers []utils.FeeTier
+		if payload.FeeTiers != "" {
+			if err := json.Unmarshal([]byte(payload.FeeTiers), &tiers); err != nil {
+				return nil, fmt.Errorf("failed to decode fee tiers: %w", err)
+			}
+		}
+		return utils.AdminPayload{
+			FirstName:           deref(payload.FirstName),
+			LastName:            deref(payload.LastName),
+			HashedPassword:      deref(payload.HashedPassword),
+			Email:               deref(payload.Email),
+			AdminID:             deref(payload.AdminID),
+			UserId:              deref(payload.UserId),
+			FeeAction:           payload.FeeAction,
+			FeeKind:             payload.FeeKind,
+			FeeFlat:             payload.FeeFlat,
+			FeeBasisPoints:      payload.FeeBasisPoints,
+			FeeTiers:            tiers,
+			WalletID:            payload.WalletID,
+			Tier:                payload.Tier,
+			LimitScope:          payload.LimitScope,
+			LimitPerTransaction: payload.LimitPerTx,
+			LimitDaily:          payload.LimitDaily,
+			LimitMonthly:        payload.LimitMonthly,
+			Role:                payload.Role,
+			TargetAdminID:       payload.TargetAdminID,
+			Action:              payload.Action,
+			PollID:              entry.PollID,
+			Term:                entry.Term,
+		}, nil
+	case utils.RefWallet:
+		var payload legacyWalletPayload
+		if err := db.First(&payload, entry.PayloadID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load wallet payload: %w", err)
+		}
+		return utils.WalletOperationPayload{
+			Wallet1:           payload.Wallet1,
+			Wallet2:           deref(payload.Wallet2),
+			Amount:            payload.Amount,
+			ScheduleID:        payload.ScheduleID,
+			Occurrence:        payload.Occurrence,
+			Attempt:           payload.Attempt,
+			Interval:          payload.Interval,
+			StartAt:           payload.StartAt,
+			MaxRetries:        payload.MaxRetries,
+			RetryDelaySeconds: payload.RetryDelaySeconds,
+			Action:            payload.Action,
+			PollID:            entry.PollID,
+			Term:              entry.Term,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCommand, entry.ReferenceTable)
+	}
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
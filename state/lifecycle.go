@@ -0,0 +1,239 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Timing paces the elections and the replication of a node
+type Timing struct {
+	// a follower without news from a leader starts an election after a random delay in this range
+	ElectionTimeoutMin, ElectionTimeoutMax time.Duration
+	// delay between two rounds of heartbeats of a leader
+	HeartbeatInterval time.Duration
+	// deadline of a request vote or append entries rpc
+	RPCTimeout time.Duration
+}
+
+var defaultTiming = Timing{
+	ElectionTimeoutMin: 1500 * time.Millisecond,
+	ElectionTimeoutMax: 3000 * time.Millisecond,
+	HeartbeatInterval:  300 * time.Millisecond,
+	RPCTimeout:         time.Second,
+}
+
+// SetTiming replaces the default timing of the node, it must be called before Start
+func (n *Node) SetTiming(t Timing) {
+	n.Mu.Lock()
+	defer n.Mu.Unlock()
+	n.timing = t
+}
+
+// Start runs the node in the background until Stop is called or ctx is done. A stopped node can be
+// started again
+func (n *Node) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	n.Mu.Lock()
+	n.cancel = cancel
+	n.Mu.Unlock()
+	n.goTracked(func() { n.Run(ctx) })
+}
+
+// Stop stops the run loop of the node. A leader first replicates and commits the proposals still
+// queued, the api server must already be shut down so none is added meanwhile. Stop returns once the
+// loop and every background task have returned, or with the error of ctx
+func (n *Node) Stop(ctx context.Context) error {
+	n.Mu.Lock()
+	cancel := n.cancel
+	n.cancel = nil
+	n.Mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		n.routines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		fmt.Printf("%v has stopped\n", n.Address)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("node %s did not stop in time: %w", n.Address, ctx.Err())
+	}
+}
+
+// Close flushes and closes the databases of a stopped node and releases its data directory
+func (n *Node) Close() error {
+	if err := n.Log.Close(); err != nil {
+		return fmt.Errorf("could not close log of %s: %w", n.Address, err)
+	}
+	// the entries of a node may be kept apart from its term and its vote
+	if n.Log != LogStore(n.State) {
+		if err := n.State.Close(); err != nil {
+			return fmt.Errorf("could not close persistent state of %s: %w", n.Address, err)
+		}
+	}
+	if err := n.StateMachine.Close(); err != nil {
+		return fmt.Errorf("could not close state machine of %s: %w", n.Address, err)
+	}
+	return n.dataDir.Close()
+}
+
+// goTracked runs task in the background, Stop waits for it
+func (n *Node) goTracked(task func()) {
+	n.routines.Add(1)
+	go func() {
+		defer n.routines.Done()
+		task()
+	}()
+}
+
+// Run drives the node through its roles until ctx is done. The loop owns the role, the election timer
+// and the heartbeat rounds, rpcs and elections reach it through events and never wait for it. A leader
+// replicates the proposals still queued before Run returns
+func (n *Node) Run(ctx context.Context) {
+	n.setRole(Follower, 0)
+	election := n.clock.NewTimer(n.electionTimeout())
+	defer election.Stop()
+	var heartbeat <-chan time.Time
+	// a round reports its end here rather than as an event, which may be dropped. At most one round runs
+	// at a time, so the report never waits
+	roundDone := make(chan struct{}, 1)
+	inRound := false
+	for {
+		select {
+		case <-election.C():
+			if n.role() != Leader {
+				fmt.Printf("%v has timed out, starting election \n", n.Address)
+				n.campaign()
+			}
+			election.Reset(n.electionTimeout())
+
+		case <-heartbeat:
+			heartbeat = nil
+			inRound = true
+			term := n.term
+			n.goTracked(func() {
+				n.AppendEntry(term)
+				roundDone <- struct{}{}
+			})
+
+		case <-roundDone:
+			inRound = false
+			if n.role() == Leader {
+				heartbeat = n.clock.After(n.timing.HeartbeatInterval)
+			}
+
+		case ev := <-n.events:
+			switch ev := ev.(type) {
+			case rpcReceived:
+				if ev.resetTimer {
+					election.Reset(n.electionTimeout())
+				}
+				role := n.role()
+				if role != Follower && (ev.term > n.term || (role == Candidate && ev.fromLeader && ev.term == n.term)) {
+					n.becomeFollower(fmt.Sprintf("received an rpc of term %v", ev.term))
+					heartbeat = nil
+				}
+			case voteResult:
+				if n.role() != Candidate || ev.term != n.term {
+					continue
+				}
+				if !ev.won {
+					n.becomeFollower("lost the election")
+					continue
+				}
+				if err := n.becomeLeader(); err != nil {
+					fmt.Println(err)
+					n.becomeFollower("could not take the lead")
+					continue
+				}
+				if !inRound {
+					heartbeat = n.clock.After(0)
+				}
+			case stepDown:
+				if n.role() != Follower && ev.term == n.term {
+					n.becomeFollower("some node had a higher term")
+					heartbeat = nil
+				}
+			}
+
+		case <-ctx.Done():
+			if n.role() == Leader {
+				n.flush(inRound, roundDone)
+			}
+			// a stopped node leads nothing
+			n.setRole(Follower, n.term)
+			return
+		}
+	}
+}
+
+// flush runs a last round of replication once the round in flight, if any, reported its end on
+// roundDone. A round waits at most the rpc timeout for its peers, and so does flush
+func (n *Node) flush(inRound bool, roundDone <-chan struct{}) {
+	if inRound {
+		<-roundDone
+	}
+	fmt.Printf("%v is stopping, replicating queued proposals\n", n.Address)
+	n.AppendEntry(n.term)
+}
+
+// campaign moves the node to a new term as a candidate and asks the peers for their votes
+func (n *Node) campaign() {
+	term, err := n.startElection()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	n.setRole(Candidate, term)
+	n.goTracked(func() { n.requestVotes(term) })
+}
+
+// becomeLeader takes the lead in the term the node campaigned in
+func (n *Node) becomeLeader() error {
+	lastIndex, _, err := n.Log.LastIndexAndTerm()
+	if err != nil {
+		return fmt.Errorf("error getting last log index: %w", err)
+	}
+	n.Mu.Lock()
+	n.Status = Leader
+	n.LeaderAddress = n.Address
+	for _, peer := range n.Peers {
+		n.NextIndex[peer] = int64(lastIndex) + 1
+		n.MatchIndex[peer] = 0
+	}
+	// attempts proposed during a previous term may never have been committed
+	n.proposedSchedules = nil
+	n.Mu.Unlock()
+	fmt.Printf("%s became leader in term %v. Starting heartbeat loop.\n", n.Address, n.term)
+	return nil
+}
+
+func (n *Node) becomeFollower(reason string) {
+	fmt.Printf("Reverting %v to follower: %s\n", n.Address, reason)
+	n.setRole(Follower, n.term)
+}
+
+func (n *Node) setRole(role string, term int32) {
+	n.Mu.Lock()
+	n.Status = role
+	n.Mu.Unlock()
+	n.term = term
+}
+
+func (n *Node) role() string {
+	n.Mu.RLock()
+	defer n.Mu.RUnlock()
+	return n.Status
+}
+
+// electionTimeout draws the delay after which a follower without news from a leader starts an election
+func (n *Node) electionTimeout() time.Duration {
+	spread := n.timing.ElectionTimeoutMax - n.timing.ElectionTimeoutMin
+	return n.timing.ElectionTimeoutMin + time.Duration(n.rng.Int63n(int64(spread)+1))
+}
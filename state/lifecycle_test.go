@@ -0,0 +1,170 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"raft/datadir"
+	"raft/utils"
+)
+
+type emptyQueue struct{}
+
+func (emptyQueue) Retrieve() ([]utils.Payload, error) { return nil, nil }
+func (emptyQueue) Clear() error                       { return nil }
+
+// bootstrapSingleNode lays out the datadir of the only node of a cluster
+func bootstrapSingleNode(t *testing.T) datadir.Layout {
+	t.Helper()
+	layout := datadir.In(t.TempDir())
+	dir, err := datadir.Init(layout, datadir.Identity{NodeID: "node-0", ClusterID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := InitPersistentState(layout.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ps.Bootstrap([]utils.Member{{NodeID: "node-0", Address: "7000"}})
+	ps.Close()
+	dir.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
+
+// openSingleNode opens the only node of a cluster, it leads once started
+func openSingleNode(t *testing.T) *Node {
+	t.Helper()
+	n, err := OpenNode(bootstrapSingleNode(t), "test", "7000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	n.SetProposalQueue(emptyQueue{})
+	return n
+}
+
+func TestStopWithEventsDropped(t *testing.T) {
+	// the node prints every event it drops
+	stdout := os.Stdout
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+		defer func() { os.Stdout, _ = stdout, devNull.Close() }()
+	}
+	n := openSingleNode(t)
+	// rounds back to back, so one is nearly always in flight
+	n.SetTiming(Timing{ElectionTimeoutMin: 20 * time.Millisecond, ElectionTimeoutMax: 40 * time.Millisecond,
+		HeartbeatInterval: time.Microsecond, RPCTimeout: 10 * time.Millisecond})
+	n.Start(context.Background())
+	waitLeader(t, n)
+	// keep the event queue full while the node leads and stops
+	stop, flooded := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(flooded)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				n.send(rpcReceived{})
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-flooded
+	}()
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseWithSeparateLogStore(t *testing.T) {
+	layout := bootstrapSingleNode(t)
+	// another sqlite file, so the test does not depend on package wal
+	entries := func(layout datadir.Layout) (LogStore, error) {
+		return InitPersistentState(filepath.Join(layout.Dir, "entries.db"))
+	}
+	n, err := OpenNode(layout, "test", "7000", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Log == LogStore(n.State) {
+		t.Fatal("the node runs on its sqlite log")
+	}
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, db := range map[string]*gorm.DB{"log store": n.Log.(*PersistentState).DB, "state": n.State.DB} {
+		sqlDB, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sqlDB.Ping() == nil {
+			t.Fatalf("%s still open after close", name)
+		}
+	}
+}
+
+// waitLeader waits until n leads
+func waitLeader(t *testing.T, n *Node) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for n.role() != Leader {
+		if time.Now().After(deadline) {
+			t.Fatal("the only node of the cluster did not take the lead")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	layout := bootstrapSingleNode(t)
+	timing := Timing{ElectionTimeoutMin: 20 * time.Millisecond, ElectionTimeoutMax: 40 * time.Millisecond,
+		HeartbeatInterval: 5 * time.Millisecond, RPCTimeout: 10 * time.Millisecond}
+	var term int32
+	for run := 0; run < 2; run++ {
+		n, err := OpenNode(layout, "test", "7000", nil)
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		n.SetProposalQueue(emptyQueue{})
+		n.SetTiming(timing)
+		if err := n.Stop(context.Background()); err != nil {
+			t.Fatalf("stop of a node never started: %v", err)
+		}
+		n.Start(context.Background())
+		waitLeader(t, n)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = n.Stop(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Stop(context.Background()); err != nil {
+			t.Fatalf("second stop: %v", err)
+		}
+		current, err := n.State.GetCurrentTerm()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current <= term {
+			t.Fatalf("run %d led in term %d, after term %d", run, current, term)
+		}
+		term = current
+		// the data directory is released for the next run
+		if err := n.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
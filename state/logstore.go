@@ -0,0 +1,238 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"raft/datadir"
+	pb "raft/raft"
+	"raft/utils"
+
+	"gorm.io/gorm"
+)
+
+// LogStore keeps the raft log of a node. Entries are numbered without gaps, from 1 or from the index
+// after the compacted prefix, and travel as the protobuf entries sent to the peers, command included.
+// PersistentState is the sqlite backend, package wal is an append only file backend
+type LogStore interface {
+	// Append adds entries after the last one, the first must carry the index that follows it
+	Append(entries []*pb.LogEntry) error
+	// TruncateSuffix removes the entries from index on
+	TruncateSuffix(index int32) error
+	// Entries returns the entries from lo to hi included, fewer when the log ends before hi
+	Entries(lo, hi int32) ([]*pb.LogEntry, error)
+	// LastIndexAndTerm returns the position of the last entry, the last compacted one when the log holds
+	// none, zeros for an empty log
+	LastIndexAndTerm() (int32, int32, error)
+	// CompactPrefix drops the entries up to index included, once a snapshot covers them
+	CompactPrefix(index int32) error
+	Close() error
+}
+
+// LogRewriter is a log store that rewrites its entries in place, the snapshot reseals and scrubs the
+// personal data of the user commands it holds through it
+type LogRewriter interface {
+	// Rewrite passes every entry kept on disk to rewrite and writes back the ones it reports changed, with
+	// their index and term. It returns the number of entries changed
+	Rewrite(rewrite func(entry *pb.LogEntry) (bool, error)) (int, error)
+}
+
+// LogOpener opens the log store kept in the data directory of a node
+type LogOpener func(layout datadir.Layout) (LogStore, error)
+
+var _ LogStore = (*PersistentState)(nil)
+
+var (
+	// ErrLogGap is returned when appended entries do not follow the last one of the log
+	ErrLogGap = errors.New("entries do not follow the end of the log")
+	// ErrCompacted is returned for entries dropped by CompactPrefix
+	ErrCompacted = errors.New("entries compacted")
+	// ErrNoEntry is returned for an index past the end of the log
+	ErrNoEntry = errors.New("no log entry at this index")
+)
+
+// EntryAt returns the entry at index of store
+func EntryAt(store LogStore, index int32) (*pb.LogEntry, error) {
+	entries, err := store.Entries(index, index)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrNoEntry, index)
+	}
+	return entries[0], nil
+}
+
+// appendPayloads appends the payloads a leader proposes after the last entry of store
+func appendPayloads(store LogStore, payloads []utils.Payload) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+	last, _, err := store.LastIndexAndTerm()
+	if err != nil {
+		return fmt.Errorf("failed to get last log index: %w", err)
+	}
+	entries := make([]*pb.LogEntry, len(payloads))
+	for i, p := range payloads {
+		command, err := EncodeCommand(p)
+		if err != nil {
+			return err
+		}
+		entries[i] = &pb.LogEntry{Index: int64(last) + int64(i) + 1, Term: p.GetTerm(), Command: command}
+	}
+	return store.Append(entries)
+}
+
+func (ps *PersistentState) Append(entries []*pb.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	rows := make([]LogEntry, len(entries))
+	for i, entry := range entries {
+		var err error
+		if rows[i], err = ProtoToLogEntry(entry); err != nil {
+			return err
+		}
+	}
+	return ps.DB.Transaction(func(tx *gorm.DB) error {
+		last, _, err := lastIndexAndTerm(tx)
+		if err != nil {
+			return fmt.Errorf("failed to get last log index: %w", err)
+		}
+		for i, row := range rows {
+			if row.Index != int(last)+i+1 {
+				return fmt.Errorf("%w: entry %d after %d", ErrLogGap, row.Index, int(last)+i)
+			}
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return fmt.Errorf("failed to create the log entries: %w", err)
+		}
+		return nil
+	})
+}
+
+func (ps *PersistentState) TruncateSuffix(index int32) error {
+	return ps.DeleteLogEntriesFrom(int(index))
+}
+
+func (ps *PersistentState) Entries(lo, hi int32) ([]*pb.LogEntry, error) {
+	var meta MetaState
+	if err := ps.DB.First(&meta, 1).Error; err != nil {
+		return nil, err
+	}
+	if lo <= meta.CompactedIndex {
+		return nil, fmt.Errorf("%w: entry %d and before", ErrCompacted, meta.CompactedIndex)
+	}
+	rows, err := ps.GetEntriesForCommit(int(lo)-1, int(hi))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*pb.LogEntry, len(rows))
+	for i, row := range rows {
+		if entries[i], err = ToProtoLogEntry(row); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// CompactPrefix deletes the entries up to index and records where the log now starts, the poll ids of
+// their proposals are then unknown to the api
+func (ps *PersistentState) CompactPrefix(index int32) error {
+	return ps.DB.Transaction(func(tx *gorm.DB) error {
+		var meta MetaState
+		if err := tx.First(&meta, 1).Error; err != nil {
+			return err
+		}
+		if index <= meta.CompactedIndex {
+			return nil
+		}
+		var entry LogEntry
+		if err := tx.First(&entry, "`index` = ?", index).Error; err != nil {
+			return fmt.Errorf("cannot compact up to %d: %w", index, err)
+		}
+		if err := tx.Where("`index` <= ?", index).Delete(&LogEntry{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&MetaState{}).Where("id = ?", 1).
+			Updates(map[string]interface{}{"compacted_index": index, "compacted_term": entry.Term}).Error
+	})
+}
+
+// recordOutcome stores how the entry was applied. The entries of another log store get a row of their
+// own here, without their command, so the api finds them by poll id
+func (ps *PersistentState) recordOutcome(entry LogEntry, status utils.TransactionStatus, code utils.ErrorCode,
+	message string) error {
+	res := ps.DB.Model(&LogEntry{}).Where("`index` = ?", entry.Index).Updates(map[string]interface{}{
+		"applied":       true,
+		"status":        status,
+		"error_code":    code,
+		"error_message": message,
+	})
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error
+	}
+	row := LogEntry{Index: entry.Index, Term: entry.Term, ReferenceTable: entry.ReferenceTable, PollID: entry.PollID,
+		Applied: true, Status: status, ErrorCode: code, ErrorMessage: message}
+	return ps.DB.Create(&row).Error
+}
+
+// appliedBetween returns the indexes after lo up to hi whose entry was applied before the node restarted
+func (ps *PersistentState) appliedBetween(lo, hi int32) (map[int32]bool, error) {
+	var indexes []int32
+	err := ps.DB.Model(&LogEntry{}).Where("`index` > ? AND `index` <= ? AND applied = ?", lo, hi, true).
+		Pluck("index", &indexes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied entries: %w", err)
+	}
+	applied := make(map[int32]bool, len(indexes))
+	for _, index := range indexes {
+		applied[index] = true
+	}
+	return applied, nil
+}
+
+// moveEntries moves the entries of the sqlite log to store, the first time a node runs on another log
+// store. Their rows stay, without command, as the record of how they were applied. An entry moved before
+// a crash is not moved again
+func (ps *PersistentState) moveEntries(store LogStore) (int, error) {
+	last, _, err := store.LastIndexAndTerm()
+	if err != nil {
+		return 0, err
+	}
+	var rows []LogEntry
+	if err := ps.DB.Where("command IS NOT NULL AND `index` > ?", last).Order("`index` asc").Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("failed to read the sqlite log: %w", err)
+	}
+	// a corrupted entry must not reach the new store
+	if err := verifyEntries(rows); err != nil {
+		return 0, err
+	}
+	entries := make([]*pb.LogEntry, len(rows))
+	for i, row := range rows {
+		if entries[i], err = ToProtoLogEntry(row); err != nil {
+			return 0, err
+		}
+	}
+	if err := store.Append(entries); err != nil {
+		return 0, fmt.Errorf("failed to move the sqlite log: %w", err)
+	}
+	err = ps.DB.Model(&LogEntry{}).Where("command IS NOT NULL").
+		Updates(map[string]interface{}{"command": nil, "checksum": 0}).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear the moved commands: %w", err)
+	}
+	return len(rows), nil
+}
+
+// TruncateLog removes the entries from index on, as a follower does for entries the leader does not hold
+func (n *Node) TruncateLog(index int32) error {
+	if err := n.Log.TruncateSuffix(index); err != nil {
+		return err
+	}
+	if n.Log == LogStore(n.State) {
+		return nil
+	}
+	// the rows left by the entries moved from the sqlite log
+	return n.State.DeleteLogEntriesFrom(int(index))
+}
@@ -2,158 +2,311 @@ package state
 
 import (
 	"fmt"
+	"time"
 
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
-	"gorm.io/gorm"
 
+	"raft/pii"
 	pb "raft/raft"
+	"raft/state/stateMachine"
 	"raft/utils"
 )
 
+// Gorm log Entry -> proto Log entry, the command goes out as stored
+func ToProtoLogEntry(entry LogEntry) (*pb.LogEntry, error) {
+	var command pb.Command
+	if err := proto.Unmarshal(entry.Command, &command); err != nil {
+		return nil, fmt.Errorf("failed to decode the command of entry %d: %w", entry.Index, err)
+	}
+	return &pb.LogEntry{Index: int64(entry.Index), Term: entry.Term, Command: &command}, nil
+}
+
 // proto log entry -> gorm log entry
-func ProtoToLogEntry(entry *pb.LogEntry, tableRef string, term int32) (utils.Payload, error) {
-	switch tableRef {
-	case string(utils.RefUser):
-		userPayload, ok := entry.Payload.(*pb.LogEntry_UserPayload)
-		if ok {
-			return utils.UserPayload{
-				FirstName:                userPayload.UserPayload.FirstName,
-				LastName:                 userPayload.UserPayload.LastName,
-				HashedPassword:           userPayload.UserPayload.HashedPassword,
-				Email:                    userPayload.UserPayload.Email,
-				DateOfBirth:              userPayload.UserPayload.DateOfBirth.AsTime(),
-				IdentificationNumber:     userPayload.UserPayload.IdentificationNumber,
-				IdentificationImageFront: userPayload.UserPayload.IdentificationImageFront,
-				IdentificationImageBack:  userPayload.UserPayload.IdentificationImageBack,
-				PrevPW:                   userPayload.UserPayload.PrevPW,
-				NewPW:                    userPayload.UserPayload.NewPW,
-				UserID:                   int(userPayload.UserPayload.UserID),
-				Action:                   utils.UserAction(userPayload.UserPayload.Action),
-				PollID:                   userPayload.UserPayload.PollID,
-				Term:                     term,
-			}, nil
-		} else {
-			return utils.UserPayload{}, fmt.Errorf("failed to cast payload to UserPayload")
+func ProtoToLogEntry(entry *pb.LogEntry) (LogEntry, error) {
+	command := entry.GetCommand()
+	if command == nil {
+		return LogEntry{}, fmt.Errorf("entry %d has no command", entry.GetIndex())
+	}
+	data, err := proto.Marshal(command)
+	if err != nil {
+		return LogEntry{}, fmt.Errorf("failed to encode the command of entry %d: %w", entry.GetIndex(), err)
+	}
+	return LogEntry{
+		Index:          int(entry.GetIndex()),
+		Term:           entry.GetTerm(),
+		ReferenceTable: utils.RefTable(command.GetType()),
+		PollID:         command.GetPollID(),
+		Command:        data,
+		Checksum:       entryChecksum(int(entry.GetIndex()), entry.GetTerm(), data),
+	}, nil
+}
+
+func userToProto(payload utils.UserPayload) (*pb.UserPayload, error) {
+	userPayload := &pb.UserPayload{
+		FirstName:      payload.FirstName,
+		LastName:       payload.LastName,
+		HashedPassword: payload.HashedPassword,
+		Email:          payload.Email,
+		PrevPW:         payload.PrevPW,
+		NewPW:          payload.NewPW,
+		UserID:         int64(payload.UserID),
+		SweepWalletID:  int64(payload.SweepWalletID),
+		Action:         string(payload.Action),
+		Time:           stampToProto(payload.Time),
+	}
+	if err := sealUserPII(userPayload, payload); err != nil {
+		return nil, err
+	}
+	return userPayload, nil
+}
+
+func userFromProto(userPayload *pb.UserPayload) (utils.UserPayload, error) {
+	personal, err := openUserPII(userPayload)
+	if err != nil {
+		return utils.UserPayload{}, err
+	}
+	return utils.UserPayload{
+		FirstName:                userPayload.FirstName,
+		LastName:                 userPayload.LastName,
+		HashedPassword:           userPayload.HashedPassword,
+		Email:                    userPayload.Email,
+		DateOfBirth:              personal.DateOfBirth,
+		IdentificationNumber:     personal.IdentificationNumber,
+		IdentificationImageFront: personal.IdentificationImageFront,
+		IdentificationImageBack:  personal.IdentificationImageBack,
+		PrevPW:                   userPayload.PrevPW,
+		NewPW:                    userPayload.NewPW,
+		UserID:                   int(userPayload.UserID),
+		SweepWalletID:            int(userPayload.SweepWalletID),
+		Action:                   utils.UserAction(userPayload.Action),
+		Time:                     stampFromProto(userPayload.GetTime()),
+	}, nil
+}
+
+func adminToProto(payload utils.AdminPayload) *pb.AdminPayload {
+	return &pb.AdminPayload{
+		FirstName:           payload.FirstName,
+		LastName:            payload.LastName,
+		HashedPassword:      payload.HashedPassword,
+		Email:               payload.Email,
+		AdminID:             int64(payload.AdminID),
+		UserId:              int64(payload.UserId),
+		FeeAction:           string(payload.FeeAction),
+		FeeKind:             string(payload.FeeKind),
+		FeeFlat:             payload.FeeFlat,
+		FeeBasisPoints:      payload.FeeBasisPoints,
+		FeeTiers:            feeTiersToProto(payload.FeeTiers),
+		WalletID:            int64(payload.WalletID),
+		Tier:                string(payload.Tier),
+		LimitScope:          string(payload.LimitScope),
+		LimitPerTransaction: payload.LimitPerTransaction,
+		LimitDaily:          payload.LimitDaily,
+		LimitMonthly:        payload.LimitMonthly,
+		Role:                string(payload.Role),
+		TargetAdminID:       int64(payload.TargetAdminID),
+		Action:              string(payload.Action),
+		Time:                stampToProto(payload.Time),
+	}
+}
+
+func adminFromProto(adminPayload *pb.AdminPayload) utils.AdminPayload {
+	return utils.AdminPayload{
+		FirstName:           adminPayload.FirstName,
+		LastName:            adminPayload.LastName,
+		HashedPassword:      adminPayload.HashedPassword,
+		Email:               adminPayload.Email,
+		AdminID:             int(adminPayload.AdminID),
+		UserId:              int(adminPayload.UserId),
+		FeeAction:           utils.WalletAction(adminPayload.FeeAction),
+		FeeKind:             utils.FeeKind(adminPayload.FeeKind),
+		FeeFlat:             adminPayload.FeeFlat,
+		FeeBasisPoints:      adminPayload.FeeBasisPoints,
+		FeeTiers:            protoToFeeTiers(adminPayload.FeeTiers),
+		WalletID:            int(adminPayload.WalletID),
+		Tier:                utils.UserTier(adminPayload.Tier),
+		LimitScope:          utils.LimitScope(adminPayload.LimitScope),
+		LimitPerTransaction: adminPayload.LimitPerTransaction,
+		LimitDaily:          adminPayload.LimitDaily,
+		LimitMonthly:        adminPayload.LimitMonthly,
+		Role:                utils.AdminRole(adminPayload.Role),
+		TargetAdminID:       int(adminPayload.TargetAdminID),
+		Action:              utils.AdminAction(adminPayload.Action),
+		Time:                stampFromProto(adminPayload.GetTime()),
+	}
+}
+
+func walletToProto(payload utils.WalletOperationPayload) *pb.WalletOperationPayload {
+	return &pb.WalletOperationPayload{
+		Wallet1:           int64(payload.Wallet1),
+		Wallet2:           int64(payload.Wallet2),
+		Amount:            payload.Amount,
+		Action:            string(payload.Action),
+		ScheduleID:        int64(payload.ScheduleID),
+		Occurrence:        int64(payload.Occurrence),
+		Attempt:           int64(payload.Attempt),
+		Interval:          string(payload.Interval),
+		StartAt:           timestamppb.New(payload.StartAt),
+		MaxRetries:        int64(payload.MaxRetries),
+		RetryDelaySeconds: payload.RetryDelaySeconds,
+		Time:              stampToProto(payload.Time),
+	}
+}
+
+func walletFromProto(walletPayload *pb.WalletOperationPayload) utils.WalletOperationPayload {
+	return utils.WalletOperationPayload{
+		Wallet1:           int(walletPayload.Wallet1),
+		Wallet2:           int(walletPayload.Wallet2),
+		Amount:            walletPayload.Amount,
+		Action:            utils.WalletAction(walletPayload.Action),
+		ScheduleID:        int(walletPayload.ScheduleID),
+		Occurrence:        int(walletPayload.Occurrence),
+		Attempt:           int(walletPayload.Attempt),
+		Interval:          utils.ScheduleInterval(walletPayload.Interval),
+		StartAt:           walletPayload.StartAt.AsTime(),
+		MaxRetries:        int(walletPayload.MaxRetries),
+		RetryDelaySeconds: walletPayload.RetryDelaySeconds,
+		Time:              stampFromProto(walletPayload.GetTime()),
+	}
+}
+
+// stampToProto encodes the time a leader stamped, entries appended before leaders stamped them have none
+func stampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func stampFromProto(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
+
+func configurationToProto(payload utils.ConfigurationPayload) *pb.ConfigurationPayload {
+	members := make([]*pb.Member, len(payload.Members))
+	for i, m := range payload.Members {
+		members[i] = &pb.Member{NodeId: m.NodeID, Address: m.Address}
+	}
+	return &pb.ConfigurationPayload{Members: members}
+}
+
+func configurationFromProto(configurationPayload *pb.ConfigurationPayload) utils.ConfigurationPayload {
+	members := make([]utils.Member, len(configurationPayload.GetMembers()))
+	for i, m := range configurationPayload.GetMembers() {
+		members[i] = utils.Member{NodeID: m.GetNodeId(), Address: m.GetAddress()}
+	}
+	return utils.ConfigurationPayload{Members: members}
+}
+
+func protoToFeeTiers(tiers []*pb.FeeTier) []utils.FeeTier {
+	result := make([]utils.FeeTier, 0, len(tiers))
+	for _, t := range tiers {
+		result = append(result, utils.FeeTier{UpTo: t.UpTo, Flat: t.Flat, BasisPoints: t.BasisPoints})
+	}
+	return result
+}
+
+func feeTiersToProto(tiers []utils.FeeTier) []*pb.FeeTier {
+	result := make([]*pb.FeeTier, 0, len(tiers))
+	for _, t := range tiers {
+		result = append(result, &pb.FeeTier{UpTo: t.UpTo, Flat: t.Flat, BasisPoints: t.BasisPoints})
+	}
+	return result
+}
+
+// sealUserPII copies the personal data of a payload into its proto form, sealed so it is never stored nor
+// sent in clear
+func sealUserPII(dst *pb.UserPayload, payload utils.UserPayload) error {
+	var err error
+	if pii.Enabled() {
+		if dst.SealedDateOfBirth, err = pii.Seal(pii.FormatTime(payload.DateOfBirth)); err != nil {
+			return err
 		}
-	case string(utils.RefAdmin):
-		adminPayload, ok := entry.Payload.(*pb.LogEntry_AdminPayload)
-		if ok {
-			return utils.AdminPayload{
-				FirstName:      adminPayload.AdminPayload.FirstName,
-				LastName:       adminPayload.AdminPayload.LastName,
-				HashedPassword: adminPayload.AdminPayload.HashedPassword,
-				Email:          adminPayload.AdminPayload.Email,
-				AdminID:        int(adminPayload.AdminPayload.AdminID),
-				UserId:         int(adminPayload.AdminPayload.UserId),
-				Action:         utils.AdminAction(adminPayload.AdminPayload.Action),
-				PollID:         adminPayload.AdminPayload.PollID,
-				Term:           term,
-			}, nil
-		} else {
-			return utils.AdminPayload{}, fmt.Errorf("failed to cast payload to AdminPayload")
+	} else {
+		dst.DateOfBirth = timestamppb.New(payload.DateOfBirth)
+	}
+	if dst.IdentificationNumber, err = pii.Seal(payload.IdentificationNumber); err != nil {
+		return err
+	}
+	if dst.IdentificationImageFront, err = pii.Seal(payload.IdentificationImageFront); err != nil {
+		return err
+	}
+	dst.IdentificationImageBack, err = pii.Seal(payload.IdentificationImageBack)
+	return err
+}
+
+// openUserPII reads the personal data of a stored payload, values written in clear by nodes without keys
+// are accepted as is
+func openUserPII(src *pb.UserPayload) (utils.UserPayload, error) {
+	var personal utils.UserPayload
+	var err error
+	if src.SealedDateOfBirth != "" {
+		dateOfBirth, err := pii.Open(src.SealedDateOfBirth)
+		if err != nil {
+			return personal, fmt.Errorf("failed to open date of birth: %w", err)
 		}
-	case string(utils.RefWallet):
-		walletPayload, ok := entry.Payload.(*pb.LogEntry_WalletOperationPayload)
-		if ok {
-			return utils.WalletOperationPayload{
-				Wallet1: int(walletPayload.WalletOperationPayload.Wallet1),
-				Wallet2: int(walletPayload.WalletOperationPayload.Wallet2),
-				Amount:  walletPayload.WalletOperationPayload.Amount,
-				PollID:  walletPayload.WalletOperationPayload.PollID,
-				Action:  utils.WalletAction(walletPayload.WalletOperationPayload.Action),
-				Term:    term,
-			}, nil
-		} else {
-			return utils.WalletOperationPayload{}, fmt.Errorf("failed to cast payload to Wallet operation")
+		if personal.DateOfBirth, err = pii.ParseTime(dateOfBirth); err != nil {
+			return personal, err
 		}
-	default:
-		return utils.UserPayload{}, fmt.Errorf("unsopported table reference:%s", tableRef)
+	} else {
+		personal.DateOfBirth = src.DateOfBirth.AsTime()
+	}
+	if personal.IdentificationNumber, err = pii.Open(src.IdentificationNumber); err != nil {
+		return personal, fmt.Errorf("failed to open identification number: %w", err)
+	}
+	if personal.IdentificationImageFront, err = pii.Open(src.IdentificationImageFront); err != nil {
+		return personal, fmt.Errorf("failed to open identification image: %w", err)
 	}
+	if personal.IdentificationImageBack, err = pii.Open(src.IdentificationImageBack); err != nil {
+		return personal, fmt.Errorf("failed to open identification image: %w", err)
+	}
+	return personal, nil
 }
 
-// Gorm log Entry -> proto Lof entry
-func ToProtoLogEntry(entry LogEntry, db *gorm.DB) (*pb.LogEntry, error) {
-	refTable := entry.ReferenceTable
-
-	switch refTable {
-	case utils.RefUser:
-		var payload UserPayload
-		if err := db.First(&payload, entry.PayloadID).Error; err != nil {
-			return nil, fmt.Errorf("failed to load user payload: %w", err)
-		}
-		return &pb.LogEntry{
-			Index:          int64(entry.Index),
-			Term:           entry.Term,
-			ReferenceTable: string(refTable),
-			Payload: &pb.LogEntry_UserPayload{
-				UserPayload: &pb.UserPayload{
-					FirstName:                *payload.FirstName,
-					LastName:                 *payload.LastName,
-					HashedPassword:           *payload.HashedPassword,
-					Email:                    *payload.Email,
-					DateOfBirth:              timestamppb.New(*payload.DateOfBirth),
-					IdentificationNumber:     *payload.IdentificationNumber,
-					IdentificationImageFront: *payload.IdentificationImageFront,
-					IdentificationImageBack:  *payload.IdentificationImageBack,
-					PrevPW:                   *payload.PrevPW,
-					NewPW:                    *payload.NewPW,
-					UserID:                   int64(*payload.UserID),
-					Action:                   string(payload.Action),
-					PollID:                   entry.PollID,
-				},
-			},
-		}, nil
-
-	case utils.RefAdmin:
-		var payload AdminPayload
-		if err := db.First(&payload, entry.PayloadID).Error; err != nil {
-			return nil, fmt.Errorf("failed to load admin payload: %w", err)
+// resealUserPII seals again, under the active key, the personal data of a stored payload still in clear or
+// sealed with a retired key. It reports whether the payload changed
+func resealUserPII(payload *pb.UserPayload) (bool, error) {
+	if !pii.Enabled() {
+		return false, nil
+	}
+	changed := false
+	// dates written without keys are timestamps
+	if payload.SealedDateOfBirth == "" && payload.DateOfBirth != nil {
+		payload.SealedDateOfBirth = pii.FormatTime(payload.DateOfBirth.AsTime())
+		payload.DateOfBirth = nil
+	}
+	for _, field := range []*string{&payload.SealedDateOfBirth, &payload.IdentificationNumber,
+		&payload.IdentificationImageFront, &payload.IdentificationImageBack} {
+		if !pii.NeedsReseal(*field) {
+			continue
 		}
-		if payload.FirstName == nil || payload.LastName == nil || payload.HashedPassword == nil || payload.Email == nil {
-			fmt.Println("what i just edited")
-			fmt.Println(entry.Term, string(refTable), payload.FirstName, payload.LastName,
-				payload.HashedPassword, payload.Action, payload.AdminID, payload.UserId)
-			return nil, fmt.Errorf("nil field")
+		value, err := pii.Open(*field)
+		if err != nil {
+			return changed, err
 		}
-		return &pb.LogEntry{
-			Index:          int64(entry.Index),
-			Term:           entry.Term,
-			ReferenceTable: string(refTable),
-			Payload: &pb.LogEntry_AdminPayload{
-				AdminPayload: &pb.AdminPayload{
-					FirstName:      *payload.FirstName,
-					LastName:       *payload.LastName,
-					HashedPassword: *payload.HashedPassword,
-					Email:          *payload.Email,
-					AdminID:        int64(*payload.AdminID),
-					UserId:         int64(*payload.UserId),
-					Action:         string(payload.Action),
-					PollID:         entry.PollID,
-				},
-			},
-		}, nil
-
-	case utils.RefWallet:
-		var payload WalletOperationPayload
-		if err := db.First(&payload, entry.PayloadID).Error; err != nil {
-			return nil, fmt.Errorf("failed to load wallet payload: %w", err)
+		if *field, err = pii.Seal(value); err != nil {
+			return changed, err
 		}
-		return &pb.LogEntry{
-			Index:          int64(entry.Index),
-			Term:           entry.Term,
-			ReferenceTable: string(refTable),
-			Payload: &pb.LogEntry_WalletOperationPayload{
-				WalletOperationPayload: &pb.WalletOperationPayload{
-					Wallet1: int64(payload.Wallet1),
-					Wallet2: int64(*payload.Wallet2),
-					Amount:  payload.Amount,
-					Action:  string(payload.Action),
-					PollID:  entry.PollID,
-				},
-			},
-		}, nil
-
-	default:
-		return nil, fmt.Errorf("unsupported reference table: %s", refTable)
+		changed = true
 	}
+	return changed, nil
+}
+
+// scrubUserPII clears the personal data of an erased user from a stored payload, the email becomes the
+// pseudonymous one of the tombstone
+func scrubUserPII(payload *pb.UserPayload, userID int) {
+	payload.FirstName = stateMachine.ErasedName
+	payload.LastName = stateMachine.ErasedName
+	payload.Email = stateMachine.ErasedEmail(userID)
+	payload.HashedPassword = ""
+	payload.PrevPW = ""
+	payload.NewPW = ""
+	payload.DateOfBirth = timestamppb.New(time.Time{})
+	payload.SealedDateOfBirth = ""
+	payload.IdentificationNumber = ""
+	payload.IdentificationImageFront = ""
+	payload.IdentificationImageBack = ""
 }
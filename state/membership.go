@@ -0,0 +1,171 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"raft/utils"
+)
+
+var (
+	// ErrNotBootstrapped is returned when a node starts on a log that knows no members
+	ErrNotBootstrapped = errors.New("log not bootstrapped")
+	// ErrBootstrapped is returned when a log that knows its members is bootstrapped again
+	ErrBootstrapped = errors.New("log already bootstrapped")
+)
+
+// the configuration entry a new cluster starts with, every node writes the same one
+const (
+	ConfigurationIndex = 1
+	ConfigurationTerm  = 1
+)
+
+// Member is a node of the cluster and the address it was last seen at. The peers know a node by its id,
+// votes included, so the node keeps them when its address changes
+type Member struct {
+	NodeID  string `gorm:"primaryKey"`
+	Address string
+}
+
+// Members returns the members recorded in the log
+func (ps *PersistentState) Members() ([]Member, error) {
+	var members []Member
+	err := ps.DB.Order("node_id asc").Find(&members).Error
+	return members, err
+}
+
+// RecordMembers adds the members the log does not know yet, the known ones keep their address
+func (ps *PersistentState) RecordMembers(members []utils.Member) error {
+	return recordMembers(ps.DB, members)
+}
+
+func recordMembers(db *gorm.DB, members []utils.Member) error {
+	if len(members) == 0 {
+		return nil
+	}
+	rows := make([]Member, len(members))
+	for i, m := range members {
+		rows[i] = Member{NodeID: m.NodeID, Address: m.Address}
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+		return fmt.Errorf("failed to record members: %w", err)
+	}
+	return nil
+}
+
+// SetMemberAddress records the address a member moved to
+func (ps *PersistentState) SetMemberAddress(nodeID, address string) error {
+	return ps.DB.Model(&Member{}).Where("node_id = ?", nodeID).Update("address", address).Error
+}
+
+// Bootstrap starts the empty log of a new cluster: it writes the configuration entry listing members,
+// moves to its term and records the members. Every node of the cluster is bootstrapped with the same
+// members, so their logs start with the same entry
+func (ps *PersistentState) Bootstrap(members []utils.Member) error {
+	command, err := EncodeCommand(utils.ConfigurationPayload{Members: members, PollID: "bootstrap",
+		Term: ConfigurationTerm})
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("failed to encode command: %w", err)
+	}
+	return ps.DB.Transaction(func(tx *gorm.DB) error {
+		var known, entries int64
+		if err := tx.Model(&Member{}).Count(&known).Error; err != nil {
+			return err
+		}
+		if known > 0 {
+			return ErrBootstrapped
+		}
+		if err := tx.Model(&LogEntry{}).Count(&entries).Error; err != nil {
+			return err
+		}
+		if entries > 0 {
+			return fmt.Errorf("log holds %d entries, only an empty log gets a configuration entry", entries)
+		}
+		entry := LogEntry{Index: ConfigurationIndex, Term: ConfigurationTerm, ReferenceTable: utils.RefConfiguration,
+			PollID: command.PollID, Command: data}
+		entry.Checksum = entryChecksum(entry.Index, entry.Term, entry.Command)
+		if err := tx.Create(&entry).Error; err != nil {
+			return fmt.Errorf("failed to write the configuration entry: %w", err)
+		}
+		if err := tx.Model(&MetaState{}).Where("id = ?", 1).
+			Updates(map[string]interface{}{"current_term": ConfigurationTerm, "voted_for": ""}).Error; err != nil {
+			return err
+		}
+		return recordMembers(tx, members)
+	})
+}
+
+// loadMembers reads the peers of node id, the one at address, and where to reach them. A node that
+// moved records its new address
+func loadMembers(ps *PersistentState, id, address string) ([]string, map[string]string, error) {
+	members, err := ps.Members()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read members: %w", err)
+	}
+	if len(members) == 0 {
+		return nil, nil, fmt.Errorf("%w: the log knows no members, bootstrap the cluster first", ErrNotBootstrapped)
+	}
+	peers := make([]string, 0, len(members))
+	addresses := make(map[string]string, len(members))
+	self := false
+	for _, m := range members {
+		if m.NodeID != id {
+			peers = append(peers, m.NodeID)
+			addresses[m.NodeID] = m.Address
+			continue
+		}
+		self = true
+		if m.Address != address {
+			if err := ps.SetMemberAddress(id, address); err != nil {
+				return nil, nil, fmt.Errorf("could not record new address: %w", err)
+			}
+			log.Printf("node %s moved from %s to %s", id, m.Address, address)
+		}
+	}
+	if !self {
+		return nil, nil, fmt.Errorf("node %s is not a member of its cluster", id)
+	}
+	return peers, addresses, nil
+}
+
+// PeerAddress returns where the peer with node id was last seen
+func (n *Node) PeerAddress(id string) string {
+	n.Mu.RLock()
+	defer n.Mu.RUnlock()
+	return n.addresses[id]
+}
+
+// ObservePeer records the address a peer sent an rpc from, it reports whether the peer is a member
+func (n *Node) ObservePeer(id, address string) bool {
+	n.Mu.Lock()
+	known, ok := n.addresses[id]
+	if !ok || address == "" || address == known {
+		n.Mu.Unlock()
+		return ok
+	}
+	n.addresses[id] = address
+	n.Mu.Unlock()
+	if err := n.State.SetMemberAddress(id, address); err != nil {
+		log.Printf("%s could not record the new address of %s: %v", n.Address, id, err)
+	}
+	log.Printf("%s: peer %s moved from %s to %s", n.Address, id, known, address)
+	return true
+}
+
+// dialTarget is where to reach address, a port alone stands for localhost
+func dialTarget(address string) string {
+	if strings.Contains(address, ":") {
+		return address
+	}
+	return "localhost:" + address
+}
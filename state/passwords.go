@@ -0,0 +1,68 @@
+package state
+
+import (
+	"fmt"
+	"log"
+
+	"raft/utils"
+)
+
+// passwords hashed per heartbeat, hashing is slow on purpose and must not hold back the replication
+const rehashBatch = 2
+
+// SetPasswordHasher sets how the node, when it leads, hashes the passwords still stored in clear by
+// accounts created before passwords were hashed
+func (node *Node) SetPasswordHasher(hash func(string) (string, error)) {
+	node.Mu.Lock()
+	defer node.Mu.Unlock()
+	node.hashPassword = hash
+}
+
+// rehashPayloads proposes to replace the passwords stored in clear by their hash, each of them once per
+// term. Once every proposal is applied no password in clear is left and the migration is over. Heartbeat
+// rounds never overlap and call it without the node lock, which the hashing must not hold
+func (node *Node) rehashPayloads(term int32) []utils.Payload {
+	node.Mu.RLock()
+	hashPassword := node.hashPassword
+	node.Mu.RUnlock()
+	if hashPassword == nil {
+		return nil
+	}
+	if node.rehashTerm != term {
+		node.rehashTerm = term
+		node.rehashed = make(map[string]bool)
+	}
+	users, admins, err := node.StateMachine.ClearPasswords(len(node.rehashed) + rehashBatch)
+	if err != nil {
+		log.Printf("could not get passwords stored in clear: %v", err)
+		return nil
+	}
+	var payloads []utils.Payload
+	propose := func(pollID, password string, payload func(hash string) utils.Payload) {
+		if len(payloads) == rehashBatch || node.rehashed[pollID] {
+			return
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			log.Printf("could not hash password for %s: %v", pollID, err)
+			return
+		}
+		node.rehashed[pollID] = true
+		payloads = append(payloads, payload(hash))
+	}
+	for _, u := range users {
+		pollID := fmt.Sprintf("rehash-user-%d", u.UserID)
+		propose(pollID, u.HashedPassword, func(hash string) utils.Payload {
+			return utils.UserPayload{UserID: u.UserID, NewPW: hash, Action: utils.UserRehashPassword,
+				PollID: pollID, Term: term}
+		})
+	}
+	for _, a := range admins {
+		pollID := fmt.Sprintf("rehash-admin-%d", a.AdminID)
+		propose(pollID, a.HashedPassword, func(hash string) utils.Payload {
+			return utils.AdminPayload{AdminID: -1, UserId: -1, TargetAdminID: a.AdminID, HashedPassword: hash,
+				Action: utils.AdminRehashPassword, PollID: pollID, Term: term}
+		})
+	}
+	return payloads
+}
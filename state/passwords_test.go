@@ -0,0 +1,82 @@
+package state
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+func TestRehashPasswordsStoredInClear(t *testing.T) {
+	sm, _ := openNodeStateMachines(t)
+	hashed, err := bcrypt.GenerateFromPassword([]byte("hashed"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []interface{}{
+		&models.User{UserID: 1, Email: "one@test.invalid", HashedPassword: "first"},
+		&models.User{UserID: 2, Email: "two@test.invalid", HashedPassword: string(hashed)},
+		&models.User{UserID: 3, Email: "three@test.invalid", HashedPassword: "third", Status: utils.AccountClosed},
+		&models.User{UserID: 4, Email: "erased@test.invalid"},
+		&models.Admin{AdminID: 1, Email: "root@test.invalid", HashedPassword: "root", Role: utils.RoleSuperAdmin},
+	} {
+		if err := sm.DB.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := &Node{StateMachine: sm}
+	node.SetPasswordHasher(func(password string) (string, error) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		return string(hash), err
+	})
+
+	// the passwords in clear are proposed a batch at a time, each of them once per term
+	var payloads []utils.Payload
+	for round := 0; round < 3; round++ {
+		payloads = append(payloads, node.rehashPayloads(3)...)
+	}
+	if len(payloads) != 3 {
+		t.Fatalf("proposed %d payloads, want users 1 and 3 and admin 1", len(payloads))
+	}
+	for _, p := range payloads {
+		var err error
+		switch p := p.(type) {
+		case utils.UserPayload:
+			err = sm.ApplyUserOperation(p)
+		case utils.AdminPayload:
+			err = sm.ApplyAdminOperations(p)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", p.GetPollID(), err)
+		}
+	}
+	users, admins, err := sm.ClearPasswords(10)
+	if err != nil || len(users) != 0 || len(admins) != 0 {
+		t.Fatalf("%d users and %d admins left in clear, %v", len(users), len(admins), err)
+	}
+	var user models.User
+	if err := sm.DB.First(&user, "user_id = ?", 3).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte("third")) != nil {
+		t.Fatalf("user 3 holds %q, want the hash of its password", user.HashedPassword)
+	}
+	if again := node.rehashPayloads(4); len(again) != 0 {
+		t.Fatalf("proposed %d payloads once the migration was over", len(again))
+	}
+
+	// the hash must match the password in clear, a forged proposal cannot change it
+	if err := sm.DB.Model(&models.User{}).Where("user_id = ?", 1).Update("hashed_password", "first").Error; err != nil {
+		t.Fatal(err)
+	}
+	forged := utils.UserPayload{UserID: 1, NewPW: string(hashed), Action: utils.UserRehashPassword}
+	if err := sm.ApplyUserOperation(forged); utils.ErrorCodeOf(err) != utils.CodeInvalidCredentials {
+		t.Fatalf("rehash with the hash of another password: %v", err)
+	}
+	forged.UserID = 2
+	if err := sm.ApplyUserOperation(forged); utils.ErrorCodeOf(err) != utils.CodeInvalidRequest {
+		t.Fatalf("rehash of a hashed password: %v", err)
+	}
+}
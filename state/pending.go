@@ -0,0 +1,107 @@
+package state
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTooManyPending is returned when a caller already has the most proposals waiting for a commit
+	ErrTooManyPending = errors.New("too many operations pending")
+	// ErrAlreadyPending is returned when a caller proposes again a poll id still waiting for a commit
+	ErrAlreadyPending = errors.New("operation already pending")
+)
+
+// PendingProposals counts, on the leader, the proposals of each caller that are not committed yet. A
+// proposal is released when its entry is applied, or after ttl if it never reaches the log. The zero
+// value has no bound
+type PendingProposals struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	callers map[string]int
+	polls   map[string]map[string]time.Time // expiry by poll id, then caller
+}
+
+func newPendingProposals() *PendingProposals {
+	return &PendingProposals{callers: make(map[string]int), polls: make(map[string]map[string]time.Time)}
+}
+
+// SetPendingLimit bounds the proposals a caller may have waiting for a commit, 0 disables the bound
+func (n *Node) SetPendingLimit(max int, ttl time.Duration) {
+	n.Pending.mu.Lock()
+	defer n.Pending.mu.Unlock()
+	n.Pending.max = max
+	n.Pending.ttl = ttl
+}
+
+// Acquire registers the proposal pollID of caller. It fails when the caller already has too many pending,
+// or already proposed pollID and it is not applied yet: every proposal takes a slot, a retry included
+func (p *PendingProposals) Acquire(caller, pollID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.max <= 0 {
+		return nil
+	}
+	now := time.Now()
+	p.expire(now)
+	if _, ok := p.polls[pollID][caller]; ok {
+		return ErrAlreadyPending
+	}
+	if p.callers[caller] >= p.max {
+		return ErrTooManyPending
+	}
+	if p.callers == nil {
+		p.callers = make(map[string]int)
+		p.polls = make(map[string]map[string]time.Time)
+	}
+	p.callers[caller]++
+	if p.polls[pollID] == nil {
+		p.polls[pollID] = make(map[string]time.Time)
+	}
+	p.polls[pollID][caller] = now.Add(p.ttl)
+	return nil
+}
+
+// Release forgets the proposals pollID of every caller, once its entry is applied. Releasing an unknown
+// proposal is a no-op
+func (p *PendingProposals) Release(pollID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for caller := range p.polls[pollID] {
+		p.release(caller, pollID)
+	}
+}
+
+// ReleaseCaller forgets the proposal pollID of caller, refused before it reached the queue
+func (p *PendingProposals) ReleaseCaller(caller, pollID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.release(caller, pollID)
+}
+
+func (p *PendingProposals) release(caller, pollID string) {
+	if _, ok := p.polls[pollID][caller]; !ok {
+		return
+	}
+	delete(p.polls[pollID], caller)
+	if len(p.polls[pollID]) == 0 {
+		delete(p.polls, pollID)
+	}
+	if p.callers[caller] <= 1 {
+		delete(p.callers, caller)
+	} else {
+		p.callers[caller]--
+	}
+}
+
+func (p *PendingProposals) expire(now time.Time) {
+	for pollID, callers := range p.polls {
+		for caller, expires := range callers {
+			if now.After(expires) {
+				p.release(caller, pollID)
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPendingProposals(t *testing.T) {
+	p := newPendingProposals()
+	p.max, p.ttl = 2, time.Minute
+	acquire := func(caller, pollID string, want error) {
+		t.Helper()
+		if err := p.Acquire(caller, pollID); !errors.Is(err, want) {
+			t.Fatalf("Acquire(%s, %s) = %v, want %v", caller, pollID, err, want)
+		}
+	}
+	acquire("alice", "a1", nil)
+	// a retry of a pending proposal would be queued again, it takes no free slot
+	acquire("alice", "a1", ErrAlreadyPending)
+	acquire("alice", "a2", nil)
+	acquire("alice", "a3", ErrTooManyPending)
+	// poll ids are chosen by the callers, another one may use the same
+	acquire("bob", "a1", nil)
+
+	p.ReleaseCaller("alice", "a2")
+	acquire("alice", "a3", nil)
+	// applying the entry of a1 frees it for every caller
+	p.Release("a1")
+	acquire("alice", "a4", nil)
+	acquire("bob", "a1", nil)
+	acquire("alice", "a5", ErrTooManyPending)
+
+	p.expire(time.Now().Add(2 * time.Minute))
+	acquire("alice", "a5", nil)
+	if len(p.callers) != 1 || len(p.polls) != 1 {
+		t.Fatalf("%d callers and %d polls pending after expiry, want 1 and 1", len(p.callers), len(p.polls))
+	}
+}
+
+func TestPendingProposalsUnbounded(t *testing.T) {
+	var p PendingProposals
+	for i := 0; i < 3; i++ {
+		if err := p.Acquire("alice", "a1"); err != nil {
+			t.Fatalf("unbounded Acquire = %v", err)
+		}
+	}
+	p.Release("a1")
+}
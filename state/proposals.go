@@ -0,0 +1,23 @@
+package state
+
+import "raft/utils"
+
+// ProposalQueue holds the operations accepted by the api and not yet appended to the log, the leader
+// drains it at every round of heartbeats
+type ProposalQueue interface {
+	Retrieve() ([]utils.Payload, error)
+	Clear() error
+}
+
+// redisQueue is the queue the api servers push to
+type redisQueue struct{}
+
+func (redisQueue) Retrieve() ([]utils.Payload, error) { return utils.RetreivePayloads() }
+func (redisQueue) Clear() error                       { return utils.ClearPayloads() }
+
+// SetProposalQueue replaces the redis queue of the node, it must be called before Start
+func (n *Node) SetProposalQueue(q ProposalQueue) {
+	n.Mu.Lock()
+	defer n.Mu.Unlock()
+	n.proposals = q
+}
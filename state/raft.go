@@ -2,113 +2,177 @@
 package state
 
 import (
-	//"errors"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"raft/blobstore"
+	"raft/datadir"
 	"raft/state/stateMachine"
 	"raft/utils"
+	"slices"
 	"sync"
 	"time"
 )
 
 type Node struct {
-	CommitIndex, LastApplied                                                                 int32
-	LeaderAddress, Status, Address                                                           string
-	Peers                                                                                    []string
-	Mu                                                                                       sync.RWMutex
-	ResetTimerChan, StopTimerChan, StartElectionChan, BecomeLeaderChan, RevertToFollowerChan chan bool
-	MatchIndex                                                                               map[string]int32
-	NextIndex                                                                                map[string]int64
-	Log                                                                                      *PersistentState
-	StateMachine                                                                             *stateMachine.StateMachine
+	CommitIndex, LastApplied        int32
+	LeaderAddress, Status, Address  string   // Address is where the peers reach the node
+	ID                              string   // node id, the peers know the node and its votes by it
+	ClusterID                       string   // cluster of the data directory, the peers must share it
+	Peers                           []string // node ids of the other members
+	Mu                              sync.RWMutex
+	addresses                       map[string]string // where each peer was last seen, by node id
+	MatchIndex                      map[string]int32
+	NextIndex                       map[string]int64
+	Log                             LogStore         // the entries
+	State                           *PersistentState // term, vote, members and how entries were applied
+	StateMachine                    *stateMachine.StateMachine
+	Blobs                           *blobstore.Store
+	Pending                         *PendingProposals
+	proposedSchedules               map[int]string // last attempt proposed per schedule
+	bootstrapAdmin                  *utils.AdminPayload
+	bootstrapTerm                   int32 // term the bootstrap admin was last proposed in
+	hashPassword                    func(string) (string, error)
+	rehashed                        map[string]bool // passwords in clear proposed in rehashTerm, by poll id, only used by heartbeat rounds
+	rehashTerm                      int32
+	snapshotIndex, snapshotInterval int32
+	timing                          Timing
+	transport                       Transport
+	clock                           Clock
+	rng                             *rand.Rand // draws the election timeouts, only used by the run loop
+	proposals                       ProposalQueue
+	faults                          *Faults // nil unless fault injection is enabled
+	events                          chan event
+	term                            int32              // term of the current role, owned by the run loop
+	cancel                          context.CancelFunc // stops the run loop
+	routines                        sync.WaitGroup     // run loop and background tasks
+	dataDir                         *datadir.Dir
 }
 
-// creates a new computational node
-func NewNode(address string, allPeers []string) (*Node, error) {
-	peers := make([]string, 0)
-	for _, val := range allPeers {
-		if val != address {
-			peers = append(peers, val)
-		}
+// OpenNode creates the node of a bootstrapped data directory, it holds the directory until closed and
+// serves at address. clusterID is the cluster the directory must belong to, empty for the one it
+// records. The peers are the members recorded in the log. openLog opens the store of the log entries,
+// nil keeps them in the sqlite log beside the term, the vote and the members
+func OpenNode(layout datadir.Layout, clusterID, address string, openLog LogOpener) (*Node, error) {
+	dir, err := datadir.Open(layout, clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("could not open data directory of %s: %w", address, err)
 	}
-	ps, err := InitPersistentState(fmt.Sprintf("%s.db", address))
+	// what was opened is closed again if the node cannot start
+	opened := false
+	var closers []func() error
+	defer func() {
+		if opened {
+			return
+		}
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+		dir.Close()
+	}()
+	ps, err := InitPersistentState(layout.Log)
 	if err != nil {
 		fmt.Println("Error initializing persistent state:", err)
 		return nil, fmt.Errorf("could not initialize persistent state for %s, error: %w", address, err)
 	}
-	sm, sm_init_err := stateMachine.InitStateMachine(fmt.Sprintf("%s.sm.db", address))
+	closers = append(closers, ps.Close)
+	store, err := openLogStore(ps, layout, openLog)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to start %s: %w", address, err)
+	}
+	if store != LogStore(ps) {
+		closers = append(closers, store.Close)
+	}
+	peers, addresses, err := loadMembers(ps, dir.Identity.NodeID, address)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to start %s: %w", address, err)
+	}
+	sm, sm_init_err := stateMachine.InitStateMachine(layout.StateMachine)
 	if sm_init_err != nil {
 		fmt.Println("Error initializing state machine:", sm_init_err)
 		return nil, fmt.Errorf("could not initialize state machine %s, error: %w", address, sm_init_err)
 	}
+	closers = append(closers, sm.Close)
+	blobs, err := blobstore.Open(layout.Blobs)
+	if err != nil {
+		return nil, fmt.Errorf("could not open blob store for %s, error: %w", address, err)
+	}
+	snapshotIndex, err := ps.GetSnapshotIndex()
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot index for %s, error: %w", address, err)
+	}
+	opened = true
 	return &Node{
-		CommitIndex:          0,
-		LastApplied:          0,
-		LeaderAddress:        "",
-		Status:               "follower",
-		Peers:                peers,
-		Address:              address,
-		ResetTimerChan:       make(chan bool, 1),
-		StopTimerChan:        make(chan bool, 1),
-		StartElectionChan:    make(chan bool, 1),
-		BecomeLeaderChan:     make(chan bool, 1),
-		RevertToFollowerChan: make(chan bool, 1),
-		NextIndex:            make(map[string]int64),
-		MatchIndex:           make(map[string]int32),
-		Log:                  ps,
-		StateMachine:         sm,
+		CommitIndex:      0,
+		LastApplied:      0,
+		LeaderAddress:    "",
+		Status:           Follower,
+		Peers:            peers,
+		Address:          address,
+		ID:               dir.Identity.NodeID,
+		addresses:        addresses,
+		ClusterID:        dir.Identity.ClusterID,
+		dataDir:          dir,
+		timing:           defaultTiming,
+		transport:        grpcTransport{},
+		clock:            realClock{},
+		rng:              rand.New(rand.NewSource(time.Now().UnixNano())),
+		proposals:        redisQueue{},
+		events:           make(chan event, eventQueueSize),
+		NextIndex:        make(map[string]int64),
+		MatchIndex:       make(map[string]int32),
+		Log:              store,
+		State:            ps,
+		StateMachine:     sm,
+		Blobs:            blobs,
+		Pending:          newPendingProposals(),
+		snapshotIndex:    snapshotIndex,
+		snapshotInterval: defaultSnapshotInterval,
 	}, nil
 }
 
-// StartTimer starts a timer for the node and waits for a timeout to start election or an RPC to reset the timer
-func (n *Node) StartTimer(wg *sync.WaitGroup) {
-	go func() {
-		for {
-			timeout := time.Duration(rand.Int31n(15)+15) * time.Second
-			timer := time.NewTimer(timeout)
-			fmt.Printf("%v has set a timer for %v seconds \n", n.Address, timeout.Seconds())
-			select {
-			case <-timer.C:
-				fmt.Printf("%v has timed out, starting election \n", n.Address)
-				timer.Stop()
-				n.StartElectionChan <- true
-			case <-n.ResetTimerChan:
-				fmt.Printf("%v has received an RPC, restarting timer \n", n.Address)
-				if !timer.Stop() {
-					<-timer.C
-				}
-				continue
-			case <-n.StopTimerChan:
-				fmt.Printf("%v has become a leader, stoping global timer \n", n.Address)
-				n.BecomeLeaderChan <- true
-				timer.Stop()
-				return
-			}
-
+// openLogStore opens the store of the log entries and checks them, a corrupted log must not be served
+// to the peers nor applied. The entries a node kept in its sqlite log move to another store the first
+// time it runs on it, they cannot move back
+func openLogStore(ps *PersistentState, layout datadir.Layout, openLog LogOpener) (LogStore, error) {
+	if openLog == nil {
+		if _, err := os.Stat(layout.WAL); err == nil {
+			return nil, fmt.Errorf("the log entries are in %s, run the node on the wal log backend", layout.WAL)
 		}
-	}()
-}
-
-// BegginElection is called when a node times out and starts an election
-func (n *Node) BeginElection() {
-
-	t, err := n.Log.GetCurrentTerm()
+		if err := ps.VerifyLog(); err != nil {
+			return nil, err
+		}
+		return ps, nil
+	}
+	store, err := openLog(layout)
 	if err != nil {
-		fmt.Println("Error getting current term:", err)
-		return
+		return nil, fmt.Errorf("could not open log store: %w", err)
 	}
-	err = n.Log.SetCurrentTerm(t + 1)
+	moved, err := ps.moveEntries(store)
 	if err != nil {
-		fmt.Println("Error setting current term:", err)
-		return
+		store.Close()
+		return nil, err
+	}
+	if moved > 0 {
+		log.Printf("moved %d entries of %s to the log store", moved, layout.Log)
 	}
-	err = n.Log.SetVotedFor(n.Address)
+	return store, nil
+}
+
+// startElection moves the node to the next term and votes for itself, it returns the new term
+func (n *Node) startElection() (int32, error) {
+	term, err := n.State.Campaign(n.ID)
 	if err != nil {
-		fmt.Println("Error setting vote:", err)
-		return
+		return 0, fmt.Errorf("error setting current term and vote: %w", err)
 	}
+	return term, nil
+}
+
+// requestVotes asks the peers for their votes in term and reports the outcome to the run loop
+func (n *Node) requestVotes(term int32) {
 	mu := sync.Mutex{}
 	receivedVotes := 1
 
@@ -122,7 +186,7 @@ func (n *Node) BeginElection() {
 		wg.Add(1)
 		go func(p string) {
 			defer wg.Done()
-			VoteGranted := requestVoteRPCStub(n, p, cancel)
+			VoteGranted := requestVoteRPCStub(n, p, term, cancel)
 			c <- VoteGranted
 		}(peer)
 	}
@@ -137,31 +201,9 @@ func (n *Node) BeginElection() {
 		select {
 		case granted, open := <-c:
 			if !open {
-				if receivedVotes > len(n.Peers)/2 {
-					fmt.Printf("received %v votes , %v is now the leader \n", receivedVotes, n.Address)
-					logCount, e := n.Log.GetLogLength()
-					if e != nil {
-						fmt.Println("Error getting log length:", e.Error())
-						return
-					}
-					n.Mu.Lock()
-					n.Status = "leader"
-					n.LeaderAddress = n.Address
-					for _, peer := range n.Peers {
-						n.NextIndex[peer] = logCount + 1
-						n.MatchIndex[peer] = 0
-					}
-					n.Mu.Unlock()
-					n.StopTimerChan <- true
-					return
-				} else {
-					n.Mu.Lock()
-					n.Status = "follower"
-					n.Mu.Unlock()
-					n.ResetTimerChan <- true
-					fmt.Printf("received votes %v , %v will revert to follower \n", receivedVotes, n.Address)
-					return
-				}
+				fmt.Printf("received %v votes for %v in term %v \n", receivedVotes, n.Address, term)
+				n.send(voteResult{term: term, won: receivedVotes >= n.quorum()})
+				return
 			} else if granted {
 				mu.Lock()
 				receivedVotes++
@@ -169,47 +211,60 @@ func (n *Node) BeginElection() {
 			}
 		case <-ctx.Done():
 			fmt.Println("some node had a higher term!")
-			n.RevertToFollowerChan <- true
+			n.send(stepDown{term: term})
 			return
 		}
 	}
 }
 
-// AppendEntry sends a heartbeat/appendEntry RPCs to all peers and waits for a majority to respond
-func (node *Node) AppendEntry() {
-	ch := make(chan bool, len(node.Peers))
-	responses := int32(1)
+// AppendEntry sends a heartbeat/appendEntry RPCs to all peers in term ct, the term the node leads in, and
+// commits what a majority of the cluster holds
+func (node *Node) AppendEntry(ct int32) {
+	// the term moves on as soon as the node hears of a later one, it may not lead in it
+	current, err := node.State.GetCurrentTerm()
+	if err != nil {
+		log.Printf("could not get current term: %v", err)
+		return
+	}
+	if current != ct {
+		node.send(stepDown{term: ct})
+		return
+	}
 
 	// Get all staged commands from redis
-	requests, err := utils.RetreivePayloads()
+	requests, err := node.proposals.Retrieve()
 	if err != nil {
 		requests = []utils.Payload{}
 		fmt.Println(err)
 	}
 	fmt.Println(requests)
-	// get the term
-	ct, err := node.Log.GetCurrentTerm()
-	if err != nil {
-		log.Printf("could not get current term: %v", err)
-		return
+	// entries take the term and the time of the leader appending them, not the ones of the api that
+	// queued them, so every node applies them alike
+	now := node.clock.Now()
+	requests = append(requests, node.dueSchedulePayloads(ct, now)...)
+	requests = append(requests, node.bootstrapPayloads(ct)...)
+	requests = append(requests, node.rehashPayloads(ct)...)
+	for i, request := range requests {
+		requests[i] = stamp(request, ct, now)
 	}
 
 	// append operations to log
-	if err := node.Log.AppendLogEntry(requests); err != nil {
+	if err := appendPayloads(node.Log, requests); err != nil {
 		log.Printf("could not append log entry: %v", err)
 		return
 	}
-	errPayload := utils.ClearPayloads()
+	errPayload := node.proposals.Clear()
 	if errPayload != nil {
 		fmt.Print(errPayload)
 	}
-	// just for testing purposes
-	ent, e2 := node.Log.GetAllLogEntries()
-	if e2 != nil {
-		log.Printf("could not get all log entries: %v\n", e2)
+	lastIndex, _, err := node.Log.LastIndexAndTerm()
+	if err != nil {
+		log.Printf("could not read last log entry: %v", err)
 		return
 	}
-	log.Printf("log entries after append for %v: %v \n", node.Address, ent)
+
+	ch := make(chan bool, len(node.Peers))
+	responses := int32(1)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -221,33 +276,46 @@ func (node *Node) AppendEntry() {
 		go func(peer string) {
 			defer wg.Done()
 
-			prevIndex := int32(node.NextIndex[peer] - 1)
+			node.Mu.RLock()
+			nextIndex := node.NextIndex[peer]
+			node.Mu.RUnlock()
+			prevIndex := int32(nextIndex - 1)
 			prevTerm := int32(0)
 			//adjust the prevTerm based on the prevIndex
 			if prevIndex > 0 {
-				entry, err := node.Log.GetLogEntry(int(prevIndex))
+				entry, err := EntryAt(node.Log, prevIndex)
 				if err != nil {
 					log.Printf("could not get log entry: %v", err)
+					ch <- false
+					return
 				}
-				prevTerm = entry.Term
+				prevTerm = entry.GetTerm()
 			}
 
 			//fetch the actual commands based on the last commited entry so as to make the node be up to date
-			entries, err := node.Log.GetCommandsFromIndex(int(node.NextIndex[peer]))
+			entries, err := node.Log.Entries(int32(nextIndex), lastIndex)
 			if err != nil {
-				log.Printf("could not get commands from index: %v", err)
+				log.Printf("could not get entries from index %v: %v", nextIndex, err)
+				ch <- false
+				return
+			}
+			res, err := appendEntryRPCStub(node, peer, entries, ct, prevIndex, prevTerm)
+			if err != nil {
+				// the peer is down or late, it gets the same entries next round
+				log.Printf("could not append entries to %v: %v", peer, err)
+				ch <- false
+				return
 			}
-			res, _ := appendEntryRPCStub(node, peer, entries, ct, prevIndex, prevTerm)
 			if res.Success {
 				node.Mu.Lock()
-				node.NextIndex[peer] += int64(len(entries))
-				node.MatchIndex[peer] += int32(len(entries))
+				node.MatchIndex[peer] = prevIndex + int32(len(entries))
+				node.NextIndex[peer] = int64(node.MatchIndex[peer]) + 1
 				node.Mu.Unlock()
 				ch <- true
 			} else if res.Term > ct {
 				cancel() // Trigger early exit due to stale term
 			} else {
-				// TODO : log matching property should end at 0, in that case wipe all follower log
+				// the logs differ at prevIndex, the next round starts one entry earlier
 				node.Mu.Lock()
 				if node.NextIndex[peer] > 1 {
 					node.NextIndex[peer]--
@@ -265,159 +333,166 @@ func (node *Node) AppendEntry() {
 	}()
 
 	// evalution is done here
+	stale := ctx.Done()
+	deposed := false
 	for {
 		select {
 		case granted, open := <-ch:
 			if !open {
 				// All goroutines are done
 				fmt.Printf("\n************** acks received: %v ********************\n", responses)
-				if responses > int32(len(node.Peers)/2) {
-					lastEntry, err := node.Log.GetLastLogEntry()
-					if err != nil {
-						return
-					}
-					node.Mu.Lock()
-					node.CommitIndex = int32(lastEntry.Index)
-					node.Mu.Unlock()
-					node.Commit()
-					node.PrintDetails()
-					return
-				} else {
-					return
+				if !deposed {
+					node.advanceCommit(ct, lastIndex)
 				}
+				return
 			}
 			if granted {
 				responses++
 			}
-		case <-ctx.Done():
+		case <-stale:
 			fmt.Println("some node had a higher term!")
-			node.RevertToFollowerChan <- true
+			node.send(stepDown{term: ct})
+			// keep collecting the responses still on their way
+			stale = nil
+			deposed = true
 		}
 	}
 }
 
+// advanceCommit commits the entries held by a majority of the cluster. Only an entry of term ct is
+// counted, earlier ones are committed with it
+func (node *Node) advanceCommit(ct, lastIndex int32) {
+	node.Mu.RLock()
+	matches := []int32{lastIndex}
+	for _, peer := range node.Peers {
+		matches = append(matches, node.MatchIndex[peer])
+	}
+	commitIndex := node.CommitIndex
+	node.Mu.RUnlock()
+	slices.Sort(matches)
+	// the highest index a quorum of the nodes holds
+	index := matches[len(matches)-node.quorum()]
+	if index <= commitIndex {
+		return
+	}
+	entry, err := EntryAt(node.Log, index)
+	if err != nil {
+		log.Printf("could not get log entry: %v", err)
+		return
+	}
+	if entry.GetTerm() != ct {
+		return
+	}
+	node.Mu.Lock()
+	node.CommitIndex = index
+	node.Mu.Unlock()
+	node.Commit()
+	node.PrintDetails()
+}
+
+// quorum is the number of nodes, this one included, that make a majority of the cluster
+func (n *Node) quorum() int {
+	return (len(n.Peers)+1)/2 + 1
+}
+
+// stamp gives a payload the term and the time of the leader appending it
+func stamp(p utils.Payload, term int32, now time.Time) utils.Payload {
+	switch p := p.(type) {
+	case utils.UserPayload:
+		p.Term = term
+		p.Time = now
+		return p
+	case utils.AdminPayload:
+		p.Term = term
+		p.Time = now
+		return p
+	case utils.WalletOperationPayload:
+		p.Term = term
+		p.Time = now
+		return p
+	}
+	return p
+}
+
 func (n *Node) Commit() {
+	if n.Faults().paused() {
+		return
+	}
 	if n.CommitIndex > n.LastApplied {
 		// now fetch all entries that fall in the range of last applied but less than commit index
-		entries, err := n.Log.GetEntriesForCommit(int(n.LastApplied), int(n.CommitIndex))
+		entries, err := n.Log.Entries(n.LastApplied+1, n.CommitIndex)
 		if err != nil {
-			fmt.Println(err)
+			log.Printf("%s cannot apply committed entries: %v", n.Address, err)
+			return
+		}
+		// entries applied before the node restarted
+		applied, err := n.State.appliedBetween(n.LastApplied, n.CommitIndex)
+		if err != nil {
+			log.Printf("%s cannot apply committed entries: %v", n.Address, err)
+			return
 		}
 		n.Mu.Lock()
 		defer n.Mu.Unlock()
-		for _, entry := range entries {
+		for _, e := range entries {
+			entry, err := ProtoToLogEntry(e)
+			if err != nil {
+				fmt.Printf("%s cannot read entry %d: %v\n", n.Address, e.GetIndex(), err)
+				return
+			}
 			// cast the payload to the correct type
-			if entry.Applied {
+			if applied[int32(entry.Index)] {
 				n.LastApplied++
 				continue
 			} else {
-				switch entry.ReferenceTable {
-				case utils.RefUser:
-
-					var payload UserPayload
-					if err := n.Log.DB.First(&payload, entry.PayloadID).Error; err != nil {
-						return
-					}
-					userPayload := utils.UserPayload{
-						FirstName:                *payload.FirstName,
-						LastName:                 *payload.LastName,
-						HashedPassword:           *payload.HashedPassword,
-						Email:                    *payload.Email,
-						DateOfBirth:              *payload.DateOfBirth,
-						IdentificationNumber:     *payload.IdentificationNumber,
-						IdentificationImageFront: *payload.IdentificationImageFront,
-						IdentificationImageBack:  *payload.IdentificationImageBack,
-						PrevPW:                   *payload.PrevPW,
-						NewPW:                    *payload.NewPW,
-						UserID:                   *payload.UserID,
-						Action:                   payload.Action,
-					}
-					if err2 := n.StateMachine.ApplyUserOperation(userPayload); err2 != nil {
-						n.Log.DB.Model(&entry).Where("`index` = ?", entry.Index).Updates(map[string]interface{}{
-							"applied": true,
-							"status":  utils.TxFailed,
-						})
-						continue
-					}
-
-				case utils.RefAdmin:
-
-					var payload AdminPayload
-					if err := n.Log.DB.First(&payload, entry.PayloadID).Error; err != nil {
-						return
-					}
-					adminPayload := utils.AdminPayload{
-						FirstName:      *payload.FirstName,
-						LastName:       *payload.LastName,
-						HashedPassword: *payload.HashedPassword,
-						Email:          *payload.Email,
-						AdminID:        *payload.AdminID,
-						UserId:         *payload.UserId,
-						Action:         payload.Action,
-					}
-					if err2 := n.StateMachine.ApplyAdminOperations(adminPayload); err2 != nil {
-						n.Log.DB.Model(&entry).Where("`index` = ?", entry.Index).Updates(map[string]interface{}{
-							"applied": true,
-							"status":  utils.TxFailed,
-						})
-						continue
-					}
-
-				case utils.RefWallet:
-
-					var payload WalletOperationPayload
-					if err := n.Log.DB.First(&payload, entry.PayloadID).Error; err != nil {
-						return
-					}
-					walletPayload := utils.WalletOperationPayload{
-						Wallet1: payload.Wallet1,
-						Wallet2: *payload.Wallet2,
-						Amount:  payload.Amount,
-						Action:  payload.Action,
-					}
-					if err2 := n.StateMachine.ApplyWalletOperation(walletPayload); err2 != nil {
-						n.Log.DB.Model(&entry).Where("`index` = ?", entry.Index).Updates(map[string]interface{}{
-							"applied": true,
-							"status":  utils.TxFailed,
-						})
-						continue
-					}
-
-				default:
-					fmt.Println("Unknown reference table")
-					n.Log.DB.Model(&entry).Where("`index` = ?", entry.Index).Updates(map[string]interface{}{
-						"applied": true,
-						"status":  utils.TxFailed,
-					})
+				// the caller may propose again once the entry is applied, whatever its outcome
+				n.Pending.Release(entry.PollID)
+				payload, handler, err := DecodeCommand(entry.Command)
+				if errors.Is(err, ErrUnknownCommand) {
+					fmt.Println("Unknown command")
+					n.recordOutcome(entry, utils.TxFailed, utils.CodeUnsupportedOperation, err.Error())
+					continue
+				}
+				if err != nil {
+					// the same command applies on every node, this one waits until it can read it
+					fmt.Printf("%s cannot read entry %d: %v\n", n.Address, entry.Index, err)
+					return
+				}
+				if err2 := handler.Apply(n, entry.Index, payload); err2 != nil {
+					n.recordOutcome(entry, utils.TxFailed, utils.ErrorCodeOf(err2), err2.Error())
 					continue
 				}
 				//TODO : this should be in some transaction format
-				n.Log.DB.Model(&entry).Where("`index` = ?", entry.Index).Updates(map[string]interface{}{
-					"applied": true,
-					"status":  utils.TxSuccess,
-				})
+				n.recordOutcome(entry, utils.TxSuccess, "", "")
 				n.LastApplied++
 			}
 		}
+		n.maybeSnapshot()
 	} else {
 		fmt.Println("Nothing to commit")
 	}
 }
 
+// recordOutcome records how entry was applied
+func (n *Node) recordOutcome(entry LogEntry, status utils.TransactionStatus, code utils.ErrorCode, message string) {
+	if err := n.State.recordOutcome(entry, status, code, message); err != nil {
+		log.Printf("%s could not record the outcome of entry %d: %v", n.Address, entry.Index, err)
+	}
+}
+
 func (n *Node) PrintDetails() {
-	ct, err := n.Log.GetCurrentTerm()
+	ct, err := n.State.GetCurrentTerm()
 	if err != nil {
 		fmt.Println("Error getting current term:", err)
 		return
 	}
-	vf, err1 := n.Log.GetVotedFor()
+	vf, err1 := n.State.GetVotedFor()
 	if err1 != nil {
 		fmt.Println("Error getting voted for:", err1)
 		return
 	}
 	fmt.Println("======================================")
-	fmt.Printf("Address: %v, currentTerm : %v, Voted For: %v, Commit Index: %v, Last Applied: %v \n",
-		n.Address, ct, vf, n.CommitIndex, n.LastApplied)
+	fmt.Printf("Address: %v, ID: %v, currentTerm : %v, Voted For: %v, Commit Index: %v, Last Applied: %v \n",
+		n.Address, n.ID, ct, vf, n.CommitIndex, n.LastApplied)
 	fmt.Printf(" Leader Adress : %v, Status: %v, Peers: %v \n",
 		n.LeaderAddress, n.Status, n.Peers)
 	fmt.Println("=======================================")
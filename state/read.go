@@ -0,0 +1,92 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	pb "raft/raft"
+)
+
+var (
+	// ErrNotLeader is returned by ReadBarrier on a node that does not lead, or no longer does
+	ErrNotLeader = errors.New("node is not the leader")
+	// ErrNotReady is returned by ReadBarrier while the leader has not applied every committed entry yet,
+	// the read can be retried shortly
+	ErrNotReady = errors.New("leader is not ready to serve reads")
+)
+
+// ReadBarrier returns once the state machine of the node reflects every operation completed before the
+// call, reads of the state machine that follow are linearizable. The node must lead and confirms it
+// still does with a quorum of its peers, a partitioned leader fails instead of serving stale data
+func (n *Node) ReadBarrier() error {
+	n.Mu.RLock()
+	leader := n.Status == Leader
+	commitIndex := n.CommitIndex
+	n.Mu.RUnlock()
+	if !leader {
+		return ErrNotLeader
+	}
+	term, err := n.State.GetCurrentTerm()
+	if err != nil {
+		return fmt.Errorf("could not get current term: %w", err)
+	}
+	// a new leader only knows what was committed before it once an entry of its own term is
+	lastIndex, _, err := n.Log.LastIndexAndTerm()
+	if err != nil {
+		return fmt.Errorf("could not read last log entry: %w", err)
+	}
+	if lastIndex > 0 {
+		if commitIndex == 0 {
+			return ErrNotReady
+		}
+		entry, err := EntryAt(n.Log, commitIndex)
+		if err != nil {
+			return fmt.Errorf("could not get log entry: %w", err)
+		}
+		if entry.GetTerm() != term {
+			return ErrNotReady
+		}
+	}
+	if !n.confirmLeadership(term) {
+		return ErrNotLeader
+	}
+	n.Mu.RLock()
+	applied := n.LastApplied
+	n.Mu.RUnlock()
+	if applied < commitIndex {
+		return ErrNotReady
+	}
+	return nil
+}
+
+// confirmLeadership sends an empty append entries rpc of term to the peers and reports whether a quorum,
+// this node included, still follows it
+func (n *Node) confirmLeadership(term int32) bool {
+	var mu sync.Mutex
+	acks := 1
+	var wg sync.WaitGroup
+	for _, peer := range n.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			// no entries and no commit index, the follower only checks the term
+			res, err := n.transport.AppendEntries(n.PeerAddress(peer), &pb.AppendEntriesRequest{Term: term,
+				LeaderId: n.ID, LeaderAddress: n.Address, ClusterId: n.ClusterID}, n.timing.RPCTimeout)
+			if err != nil {
+				return
+			}
+			if res.Term > term {
+				n.send(stepDown{term: term})
+			}
+			if !res.Success || res.Term != term {
+				return
+			}
+			mu.Lock()
+			acks++
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return acks >= n.quorum()
+}
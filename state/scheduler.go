@@ -0,0 +1,46 @@
+package state
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"raft/utils"
+)
+
+// dueSchedulePayloads returns a transfer for every standing order due at now, read from the state
+// machine of this node. Only the leader calls it, an attempt already proposed is not proposed again
+// until it has been applied
+func (node *Node) dueSchedulePayloads(term int32, now time.Time) []utils.Payload {
+	schedules, err := node.StateMachine.DueSchedules(now)
+	if err != nil {
+		log.Printf("could not get due schedules: %v", err)
+		return nil
+	}
+	node.Mu.Lock()
+	defer node.Mu.Unlock()
+	if node.proposedSchedules == nil {
+		node.proposedSchedules = make(map[int]string)
+	}
+	payloads := make([]utils.Payload, 0, len(schedules))
+	for _, s := range schedules {
+		pollID := fmt.Sprintf("schedule-%d-%d-%d", s.ID, s.Occurrence, s.Attempt)
+		if node.proposedSchedules[s.ID] == pollID {
+			continue
+		}
+		node.proposedSchedules[s.ID] = pollID
+		payloads = append(payloads, utils.WalletOperationPayload{
+			Wallet1:    s.Wallet1,
+			Wallet2:    s.Wallet2,
+			Amount:     s.Amount,
+			Action:     utils.WalletTransfer,
+			ScheduleID: s.ID,
+			Occurrence: s.Occurrence,
+			Attempt:    s.Attempt,
+			PollID:     pollID,
+			Term:       term,
+			Time:       now,
+		})
+	}
+	return payloads
+}
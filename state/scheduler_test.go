@@ -0,0 +1,59 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"raft/state/stateMachine"
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// openNodeStateMachines opens the state machines of two nodes in one process, the second is left as the
+// package default
+func openNodeStateMachines(t *testing.T) (*stateMachine.StateMachine, *stateMachine.StateMachine) {
+	t.Helper()
+	machines := make([]*stateMachine.StateMachine, 2)
+	for i := range machines {
+		sm, err := stateMachine.InitStateMachine(filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sm.Close() })
+		machines[i] = sm
+	}
+	return machines[0], machines[1]
+}
+
+func TestDueSchedulesReadTheLeader(t *testing.T) {
+	own, other := openNodeStateMachines(t)
+	at := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	due := models.Schedule{ID: 4, Wallet1: 1, Wallet2: 2, Amount: 10, Status: utils.ScheduleActive,
+		Occurrence: 3, Attempt: 1, NextRunAt: at.Add(-time.Minute)}
+	later := models.Schedule{ID: 5, Wallet1: 1, Wallet2: 2, Amount: 10, Status: utils.ScheduleActive,
+		Occurrence: 1, NextRunAt: at.Add(time.Minute)}
+	for _, s := range []*models.Schedule{&due, &later} {
+		if err := own.DB.Create(s).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// another node of the process knows a schedule the leader does not
+	if err := other.DB.Create(&models.Schedule{ID: 9, Status: utils.ScheduleActive, NextRunAt: at.Add(-time.Hour)}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	node := &Node{StateMachine: own}
+	payloads := node.dueSchedulePayloads(7, at)
+	if len(payloads) != 1 {
+		t.Fatalf("proposed %d payloads, want the one due schedule: %+v", len(payloads), payloads)
+	}
+	got := payloads[0].(utils.WalletOperationPayload)
+	if got.ScheduleID != 4 || got.PollID != "schedule-4-3-1" || got.Term != 7 || !got.Time.Equal(at) {
+		t.Fatalf("proposed %+v, want schedule 4 occurrence 3 attempt 1 in term 7 at %v", got, at)
+	}
+	// the attempt is not proposed again before it is applied
+	if again := node.dueSchedulePayloads(7, at.Add(time.Second)); len(again) != 0 {
+		t.Fatalf("proposed %d payloads again", len(again))
+	}
+}
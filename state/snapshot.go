@@ -0,0 +1,58 @@
+package state
+
+import (
+	"log"
+)
+
+// applied entries between two snapshots when none is configured
+const defaultSnapshotInterval = 1000
+
+// SetSnapshotInterval sets the number of applied entries after which the node takes a snapshot
+func (n *Node) SetSnapshotInterval(interval int) {
+	n.Mu.Lock()
+	defer n.Mu.Unlock()
+	n.snapshotInterval = int32(interval)
+}
+
+// maybeSnapshot takes a snapshot once enough entries were applied since the last one, the caller holds n.Mu
+func (n *Node) maybeSnapshot() {
+	if n.LastApplied-n.snapshotIndex < n.snapshotInterval {
+		return
+	}
+	if err := n.snapshot(); err != nil {
+		log.Printf("%s failed to take a snapshot: %v", n.Address, err)
+	}
+}
+
+// snapshot records the last applied index as the snapshot point and runs the maintenance that only
+// concerns applied entries: personal data still in clear or sealed with a retired key is sealed again
+// under the active key, in the state machine and in the commands up to the snapshot point, and the
+// commands of users erased before the snapshot point are scrubbed. A log store other than the sqlite log
+// is rewritten in place for it. The log itself is kept whole, followers still catch up from it
+func (n *Node) snapshot() error {
+	index := n.LastApplied
+	users, err := n.StateMachine.ResealPII()
+	if err != nil {
+		return err
+	}
+	payloads, scrubbed := 0, 0
+	if rewriter, ok := n.Log.(LogRewriter); ok {
+		if payloads, scrubbed, err = n.State.RewritePII(rewriter, index); err != nil {
+			return err
+		}
+	} else if n.Log == LogStore(n.State) {
+		if payloads, err = n.State.ResealPII(index); err != nil {
+			return err
+		}
+		if scrubbed, err = n.State.ScrubErasures(index); err != nil {
+			return err
+		}
+	}
+	if err := n.State.SetSnapshotIndex(index); err != nil {
+		return err
+	}
+	n.snapshotIndex = index
+	log.Printf("%s took a snapshot at index %d, resealed %d users and %d commands, scrubbed %d commands",
+		n.Address, index, users, payloads, scrubbed)
+	return nil
+}
@@ -0,0 +1,192 @@
+package stateMachine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// checkWalletUsable rejects wallet actions on wallets that are not active or whose owner is not active
+func checkWalletUsable(tx *gorm.DB, wallet *models.Wallet) error {
+	if wallet.Status != utils.AccountActive {
+		return walletStatusError(wallet)
+	}
+	if wallet.IsTreasury {
+		return nil
+	}
+	var user models.User
+	if err := tx.First(&user, "user_id = ?", wallet.UserID).Error; err != nil {
+		return notFound(err, utils.CodeUserNotFound, "owner of wallet %d not found: %w", wallet.WalletID, err)
+	}
+	if user.Status != utils.AccountActive {
+		return accountStatusError(&user)
+	}
+	return nil
+}
+
+// accountStatusError explains why a user that is not active was rejected
+func accountStatusError(user *models.User) error {
+	switch user.Status {
+	case utils.AccountPendingKYC:
+		return utils.NewTxError(utils.CodeAccountPendingKYC, "account of user %d is pending kyc validation", user.UserID)
+	case utils.AccountFrozen:
+		return utils.NewTxError(utils.CodeAccountFrozen, "account of user %d is frozen", user.UserID)
+	case utils.AccountClosed:
+		return utils.NewTxError(utils.CodeAccountClosed, "account of user %d is closed", user.UserID)
+	default:
+		return fmt.Errorf("account of user %d is %s", user.UserID, user.Status)
+	}
+}
+
+// walletStatusError explains why a wallet that is not active was rejected
+func walletStatusError(wallet *models.Wallet) error {
+	switch wallet.Status {
+	case utils.AccountFrozen:
+		return utils.NewTxError(utils.CodeWalletFrozen, "wallet %d is frozen", wallet.WalletID)
+	case utils.AccountClosed:
+		return utils.NewTxError(utils.CodeWalletClosed, "wallet %d is closed", wallet.WalletID)
+	default:
+		return fmt.Errorf("wallet %d is %s", wallet.WalletID, wallet.Status)
+	}
+}
+
+// notFound tags a failed lookup with code when the record is missing, other failures stay internal errors
+func notFound(err error, code utils.ErrorCode, format string, args ...interface{}) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.NewTxError(code, format, args...)
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// setUserFrozen freezes a user or lifts the freeze at the time the operation was stamped, unfrozen users
+// go back to where KYC left them
+func setUserFrozen(tx *gorm.DB, userID int, frozen bool, at time.Time) error {
+	var user models.User
+	if err := tx.First(&user, "user_id = ?", userID).Error; err != nil {
+		return notFound(err, utils.CodeUserNotFound, "unable to get user: %w", err)
+	}
+	if user.Status == utils.AccountClosed {
+		return accountStatusError(&user)
+	}
+	status := utils.AccountFrozen
+	if !frozen {
+		if user.Status != utils.AccountFrozen {
+			return utils.NewTxError(utils.CodeInvalidRequest, "account of user %d is not frozen", userID)
+		}
+		status = utils.AccountPendingKYC
+		if user.Active {
+			status = utils.AccountActive
+		}
+	}
+	if err := tx.Model(&user).Updates(map[string]interface{}{"status": status, "updated_at": at}).Error; err != nil {
+		return fmt.Errorf("failed to update user status: %w", err)
+	}
+	return nil
+}
+
+// setWalletFrozen freezes a single wallet or lifts the freeze
+func setWalletFrozen(tx *gorm.DB, walletID int, frozen bool) error {
+	var wallet models.Wallet
+	if err := tx.First(&wallet, "wallet_id = ?", walletID).Error; err != nil {
+		return notFound(err, utils.CodeWalletNotFound, "wallet not found: %w", err)
+	}
+	if wallet.IsTreasury {
+		return utils.NewTxError(utils.CodeInvalidRequest, "the treasury wallet cannot be frozen")
+	}
+	if wallet.Status == utils.AccountClosed {
+		return walletStatusError(&wallet)
+	}
+	status := utils.AccountFrozen
+	if !frozen {
+		if wallet.Status != utils.AccountFrozen {
+			return utils.NewTxError(utils.CodeInvalidRequest, "wallet %d is not frozen", walletID)
+		}
+		status = utils.AccountActive
+	}
+	if err := tx.Model(&wallet).Update("status", status).Error; err != nil {
+		return fmt.Errorf("failed to update wallet status: %w", err)
+	}
+	return nil
+}
+
+// closeAccount closes a user and all of its wallets at the time the operation was stamped. Remaining funds
+// are swept into sweepWalletID, without one the account can only be closed once every wallet is empty
+func closeAccount(tx *gorm.DB, userID, sweepWalletID int, at time.Time) error {
+	var user models.User
+	if err := tx.First(&user, "user_id = ?", userID).Error; err != nil {
+		return notFound(err, utils.CodeUserNotFound, "unable to get user: %w", err)
+	}
+	if user.Status == utils.AccountClosed || user.Status == utils.AccountFrozen {
+		return accountStatusError(&user)
+	}
+	var wallets []models.Wallet
+	if err := tx.Where("user_id = ?", userID).Find(&wallets).Error; err != nil {
+		return fmt.Errorf("unable to get wallets: %w", err)
+	}
+	var total int64
+	for _, w := range wallets {
+		if w.Status == utils.AccountFrozen && w.Balance > 0 {
+			return walletStatusError(&w)
+		}
+		total += w.Balance
+	}
+
+	if total > 0 {
+		if sweepWalletID <= 0 {
+			return utils.NewTxError(utils.CodeBalanceRemaining, "the account still holds %d, empty it or provide a wallet to sweep the funds to", total)
+		}
+		var target models.Wallet
+		if err := tx.First(&target, "wallet_id = ?", sweepWalletID).Error; err != nil {
+			return notFound(err, utils.CodeWalletNotFound, "sweep wallet not found: %w", err)
+		}
+		if target.UserID == userID && !target.IsTreasury {
+			return utils.NewTxError(utils.CodeInvalidRequest, "cannot sweep funds into a wallet of the closed account")
+		}
+		if err := checkWalletUsable(tx, &target); err != nil {
+			return err
+		}
+		for _, w := range wallets {
+			if w.Balance == 0 {
+				continue
+			}
+			target.Balance += w.Balance
+			operation := models.WalletOperation{
+				Wallet1:   w.WalletID,
+				Wallet2:   &target.WalletID,
+				Amount:    w.Balance,
+				Type:      utils.WalletTransfer,
+				Timestamp: at,
+				Status:    utils.TxSuccess,
+			}
+			if err := tx.Create(&operation).Error; err != nil {
+				return fmt.Errorf("failed to record sweep: %w", err)
+			}
+		}
+		if err := tx.Save(&target).Error; err != nil {
+			return fmt.Errorf("failed to update sweep wallet: %w", err)
+		}
+	}
+
+	walletIDs := make([]int, 0, len(wallets))
+	for _, w := range wallets {
+		walletIDs = append(walletIDs, w.WalletID)
+	}
+	if err := cancelSchedules(tx, walletIDs, at); err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Wallet{}).Where("user_id = ?", userID).
+		Updates(map[string]interface{}{"balance": 0, "status": utils.AccountClosed}).Error; err != nil {
+		return fmt.Errorf("failed to close wallets: %w", err)
+	}
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"status": utils.AccountClosed, "active": false, "updated_at": at,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to close account: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package stateMachine
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// adminPermissions lists the actions each role may perform, super admins may perform all of them
+var adminPermissions = map[utils.AdminRole][]utils.AdminAction{
+	utils.RoleKYCOfficer: {utils.AdminValidateUser, utils.AdminSetUserTier, utils.AdminFreezeUser, utils.AdminUnfreezeUser},
+	utils.RoleFinance: {utils.AdminSetFeeSchedule, utils.AdminSetSpendingLimit, utils.AdminFreezeWallet,
+		utils.AdminUnfreezeWallet},
+	utils.RoleReadOnly: {},
+}
+
+// ValidAdminRole reports whether role is one of the known admin roles
+func ValidAdminRole(role utils.AdminRole) bool {
+	if role == utils.RoleSuperAdmin {
+		return true
+	}
+	_, ok := adminPermissions[role]
+	return ok
+}
+
+// AdminAllowed reports whether an admin with the given role may perform action
+func AdminAllowed(role utils.AdminRole, action utils.AdminAction) bool {
+	if role == utils.RoleSuperAdmin {
+		return true
+	}
+	for _, a := range adminPermissions[role] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeAdmin checks, at apply time, that the admin behind a proposal may perform action
+func authorizeAdmin(tx *gorm.DB, adminID int, action utils.AdminAction) (*models.Admin, error) {
+	var admin models.Admin
+	if err := tx.First(&admin, "admin_id = ?", adminID).Error; err != nil {
+		return nil, notFound(err, utils.CodeAdminNotFound, "unable to get admin %d: %w", adminID, err)
+	}
+	if !admin.Active {
+		return nil, utils.NewTxError(utils.CodeForbidden, "admin %d is not active", adminID)
+	}
+	if !AdminAllowed(admin.Role, action) {
+		return nil, utils.NewTxError(utils.CodeForbidden, "role %s of admin %d does not allow %s", admin.Role, adminID, action)
+	}
+	return &admin, nil
+}
+
+// createAdmin adds an admin on behalf of a super admin. A proposal without a creator bootstraps the
+// first super admin, it is only accepted while there is no admin at all
+func createAdmin(tx *gorm.DB, adminPayload utils.AdminPayload) error {
+	role := adminPayload.Role
+	if adminPayload.AdminID <= 0 {
+		var count int64
+		if err := tx.Model(&models.Admin{}).Count(&count).Error; err != nil {
+			return fmt.Errorf("unable to count admins: %w", err)
+		}
+		if count > 0 {
+			return utils.NewTxError(utils.CodeForbidden, "admins already exist, the bootstrap admin is ignored")
+		}
+		role = utils.RoleSuperAdmin
+	} else {
+		if _, err := authorizeAdmin(tx, adminPayload.AdminID, utils.AdminCreateAccount); err != nil {
+			return err
+		}
+		if role == "" {
+			role = utils.RoleReadOnly
+		}
+	}
+	if !ValidAdminRole(role) {
+		return utils.NewTxError(utils.CodeInvalidRequest, "unknown admin role: %s", role)
+	}
+	admin := models.Admin{
+		FirstName:      adminPayload.FirstName,
+		LastName:       adminPayload.LastName,
+		HashedPassword: adminPayload.HashedPassword,
+		Email:          adminPayload.Email,
+		Role:           role,
+	}
+	if err := tx.Create(&admin).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return utils.NewTxError(utils.CodeAlreadyExists, "failed to create admin: %w", err)
+		}
+		return fmt.Errorf("failed to create admin: %w", err)
+	}
+	return nil
+}
+
+// setAdminRole changes the role of another admin, the last active super admin cannot be demoted
+func setAdminRole(tx *gorm.DB, adminPayload utils.AdminPayload) error {
+	if !ValidAdminRole(adminPayload.Role) {
+		return utils.NewTxError(utils.CodeInvalidRequest, "unknown admin role: %s", adminPayload.Role)
+	}
+	var target models.Admin
+	if err := tx.First(&target, "admin_id = ?", adminPayload.TargetAdminID).Error; err != nil {
+		return notFound(err, utils.CodeAdminNotFound, "unable to get admin %d: %w", adminPayload.TargetAdminID, err)
+	}
+	if target.Role == utils.RoleSuperAdmin && adminPayload.Role != utils.RoleSuperAdmin {
+		var others int64
+		if err := tx.Model(&models.Admin{}).
+			Where("role = ? AND active = ? AND admin_id <> ?", utils.RoleSuperAdmin, true, target.AdminID).
+			Count(&others).Error; err != nil {
+			return fmt.Errorf("unable to count super admins: %w", err)
+		}
+		if others == 0 {
+			return utils.NewTxError(utils.CodeInvalidRequest, "admin %d is the last super admin", target.AdminID)
+		}
+	}
+	if err := tx.Model(&target).Update("role", adminPayload.Role).Error; err != nil {
+		return fmt.Errorf("failed to update admin role: %w", err)
+	}
+	return nil
+}
+
+// recordAdminAudit keeps a trace of an applied admin operation and of its outcome
+func (sm *StateMachine) recordAdminAudit(adminPayload utils.AdminPayload, err error) {
+	audit := models.AdminAudit{
+		AdminID:        adminPayload.AdminID,
+		Action:         adminPayload.Action,
+		TargetUserID:   adminPayload.UserId,
+		TargetWalletID: adminPayload.WalletID,
+		TargetAdminID:  adminPayload.TargetAdminID,
+		Status:         utils.TxSuccess,
+		Timestamp:      adminPayload.Time,
+	}
+	if err != nil {
+		audit.Status = utils.TxFailed
+		audit.ErrorCode = utils.ErrorCodeOf(err)
+		audit.ErrorMessage = err.Error()
+	}
+	if errAudit := sm.DB.Create(&audit).Error; errAudit != nil {
+		fmt.Println("failed to record admin audit:", errAudit)
+	}
+}
+
+// GetAdminAudit returns the most recent admin operations, of a single admin when adminID is positive
+func GetAdminAudit(adminID, limit int) ([]*models.AdminAudit, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	query := defaultSM.DB.Order("id DESC").Limit(limit)
+	if adminID > 0 {
+		query = query.Where("admin_id = ?", adminID)
+	}
+	var audit []*models.AdminAudit
+	err := query.Find(&audit).Error
+	return audit, err
+}
+
+// CountAdmins returns the number of admins, the bootstrap admin is only proposed while there is none
+func (sm *StateMachine) CountAdmins() (int64, error) {
+	var count int64
+	err := sm.DB.Model(&models.Admin{}).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,74 @@
+package stateMachine
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"raft/pii"
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// ErasedName replaces the names of an erased user
+const ErasedName = "erased"
+
+// Pseudonym identifies an erased user in the records kept for audit, every node derives the same one
+func Pseudonym(userID int) string {
+	return pii.BlindIndex(fmt.Sprintf("user:%d", userID))[:16]
+}
+
+// ErasedEmail replaces the email of an erased user, it stays unique
+func ErasedEmail(userID int) string {
+	return fmt.Sprintf("erased-%s@erased.invalid", Pseudonym(userID))
+}
+
+// eraseAccount closes the account if it is still open and tombstones the user at the time the operation
+// was stamped: personal data is cleared, the row, its wallets and their operations stay under a pseudonym
+func eraseAccount(tx *gorm.DB, userID, sweepWalletID int, at time.Time) error {
+	var user models.User
+	if err := tx.First(&user, "user_id = ?", userID).Error; err != nil {
+		return notFound(err, utils.CodeUserNotFound, "unable to get user: %w", err)
+	}
+	if user.ErasedAt != nil {
+		return utils.NewTxError(utils.CodeAccountErased, "user %d was erased on %s", userID, user.ErasedAt.Format(time.RFC3339))
+	}
+	if user.Status != utils.AccountClosed {
+		if err := closeAccount(tx, userID, sweepWalletID, at); err != nil {
+			return err
+		}
+		if err := tx.First(&user, "user_id = ?", userID).Error; err != nil {
+			return fmt.Errorf("unable to reload user: %w", err)
+		}
+	}
+	user.FirstName = ErasedName
+	user.LastName = ErasedName
+	user.Email = ErasedEmail(userID)
+	user.HashedPassword = ""
+	user.DateOfBirth = time.Time{}
+	user.IdentificationNumber = ""
+	user.IdentificationHash = nil
+	user.IdentificationImageFront = ""
+	user.IdentificationImageBack = ""
+	user.Pseudonym = Pseudonym(userID)
+	user.ErasedAt = &at
+	user.UpdatedAt = at
+	if err := tx.Save(&user).Error; err != nil {
+		return fmt.Errorf("failed to erase user: %w", err)
+	}
+	return nil
+}
+
+// ErasableUser returns a user about to be erased, the node keeps what it needs to scrub its own copies
+// of the personal data
+func (sm *StateMachine) ErasableUser(userID int) (*models.User, error) {
+	var user models.User
+	if err := sm.DB.First(&user, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if user.ErasedAt != nil {
+		return nil, fmt.Errorf("user %d is already erased", userID)
+	}
+	return &user, nil
+}
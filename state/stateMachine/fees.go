@@ -0,0 +1,179 @@
+package stateMachine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// ComputeFee returns the fee owed on amount under the given schedule, a nil schedule means no fee
+func ComputeFee(schedule *models.FeeSchedule, amount int64) (int64, error) {
+	if schedule == nil {
+		return 0, nil
+	}
+	switch schedule.Kind {
+	case utils.FeeFlat:
+		return schedule.Flat, nil
+	case utils.FeePercentage:
+		return amount * schedule.BasisPoints / 10000, nil
+	case utils.FeeTiered:
+		var tiers []utils.FeeTier
+		if err := json.Unmarshal([]byte(schedule.Tiers), &tiers); err != nil {
+			return 0, fmt.Errorf("invalid fee tiers: %w", err)
+		}
+		tier, ok := pickTier(tiers, amount)
+		if !ok {
+			return 0, nil
+		}
+		return tier.Flat + amount*tier.BasisPoints/10000, nil
+	default:
+		return 0, fmt.Errorf("unsupported fee kind: %s", schedule.Kind)
+	}
+}
+
+// pickTier returns the tier with the smallest upper bound that still covers amount
+func pickTier(tiers []utils.FeeTier, amount int64) (utils.FeeTier, bool) {
+	bound := func(t utils.FeeTier) int64 {
+		if t.UpTo == 0 {
+			return math.MaxInt64
+		}
+		return t.UpTo
+	}
+	sorted := make([]utils.FeeTier, len(tiers))
+	copy(sorted, tiers)
+	sort.SliceStable(sorted, func(i, j int) bool { return bound(sorted[i]) < bound(sorted[j]) })
+	for _, t := range sorted {
+		if amount <= bound(t) {
+			return t, true
+		}
+	}
+	return utils.FeeTier{}, false
+}
+
+// ValidateFeeSchedule checks a fee schedule proposed by an admin before it is applied
+func ValidateFeeSchedule(action utils.WalletAction, kind utils.FeeKind, flat, basisPoints int64, tiers []utils.FeeTier) error {
+	if action != utils.WalletTransfer && action != utils.WalletWithdraw {
+		return utils.NewTxError(utils.CodeInvalidRequest, "fees can only be charged on transfers and withdrawals")
+	}
+	if flat < 0 || basisPoints < 0 || basisPoints > 10000 {
+		return utils.NewTxError(utils.CodeInvalidRequest, "fee amounts must be positive and percentages at most 100%%")
+	}
+	switch kind {
+	case utils.FeeFlat, utils.FeePercentage:
+		return nil
+	case utils.FeeTiered:
+		if len(tiers) == 0 {
+			return utils.NewTxError(utils.CodeInvalidRequest, "a tiered fee schedule needs at least one tier")
+		}
+		for _, t := range tiers {
+			if t.UpTo < 0 || t.Flat < 0 || t.BasisPoints < 0 || t.BasisPoints > 10000 {
+				return utils.NewTxError(utils.CodeInvalidRequest, "invalid fee tier: %+v", t)
+			}
+		}
+		return nil
+	default:
+		return utils.NewTxError(utils.CodeInvalidRequest, "unsupported fee kind: %s", kind)
+	}
+}
+
+// feeScheduleFor returns the schedule for an action inside a transaction, nil if none is set
+func feeScheduleFor(tx *gorm.DB, action utils.WalletAction) (*models.FeeSchedule, error) {
+	var schedule models.FeeSchedule
+	err := tx.First(&schedule, "action = ?", action).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get fee schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// treasuryWallet returns the fee collection wallet, creating it the first time a fee is charged
+func treasuryWallet(tx *gorm.DB) (*models.Wallet, error) {
+	var wallet models.Wallet
+	err := tx.First(&wallet, "is_treasury = ?", true).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		wallet = models.Wallet{IsTreasury: true}
+		if err := tx.Create(&wallet).Error; err != nil {
+			return nil, fmt.Errorf("failed to create treasury wallet: %w", err)
+		}
+		return &wallet, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get treasury wallet: %w", err)
+	}
+	return &wallet, nil
+}
+
+// setFeeSchedule replaces the fee schedule of an action
+func setFeeSchedule(tx *gorm.DB, adminPayload utils.AdminPayload) error {
+	if err := ValidateFeeSchedule(adminPayload.FeeAction, adminPayload.FeeKind, adminPayload.FeeFlat,
+		adminPayload.FeeBasisPoints, adminPayload.FeeTiers); err != nil {
+		return err
+	}
+	tiers := ""
+	if adminPayload.FeeKind == utils.FeeTiered {
+		data, err := json.Marshal(adminPayload.FeeTiers)
+		if err != nil {
+			return fmt.Errorf("failed to encode fee tiers: %w", err)
+		}
+		tiers = string(data)
+	}
+	schedule, err := feeScheduleFor(tx, adminPayload.FeeAction)
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		schedule = &models.FeeSchedule{Action: adminPayload.FeeAction}
+	}
+	schedule.Kind = adminPayload.FeeKind
+	schedule.Flat = adminPayload.FeeFlat
+	schedule.BasisPoints = adminPayload.FeeBasisPoints
+	schedule.Tiers = tiers
+	schedule.UpdatedBy = adminPayload.AdminID
+	schedule.UpdatedAt = adminPayload.Time
+	if err := tx.Save(schedule).Error; err != nil {
+		return fmt.Errorf("failed to save fee schedule: %w", err)
+	}
+	return nil
+}
+
+// GetFeeSchedules returns every configured fee schedule
+func GetFeeSchedules() ([]*models.FeeSchedule, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	var schedules []*models.FeeSchedule
+	err := defaultSM.DB.Find(&schedules).Error
+	return schedules, err
+}
+
+// GetTreasuryWallet returns the fee collection wallet
+func GetTreasuryWallet() (*models.Wallet, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	var wallet models.Wallet
+	if err := defaultSM.DB.First(&wallet, "is_treasury = ?", true).Error; err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+func SumFeesBetween(start, end time.Time) (int64, error) {
+	var total int64
+	err := defaultSM.DB.Model(&models.WalletOperation{}).
+		Select("COALESCE(SUM(fee), 0)").
+		Where("timestamp >= ? AND timestamp < ?", start, end).
+		Scan(&total).Error
+	return total, err
+}
@@ -0,0 +1,191 @@
+package stateMachine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// ValidTier reports whether tier is one of the known user tiers
+func ValidTier(tier utils.UserTier) bool {
+	switch tier {
+	case utils.TierUnverified, utils.TierStandard, utils.TierPremium:
+		return true
+	}
+	return false
+}
+
+// ValidateSpendingLimit checks a spending limit proposed by an admin before it is applied
+func ValidateSpendingLimit(scope utils.LimitScope, tier utils.UserTier, userID, walletID int, perTx, daily, monthly int64) error {
+	if perTx < 0 || daily < 0 || monthly < 0 {
+		return utils.NewTxError(utils.CodeInvalidRequest, "spending limits cannot be negative")
+	}
+	switch scope {
+	case utils.LimitScopeTier:
+		if !ValidTier(tier) {
+			return utils.NewTxError(utils.CodeInvalidRequest, "unknown user tier: %s", tier)
+		}
+	case utils.LimitScopeUser:
+		if userID <= 0 {
+			return utils.NewTxError(utils.CodeInvalidRequest, "a user limit needs a user id")
+		}
+	case utils.LimitScopeWallet:
+		if walletID <= 0 {
+			return utils.NewTxError(utils.CodeInvalidRequest, "a wallet limit needs a wallet id")
+		}
+	default:
+		return utils.NewTxError(utils.CodeInvalidRequest, "unsupported limit scope: %s", scope)
+	}
+	return nil
+}
+
+// setSpendingLimit creates or replaces the limit of the tier, user or wallet targeted by the payload
+func setSpendingLimit(tx *gorm.DB, adminPayload utils.AdminPayload) error {
+	if err := ValidateSpendingLimit(adminPayload.LimitScope, adminPayload.Tier, adminPayload.UserId, adminPayload.WalletID,
+		adminPayload.LimitPerTransaction, adminPayload.LimitDaily, adminPayload.LimitMonthly); err != nil {
+		return err
+	}
+	// only keep the column identifying the target so the unique index matches
+	target := models.SpendingLimit{Scope: adminPayload.LimitScope}
+	switch adminPayload.LimitScope {
+	case utils.LimitScopeTier:
+		target.Tier = adminPayload.Tier
+	case utils.LimitScopeUser:
+		if err := tx.First(&models.User{}, "user_id = ?", adminPayload.UserId).Error; err != nil {
+			return notFound(err, utils.CodeUserNotFound, "unable to get user: %w", err)
+		}
+		target.UserID = adminPayload.UserId
+	case utils.LimitScopeWallet:
+		if err := tx.First(&models.Wallet{}, "wallet_id = ?", adminPayload.WalletID).Error; err != nil {
+			return notFound(err, utils.CodeWalletNotFound, "wallet not found: %w", err)
+		}
+		target.WalletID = adminPayload.WalletID
+	}
+	var limit models.SpendingLimit
+	err := tx.Where("scope = ? AND tier = ? AND user_id = ? AND wallet_id = ?",
+		target.Scope, target.Tier, target.UserID, target.WalletID).First(&limit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		limit = target
+	} else if err != nil {
+		return fmt.Errorf("unable to get spending limit: %w", err)
+	}
+	limit.PerTransaction = adminPayload.LimitPerTransaction
+	limit.Daily = adminPayload.LimitDaily
+	limit.Monthly = adminPayload.LimitMonthly
+	limit.UpdatedBy = adminPayload.AdminID
+	limit.UpdatedAt = adminPayload.Time
+	if err := tx.Save(&limit).Error; err != nil {
+		return fmt.Errorf("failed to save spending limit: %w", err)
+	}
+	return nil
+}
+
+// setUserTier moves a user to another tier
+func setUserTier(tx *gorm.DB, adminPayload utils.AdminPayload) error {
+	if !ValidTier(adminPayload.Tier) {
+		return utils.NewTxError(utils.CodeInvalidRequest, "unknown user tier: %s", adminPayload.Tier)
+	}
+	res := tx.Model(&models.User{}).Where("user_id = ?", adminPayload.UserId).Update("tier", adminPayload.Tier)
+	if res.Error != nil {
+		return fmt.Errorf("failed to update user tier: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return utils.NewTxError(utils.CodeUserNotFound, "unable to get user %d", adminPayload.UserId)
+	}
+	return nil
+}
+
+// checkSpendingLimits rejects an outgoing amount, fees included, that would break a limit of the wallet, its
+// owner or the owner's tier
+func checkSpendingLimits(tx *gorm.DB, wallet *models.Wallet, amount int64, now time.Time) error {
+	if wallet.IsTreasury {
+		return nil
+	}
+	tier := utils.TierUnverified
+	var user models.User
+	if err := tx.First(&user, "user_id = ?", wallet.UserID).Error; err == nil {
+		tier = user.Tier
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("unable to get wallet owner: %w", err)
+	}
+
+	var limits []models.SpendingLimit
+	if err := tx.Where("(scope = ? AND tier = ?) OR (scope = ? AND user_id = ?) OR (scope = ? AND wallet_id = ?)",
+		utils.LimitScopeTier, tier, utils.LimitScopeUser, wallet.UserID, utils.LimitScopeWallet, wallet.WalletID).
+		Find(&limits).Error; err != nil {
+		return fmt.Errorf("unable to get spending limits: %w", err)
+	}
+
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	for _, limit := range limits {
+		target := describeLimit(limit)
+		if limit.PerTransaction > 0 && amount > limit.PerTransaction {
+			return utils.NewTxError(utils.CodeLimitExceeded, "per transaction limit exceeded for %s: %d requested, limit %d", target, amount, limit.PerTransaction)
+		}
+		// wallet limits only count that wallet, user and tier limits count every wallet of the owner
+		wallets := tx.Model(&models.Wallet{}).Select("wallet_id").Where("user_id = ?", wallet.UserID)
+		if limit.Scope == utils.LimitScopeWallet {
+			wallets = tx.Model(&models.Wallet{}).Select("wallet_id").Where("wallet_id = ?", wallet.WalletID)
+		}
+		if limit.Daily > 0 {
+			spent, err := sumOutgoingBetween(tx, wallets, dayStart, dayStart.AddDate(0, 0, 1))
+			if err != nil {
+				return err
+			}
+			if spent+amount > limit.Daily {
+				return utils.NewTxError(utils.CodeLimitExceeded, "daily limit exceeded for %s: %d already spent, %d requested, limit %d", target, spent, amount, limit.Daily)
+			}
+		}
+		if limit.Monthly > 0 {
+			spent, err := sumOutgoingBetween(tx, wallets, monthStart, monthStart.AddDate(0, 1, 0))
+			if err != nil {
+				return err
+			}
+			if spent+amount > limit.Monthly {
+				return utils.NewTxError(utils.CodeLimitExceeded, "monthly limit exceeded for %s: %d already spent, %d requested, limit %d", target, spent, amount, limit.Monthly)
+			}
+		}
+	}
+	return nil
+}
+
+// sumOutgoingBetween adds up the successful withdrawals and transfers sent from the given wallets and their fees
+func sumOutgoingBetween(tx *gorm.DB, wallets *gorm.DB, start, end time.Time) (int64, error) {
+	var total int64
+	err := tx.Model(&models.WalletOperation{}).
+		Select("COALESCE(SUM(amount + fee), 0)").
+		Where("wallet1 IN (?) AND type IN ? AND status = ? AND timestamp >= ? AND timestamp < ?",
+			wallets, []utils.WalletAction{utils.WalletWithdraw, utils.WalletTransfer}, utils.TxSuccess, start, end).
+		Scan(&total).Error
+	if err != nil {
+		return 0, fmt.Errorf("unable to sum outgoing operations: %w", err)
+	}
+	return total, nil
+}
+
+func describeLimit(limit models.SpendingLimit) string {
+	switch limit.Scope {
+	case utils.LimitScopeTier:
+		return fmt.Sprintf("tier %s", limit.Tier)
+	case utils.LimitScopeUser:
+		return fmt.Sprintf("user %d", limit.UserID)
+	default:
+		return fmt.Sprintf("wallet %d", limit.WalletID)
+	}
+}
+
+// GetSpendingLimits returns every configured spending limit
+func GetSpendingLimits() ([]*models.SpendingLimit, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	var limits []*models.SpendingLimit
+	err := defaultSM.DB.Find(&limits).Error
+	return limits, err
+}
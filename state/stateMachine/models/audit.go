@@ -0,0 +1,20 @@
+package models
+
+import (
+	"raft/utils"
+	"time"
+)
+
+// AdminAudit records every admin operation applied by the state machine, rejected ones included
+type AdminAudit struct {
+	ID             int `gorm:"primaryKey"`
+	AdminID        int `gorm:"index"`
+	Action         utils.AdminAction
+	TargetUserID   int `gorm:"default:0"`
+	TargetWalletID int `gorm:"default:0"`
+	TargetAdminID  int `gorm:"default:0"`
+	Status         utils.TransactionStatus
+	ErrorCode      utils.ErrorCode `gorm:"default:''"`
+	ErrorMessage   string          `gorm:"default:''"`
+	Timestamp      time.Time       `gorm:"index"`
+}
@@ -6,48 +6,60 @@ import (
 )
 
 type Wallet struct {
-	WalletID  int `gorm:"primaryKey"`
-	UserID    int
-	UserRef   User      `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
-	Balance   int64     `gorm:"default:0"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
+	WalletID   int `gorm:"primaryKey"`
+	UserID     int
+	UserRef    User                `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	Balance    int64               `gorm:"default:0"`
+	IsTreasury bool                `gorm:"default:false"` // collects the fees, not owned by any user
+	Status     utils.AccountStatus `gorm:"default:'active'"`
+	CreatedAt  time.Time           `gorm:"autoCreateTime"`
 }
 
 type Admin struct {
 	AdminID        int `gorm:"primaryKey"`
 	FirstName      string
 	LastName       string
-	HashedPassword string
-	Email          string `gorm:"unique"`
-	Active         bool   `gorm:"default:true"`
+	HashedPassword string          `json:"-"`
+	Email          string          `gorm:"unique"`
+	Active         bool            `gorm:"default:true"`
+	Role           utils.AdminRole `gorm:"default:'read_only'"`
 }
 
 type WalletOperation struct {
-	ID         int `gorm:"primaryKey"`
-	Type       utils.WalletAction
-	Amount     int64
-	Timestamp  time.Time
-	Status     utils.TransactionStatus
-	Wallet1    int
-	Wallet1Ref Wallet `gorm:"foreignKey:Wallet1;references:WalletID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
-	Wallet2    *int
-	Wallet2Ref Wallet `gorm:"foreignKey:Wallet2;references:WalletID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	ID           int `gorm:"primaryKey"`
+	Type         utils.WalletAction
+	Amount       int64
+	Fee          int64 `gorm:"default:0"`
+	Timestamp    time.Time
+	Status       utils.TransactionStatus
+	ErrorCode    utils.ErrorCode `gorm:"default:''"`
+	ErrorMessage string          `gorm:"default:''"`
+	Wallet1      int
+	Wallet1Ref   Wallet `gorm:"foreignKey:Wallet1;references:WalletID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	Wallet2      *int
+	Wallet2Ref   Wallet `gorm:"foreignKey:Wallet2;references:WalletID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
 }
 
 type User struct {
 	UserID                   int `gorm:"primaryKey"`
 	FirstName                string
 	LastName                 string
-	HashedPassword           string
-	Email                    string  `gorm:"unique"`
-	Rating                   float32 `gorm:"default:0.0"`
-	DateOfBirth              time.Time
-	IdentificationNumber     string `gorm:"unique"`
-	IdentificationImageFront string
-	IdentificationImageBack  string
+	HashedPassword           string    `json:"-"`
+	Email                    string    `gorm:"unique"`
+	Rating                   float32   `gorm:"default:0.0"`
+	DateOfBirth              time.Time `gorm:"serializer:pii;type:text"`
+	IdentificationNumber     string    `gorm:"serializer:pii;type:text"`
+	IdentificationHash       *string   `gorm:"uniqueIndex" json:"-"` // blind index of the identification number
+	IdentificationImageFront string    `gorm:"serializer:pii;type:text"`
+	IdentificationImageBack  string    `gorm:"serializer:pii;type:text"`
 	ValidatedBy              *int
 	ValidatorRef             Admin     `gorm:"foreignKey:ValidatedBy;references:AdminID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
 	CreatedAt                time.Time `gorm:"autoCreateTime"`
 	UpdatedAt                time.Time
-	Active                   bool `gorm:"default:false"`
+	Active                   bool                `gorm:"default:false"`
+	Tier                     utils.UserTier      `gorm:"default:'unverified'"`
+	Status                   utils.AccountStatus `gorm:"default:'pending_kyc'"`
+	// set once the personal data is erased, the row is kept under a pseudonym for the financial records
+	ErasedAt  *time.Time
+	Pseudonym string `gorm:"default:''"`
 }
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"raft/utils"
+	"time"
+)
+
+// FeeSchedule describes how much is charged on a given wallet action, there is at most one per action
+type FeeSchedule struct {
+	ID          int                `gorm:"primaryKey"`
+	Action      utils.WalletAction `gorm:"unique"`
+	Kind        utils.FeeKind
+	Flat        int64
+	BasisPoints int64  // percentage expressed in hundredths of a percent
+	Tiers       string // json encoded []utils.FeeTier, only used by tiered schedules
+	UpdatedBy   int
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"raft/utils"
+	"time"
+)
+
+// SpendingLimit caps the outgoing amounts of a tier, a user or a single wallet, a zero cap means unlimited
+type SpendingLimit struct {
+	ID             int              `gorm:"primaryKey"`
+	Scope          utils.LimitScope `gorm:"uniqueIndex:idx_limit_target"`
+	Tier           utils.UserTier   `gorm:"uniqueIndex:idx_limit_target"`
+	UserID         int              `gorm:"uniqueIndex:idx_limit_target"`
+	WalletID       int              `gorm:"uniqueIndex:idx_limit_target"`
+	PerTransaction int64
+	Daily          int64
+	Monthly        int64
+	UpdatedBy      int
+	UpdatedAt      time.Time
+}
@@ -0,0 +1,7 @@
+package models
+
+// BlindIndexKey records the key the blind indexes of the state machine were computed with, there is one row
+type BlindIndexKey struct {
+	ID    int `gorm:"primaryKey"`
+	KeyID string
+}
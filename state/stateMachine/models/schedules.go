@@ -0,0 +1,41 @@
+package models
+
+import (
+	"raft/utils"
+	"time"
+)
+
+// Schedule is a standing order transferring Amount from Wallet1 to Wallet2 every Interval
+type Schedule struct {
+	ID                int `gorm:"primaryKey"`
+	Wallet1           int
+	Wallet2           int
+	Amount            int64
+	Interval          utils.ScheduleInterval
+	StartAt           time.Time
+	Status            utils.ScheduleStatus
+	MaxRetries        int   // extra attempts after a failed execution before the occurrence is skipped
+	RetryDelaySeconds int64 // wait between two attempts of the same occurrence
+	Occurrence        int   // next occurrence to execute, starting at 1
+	Attempt           int   // attempts already made for the current occurrence
+	DueAt             time.Time
+	NextRunAt         time.Time `gorm:"index"` // when the leader proposes the next attempt
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time
+}
+
+// ScheduleExecution records every attempt made for an occurrence of a schedule
+type ScheduleExecution struct {
+	ID                int      `gorm:"primaryKey"`
+	ScheduleID        int      `gorm:"uniqueIndex:idx_schedule_attempt"`
+	ScheduleRef       Schedule `gorm:"foreignKey:ScheduleID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Occurrence        int      `gorm:"uniqueIndex:idx_schedule_attempt"`
+	Attempt           int      `gorm:"uniqueIndex:idx_schedule_attempt"`
+	DueAt             time.Time
+	ExecutedAt        time.Time
+	Status            utils.TransactionStatus
+	ErrorCode         utils.ErrorCode `gorm:"default:''"`
+	ErrorMessage      string          `gorm:"default:''"`
+	RetryScheduled    bool            `gorm:"default:false"` // false on a failure means the occurrence was given up
+	WalletOperationID *int
+}
@@ -0,0 +1,71 @@
+package stateMachine
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// accounts created before passwords were hashed hold them in clear, bcrypt hashes start with this prefix
+const hashedPasswordPrefix = "$2"
+
+// ClearPasswords returns the users and admins whose password is still stored in clear, at most limit of each
+func (sm *StateMachine) ClearPasswords(limit int) ([]*models.User, []*models.Admin, error) {
+	const inClear = "hashed_password <> '' AND hashed_password NOT LIKE ?"
+	var users []*models.User
+	if err := sm.DB.Where(inClear, hashedPasswordPrefix+"%").Order("user_id").Limit(limit).Find(&users).Error; err != nil {
+		return nil, nil, fmt.Errorf("unable to get users: %w", err)
+	}
+	var admins []*models.Admin
+	if err := sm.DB.Where(inClear, hashedPasswordPrefix+"%").Order("admin_id").Limit(limit).Find(&admins).Error; err != nil {
+		return nil, nil, fmt.Errorf("unable to get admins: %w", err)
+	}
+	return users, admins, nil
+}
+
+// rehashPassword checks that hash is a bcrypt hash of the password stored in clear. Anyone may propose it,
+// it cannot change which password is accepted
+func rehashPassword(stored, hash string) error {
+	if stored == "" || strings.HasPrefix(stored, hashedPasswordPrefix) {
+		return utils.NewTxError(utils.CodeInvalidRequest, "the password is not stored in clear")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(stored)) != nil {
+		return utils.NewTxError(utils.CodeInvalidCredentials, "the hash does not match the stored password")
+	}
+	return nil
+}
+
+// rehashUserPassword replaces the password a user holds in clear by its hash
+func rehashUserPassword(tx *gorm.DB, userID int, hash string) error {
+	var user models.User
+	if err := tx.First(&user, "user_id = ?", userID).Error; err != nil {
+		return notFound(err, utils.CodeUserNotFound, "unable to get user: %w", err)
+	}
+	if err := rehashPassword(user.HashedPassword, hash); err != nil {
+		return err
+	}
+	if err := tx.Model(&user).Update("hashed_password", hash).Error; err != nil {
+		return fmt.Errorf("unable to rehash password: %w", err)
+	}
+	return nil
+}
+
+// rehashAdminPassword replaces the password an admin holds in clear by its hash
+func rehashAdminPassword(tx *gorm.DB, adminID int, hash string) error {
+	var admin models.Admin
+	if err := tx.First(&admin, "admin_id = ?", adminID).Error; err != nil {
+		return notFound(err, utils.CodeAdminNotFound, "unable to get admin %d: %w", adminID, err)
+	}
+	if err := rehashPassword(admin.HashedPassword, hash); err != nil {
+		return err
+	}
+	if err := tx.Model(&admin).Update("hashed_password", hash).Error; err != nil {
+		return fmt.Errorf("unable to rehash password: %w", err)
+	}
+	return nil
+}
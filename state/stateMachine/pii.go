@@ -0,0 +1,71 @@
+package stateMachine
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"raft/pii"
+	"raft/state/stateMachine/models"
+)
+
+// columns of the users sealed by the pii serializer
+var userPIIColumns = []string{"date_of_birth", "identification_number", "identification_image_front",
+	"identification_image_back"}
+
+// migratePII replaces the unique constraint on identification numbers, useless once they are sealed, by the
+// blind index. The indexes of the users created before it existed are filled, all of them are computed again
+// when the index key changed, pseudonyms of erased users included, so the state matches a replay of the log
+func migratePII(db *gorm.DB) error {
+	if db.Migrator().HasConstraint(&models.User{}, "uni_users_identification_number") {
+		if err := db.Migrator().DropConstraint(&models.User{}, "uni_users_identification_number"); err != nil {
+			return fmt.Errorf("failed to drop identification number constraint: %w", err)
+		}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var recorded models.BlindIndexKey
+		if err := tx.First(&recorded, "id = ?", 1).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to read the blind index key: %w", err)
+		}
+		keyID := pii.IndexKeyID()
+		rekeyed := recorded.KeyID != keyID
+		users := tx.Model(&models.User{})
+		if !rekeyed {
+			users = users.Where("identification_hash IS NULL")
+		}
+		var found []*models.User
+		if err := users.Find(&found).Error; err != nil {
+			return fmt.Errorf("failed to read users: %w", err)
+		}
+		for _, user := range found {
+			columns := map[string]interface{}{}
+			if user.ErasedAt != nil {
+				if rekeyed {
+					columns["pseudonym"] = Pseudonym(user.UserID)
+					columns["email"] = ErasedEmail(user.UserID)
+				}
+			} else if user.IdentificationNumber != "" {
+				// signups replayed after an erasure have no identification number to index
+				columns["identification_hash"] = pii.BlindIndex(user.IdentificationNumber)
+			}
+			if len(columns) == 0 {
+				continue
+			}
+			if err := tx.Model(user).UpdateColumns(columns).Error; err != nil {
+				return fmt.Errorf("failed to index user %d: %w", user.UserID, err)
+			}
+		}
+		if rekeyed {
+			if err := tx.Save(&models.BlindIndexKey{ID: 1, KeyID: keyID}).Error; err != nil {
+				return fmt.Errorf("failed to record the blind index key: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ResealPII seals the personal data of users still in clear or sealed with a retired key under the active key
+func (sm *StateMachine) ResealPII() (int, error) {
+	return pii.Reseal(sm.DB, "users", "user_id", userPIIColumns)
+}
@@ -0,0 +1,263 @@
+package stateMachine
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+// MaxScheduleRetries bounds how many times a failed occurrence is attempted again
+const MaxScheduleRetries = 10
+
+// ValidateSchedule checks a standing order proposed by a user before it is applied
+func ValidateSchedule(walletPayload utils.WalletOperationPayload) error {
+	if walletPayload.Amount <= 0 {
+		return utils.NewTxError(utils.CodeInvalidRequest, "the amount of a scheduled transfer must be positive")
+	}
+	if walletPayload.Wallet1 == walletPayload.Wallet2 {
+		return utils.NewTxError(utils.CodeInvalidRequest, "a scheduled transfer needs two different wallets")
+	}
+	switch walletPayload.Interval {
+	case utils.IntervalDaily, utils.IntervalWeekly, utils.IntervalMonthly:
+	default:
+		return utils.NewTxError(utils.CodeInvalidRequest, "unsupported schedule interval: %s", walletPayload.Interval)
+	}
+	if walletPayload.StartAt.IsZero() {
+		return utils.NewTxError(utils.CodeInvalidRequest, "a scheduled transfer needs a start date")
+	}
+	if walletPayload.MaxRetries < 0 || walletPayload.MaxRetries > MaxScheduleRetries {
+		return utils.NewTxError(utils.CodeInvalidRequest, "max retries must be between 0 and %d", MaxScheduleRetries)
+	}
+	if walletPayload.RetryDelaySeconds < 0 {
+		return utils.NewTxError(utils.CodeInvalidRequest, "the retry delay cannot be negative")
+	}
+	return nil
+}
+
+// dueAt returns when the given occurrence of a schedule is due, occurrences start at 1.
+// Monthly dates are computed from the start date and stick to the last day of shorter months
+func dueAt(start time.Time, interval utils.ScheduleInterval, occurrence int) time.Time {
+	n := occurrence - 1
+	switch interval {
+	case utils.IntervalDaily:
+		return start.AddDate(0, 0, n)
+	case utils.IntervalWeekly:
+		return start.AddDate(0, 0, 7*n)
+	default:
+		first := time.Date(start.Year(), start.Month()+time.Month(n), 1, start.Hour(), start.Minute(),
+			start.Second(), start.Nanosecond(), start.Location())
+		lastDay := first.AddDate(0, 1, -1).Day()
+		day := start.Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		return first.AddDate(0, 0, day-1)
+	}
+}
+
+// applyScheduleAction creates a standing order or changes its status
+func applyScheduleAction(tx *gorm.DB, walletPayload utils.WalletOperationPayload) error {
+	if walletPayload.Action == utils.WalletScheduleCreate {
+		if err := ValidateSchedule(walletPayload); err != nil {
+			return err
+		}
+		for _, id := range []int{walletPayload.Wallet1, walletPayload.Wallet2} {
+			var wallet models.Wallet
+			if err := tx.First(&wallet, "wallet_id = ?", id).Error; err != nil {
+				return notFound(err, utils.CodeWalletNotFound, "wallet %d not found: %w", id, err)
+			}
+			if err := checkWalletUsable(tx, &wallet); err != nil {
+				return err
+			}
+		}
+		schedule := models.Schedule{
+			Wallet1:           walletPayload.Wallet1,
+			Wallet2:           walletPayload.Wallet2,
+			Amount:            walletPayload.Amount,
+			Interval:          walletPayload.Interval,
+			StartAt:           walletPayload.StartAt,
+			Status:            utils.ScheduleActive,
+			MaxRetries:        walletPayload.MaxRetries,
+			RetryDelaySeconds: walletPayload.RetryDelaySeconds,
+			Occurrence:        1,
+			DueAt:             walletPayload.StartAt,
+			NextRunAt:         walletPayload.StartAt,
+			UpdatedAt:         walletPayload.Time,
+		}
+		if err := tx.Create(&schedule).Error; err != nil {
+			return fmt.Errorf("failed to create schedule: %w", err)
+		}
+		return nil
+	}
+
+	var schedule models.Schedule
+	if err := tx.First(&schedule, "id = ?", walletPayload.ScheduleID).Error; err != nil {
+		return notFound(err, utils.CodeScheduleNotFound, "schedule %d not found: %w", walletPayload.ScheduleID, err)
+	}
+	if walletPayload.Wallet1 > 0 && schedule.Wallet1 != walletPayload.Wallet1 {
+		return utils.NewTxError(utils.CodeInvalidRequest, "schedule %d is not funded by wallet %d", schedule.ID, walletPayload.Wallet1)
+	}
+	var status utils.ScheduleStatus
+	switch walletPayload.Action {
+	case utils.WalletSchedulePause:
+		if schedule.Status != utils.ScheduleActive {
+			return utils.NewTxError(utils.CodeInvalidRequest, "schedule %d is %s", schedule.ID, schedule.Status)
+		}
+		status = utils.SchedulePaused
+	case utils.WalletScheduleResume:
+		// occurrences missed while paused are executed once the schedule resumes
+		if schedule.Status != utils.SchedulePaused {
+			return utils.NewTxError(utils.CodeInvalidRequest, "schedule %d is %s", schedule.ID, schedule.Status)
+		}
+		status = utils.ScheduleActive
+	default:
+		if schedule.Status == utils.ScheduleCancelled {
+			return utils.NewTxError(utils.CodeInvalidRequest, "schedule %d is already cancelled", schedule.ID)
+		}
+		status = utils.ScheduleCancelled
+	}
+	if err := tx.Model(&schedule).Updates(map[string]interface{}{"status": status, "updated_at": walletPayload.Time}).Error; err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+	return nil
+}
+
+// executeSchedule runs the attempt of a schedule proposed by the leader. Only the current attempt of an
+// active schedule is executed, proposals for an attempt already applied are rejected as stale so every
+// occurrence moves funds at most once. Otherwise the returned error is the one of the transfer, the execution
+// is recorded either way
+func (sm *StateMachine) executeSchedule(walletPayload utils.WalletOperationPayload) error {
+	var transferErr error
+	err := sm.stampedAt(walletPayload.Time).Transaction(func(tx *gorm.DB) error {
+		var schedule models.Schedule
+		if err := tx.First(&schedule, "id = ?", walletPayload.ScheduleID).Error; err != nil {
+			return notFound(err, utils.CodeScheduleNotFound, "schedule %d not found: %w", walletPayload.ScheduleID, err)
+		}
+		if schedule.Status != utils.ScheduleActive || schedule.Occurrence != walletPayload.Occurrence ||
+			schedule.Attempt != walletPayload.Attempt {
+			return utils.NewTxError(utils.CodeScheduleStale, "stale execution of schedule %d occurrence %d attempt %d",
+				schedule.ID, walletPayload.Occurrence, walletPayload.Attempt)
+		}
+
+		// the transfer always uses the replicated schedule, not the amounts carried by the proposal
+		transfer := utils.WalletOperationPayload{
+			Wallet1: schedule.Wallet1,
+			Wallet2: schedule.Wallet2,
+			Amount:  schedule.Amount,
+			Action:  utils.WalletTransfer,
+			Time:    walletPayload.Time,
+		}
+		execution := models.ScheduleExecution{
+			ScheduleID: schedule.ID,
+			Occurrence: schedule.Occurrence,
+			Attempt:    schedule.Attempt,
+			DueAt:      schedule.DueAt,
+			ExecutedAt: walletPayload.Time,
+			Status:     utils.TxSuccess,
+		}
+		if err := tx.SavePoint("schedule_transfer").Error; err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		operation, err := applyWalletOperation(tx, transfer)
+		if err != nil {
+			if errRb := tx.RollbackTo("schedule_transfer").Error; errRb != nil {
+				return fmt.Errorf("failed to roll back scheduled transfer: %w", errRb)
+			}
+			transferErr = err
+			rejected := rejectedOperation(transfer, err)
+			if errop := tx.Create(&rejected).Error; errop != nil {
+				return fmt.Errorf("failed to record rejected wallet operation: %w", errop)
+			}
+			operation = &rejected
+			execution.Status = utils.TxFailed
+			execution.ErrorCode = rejected.ErrorCode
+			execution.ErrorMessage = rejected.ErrorMessage
+		}
+		execution.WalletOperationID = &operation.ID
+
+		// retries are spaced from the previous attempt so every replica computes the same dates
+		if transferErr != nil && schedule.Attempt < schedule.MaxRetries {
+			execution.RetryScheduled = true
+			schedule.Attempt++
+			schedule.NextRunAt = schedule.NextRunAt.Add(time.Duration(schedule.RetryDelaySeconds) * time.Second)
+		} else {
+			schedule.Occurrence++
+			schedule.Attempt = 0
+			schedule.DueAt = dueAt(schedule.StartAt, schedule.Interval, schedule.Occurrence)
+			schedule.NextRunAt = schedule.DueAt
+		}
+		schedule.UpdatedAt = walletPayload.Time
+		if err := tx.Create(&execution).Error; err != nil {
+			return fmt.Errorf("failed to record schedule execution: %w", err)
+		}
+		if err := tx.Save(&schedule).Error; err != nil {
+			return fmt.Errorf("failed to update schedule: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return transferErr
+}
+
+// cancelSchedules cancels every standing order funded by or paying into the given wallets at the given time
+func cancelSchedules(tx *gorm.DB, walletIDs []int, at time.Time) error {
+	if len(walletIDs) == 0 {
+		return nil
+	}
+	err := tx.Model(&models.Schedule{}).
+		Where("(wallet1 IN ? OR wallet2 IN ?) AND status <> ?", walletIDs, walletIDs, utils.ScheduleCancelled).
+		Updates(map[string]interface{}{"status": utils.ScheduleCancelled, "updated_at": at}).Error
+	if err != nil {
+		return fmt.Errorf("failed to cancel schedules: %w", err)
+	}
+	return nil
+}
+
+// GetSchedule returns a single standing order
+func GetSchedule(scheduleID int) (*models.Schedule, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	var schedule models.Schedule
+	if err := defaultSM.DB.First(&schedule, "id = ?", scheduleID).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// GetSchedulesByWallet returns the standing orders funded by a wallet
+func GetSchedulesByWallet(walletID int) ([]*models.Schedule, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	var schedules []*models.Schedule
+	err := defaultSM.DB.Where("wallet1 = ?", walletID).Order("id").Find(&schedules).Error
+	return schedules, err
+}
+
+// GetScheduleExecutions returns every attempt made for a standing order, most recent first
+func GetScheduleExecutions(scheduleID int) ([]*models.ScheduleExecution, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	var executions []*models.ScheduleExecution
+	err := defaultSM.DB.Where("schedule_id = ?", scheduleID).
+		Order("occurrence DESC, attempt DESC").
+		Find(&executions).Error
+	return executions, err
+}
+
+// DueSchedules returns the active standing orders whose next attempt is due at now
+func (sm *StateMachine) DueSchedules(now time.Time) ([]*models.Schedule, error) {
+	var schedules []*models.Schedule
+	err := sm.DB.Where("status = ? AND next_run_at <= ?", utils.ScheduleActive, now).
+		Order("next_run_at").
+		Find(&schedules).Error
+	return schedules, err
+}
@@ -1,12 +1,14 @@
 package stateMachine
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"raft/pii"
 	"raft/state/stateMachine/models"
 	"raft/utils"
 )
@@ -21,21 +23,51 @@ type StateMachine struct {
 
 // initialize the database and auto migrate
 func InitStateMachine(path string) (*StateMachine, error) {
-	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SQLite DB at %s: %w", path, err)
 	}
 
+	// admins created before roles existed could do everything, they become super admins
+	legacyAdmins := db.Migrator().HasTable(&models.Admin{}) && !db.Migrator().HasColumn(&models.Admin{}, "Role")
+
 	// Migrate the schema
-	err = db.AutoMigrate(&models.Admin{}, &models.User{}, &models.Wallet{}, &models.WalletOperation{})
+	err = db.AutoMigrate(&models.Admin{}, &models.User{}, &models.Wallet{}, &models.WalletOperation{}, &models.FeeSchedule{},
+		&models.SpendingLimit{}, &models.Schedule{}, &models.ScheduleExecution{}, &models.AdminAudit{}, &models.BlindIndexKey{})
 	if err != nil {
 		return nil, fmt.Errorf("failed automigrate %w", err)
 	}
+	if legacyAdmins {
+		if err := db.Model(&models.Admin{}).Where("1 = 1").Update("role", utils.RoleSuperAdmin).Error; err != nil {
+			return nil, fmt.Errorf("failed to migrate admin roles: %w", err)
+		}
+	}
+	if err := migratePII(db); err != nil {
+		return nil, err
+	}
+	// users validated before account statuses existed are active
+	if err := db.Model(&models.User{}).
+		Where("active = ? AND status = ?", true, utils.AccountPendingKYC).
+		Update("status", utils.AccountActive).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate account statuses: %w", err)
+	}
 	defaultSM = &StateMachine{DB: db}
 	fmt.Println("successfully initialized state machine")
 	return defaultSM, nil
 }
 
+// Close writes back what sqlite still holds in its write ahead log, if any, and closes the database
+func (sm *StateMachine) Close() error {
+	if err := sm.DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+		return fmt.Errorf("failed to checkpoint state machine: %w", err)
+	}
+	db, err := sm.DB.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
 // ordinary get operations
 
 // GetUserByID return the user information
@@ -59,7 +91,7 @@ func CountValidatedUsers() (int64, error) {
 func CountWalletOperationsBetween(start, end time.Time) (int64, error) {
 	var count int64
 	err := defaultSM.DB.Model(&models.WalletOperation{}).
-		Where("timestamp >= ? AND timestamp < ?", start, end).
+		Where("status = ? AND timestamp >= ? AND timestamp < ?", utils.TxSuccess, start, end).
 		Count(&count).Error
 	return count, err
 }
@@ -67,7 +99,7 @@ func CountWalletOperationsBetween(start, end time.Time) (int64, error) {
 func CountUserTransactionBetween(userID int, start, end time.Time) (int64, error) {
 	var count int64
 	err := defaultSM.DB.Model(&models.WalletOperation{}).
-		Where("wallet1 = ? AND timestamp >= ? AND timestamp < ?", userID, start, end).
+		Where("wallet1 = ? AND status = ? AND timestamp >= ? AND timestamp < ?", userID, utils.TxSuccess, start, end).
 		Count(&count).Error
 	return count, err
 }
@@ -76,7 +108,7 @@ func SumUserTransactionBetween(userID int, start, end time.Time) (float64, error
 	var total float64
 	err := defaultSM.DB.Model(&models.WalletOperation{}).
 		Select("COALESCE(SUM(amount), 0)").
-		Where("wallet1 = ? AND timestamp >= ? AND timestamp < ?", userID, start, end).
+		Where("wallet1 = ? AND status = ? AND timestamp >= ? AND timestamp < ?", userID, utils.TxSuccess, start, end).
 		Scan(&total).Error
 	return total, err
 }
@@ -85,7 +117,7 @@ func SumWalletOperationAmountsBetween(start, end time.Time) (float64, error) {
 	var total float64
 	err := defaultSM.DB.Model(&models.WalletOperation{}).
 		Select("COALESCE(SUM(amount), 0)").
-		Where("timestamp >= ? AND timestamp < ?", start, end).
+		Where("status = ? AND timestamp >= ? AND timestamp < ?", utils.TxSuccess, start, end).
 		Scan(&total).Error
 	return total, err
 }
@@ -218,90 +250,199 @@ func GetUsers() ([]*models.User, error) {
 	return users, nil
 }
 
+// GetUsersByStatus returns the users in the given account status, oldest first
+func GetUsersByStatus(status utils.AccountStatus) ([]*models.User, error) {
+	if defaultSM == nil {
+		return nil, fmt.Errorf("state machine not yet initialized")
+	}
+	var users []*models.User
+	err := defaultSM.DB.Where("status = ?", status).Order("user_id").Find(&users).Error
+	return users, err
+}
+
 // ApplyWalletOperation performs balace mutation on a wallet
 // ApplyWalletOperation applies a persisted wallet operation and updates its status.
 func (sm *StateMachine) ApplyWalletOperation(walletPayload utils.WalletOperationPayload) error {
+	switch walletPayload.Action {
+	case utils.WalletScheduleCreate, utils.WalletSchedulePause, utils.WalletScheduleResume, utils.WalletScheduleCancel:
+		return sm.stampedAt(walletPayload.Time).Transaction(func(tx *gorm.DB) error {
+			return applyScheduleAction(tx, walletPayload)
+		})
+	}
+	if walletPayload.ScheduleID > 0 {
+		return sm.executeSchedule(walletPayload)
+	}
 
-	err := sm.DB.Transaction(func(tx *gorm.DB) error {
-		// get first wallet
-		var w1 models.Wallet
-		if err := tx.First(&w1, "wallet_id = ?", walletPayload.Wallet1).Error; err != nil {
-			return fmt.Errorf("wallet1 not found: %w", err)
-		}
-		//perform wallet actions
-		switch walletPayload.Action {
-		case utils.WalletDeposit:
-			w1.Balance += walletPayload.Amount
-
-		case utils.WalletWithdraw:
-			if w1.Balance < walletPayload.Amount {
-				return fmt.Errorf("insufficient funds")
-			}
-			w1.Balance -= walletPayload.Amount
-
-		case utils.WalletTransfer:
-			if walletPayload.Wallet2 < 0 {
-				return fmt.Errorf("wallet2 is required for transfer")
-			}
-			var w2 models.Wallet
-			if err := tx.First(&w2, "wallet_id = ?", walletPayload.Wallet2).Error; err != nil {
-				return fmt.Errorf("wallet2 not found: %w", err)
-			}
-			if w1.Balance < walletPayload.Amount {
-				return fmt.Errorf("insufficient funds")
-			}
-			w1.Balance -= walletPayload.Amount
-			w2.Balance += walletPayload.Amount
+	err := sm.stampedAt(walletPayload.Time).Transaction(func(tx *gorm.DB) error {
+		_, err := applyWalletOperation(tx, walletPayload)
+		return err
+	})
+	if err != nil {
+		sm.recordRejected(walletPayload, err)
+	}
+	return err
+}
 
-			if err := tx.Save(&w2).Error; err != nil {
-				return fmt.Errorf("failed to update wallet2: %w", err)
-			}
+// applyWalletOperation moves the funds of a wallet operation inside tx and records it
+func applyWalletOperation(tx *gorm.DB, walletPayload utils.WalletOperationPayload) (*models.WalletOperation, error) {
+	// get first wallet
+	var w1 models.Wallet
+	if err := tx.First(&w1, "wallet_id = ?", walletPayload.Wallet1).Error; err != nil {
+		return nil, notFound(err, utils.CodeWalletNotFound, "wallet1 not found: %w", err)
+	}
+	if err := checkWalletUsable(tx, &w1); err != nil {
+		return nil, err
+	}
+	// fees are charged on top of the amount and collected in the treasury wallet
+	schedule, err := feeScheduleFor(tx, walletPayload.Action)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := ComputeFee(schedule, walletPayload.Amount)
+	if err != nil {
+		return nil, err
+	}
+	// the time stamped by the leader, so every replica counts the same operations in the limit windows.
+	// Entries appended before leaders stamped them have none and fall in no window
+	now := walletPayload.Time
+	if walletPayload.Action == utils.WalletWithdraw || walletPayload.Action == utils.WalletTransfer {
+		if err := checkSpendingLimits(tx, &w1, walletPayload.Amount+fee, now); err != nil {
+			return nil, err
+		}
+	}
+	//perform wallet actions
+	switch walletPayload.Action {
+	case utils.WalletDeposit:
+		w1.Balance += walletPayload.Amount
+
+	case utils.WalletWithdraw:
+		if w1.Balance < walletPayload.Amount+fee {
+			return nil, utils.NewTxError(utils.CodeInsufficientFunds, "insufficient funds")
+		}
+		w1.Balance -= walletPayload.Amount + fee
 
-		default:
-			return fmt.Errorf("unsupported operation type: %s", walletPayload.Action)
+	case utils.WalletTransfer:
+		if walletPayload.Wallet2 < 0 {
+			return nil, utils.NewTxError(utils.CodeInvalidRequest, "wallet2 is required for transfer")
+		}
+		var w2 models.Wallet
+		if err := tx.First(&w2, "wallet_id = ?", walletPayload.Wallet2).Error; err != nil {
+			return nil, notFound(err, utils.CodeWalletNotFound, "wallet2 not found: %w", err)
 		}
+		if err := checkWalletUsable(tx, &w2); err != nil {
+			return nil, err
+		}
+		if w1.Balance < walletPayload.Amount+fee {
+			return nil, utils.NewTxError(utils.CodeInsufficientFunds, "insufficient funds")
+		}
+		w1.Balance -= walletPayload.Amount + fee
+		w2.Balance += walletPayload.Amount
 
-		if err := tx.Save(&w1).Error; err != nil {
-			return fmt.Errorf("failed to update wallet1: %w", err)
+		if err := tx.Save(&w2).Error; err != nil {
+			return nil, fmt.Errorf("failed to update wallet2: %w", err)
 		}
-		walletOperation := models.WalletOperation{
-			Wallet1:   walletPayload.Wallet1,
-			Wallet2:   &walletPayload.Wallet2,
-			Amount:    walletPayload.Amount,
-			Type:      walletPayload.Action,
-			Timestamp: time.Now(),
-			Status:    utils.TxSuccess,
+
+	default:
+		return nil, utils.NewTxError(utils.CodeUnsupportedOperation, "unsupported operation type: %s", walletPayload.Action)
+	}
+
+	if err := tx.Save(&w1).Error; err != nil {
+		return nil, fmt.Errorf("failed to update wallet1: %w", err)
+	}
+	if fee > 0 {
+		treasury, err := treasuryWallet(tx)
+		if err != nil {
+			return nil, err
 		}
-		if errop := tx.Create(&walletOperation).Error; errop != nil {
-			return fmt.Errorf("failed to create wallet operation: %w", errop)
+		// the treasury may be one of the wallets saved above
+		if err := tx.Model(treasury).Update("balance", gorm.Expr("balance + ?", fee)).Error; err != nil {
+			return nil, fmt.Errorf("failed to credit treasury: %w", err)
 		}
-		return nil
-	})
+	}
+	walletOperation := models.WalletOperation{
+		Wallet1:   walletPayload.Wallet1,
+		Wallet2:   &walletPayload.Wallet2,
+		Amount:    walletPayload.Amount,
+		Fee:       fee,
+		Type:      walletPayload.Action,
+		Timestamp: now,
+		Status:    utils.TxSuccess,
+	}
+	if errop := tx.Create(&walletOperation).Error; errop != nil {
+		return nil, fmt.Errorf("failed to create wallet operation: %w", errop)
+	}
+	return &walletOperation, nil
+}
 
-	return err
+// recordRejected keeps a trace of a rolled back wallet operation and why it was rejected
+func (sm *StateMachine) recordRejected(walletPayload utils.WalletOperationPayload, err error) {
+	rejected := rejectedOperation(walletPayload, err)
+	if errop := sm.DB.Create(&rejected).Error; errop != nil {
+		fmt.Println("failed to record rejected wallet operation:", errop)
+	}
+}
+
+func rejectedOperation(walletPayload utils.WalletOperationPayload, err error) models.WalletOperation {
+	return models.WalletOperation{
+		Wallet1:      walletPayload.Wallet1,
+		Wallet2:      &walletPayload.Wallet2,
+		Amount:       walletPayload.Amount,
+		Type:         walletPayload.Action,
+		Timestamp:    walletPayload.Time,
+		Status:       utils.TxFailed,
+		ErrorCode:    utils.ErrorCodeOf(err),
+		ErrorMessage: err.Error(),
+	}
+}
+
+// stampedAt runs the queries of an operation as if the clock read the time the leader stamped, so the
+// times gorm fills in are the same on every node. Entries appended before leaders stamped them keep
+// the local clock
+func (sm *StateMachine) stampedAt(t time.Time) *gorm.DB {
+	if t.IsZero() {
+		return sm.DB
+	}
+	return sm.DB.Session(&gorm.Session{NowFunc: func() time.Time { return t }})
 }
 
 // ApplyUserOperation performs user, UserID is set to -1 if not required such as create
 func (sm *StateMachine) ApplyUserOperation(userPayload utils.UserPayload) error {
-	err := sm.DB.Transaction(func(tx *gorm.DB) error {
+	err := sm.stampedAt(userPayload.Time).Transaction(func(tx *gorm.DB) error {
 
 		switch userPayload.Action {
 
 		case utils.UserCreateAccount:
+			// signups scrubbed after an erasure are replayed without identification number
+			var idHash *string
+			if userPayload.IdentificationNumber != "" {
+				hash := pii.BlindIndex(userPayload.IdentificationNumber)
+				idHash = &hash
+			}
 			user := models.User{
 				FirstName:                userPayload.FirstName,
 				LastName:                 userPayload.LastName,
 				Email:                    userPayload.Email,
-				HashedPassword:           userPayload.HashedPassword, // hash before use!
+				HashedPassword:           userPayload.HashedPassword, // hashed by the leader before the proposal
 				DateOfBirth:              userPayload.DateOfBirth,
 				IdentificationNumber:     userPayload.IdentificationNumber,
+				IdentificationHash:       idHash,
 				IdentificationImageFront: userPayload.IdentificationImageFront,
 				IdentificationImageBack:  userPayload.IdentificationImageBack,
 			}
 			if err := tx.Create(&user).Error; err != nil {
+				if errors.Is(err, gorm.ErrDuplicatedKey) {
+					return utils.NewTxError(utils.CodeAlreadyExists, "failed to create user: %w", err)
+				}
 				return fmt.Errorf("failed to create user: %w", err)
 			}
 		case utils.UserCreateWallet:
+			var user models.User
+			if err := tx.First(&user, "user_id = ?", userPayload.UserID).Error; err != nil {
+				return notFound(err, utils.CodeUserNotFound, "unable to get user:%w", err)
+			}
+			if user.Status != utils.AccountActive && user.Status != utils.AccountPendingKYC {
+				return accountStatusError(&user)
+			}
 			wallet := models.Wallet{UserID: userPayload.UserID}
 			if err := tx.Create(&wallet).Error; err != nil {
 				return fmt.Errorf("failed to create wallet: %w", err)
@@ -309,22 +450,34 @@ func (sm *StateMachine) ApplyUserOperation(userPayload utils.UserPayload) error
 		case utils.UserUpdatePassword:
 			var user models.User
 			if err := tx.First(&user, "user_id = ?", userPayload.UserID).Error; err != nil {
-				return fmt.Errorf("unable to get user:%w", err)
+				return notFound(err, utils.CodeUserNotFound, "unable to get user:%w", err)
+			}
+			if user.Status == utils.AccountClosed {
+				return accountStatusError(&user)
 			}
 			if user.HashedPassword != userPayload.PrevPW {
-				return fmt.Errorf("invalid password supplied")
+				return utils.NewTxError(utils.CodeInvalidCredentials, "invalid password supplied")
 			}
 			user.HashedPassword = userPayload.NewPW
 			if err := tx.Save(&user).Error; err != nil {
 				return fmt.Errorf("unable to update password: %w", err)
 			}
 		case utils.UserDeleteAccount:
-			if err := tx.Where("user_id = ?", userPayload.UserID).Delete(&models.User{}).Error; err != nil {
-				return fmt.Errorf("unable to delete user: %w", err)
+			// the user row is kept so past operations still resolve, the account is closed instead
+			if err := closeAccount(tx, userPayload.UserID, userPayload.SweepWalletID, userPayload.Time); err != nil {
+				return err
+			}
+		case utils.UserEraseAccount:
+			if err := eraseAccount(tx, userPayload.UserID, userPayload.SweepWalletID, userPayload.Time); err != nil {
+				return err
+			}
+		case utils.UserRehashPassword:
+			if err := rehashUserPassword(tx, userPayload.UserID, userPayload.NewPW); err != nil {
+				return err
 			}
 
 		default:
-			return fmt.Errorf("invalid operation type: %s", userPayload.Action)
+			return utils.NewTxError(utils.CodeUnsupportedOperation, "invalid operation type: %s", userPayload.Action)
 		}
 		return nil
 	})
@@ -335,35 +488,80 @@ func (sm *StateMachine) ApplyUserOperation(userPayload utils.UserPayload) error
 // ApplyAdminOperations commits logs related to admin operation. the id is -1 if it is not required
 func (sm *StateMachine) ApplyAdminOperations(adminPayload utils.AdminPayload) error {
 
-	err := sm.DB.Transaction(func(tx *gorm.DB) error {
+	err := sm.stampedAt(adminPayload.Time).Transaction(func(tx *gorm.DB) error {
+		if adminPayload.Action == utils.AdminCreateAccount {
+			return createAdmin(tx, adminPayload)
+		}
+		// proposed by the leader on behalf of nobody, the hash must match the password stored in clear
+		if adminPayload.Action == utils.AdminRehashPassword {
+			return rehashAdminPassword(tx, adminPayload.TargetAdminID, adminPayload.HashedPassword)
+		}
+		// roles are checked here rather than by the api so a forged proposal cannot escalate privileges
+		if _, err := authorizeAdmin(tx, adminPayload.AdminID, adminPayload.Action); err != nil {
+			return err
+		}
 		switch adminPayload.Action {
-		case utils.AdminCreateAccount:
-			admin := models.Admin{
-				FirstName:      adminPayload.FirstName,
-				LastName:       adminPayload.LastName,
-				HashedPassword: adminPayload.HashedPassword,
-				Email:          adminPayload.Email,
+		case utils.AdminValidateUser:
+			var user models.User
+			if err := tx.First(&user, "user_id = ?", adminPayload.UserId).Error; err != nil {
+				return notFound(err, utils.CodeUserNotFound, "unable to get user: %w", err)
 			}
-			if err := tx.Create(&admin).Error; err != nil {
-				return fmt.Errorf("failed to create admin: %w", err)
+			if user.Status == utils.AccountClosed {
+				return accountStatusError(&user)
 			}
-		case utils.AdminValidateUser:
 			if err := tx.Model(&models.User{}).
 				Where("user_id = ?", adminPayload.UserId).
 				Updates(map[string]interface{}{
 					"validated_by": adminPayload.AdminID,
-					"updated_at":   time.Now(),
+					"updated_at":   adminPayload.Time,
 					"active":       true,
 				}).Error; err != nil {
 				return fmt.Errorf("failed to validate user: %w", err)
 			}
+			// frozen users stay frozen, unfreezing them will make them active
+			if err := tx.Model(&models.User{}).
+				Where("user_id = ? AND status = ?", adminPayload.UserId, utils.AccountPendingKYC).
+				Update("status", utils.AccountActive).Error; err != nil {
+				return fmt.Errorf("failed to update account status: %w", err)
+			}
+			// validated users leave the unverified tier, upgrades beyond standard stay explicit
+			if err := tx.Model(&models.User{}).
+				Where("user_id = ? AND tier = ?", adminPayload.UserId, utils.TierUnverified).
+				Update("tier", utils.TierStandard).Error; err != nil {
+				return fmt.Errorf("failed to update user tier: %w", err)
+			}
+		case utils.AdminSetFeeSchedule:
+			if err := setFeeSchedule(tx, adminPayload); err != nil {
+				return err
+			}
+		case utils.AdminSetSpendingLimit:
+			if err := setSpendingLimit(tx, adminPayload); err != nil {
+				return err
+			}
+		case utils.AdminSetUserTier:
+			if err := setUserTier(tx, adminPayload); err != nil {
+				return err
+			}
+		case utils.AdminFreezeUser, utils.AdminUnfreezeUser:
+			if err := setUserFrozen(tx, adminPayload.UserId, adminPayload.Action == utils.AdminFreezeUser, adminPayload.Time); err != nil {
+				return err
+			}
+		case utils.AdminFreezeWallet, utils.AdminUnfreezeWallet:
+			if err := setWalletFrozen(tx, adminPayload.WalletID, adminPayload.Action == utils.AdminFreezeWallet); err != nil {
+				return err
+			}
+		case utils.AdminSetRole:
+			if err := setAdminRole(tx, adminPayload); err != nil {
+				return err
+			}
 
 		default:
-			return fmt.Errorf("invalid admin operation type: %s", adminPayload.Action)
+			return utils.NewTxError(utils.CodeUnsupportedOperation, "invalid admin operation type: %s", adminPayload.Action)
 		}
 
 		return nil
 	})
 
+	sm.recordAdminAudit(adminPayload, err)
 	return err
 }
@@ -0,0 +1,723 @@
+package stateMachine
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"raft/config"
+	"raft/pii"
+	"raft/state/stateMachine/models"
+	"raft/utils"
+)
+
+func openStateMachine(t *testing.T) *StateMachine {
+	t.Helper()
+	sm, err := InitStateMachine(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	return sm
+}
+
+func create(t *testing.T, sm *StateMachine, rows ...interface{}) {
+	t.Helper()
+	for _, row := range rows {
+		if err := sm.DB.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func balance(t *testing.T, sm *StateMachine, walletID int) int64 {
+	t.Helper()
+	var w models.Wallet
+	if err := sm.DB.First(&w, "wallet_id = ?", walletID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return w.Balance
+}
+
+func TestComputeFee(t *testing.T) {
+	tiers := `[{"up_to":100,"flat":1},{"up_to":0,"flat":2,"basis_points":100},{"up_to":1000,"basis_points":50}]`
+	tests := []struct {
+		name     string
+		schedule *models.FeeSchedule
+		amount   int64
+		want     int64
+	}{
+		{"no schedule", nil, 500, 0},
+		{"flat", &models.FeeSchedule{Kind: utils.FeeFlat, Flat: 7}, 500, 7},
+		{"percentage", &models.FeeSchedule{Kind: utils.FeePercentage, BasisPoints: 250}, 1000, 25},
+		{"percentage rounds down", &models.FeeSchedule{Kind: utils.FeePercentage, BasisPoints: 250}, 39, 0},
+		{"lowest tier", &models.FeeSchedule{Kind: utils.FeeTiered, Tiers: tiers}, 100, 1},
+		{"middle tier", &models.FeeSchedule{Kind: utils.FeeTiered, Tiers: tiers}, 1000, 5},
+		{"unbounded tier", &models.FeeSchedule{Kind: utils.FeeTiered, Tiers: tiers}, 5000, 52},
+	}
+	for _, tt := range tests {
+		got, err := ComputeFee(tt.schedule, tt.amount)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: ComputeFee(%d) = %d, %v, want %d", tt.name, tt.amount, got, err, tt.want)
+		}
+	}
+	if _, err := ComputeFee(&models.FeeSchedule{Kind: "bogus"}, 1); err == nil {
+		t.Error("an unknown fee kind was computed")
+	}
+}
+
+func TestTransferChargesFeeToTreasury(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid", Status: utils.AccountActive},
+		&models.User{UserID: 2, Email: "two@test.invalid", Status: utils.AccountActive},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 1000}, &models.Wallet{WalletID: 2, UserID: 2},
+		&models.Wallet{WalletID: 3, IsTreasury: true},
+		&models.FeeSchedule{Action: utils.WalletTransfer, Kind: utils.FeePercentage, BasisPoints: 100})
+	at := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Wallet2: 2, Amount: 500,
+		Action: utils.WalletTransfer, Time: at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := []int64{balance(t, sm, 1), balance(t, sm, 2), balance(t, sm, 3)}; got[0] != 495 || got[1] != 500 || got[2] != 5 {
+		t.Fatalf("balances sender, receiver, treasury = %v, want [495 500 5]", got)
+	}
+	err = sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Wallet2: 2, Amount: 495,
+		Action: utils.WalletTransfer, Time: at})
+	if utils.ErrorCodeOf(err) != utils.CodeInsufficientFunds {
+		t.Fatalf("transfer leaving nothing for the fee: %v, want insufficient funds", err)
+	}
+	var op models.WalletOperation
+	if err := sm.DB.Where("status = ?", utils.TxSuccess).First(&op).Error; err != nil {
+		t.Fatal(err)
+	}
+	if op.Fee != 5 || !op.Timestamp.Equal(at) {
+		t.Fatalf("operation recorded fee %d at %v, want 5 at %v", op.Fee, op.Timestamp, at)
+	}
+}
+
+func TestSpendingLimits(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid", IdentificationNumber: "1", Tier: utils.TierStandard,
+		Status: utils.AccountActive},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 1000}, &models.Wallet{WalletID: 2, UserID: 1, Balance: 1000},
+		&models.SpendingLimit{Scope: utils.LimitScopeTier, Tier: utils.TierStandard, PerTransaction: 100},
+		&models.SpendingLimit{Scope: utils.LimitScopeWallet, WalletID: 1, Daily: 120},
+		&models.SpendingLimit{Scope: utils.LimitScopeUser, UserID: 1, Monthly: 200})
+	steps := []struct {
+		wallet  int
+		amount  int64
+		allowed bool
+	}{
+		{1, 150, false}, // above the limit of the tier
+		{1, 100, true},
+		{1, 30, false}, // above the daily limit of the wallet
+		{2, 90, true},  // other wallets only count for the user
+		{2, 20, false}, // above the monthly limit of the user
+	}
+	for i, step := range steps {
+		err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: step.wallet, Wallet2: -1,
+			Amount: step.amount, Action: utils.WalletWithdraw})
+		if (err == nil) != step.allowed {
+			t.Fatalf("step %d: withdraw %d from wallet %d: %v, want allowed %v", i, step.amount, step.wallet, err,
+				step.allowed)
+		}
+	}
+	if got := []int64{balance(t, sm, 1), balance(t, sm, 2)}; got[0] != 900 || got[1] != 910 {
+		t.Fatalf("balances = %v, want [900 910]", got)
+	}
+}
+
+// the fee leaves the wallet with the amount, both count against the limits
+func TestSpendingLimitsCountFees(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid", Tier: utils.TierStandard,
+		Status: utils.AccountActive},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 1000}, &models.Wallet{WalletID: 2, IsTreasury: true},
+		&models.FeeSchedule{Action: utils.WalletWithdraw, Kind: utils.FeeFlat, Flat: 10},
+		&models.SpendingLimit{Scope: utils.LimitScopeWallet, WalletID: 1, PerTransaction: 100, Daily: 150})
+	at := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		amount  int64
+		allowed bool
+	}{
+		{95, false}, // the fee takes it above the per transaction limit
+		{90, true},
+		{45, false}, // 100 spent with the first fee, 55 more is above the daily limit
+		{40, true},
+	}
+	for i, step := range steps {
+		err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Wallet2: -1,
+			Amount: step.amount, Action: utils.WalletWithdraw, Time: at})
+		if (err == nil) != step.allowed || err != nil && utils.ErrorCodeOf(err) != utils.CodeLimitExceeded {
+			t.Fatalf("step %d: withdraw %d: %v, want allowed %v", i, step.amount, err, step.allowed)
+		}
+	}
+	if got := []int64{balance(t, sm, 1), balance(t, sm, 2)}; got[0] != 850 || got[1] != 20 {
+		t.Fatalf("balances wallet, treasury = %v, want [850 20]", got)
+	}
+}
+
+// the fee schedule records when the leader stamped the change
+func TestFeeScheduleUsesStampedTime(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.Admin{AdminID: 1, Email: "root@test.invalid", Role: utils.RoleSuperAdmin})
+	at := time.Date(2024, time.April, 2, 8, 0, 0, 0, time.UTC)
+	if err := sm.ApplyAdminOperations(utils.AdminPayload{AdminID: 1, FeeAction: utils.WalletTransfer,
+		FeeKind: utils.FeeFlat, FeeFlat: 5, Action: utils.AdminSetFeeSchedule, Time: at}); err != nil {
+		t.Fatal(err)
+	}
+	var schedule models.FeeSchedule
+	if err := sm.DB.First(&schedule, "action = ?", utils.WalletTransfer).Error; err != nil {
+		t.Fatal(err)
+	}
+	if schedule.UpdatedBy != 1 || !schedule.UpdatedAt.Equal(at) {
+		t.Fatalf("fee schedule updated by %d at %v, want 1 at %v", schedule.UpdatedBy, schedule.UpdatedAt, at)
+	}
+}
+
+// a spending limit records when the leader stamped the change
+func TestSpendingLimitUsesStampedTime(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.Admin{AdminID: 1, Email: "root@test.invalid", Role: utils.RoleSuperAdmin})
+	at := time.Date(2024, time.April, 2, 8, 0, 0, 0, time.UTC)
+	if err := sm.ApplyAdminOperations(utils.AdminPayload{AdminID: 1, LimitScope: utils.LimitScopeTier,
+		Tier: utils.TierStandard, LimitDaily: 500, Action: utils.AdminSetSpendingLimit, Time: at}); err != nil {
+		t.Fatal(err)
+	}
+	var limit models.SpendingLimit
+	if err := sm.DB.First(&limit, "scope = ? AND tier = ?", utils.LimitScopeTier, utils.TierStandard).Error; err != nil {
+		t.Fatal(err)
+	}
+	if limit.Daily != 500 || !limit.UpdatedAt.Equal(at) {
+		t.Fatalf("limit %d updated at %v, want 500 at %v", limit.Daily, limit.UpdatedAt, at)
+	}
+}
+
+// the limit windows follow the time the leader stamped, not the clock of the replica applying the entry
+func TestSpendingLimitsUseStampedTime(t *testing.T) {
+	day := time.Date(2024, time.March, 31, 23, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at     time.Time
+		amount int64
+		want   utils.ErrorCode
+	}{
+		{day, 60, ""},
+		{day.Add(30 * time.Minute), 50, utils.CodeLimitExceeded},
+		{day.Add(2 * time.Hour), 50, ""},
+		{day.Add(3 * time.Hour), 150, utils.CodeLimitExceeded},
+		{day.Add(4 * time.Hour), 20, ""},
+		{day.Add(5 * time.Hour), 40, utils.CodeLimitExceeded},
+	}
+	// two replicas apply the same entries and must agree
+	for replica := 0; replica < 2; replica++ {
+		sm := openStateMachine(t)
+		create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid", Tier: utils.TierStandard,
+			Status: utils.AccountActive},
+			&models.Wallet{WalletID: 1, UserID: 1, Balance: 1000},
+			&models.SpendingLimit{Scope: utils.LimitScopeWallet, WalletID: 1, PerTransaction: 100, Daily: 100},
+			&models.SpendingLimit{Scope: utils.LimitScopeUser, UserID: 1, Monthly: 120})
+		for i, step := range steps {
+			err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Wallet2: -1,
+				Amount: step.amount, Action: utils.WalletWithdraw, Time: step.at})
+			if got := utils.ErrorCodeOf(err); err != nil && got != step.want || err == nil && step.want != "" {
+				t.Fatalf("replica %d step %d: withdraw %d at %v: %v, want %q", replica, i, step.amount, step.at,
+					err, step.want)
+			}
+		}
+		if got := balance(t, sm, 1); got != 870 {
+			t.Fatalf("replica %d: balance %d, want 870", replica, got)
+		}
+	}
+}
+
+func TestBlockedAccounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		owner  utils.AccountStatus
+		wallet utils.AccountStatus
+		// the receiver of the transfer is blocked instead of the sender
+		receiver bool
+		want     utils.ErrorCode
+	}{
+		{"active", utils.AccountActive, utils.AccountActive, false, ""},
+		{"pending kyc", utils.AccountPendingKYC, utils.AccountActive, false, utils.CodeAccountPendingKYC},
+		{"frozen user", utils.AccountFrozen, utils.AccountActive, false, utils.CodeAccountFrozen},
+		{"closed user", utils.AccountClosed, utils.AccountActive, false, utils.CodeAccountClosed},
+		{"frozen wallet", utils.AccountActive, utils.AccountFrozen, false, utils.CodeWalletFrozen},
+		{"closed wallet", utils.AccountActive, utils.AccountClosed, false, utils.CodeWalletClosed},
+		{"frozen receiver", utils.AccountFrozen, utils.AccountActive, true, utils.CodeAccountFrozen},
+		{"frozen receiving wallet", utils.AccountActive, utils.AccountFrozen, true, utils.CodeWalletFrozen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := openStateMachine(t)
+			blocked, other := 1, 2
+			if tt.receiver {
+				blocked, other = 2, 1
+			}
+			create(t, sm, &models.User{UserID: blocked, Email: "blocked@test.invalid", IdentificationNumber: "1",
+				Status: tt.owner},
+				&models.User{UserID: other, Email: "other@test.invalid", IdentificationNumber: "2",
+					Status: utils.AccountActive},
+				&models.Wallet{WalletID: blocked, UserID: blocked, Balance: 100, Status: tt.wallet},
+				&models.Wallet{WalletID: other, UserID: other, Balance: 100})
+			err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Wallet2: 2, Amount: 40,
+				Action: utils.WalletTransfer})
+			if got := utils.ErrorCodeOf(err); err != nil && got != tt.want || err == nil && tt.want != "" {
+				t.Fatalf("transfer: %v, want %q", err, tt.want)
+			}
+			if tt.want != "" && (balance(t, sm, 1) != 100 || balance(t, sm, 2) != 100) {
+				t.Fatal("a rejected transfer moved funds")
+			}
+		})
+	}
+}
+
+func TestFreezeAndUnfreeze(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.Admin{AdminID: 1, Email: "root@test.invalid", Role: utils.RoleSuperAdmin},
+		&models.User{UserID: 1, Email: "one@test.invalid", IdentificationNumber: "1", Status: utils.AccountActive, Active: true},
+		&models.User{UserID: 2, Email: "two@test.invalid", IdentificationNumber: "2"},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 100})
+	admin := func(action utils.AdminAction, userID, walletID int) error {
+		return sm.ApplyAdminOperations(utils.AdminPayload{AdminID: 1, UserId: userID, WalletID: walletID,
+			Action: action})
+	}
+	status := func(userID int) utils.AccountStatus {
+		var user models.User
+		if err := sm.DB.First(&user, "user_id = ?", userID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.Status
+	}
+	for _, userID := range []int{1, 2} {
+		if err := admin(utils.AdminFreezeUser, userID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// validating a frozen user keeps it frozen
+	if err := admin(utils.AdminValidateUser, 2, 0); err != nil || status(2) != utils.AccountFrozen {
+		t.Fatalf("validated frozen user is %s, %v", status(2), err)
+	}
+	for _, userID := range []int{1, 2} {
+		if err := admin(utils.AdminUnfreezeUser, userID, 0); err != nil || status(userID) != utils.AccountActive {
+			t.Fatalf("unfrozen user %d is %s, %v", userID, status(userID), err)
+		}
+	}
+	if err := admin(utils.AdminUnfreezeUser, 1, 0); utils.ErrorCodeOf(err) != utils.CodeInvalidRequest {
+		t.Fatalf("unfreeze of a user that is not frozen: %v", err)
+	}
+
+	if err := admin(utils.AdminFreezeWallet, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	deposit := utils.WalletOperationPayload{Wallet1: 1, Amount: 10, Action: utils.WalletDeposit}
+	if err := sm.ApplyWalletOperation(deposit); utils.ErrorCodeOf(err) != utils.CodeWalletFrozen {
+		t.Fatalf("deposit to a frozen wallet: %v", err)
+	}
+	if err := admin(utils.AdminUnfreezeWallet, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.ApplyWalletOperation(deposit); err != nil || balance(t, sm, 1) != 110 {
+		t.Fatalf("deposit after the freeze was lifted: balance %d, %v", balance(t, sm, 1), err)
+	}
+}
+
+func TestCloseAccount(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid", IdentificationNumber: "1",
+		Status: utils.AccountActive},
+		&models.User{UserID: 2, Email: "two@test.invalid", IdentificationNumber: "2", Status: utils.AccountActive},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 70}, &models.Wallet{WalletID: 2, UserID: 1, Balance: 30},
+		&models.Wallet{WalletID: 3, UserID: 2})
+	closeAccount := func(sweep int) error {
+		return sm.ApplyUserOperation(utils.UserPayload{UserID: 1, SweepWalletID: sweep,
+			Action: utils.UserDeleteAccount})
+	}
+	if err := closeAccount(0); utils.ErrorCodeOf(err) != utils.CodeBalanceRemaining {
+		t.Fatalf("close of an account holding funds without a sweep wallet: %v", err)
+	}
+	if err := closeAccount(2); utils.ErrorCodeOf(err) != utils.CodeInvalidRequest {
+		t.Fatalf("sweep into a wallet of the closed account: %v", err)
+	}
+	if err := closeAccount(3); err != nil {
+		t.Fatal(err)
+	}
+	if got := []int64{balance(t, sm, 1), balance(t, sm, 2), balance(t, sm, 3)}; got[0] != 0 || got[1] != 0 || got[2] != 100 {
+		t.Fatalf("balances after the sweep = %v, want [0 0 100]", got)
+	}
+	err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Amount: 10, Action: utils.WalletDeposit})
+	if utils.ErrorCodeOf(err) != utils.CodeWalletClosed {
+		t.Fatalf("deposit to a wallet of a closed account: %v", err)
+	}
+	if err := closeAccount(3); utils.ErrorCodeOf(err) != utils.CodeAccountClosed {
+		t.Fatalf("second close: %v", err)
+	}
+}
+
+func TestAccountOperationsUseStampedTime(t *testing.T) {
+	frozenAt := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	closedAt := frozenAt.Add(48 * time.Hour)
+	sm := openStateMachine(t)
+	create(t, sm, &models.Admin{AdminID: 1, Email: "root@test.invalid", Role: utils.RoleSuperAdmin},
+		&models.User{UserID: 1, Email: "one@test.invalid", IdentificationNumber: "1", Status: utils.AccountActive},
+		&models.User{UserID: 2, Email: "two@test.invalid", IdentificationNumber: "2", Status: utils.AccountActive,
+			Active: true},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 70}, &models.Wallet{WalletID: 2, UserID: 2},
+		&models.Schedule{ID: 1, Wallet1: 1, Wallet2: 2, Amount: 10, Status: utils.ScheduleActive})
+	user := func(userID int) models.User {
+		var user models.User
+		if err := sm.DB.First(&user, "user_id = ?", userID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	if err := sm.ApplyAdminOperations(utils.AdminPayload{AdminID: 1, UserId: 2, Action: utils.AdminFreezeUser,
+		Time: frozenAt}); err != nil {
+		t.Fatal(err)
+	}
+	if got := user(2).UpdatedAt; !got.Equal(frozenAt) {
+		t.Fatalf("frozen user updated at %v, want %v", got, frozenAt)
+	}
+	if err := sm.ApplyAdminOperations(utils.AdminPayload{AdminID: 1, UserId: 2, Action: utils.AdminUnfreezeUser,
+		Time: frozenAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sm.ApplyUserOperation(utils.UserPayload{UserID: 1, SweepWalletID: 2, Action: utils.UserDeleteAccount,
+		Time: closedAt}); err != nil {
+		t.Fatal(err)
+	}
+	if got := user(1).UpdatedAt; !got.Equal(closedAt) {
+		t.Fatalf("closed user updated at %v, want %v", got, closedAt)
+	}
+	var sweep models.WalletOperation
+	if err := sm.DB.First(&sweep, "wallet1 = ? AND wallet2 = ?", 1, 2).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !sweep.Timestamp.Equal(closedAt) {
+		t.Fatalf("sweep recorded at %v, want %v", sweep.Timestamp, closedAt)
+	}
+	var schedule models.Schedule
+	if err := sm.DB.First(&schedule, "id = ?", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if schedule.Status != utils.ScheduleCancelled || !schedule.UpdatedAt.Equal(closedAt) {
+		t.Fatalf("schedule of the closed account %s at %v, want cancelled at %v", schedule.Status,
+			schedule.UpdatedAt, closedAt)
+	}
+}
+
+func TestRejectedOperationsKeepTheirReason(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid", Status: utils.AccountActive},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 100})
+	at := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	rejected := []struct {
+		payload utils.WalletOperationPayload
+		code    utils.ErrorCode
+	}{
+		{utils.WalletOperationPayload{Wallet1: 1, Amount: 500, Action: utils.WalletWithdraw, Time: at},
+			utils.CodeInsufficientFunds},
+		{utils.WalletOperationPayload{Wallet1: 1, Wallet2: 9, Amount: 10, Action: utils.WalletTransfer, Time: at},
+			utils.CodeWalletNotFound},
+		{utils.WalletOperationPayload{Wallet1: 1, Amount: 10, Action: "loan", Time: at},
+			utils.CodeUnsupportedOperation},
+	}
+	for _, r := range rejected {
+		err := sm.ApplyWalletOperation(r.payload)
+		if utils.ErrorCodeOf(err) != r.code {
+			t.Fatalf("%s of %d: %v, want %s", r.payload.Action, r.payload.Amount, err, r.code)
+		}
+		var op models.WalletOperation
+		if err := sm.DB.Order("id DESC").First(&op).Error; err != nil {
+			t.Fatal(err)
+		}
+		if op.Status != utils.TxFailed || op.ErrorCode != r.code || op.ErrorMessage != err.Error() ||
+			!op.Timestamp.Equal(at) {
+			t.Fatalf("recorded %+v for %v", op, err)
+		}
+		if _, ok := utils.ErrorCatalog[r.code]; !ok {
+			t.Fatalf("%s is not documented", r.code)
+		}
+	}
+	if got := balance(t, sm, 1); got != 100 {
+		t.Fatalf("balance %d after rejected operations, want 100", got)
+	}
+}
+
+func TestAdminRoles(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid"},
+		&models.Wallet{WalletID: 1, UserID: 1})
+	// every operation is stamped a minute after the previous one
+	at := time.Date(2024, time.February, 1, 9, 0, 0, 0, time.UTC)
+	applied := 0
+	apply := func(p utils.AdminPayload) error {
+		t.Helper()
+		p.Time = at.Add(time.Duration(applied) * time.Minute)
+		applied++
+		return sm.ApplyAdminOperations(p)
+	}
+	// the bootstrap admin is only accepted while there is no admin
+	if err := apply(utils.AdminPayload{Email: "root@test.invalid", Action: utils.AdminCreateAccount}); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(utils.AdminPayload{Email: "late@test.invalid", Action: utils.AdminCreateAccount}); utils.ErrorCodeOf(err) != utils.CodeForbidden {
+		t.Fatalf("second bootstrap admin: %v", err)
+	}
+	if err := apply(utils.AdminPayload{AdminID: 1, Email: "kyc@test.invalid", Role: utils.RoleKYCOfficer,
+		Action: utils.AdminCreateAccount}); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		payload utils.AdminPayload
+		want    utils.ErrorCode
+	}{
+		{utils.AdminPayload{AdminID: 2, UserId: 1, Action: utils.AdminValidateUser}, ""},
+		{utils.AdminPayload{AdminID: 2, WalletID: 1, Action: utils.AdminFreezeWallet}, utils.CodeForbidden},
+		{utils.AdminPayload{AdminID: 2, Email: "new@test.invalid", Action: utils.AdminCreateAccount}, utils.CodeForbidden},
+		{utils.AdminPayload{AdminID: 2, TargetAdminID: 2, Role: utils.RoleSuperAdmin, Action: utils.AdminSetRole},
+			utils.CodeForbidden},
+		{utils.AdminPayload{AdminID: 9, UserId: 1, Action: utils.AdminFreezeUser}, utils.CodeAdminNotFound},
+		{utils.AdminPayload{AdminID: 1, TargetAdminID: 1, Role: utils.RoleFinance, Action: utils.AdminSetRole},
+			utils.CodeInvalidRequest},
+		{utils.AdminPayload{AdminID: 1, TargetAdminID: 2, Role: "owner", Action: utils.AdminSetRole},
+			utils.CodeInvalidRequest},
+		{utils.AdminPayload{AdminID: 1, TargetAdminID: 2, Role: utils.RoleFinance, Action: utils.AdminSetRole}, ""},
+		{utils.AdminPayload{AdminID: 2, WalletID: 1, Action: utils.AdminFreezeWallet}, ""},
+		{utils.AdminPayload{AdminID: 2, UserId: 1, Action: utils.AdminFreezeUser}, utils.CodeForbidden},
+	}
+	for i, step := range steps {
+		err := apply(step.payload)
+		if got := utils.ErrorCodeOf(err); err != nil && got != step.want || err == nil && step.want != "" {
+			t.Fatalf("step %d: %s by admin %d: %v, want %q", i, step.payload.Action, step.payload.AdminID, err,
+				step.want)
+		}
+	}
+
+	// every operation is audited, rejected ones with their reason
+	var audit []models.AdminAudit
+	if err := sm.DB.Order("id asc").Find(&audit).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 3+len(steps) {
+		t.Fatalf("%d operations audited, want %d", len(audit), 3+len(steps))
+	}
+	for i, a := range audit {
+		if want := at.Add(time.Duration(i) * time.Minute); !a.Timestamp.Equal(want) {
+			t.Fatalf("operation %d audited at %v, want the stamped %v", i, a.Timestamp, want)
+		}
+	}
+	for i, step := range steps {
+		a := audit[3+i]
+		wantStatus := utils.TxSuccess
+		if step.want != "" {
+			wantStatus = utils.TxFailed
+		}
+		if a.AdminID != step.payload.AdminID || a.Action != step.payload.Action || a.Status != wantStatus ||
+			a.ErrorCode != step.want {
+			t.Fatalf("step %d audited as %+v", i, a)
+		}
+	}
+}
+
+// the blind indexes are computed with the index key, they are computed again when the pii keys are configured
+func TestBlindIndexesFollowTheIndexKey(t *testing.T) {
+	if err := pii.Init(config.PIIConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pii.Init(config.PIIConfig{}) })
+	path := filepath.Join(t.TempDir(), "state.db")
+	sm, err := InitStateMachine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signup := func(sm *StateMachine, email, idNumber string) error {
+		return sm.ApplyUserOperation(utils.UserPayload{Email: email, IdentificationNumber: idNumber,
+			Action: utils.UserCreateAccount})
+	}
+	for _, email := range []string{"alice@test.invalid", "bob@test.invalid"} {
+		if err := signup(sm, email, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sm.ApplyUserOperation(utils.UserPayload{UserID: 2, Action: utils.UserEraseAccount}); err != nil {
+		t.Fatal(err)
+	}
+	unkeyed := Pseudonym(2)
+	sm.Close()
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	if err := pii.Init(config.PIIConfig{ActiveKey: "k1", Keys: map[string]string{"k1": key}, IndexKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	if sm, err = InitStateMachine(path); err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Close()
+	var alice, bob models.User
+	sm.DB.First(&alice, "user_id = ?", 1)
+	sm.DB.First(&bob, "user_id = ?", 2)
+	if alice.IdentificationHash == nil || *alice.IdentificationHash != pii.BlindIndex("alice@test.invalid") {
+		t.Fatalf("identification indexed as %v after the index key changed", alice.IdentificationHash)
+	}
+	if bob.Pseudonym == unkeyed || bob.Pseudonym != Pseudonym(2) || bob.Email != ErasedEmail(2) {
+		t.Fatalf("erased user kept pseudonym %q and email %q", bob.Pseudonym, bob.Email)
+	}
+	// the recomputed index still refuses a second account with the same identification number
+	if err := signup(sm, "eve@test.invalid", "alice@test.invalid"); utils.ErrorCodeOf(err) != utils.CodeAlreadyExists {
+		t.Fatalf("signup with the identification number of another user: %v", err)
+	}
+}
+
+func TestEraseAccount(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, FirstName: "Alice", Email: "alice@test.invalid", IdentificationNumber: "A1",
+		IdentificationImageFront: "front", Status: utils.AccountActive},
+		&models.User{UserID: 2, Email: "two@test.invalid", Status: utils.AccountActive},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 40}, &models.Wallet{WalletID: 2, UserID: 2})
+	if err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Wallet2: 2, Amount: 10,
+		Action: utils.WalletTransfer}); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)
+	erase := utils.UserPayload{UserID: 1, SweepWalletID: 2, Action: utils.UserEraseAccount, Time: at}
+	if err := sm.ApplyUserOperation(erase); err != nil {
+		t.Fatal(err)
+	}
+	var user models.User
+	if err := sm.DB.First(&user, "user_id = ?", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Status != utils.AccountClosed || user.ErasedAt == nil || user.Pseudonym != Pseudonym(1) ||
+		user.FirstName != ErasedName || user.Email != ErasedEmail(1) || user.IdentificationNumber != "" ||
+		user.IdentificationImageFront != "" {
+		t.Fatalf("erased user %+v", user)
+	}
+	// every replica records the erasure at the time the leader stamped it
+	if !user.ErasedAt.Equal(at) || !user.UpdatedAt.Equal(at) {
+		t.Fatalf("user erased at %v and updated at %v, want %v", user.ErasedAt, user.UpdatedAt, at)
+	}
+	// the funds are swept and the operations stay
+	if got := balance(t, sm, 2); got != 40 {
+		t.Fatalf("balance of the sweep wallet %d, want 40", got)
+	}
+	var operations int64
+	sm.DB.Model(&models.WalletOperation{}).Where("wallet1 = ?", 1).Count(&operations)
+	if operations != 2 {
+		t.Fatalf("%d operations of the erased wallet, want the transfer and the sweep", operations)
+	}
+	if _, err := sm.ErasableUser(1); err == nil {
+		t.Fatal("an erased user can be erased again")
+	}
+	if err := sm.ApplyUserOperation(erase); utils.ErrorCodeOf(err) != utils.CodeAccountErased {
+		t.Fatalf("second erasure: %v", err)
+	}
+}
+
+func TestDueAt(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		interval   utils.ScheduleInterval
+		occurrence int
+		want       time.Time
+	}{
+		{utils.IntervalDaily, 1, start},
+		{utils.IntervalDaily, 2, time.Date(2024, time.February, 1, 9, 0, 0, 0, time.UTC)},
+		{utils.IntervalWeekly, 3, time.Date(2024, time.February, 14, 9, 0, 0, 0, time.UTC)},
+		// monthly dates stick to the last day of shorter months, and come back after them
+		{utils.IntervalMonthly, 2, time.Date(2024, time.February, 29, 9, 0, 0, 0, time.UTC)},
+		{utils.IntervalMonthly, 3, time.Date(2024, time.March, 31, 9, 0, 0, 0, time.UTC)},
+		{utils.IntervalMonthly, 4, time.Date(2024, time.April, 30, 9, 0, 0, 0, time.UTC)},
+		{utils.IntervalMonthly, 14, time.Date(2025, time.February, 28, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := dueAt(start, tt.interval, tt.occurrence); !got.Equal(tt.want) {
+			t.Errorf("%s occurrence %d due %v, want %v", tt.interval, tt.occurrence, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleExecution(t *testing.T) {
+	sm := openStateMachine(t)
+	create(t, sm, &models.User{UserID: 1, Email: "one@test.invalid", Status: utils.AccountActive},
+		&models.Wallet{WalletID: 1, UserID: 1, Balance: 150}, &models.Wallet{WalletID: 2, UserID: 1})
+	start := time.Date(2024, time.January, 31, 9, 0, 0, 0, time.UTC)
+	if err := sm.ApplyWalletOperation(utils.WalletOperationPayload{Wallet1: 1, Wallet2: 2, Amount: 100,
+		Action: utils.WalletScheduleCreate, Interval: utils.IntervalMonthly, StartAt: start, MaxRetries: 1,
+		RetryDelaySeconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+	schedule := func() models.Schedule {
+		var s models.Schedule
+		if err := sm.DB.First(&s).Error; err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	execute := func(occurrence, attempt int, at time.Time) error {
+		return sm.ApplyWalletOperation(utils.WalletOperationPayload{ScheduleID: schedule().ID,
+			Occurrence: occurrence, Attempt: attempt, Amount: 1, Time: at})
+	}
+	if err := execute(1, 0, start); err != nil {
+		t.Fatal(err)
+	}
+	// a second proposal for the same attempt moves nothing and fails as stale
+	if err := execute(1, 0, start); utils.ErrorCodeOf(err) != utils.CodeScheduleStale {
+		t.Fatalf("second execution of the same attempt: %v", err)
+	}
+	if balance(t, sm, 1) != 50 || balance(t, sm, 2) != 100 {
+		t.Fatalf("balances %d and %d after the first occurrence", balance(t, sm, 1), balance(t, sm, 2))
+	}
+	feb := time.Date(2024, time.February, 29, 9, 0, 0, 0, time.UTC)
+	if s := schedule(); s.Occurrence != 2 || !s.NextRunAt.Equal(feb) || !s.UpdatedAt.Equal(start) {
+		t.Fatalf("next occurrence %d at %v, updated at %v", s.Occurrence, s.NextRunAt, s.UpdatedAt)
+	}
+	if err := execute(2, 0, feb); utils.ErrorCodeOf(err) != utils.CodeInsufficientFunds {
+		t.Fatalf("occurrence without the funds: %v", err)
+	}
+	if s := schedule(); s.Occurrence != 2 || s.Attempt != 1 || !s.NextRunAt.Equal(feb.Add(time.Minute)) {
+		t.Fatalf("retry of occurrence %d attempt %d at %v", s.Occurrence, s.Attempt, s.NextRunAt)
+	}
+	if due, _ := sm.DueSchedules(feb); len(due) != 0 {
+		t.Fatal("schedule due before its retry delay")
+	}
+	if err := execute(2, 1, feb.Add(time.Minute)); utils.ErrorCodeOf(err) != utils.CodeInsufficientFunds {
+		t.Fatalf("retry without the funds: %v", err)
+	}
+	// the retries are spent, the schedule moves to the next occurrence
+	mar := time.Date(2024, time.March, 31, 9, 0, 0, 0, time.UTC)
+	if s := schedule(); s.Occurrence != 3 || s.Attempt != 0 || !s.NextRunAt.Equal(mar) {
+		t.Fatalf("after the retries occurrence %d attempt %d at %v", s.Occurrence, s.Attempt, s.NextRunAt)
+	}
+	var executions []models.ScheduleExecution
+	sm.DB.Order("id").Find(&executions)
+	if len(executions) != 3 || executions[1].Status != utils.TxFailed || !executions[1].RetryScheduled ||
+		executions[2].RetryScheduled || executions[2].ErrorCode != utils.CodeInsufficientFunds {
+		t.Fatalf("executions %+v", executions)
+	}
+
+	pause := utils.WalletOperationPayload{ScheduleID: schedule().ID, Wallet1: 1, Action: utils.WalletSchedulePause,
+		Time: mar}
+	if err := sm.ApplyWalletOperation(pause); err != nil {
+		t.Fatal(err)
+	}
+	if s := schedule(); s.Status != utils.SchedulePaused || !s.UpdatedAt.Equal(mar) {
+		t.Fatalf("schedule %s at %v after the pause", s.Status, s.UpdatedAt)
+	}
+	if due, _ := sm.DueSchedules(mar); len(due) != 0 {
+		t.Fatal("paused schedule is due")
+	}
+	if err := execute(3, 0, mar); utils.ErrorCodeOf(err) != utils.CodeScheduleStale || balance(t, sm, 2) != 100 {
+		t.Fatalf("execution of a paused schedule moved funds, %v", err)
+	}
+	pause.Wallet1 = 2
+	if err := sm.ApplyWalletOperation(pause); utils.ErrorCodeOf(err) != utils.CodeInvalidRequest {
+		t.Fatalf("pause by a wallet that does not fund the schedule: %v", err)
+	}
+}
@@ -1,9 +1,10 @@
 package state
 
 import (
+	"errors"
 	"fmt"
+	"raft/pii"
 	"raft/utils"
-	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -22,42 +23,11 @@ type MetaState struct {
 	ID          int `gorm:"primaryKey"` // Always 1, singleton pattern
 	CurrentTerm int32
 	VotedFor    string
-}
-
-// Log entries are stored in their own table
-type UserPayload struct {
-	ID                       uint `gorm:"primaryKey"`
-	FirstName                *string
-	LastName                 *string
-	HashedPassword           *string
-	Email                    *string
-	DateOfBirth              *time.Time
-	IdentificationNumber     *string
-	IdentificationImageFront *string
-	IdentificationImageBack  *string
-	PrevPW                   *string
-	NewPW                    *string
-	UserID                   *int
-	Action                   utils.UserAction
-}
-
-type AdminPayload struct {
-	ID             uint `gorm:"primaryKey"`
-	FirstName      *string
-	LastName       *string
-	HashedPassword *string
-	Email          *string
-	AdminID        *int
-	UserId         *int
-	Action         utils.AdminAction
-}
-
-type WalletOperationPayload struct {
-	ID      uint `gorm:"primaryKey"`
-	Wallet1 int
-	Wallet2 *int
-	Amount  int64
-	Action  utils.WalletAction
+	// last log index covered by a snapshot of the node
+	SnapshotIndex int32 `gorm:"default:0"`
+	// last entry dropped by CompactPrefix, the log holds the entries that follow it
+	CompactedIndex int32 `gorm:"default:0"`
+	CompactedTerm  int32 `gorm:"default:0"`
 }
 
 type LogEntry struct {
@@ -66,22 +36,38 @@ type LogEntry struct {
 	ReferenceTable utils.RefTable
 	Status         utils.TransactionStatus `gorm:"default:'pending'"`
 	Applied        bool                    `gorm:"default:false"`
-	PayloadID      uint
+	ErrorCode      utils.ErrorCode         `gorm:"default:''"` // why the state machine rejected the entry
+	ErrorMessage   string                  `gorm:"default:''"`
 	PollID         string
+	Command        []byte `json:"-"` // encoded pb.Command, the operation of the entry
+	Checksum       uint32 `json:"-"` // crc32c of index, term and command
 }
 
+// the log is written ahead and every commit reaches the disk before it returns, so what a node tells
+// its peers it holds, its term and its vote survive a crash. The settings apply to each connection
+const durableParams = "?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=5000"
+
 // Initialize the Database and Auto-Migrate
 func InitPersistentState(filePath string) (*PersistentState, error) {
-	db, err := gorm.Open(sqlite.Open(filePath), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(filePath+durableParams), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
+	if err := checkDurability(db); err != nil {
+		return nil, err
+	}
 
 	// Migrate the schema
-	err = db.AutoMigrate(&MetaState{}, &UserPayload{}, &WalletOperationPayload{}, &AdminPayload{}, &LogEntry{})
+	err = db.AutoMigrate(&MetaState{}, &LogEntry{}, &Erasure{}, &Member{})
 	if err != nil {
 		return nil, err
 	}
+	if err := migrateCommands(db); err != nil {
+		return nil, err
+	}
+	if err := migrateChecksums(db); err != nil {
+		return nil, err
+	}
 
 	// Initialize singleton meta state if not present
 	var meta MetaState
@@ -93,6 +79,36 @@ func InitPersistentState(filePath string) (*PersistentState, error) {
 	return defaultStorage, nil
 }
 
+// Close writes back what sqlite still holds in its write ahead log, if any, and closes the log
+func (ps *PersistentState) Close() error {
+	if err := ps.DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+		return fmt.Errorf("failed to checkpoint log: %w", err)
+	}
+	db, err := ps.DB.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// checkDurability makes sure sqlite took the journal and sync settings, it silently keeps its own
+// otherwise, for instance for a database in memory
+func checkDurability(db *gorm.DB) error {
+	var journal string
+	var synchronous int
+	if err := db.Raw("PRAGMA journal_mode").Scan(&journal).Error; err != nil {
+		return fmt.Errorf("failed to read journal mode: %w", err)
+	}
+	if err := db.Raw("PRAGMA synchronous").Scan(&synchronous).Error; err != nil {
+		return fmt.Errorf("failed to read synchronous setting: %w", err)
+	}
+	// 2 is FULL
+	if journal != "wal" || synchronous != 2 {
+		return fmt.Errorf("log opened with journal mode %s and synchronous %d, want wal and full", journal, synchronous)
+	}
+	return nil
+}
+
 // Read/Write Methods for MetaState
 func (ps *PersistentState) SetCurrentTerm(term int32) error {
 	return ps.DB.Model(&MetaState{}).Where("id = ?", 1).Update("current_term", term).Error
@@ -104,6 +120,42 @@ func (ps *PersistentState) GetCurrentTerm() (int32, error) {
 	return meta.CurrentTerm, err
 }
 
+func (ps *PersistentState) SetSnapshotIndex(index int32) error {
+	return ps.DB.Model(&MetaState{}).Where("id = ?", 1).Update("snapshot_index", index).Error
+}
+
+func (ps *PersistentState) GetSnapshotIndex() (int32, error) {
+	var meta MetaState
+	err := ps.DB.First(&meta, 1).Error
+	return meta.SnapshotIndex, err
+}
+
+// ResealPII seals the personal data of the user commands up to index again under the active key
+func (ps *PersistentState) ResealPII(index int32) (int, error) {
+	if !pii.Enabled() {
+		return 0, nil
+	}
+	stored, err := userCommands(ps.DB.Where("`index` <= ?", index))
+	if err != nil {
+		return 0, err
+	}
+	resealed := 0
+	for _, uc := range stored {
+		changed, err := resealUserPII(uc.payload)
+		if err != nil {
+			return resealed, fmt.Errorf("failed to reseal entry %d: %w", uc.index, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := uc.save(ps.DB); err != nil {
+			return resealed, fmt.Errorf("failed to reseal entry %d: %w", uc.index, err)
+		}
+		resealed++
+	}
+	return resealed, nil
+}
+
 func GetCurrentTermFromAPI() (int32, error) {
 	var meta MetaState
 	err := defaultStorage.DB.First(&meta, 1).Error
@@ -114,119 +166,66 @@ func (ps *PersistentState) SetVotedFor(candidateID string) error {
 	return ps.DB.Model(&MetaState{}).Where("id = ?", 1).Update("voted_for", candidateID).Error
 }
 
-func (ps *PersistentState) GetVotedFor() (string, error) {
-	var meta MetaState
-	err := ps.DB.First(&meta, 1).Error
-	return meta.VotedFor, err
+// SetTermAndVote moves to term with the given vote, empty for none, in a single write so a crash never
+// leaves the vote of a former term in the new one
+func (ps *PersistentState) SetTermAndVote(term int32, candidateID string) error {
+	return ps.DB.Model(&MetaState{}).Where("id = ?", 1).
+		Updates(map[string]interface{}{"current_term": term, "voted_for": candidateID}).Error
 }
 
-// Managing Log Entries (Append, Read, Delete)
-func (ps *PersistentState) AppendLogEntry(payloads []utils.Payload) error {
-	return ps.DB.Transaction(func(tx *gorm.DB) error {
-		var lastIndexPtr *int
-		if err := tx.Model(&LogEntry{}).Select("MAX(`index`)").Scan(&lastIndexPtr).Error; err != nil {
-			return fmt.Errorf("failed to get last log index: %w", err)
+// AdvanceTerm moves to a later term without a vote. It reports false when the log already reached term,
+// so a vote cast in it, the node's own included, is never cleared
+func (ps *PersistentState) AdvanceTerm(term int32) (bool, error) {
+	res := ps.DB.Model(&MetaState{}).Where("id = ? AND current_term < ?", 1, term).
+		Updates(map[string]interface{}{"current_term": term, "voted_for": ""})
+	return res.RowsAffected == 1, res.Error
+}
+
+// GrantVote records a vote for candidate in term, checked and written in one statement. It reports
+// false when the log moved to another term or voted for another node in this one
+func (ps *PersistentState) GrantVote(term int32, candidateID string) (bool, error) {
+	res := ps.DB.Model(&MetaState{}).
+		Where("id = ? AND current_term = ? AND voted_for IN ('', ?)", 1, term, candidateID).
+		Update("voted_for", candidateID)
+	return res.RowsAffected == 1, res.Error
+}
+
+// Campaign moves to the term after the current one and votes for candidate in it, in one statement so
+// a vote granted meanwhile for the next term is not overwritten. It returns the new term
+func (ps *PersistentState) Campaign(candidateID string) (int32, error) {
+	for {
+		term, err := ps.GetCurrentTerm()
+		if err != nil {
+			return 0, err
 		}
-		lastIndex := 0
-		if lastIndexPtr != nil {
-			lastIndex = *lastIndexPtr
+		res := ps.DB.Model(&MetaState{}).Where("id = ? AND current_term = ?", 1, term).
+			Updates(map[string]interface{}{"current_term": term + 1, "voted_for": candidateID})
+		if res.Error != nil {
+			return 0, res.Error
 		}
-		for i, p := range payloads {
-			refTable := p.GetRefTable()
-			nextIndex := lastIndex + i + 1
-			switch refTable {
-			case utils.RefUser:
-				payload, ok := p.(utils.UserPayload)
-				if ok {
-					userPayload := UserPayload{
-						FirstName:                &payload.FirstName,
-						LastName:                 &payload.LastName,
-						HashedPassword:           &payload.HashedPassword,
-						Email:                    &payload.Email,
-						DateOfBirth:              &payload.DateOfBirth,
-						IdentificationNumber:     &payload.IdentificationNumber,
-						IdentificationImageFront: &payload.IdentificationImageFront,
-						IdentificationImageBack:  &payload.IdentificationImageBack,
-						PrevPW:                   &payload.PrevPW,
-						NewPW:                    &payload.NewPW,
-						UserID:                   &payload.UserID,
-						Action:                   payload.Action,
-					}
-					if err := tx.Create(&userPayload).Error; err != nil {
-						return fmt.Errorf("failed to created the associated user payload:%w", err)
-					}
-					logEntry := LogEntry{
-						Index: nextIndex, Term: payload.Term, ReferenceTable: refTable, PayloadID: userPayload.ID, PollID: payload.PollID,
-					}
-					if err := tx.Create(&logEntry).Error; err != nil {
-						return fmt.Errorf("failed to create the log entry:%w", err)
-					}
-					return nil
-				} else {
-					return fmt.Errorf("failed to cast payload to UserPayload")
-				}
-			case utils.RefAdmin:
-				payload, ok := p.(utils.AdminPayload)
-				fmt.Println(payload.Term)
-				if ok {
-					adminPayload := AdminPayload{
-						FirstName:      &payload.FirstName,
-						LastName:       &payload.LastName,
-						Email:          &payload.Email,
-						HashedPassword: &payload.HashedPassword,
-						AdminID:        &payload.AdminID,
-						UserId:         &payload.UserId,
-						Action:         payload.Action,
-					}
-					if err := tx.Create(&adminPayload).Error; err != nil {
-						return fmt.Errorf("failed to create admin payload: %w", err)
-					}
-					logEntry := LogEntry{
-						Index: nextIndex, Term: payload.Term, ReferenceTable: refTable, PayloadID: adminPayload.ID, PollID: payload.PollID,
-					}
-					if err := tx.Create(&logEntry).Error; err != nil {
-						return fmt.Errorf("failed to create log entry for admin payload:%w", err)
-					}
-					return nil
-				} else {
-					return fmt.Errorf("failed to cast payload as AdminPayload")
-				}
-			case utils.RefWallet:
-				payload, ok := p.(utils.WalletOperationPayload)
-				if ok {
-					walletPayload := WalletOperationPayload{
-						Wallet1: payload.Wallet1,
-						Wallet2: &payload.Wallet2,
-						Amount:  payload.Amount,
-						Action:  payload.Action,
-					}
-					if err := tx.Create(&walletPayload).Error; err != nil {
-						return fmt.Errorf("failed to create wallet payload: %w", err)
-					}
-					logEntry := LogEntry{
-						Index: nextIndex, Term: payload.Term, ReferenceTable: refTable, PayloadID: walletPayload.ID, PollID: payload.PollID,
-					}
-					if err := tx.Create(&logEntry).Error; err != nil {
-						return fmt.Errorf("failed to create log entry for wallet payload:%w", err)
-					}
-					return nil
-				} else {
-					return fmt.Errorf("failed to cast payload as wallet operation")
-				}
-			default:
-				return fmt.Errorf("unsupported operation: %s", refTable)
-			}
+		if res.RowsAffected == 1 {
+			return term + 1, nil
 		}
-		return nil
-	})
+	}
+}
+
+func (ps *PersistentState) GetVotedFor() (string, error) {
+	var meta MetaState
+	err := ps.DB.First(&meta, 1).Error
+	return meta.VotedFor, err
 }
 
 func GetLogEntryForApi(poll string) (*LogEntry, error) {
 	if defaultStorage == nil {
 		return nil, fmt.Errorf("storage not yet initialized")
 	}
+	return defaultStorage.GetLogEntryByPoll(poll)
+}
+
+// GetLogEntryByPoll returns the entry proposed under the poll id of a client request
+func (ps *PersistentState) GetLogEntryByPoll(poll string) (*LogEntry, error) {
 	var entry LogEntry
-	err := defaultStorage.DB.First(&entry, "poll_id = ?", poll).Error
+	err := ps.DB.First(&entry, "poll_id = ?", poll).Error
 	return &entry, err
 }
 
@@ -251,10 +250,34 @@ func (ps *PersistentState) GetLastLogEntry() (LogEntry, error) {
 	return entry, err
 }
 
+// LastIndexAndTerm returns the position of the last entry of the log, the last compacted one when the
+// log holds none, zeros for an empty log
+func (ps *PersistentState) LastIndexAndTerm() (int32, int32, error) {
+	return lastIndexAndTerm(ps.DB)
+}
+
+func lastIndexAndTerm(db *gorm.DB) (int32, int32, error) {
+	var entry LogEntry
+	err := db.Order("`index` desc").First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var meta MetaState
+		if err := db.First(&meta, 1).Error; err != nil {
+			return 0, 0, err
+		}
+		return meta.CompactedIndex, meta.CompactedTerm, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return int32(entry.Index), entry.Term, nil
+}
+
 func (ps *PersistentState) DeleteLogEntriesFrom(index int) error {
 	return ps.DB.Where("`index` >= ?", index).Delete(&LogEntry{}).Error
 }
 
+// GetLogLength returns the number of entries the log holds, fewer than the last index once a prefix is
+// compacted
 func (ps *PersistentState) GetLogLength() (int64, error) {
 	var count int64
 	err := ps.DB.Model(&LogEntry{}).Count(&count).Error
@@ -264,6 +287,7 @@ func (ps *PersistentState) GetLogLength() (int64, error) {
 	return count, nil
 }
 
+// GetCommandsFromIndex returns the entries from startIndex on, it fails on a corrupted entry
 func (ps *PersistentState) GetCommandsFromIndex(startIndex int) ([]LogEntry, error) {
 	var entries []LogEntry
 
@@ -273,9 +297,13 @@ func (ps *PersistentState) GetCommandsFromIndex(startIndex int) ([]LogEntry, err
 	if err != nil {
 		return nil, fmt.Errorf("error getting entries: %w", err)
 	}
+	if err := verifyEntries(entries); err != nil {
+		return nil, err
+	}
 	return entries, nil
 }
 
+// GetEntriesForCommit returns the entries after lastApplied up to commitIndex, it fails on a corrupted entry
 func (ps *PersistentState) GetEntriesForCommit(lastApplied, commitIndex int) ([]LogEntry, error) {
 	var entries []LogEntry
 	err := ps.DB.Model(&LogEntry{}).
@@ -284,5 +312,8 @@ func (ps *PersistentState) GetEntriesForCommit(lastApplied, commitIndex int) ([]
 	if err != nil {
 		return nil, fmt.Errorf("error getting entries: %w", err)
 	}
+	if err := verifyEntries(entries); err != nil {
+		return nil, err
+	}
 	return entries, nil
 }
@@ -0,0 +1,192 @@
+package state
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	pb "raft/raft"
+	"raft/utils"
+)
+
+func openLog(t *testing.T) *PersistentState {
+	t.Helper()
+	ps, err := InitPersistentState(filepath.Join(t.TempDir(), "log.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return ps
+}
+
+func TestGrantVoteOncePerTerm(t *testing.T) {
+	ps := openLog(t)
+	if _, err := ps.AdvanceTerm(3); err != nil {
+		t.Fatal(err)
+	}
+	candidates := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	granted := make([]bool, len(candidates))
+	var wg sync.WaitGroup
+	for i, c := range candidates {
+		wg.Add(1)
+		go func(i int, c string) {
+			defer wg.Done()
+			ok, err := ps.GrantVote(3, c)
+			if err != nil {
+				t.Error(err)
+			}
+			granted[i] = ok
+		}(i, c)
+	}
+	wg.Wait()
+	winners := 0
+	for _, ok := range granted {
+		if ok {
+			winners++
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("%d candidates got the vote of term 3, want 1", winners)
+	}
+	votedFor, _ := ps.GetVotedFor()
+	if ok, _ := ps.GrantVote(3, votedFor); !ok {
+		t.Fatalf("the vote for %s was not granted again", votedFor)
+	}
+	if ok, _ := ps.GrantVote(2, votedFor); ok {
+		t.Fatal("a vote was granted in a former term")
+	}
+}
+
+func TestAdvanceTermKeepsOwnVote(t *testing.T) {
+	ps := openLog(t)
+	term, err := ps.Campaign("self")
+	if err != nil || term != 1 {
+		t.Fatalf("Campaign = %d, %v, want term 1", term, err)
+	}
+	// a request vote of term 1 read the log before the campaign
+	if moved, _ := ps.AdvanceTerm(1); moved {
+		t.Fatal("advancing to the current term cleared the vote")
+	}
+	if ok, _ := ps.GrantVote(1, "other"); ok {
+		t.Fatal("another candidate got the vote the node cast for itself")
+	}
+	if moved, _ := ps.AdvanceTerm(2); !moved {
+		t.Fatal("did not advance to a later term")
+	}
+	votedFor, _ := ps.GetVotedFor()
+	if votedFor != "" {
+		t.Fatalf("voted for %q in a new term, want no vote", votedFor)
+	}
+	if term, _ := ps.Campaign("self"); term != 3 {
+		t.Fatalf("campaigned in term %d, want 3", term)
+	}
+}
+
+// proposeTransfers appends a transfer for each of polls, in term
+func proposeTransfers(t *testing.T, store LogStore, term int32, polls ...string) {
+	t.Helper()
+	var payloads []utils.Payload
+	for _, poll := range polls {
+		payloads = append(payloads, utils.WalletOperationPayload{Wallet1: 1, Wallet2: 2, Amount: 10,
+			Action: utils.WalletTransfer, PollID: poll, Term: term})
+	}
+	if err := appendPayloads(store, payloads); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkLast(t *testing.T, store LogStore, index, term int32) {
+	t.Helper()
+	gotIndex, gotTerm, err := store.LastIndexAndTerm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotIndex != index || gotTerm != term {
+		t.Fatalf("last entry %d of term %d, want %d of term %d", gotIndex, gotTerm, index, term)
+	}
+}
+
+func TestCompactPrefixKeepsBase(t *testing.T) {
+	ps := openLog(t)
+	proposeTransfers(t, ps, 1, "a", "b", "c")
+	proposeTransfers(t, ps, 2, "d", "e")
+	if err := ps.CompactPrefix(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Entries(3, 5); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("read of a compacted entry: %v", err)
+	}
+	entries, err := ps.Entries(4, 5)
+	if err != nil || len(entries) != 2 || entries[0].GetCommand().GetPollID() != "d" {
+		t.Fatalf("read %v after the compacted prefix, %v", entries, err)
+	}
+	// with every entry compacted the log still ends where it did
+	if err := ps.CompactPrefix(5); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := ps.GetLogLength(); n != 0 {
+		t.Fatalf("%d entries left after compacting the whole log", n)
+	}
+	checkLast(t, ps, 5, 2)
+	proposeTransfers(t, ps, 3, "f")
+	checkLast(t, ps, 6, 3)
+	entry, err := EntryAt(ps, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Index = 2
+	if err := ps.Append([]*pb.LogEntry{entry}); !errors.Is(err, ErrLogGap) {
+		t.Fatalf("append of an entry before the base: %v, want a gap", err)
+	}
+}
+
+func TestMoveEntries(t *testing.T) {
+	ps := openLog(t)
+	proposeTransfers(t, ps, 1, "a", "b", "c")
+	if err := ps.recordOutcome(LogEntry{Index: 1}, utils.TxSuccess, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// another store, sqlite too so the test does not depend on package wal
+	store := openLog(t)
+	moved, err := ps.moveEntries(store)
+	if err != nil || moved != 3 {
+		t.Fatalf("moved %d entries, %v, want 3", moved, err)
+	}
+	checkLast(t, store, 3, 1)
+	entries, err := store.Entries(1, 3)
+	if err != nil || len(entries) != 3 || entries[2].GetCommand().GetPollID() != "c" {
+		t.Fatalf("moved entries %v, %v", entries, err)
+	}
+	// the rows stay as the record of the outcomes, their commands do not
+	row, err := ps.GetLogEntryByPoll("a")
+	if err != nil || !row.Applied || row.Command != nil {
+		t.Fatalf("row of the moved entry: %+v, %v", row, err)
+	}
+	if moved, err := ps.moveEntries(store); err != nil || moved != 0 {
+		t.Fatalf("moved %d entries again, %v", moved, err)
+	}
+}
+
+func TestRecordOutcome(t *testing.T) {
+	ps := openLog(t)
+	proposeTransfers(t, ps, 1, "a")
+	if err := ps.recordOutcome(LogEntry{Index: 1}, utils.TxFailed, utils.CodeInsufficientFunds,
+		"insufficient funds"); err != nil {
+		t.Fatal(err)
+	}
+	row, err := ps.GetLogEntryByPoll("a")
+	if err != nil || !row.Applied || row.Status != utils.TxFailed || row.ErrorCode != utils.CodeInsufficientFunds ||
+		row.ErrorMessage != "insufficient funds" {
+		t.Fatalf("outcome of entry 1: %+v, %v", row, err)
+	}
+	// the entries of another log store have no row until they are applied
+	entry := LogEntry{Index: 2, Term: 1, ReferenceTable: utils.RefWallet, PollID: "b"}
+	if err := ps.recordOutcome(entry, utils.TxSuccess, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	row, err = ps.GetLogEntryByPoll("b")
+	if err != nil || row.Index != 2 || !row.Applied || row.Status != utils.TxSuccess || row.ErrorCode != "" {
+		t.Fatalf("outcome of entry 2: %+v, %v", row, err)
+	}
+}
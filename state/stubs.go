@@ -12,70 +12,110 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-// requestVoteRPCStub sends a request vote RPC to the given peer address and returns true if the vote is granted
-func requestVoteRPCStub(n *Node, peerAddress string, abort context.CancelFunc) bool {
-	fmt.Printf("sending request vote to %v \n", peerAddress)
-	con, err := grpc.NewClient(fmt.Sprintf("localhost:%s", peerAddress), grpc.WithTransportCredentials(insecure.NewCredentials()))
+// Transport carries the rpcs of a node to its peers, each call gives up after timeout. The simulation
+// replaces the grpc transport by an in-memory network
+type Transport interface {
+	RequestVote(peer string, req *pb.RequestVoteRequest, timeout time.Duration) (*pb.RequestVoteResponse, error)
+	AppendEntries(peer string, req *pb.AppendEntriesRequest, timeout time.Duration) (*pb.AppendEntriesResponse, error)
+	FetchBlob(peer string, req *pb.FetchBlobRequest, timeout time.Duration) (*pb.FetchBlobResponse, error)
+}
+
+// SetTransport replaces the grpc transport of the node, it must be called before Start
+func (n *Node) SetTransport(t Transport) {
+	n.Mu.Lock()
+	defer n.Mu.Unlock()
+	n.transport = t
+}
+
+// grpcTransport reaches the peers at their address
+type grpcTransport struct{}
+
+func dial(peer string) (*grpc.ClientConn, error) {
+	return grpc.NewClient(dialTarget(peer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+func (grpcTransport) RequestVote(peer string, req *pb.RequestVoteRequest, timeout time.Duration) (*pb.RequestVoteResponse, error) {
+	con, err := dial(peer)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return pb.NewRaftClient(con).RequestVote(ctx, req)
+}
+
+func (grpcTransport) AppendEntries(peer string, req *pb.AppendEntriesRequest, timeout time.Duration) (*pb.AppendEntriesResponse, error) {
+	con, err := dial(peer)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return pb.NewRaftClient(con).AppendEntries(ctx, req)
+}
+
+// documents can be larger than the default 4MB limit of a grpc response once sealed
+const maxBlobMessageSize = 32 << 20
+
+func (grpcTransport) FetchBlob(peer string, req *pb.FetchBlobRequest, timeout time.Duration) (*pb.FetchBlobResponse, error) {
+	con, err := dial(peer)
 	if err != nil {
-		log.Printf("failed to connect to server %v:", err)
+		return nil, err
 	}
 	defer con.Close()
-	c := pb.NewRaftClient(con)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	ct, e := n.Log.GetCurrentTerm()
-	if e != nil {
-		log.Printf("could not get current term: %v", err)
+	return pb.NewRaftClient(con).FetchBlob(ctx, req, grpc.MaxCallRecvMsgSize(maxBlobMessageSize))
+}
+
+// requestVoteRPCStub sends a request vote RPC to the given peer and returns true if the vote is granted
+func requestVoteRPCStub(n *Node, peer string, ct int32, abort context.CancelFunc) bool {
+	peerAddress := n.PeerAddress(peer)
+	fmt.Printf("sending request vote to %v \n", peerAddress)
+	lastIndex, lastTerm, err := n.Log.LastIndexAndTerm()
+	if err != nil {
+		log.Printf("could not read last log entry: %v", err)
 		return false
 	}
-	vr, err := c.RequestVote(ctx, &pb.RequestVoteRequest{Term: ct,
-		CandidateId: n.Address, LastLogIndex: n.CommitIndex, LastLogTerm: n.LastApplied})
+	vr, err := n.transport.RequestVote(peerAddress, &pb.RequestVoteRequest{Term: ct,
+		CandidateId: n.ID, CandidateAddress: n.Address, LastLogIndex: lastIndex, LastLogTerm: lastTerm,
+		ClusterId: n.ClusterID}, n.timing.RPCTimeout)
 	if err != nil {
 		log.Printf("could not greet: %v", err)
 		return false
 	}
-	if vr.Term >= ct {
+	if vr.Term > ct {
 		log.Printf("node %v has a higher term %v than %v", peerAddress, vr.Term, ct)
 		abort()
 	}
 	return vr.VoteGranted
 }
 
-// SendHeartbeat sends a heartbeat to a peer and returns true based on the response of the peer
-func appendEntryRPCStub(node *Node, peer string, entrySlice []LogEntry, ct, prevLogIndex, prevLogTerm int32) (*pb.AppendEntriesResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	con, err := grpc.NewClient(fmt.Sprintf("localhost:%s", peer), grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return nil, err
-	}
-	defer con.Close()
-
-	client := pb.NewRaftClient(con)
-
-	// for each entry received, extract the term, refTable and payload
-	protoEntries := make([]*pb.LogEntry, len(entrySlice))
-	for i, entry := range entrySlice {
-		if protoEntry, err := ToProtoLogEntry(entry, node.Log.DB); err == nil {
-			protoEntries[i] = protoEntry
-		} else {
-			return nil, err
-		}
-	}
-	fmt.Println(protoEntries)
+// appendEntryRPCStub sends entries to a peer, none for a heartbeat, and returns the response of the peer
+func appendEntryRPCStub(node *Node, peer string, entries []*pb.LogEntry, ct, prevLogIndex, prevLogTerm int32) (*pb.AppendEntriesResponse, error) {
+	node.Mu.RLock()
+	commitIndex := node.CommitIndex
+	node.Mu.RUnlock()
 	req := &pb.AppendEntriesRequest{
-		Term:         ct,
-		LeaderId:     node.Address,
-		PrevLogIndex: prevLogIndex,
-		PrevLogTerm:  prevLogTerm,
-		Entries:      protoEntries, // empty for heartbeat
-		LeaderCommit: int32(node.CommitIndex),
+		Term:          ct,
+		LeaderId:      node.ID,
+		LeaderAddress: node.Address,
+		PrevLogIndex:  prevLogIndex,
+		PrevLogTerm:   prevLogTerm,
+		Entries:       entries, // empty for heartbeat
+		LeaderCommit:  commitIndex,
+		ClusterId:     node.ClusterID,
 	}
+	return node.transport.AppendEntries(node.PeerAddress(peer), req, node.timing.RPCTimeout)
+}
 
-	resp, err := client.AppendEntries(ctx, req)
+// fetchBlobRPCStub asks a peer for the blob stored under hash, as stored by the peer
+func fetchBlobRPCStub(n *Node, peer, hash string) ([]byte, error) {
+	resp, err := n.transport.FetchBlob(n.PeerAddress(peer), &pb.FetchBlobRequest{Hash: hash}, 30*time.Second)
 	if err != nil {
 		return nil, err
 	}
-	return resp, nil
+	return resp.Data, nil
 }
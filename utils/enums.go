@@ -16,6 +16,8 @@ const (
 	RefWallet RefTable = "wallet"
 	RefUser   RefTable = "user"
 	RefAdmin  RefTable = "admin"
+	// members of the cluster, written by the bootstrap
+	RefConfiguration RefTable = "configuration"
 )
 
 // CRUD operations
@@ -34,6 +36,29 @@ const (
 	WalletDeposit  WalletAction = "deposit"
 	WalletWithdraw WalletAction = "withdraw"
 	WalletTransfer WalletAction = "transfer"
+
+	WalletScheduleCreate WalletAction = "schedule_create"
+	WalletSchedulePause  WalletAction = "schedule_pause"
+	WalletScheduleResume WalletAction = "schedule_resume"
+	WalletScheduleCancel WalletAction = "schedule_cancel"
+)
+
+// How often a scheduled transfer repeats
+type ScheduleInterval string
+
+const (
+	IntervalDaily   ScheduleInterval = "daily"
+	IntervalWeekly  ScheduleInterval = "weekly"
+	IntervalMonthly ScheduleInterval = "monthly"
+)
+
+// Lifecycle of a scheduled transfer
+type ScheduleStatus string
+
+const (
+	ScheduleActive    ScheduleStatus = "active"
+	SchedulePaused    ScheduleStatus = "paused"
+	ScheduleCancelled ScheduleStatus = "cancelled"
 )
 
 // User-specific actions
@@ -44,12 +69,73 @@ const (
 	UserUpdatePassword UserAction = "update_password"
 	UserCreateWallet   UserAction = "create_wallet"
 	UserDeleteAccount  UserAction = "delete_account"
+	// closes the account if needed and erases the personal data of the user
+	UserEraseAccount UserAction = "erase_account"
+	// replaces a password stored in clear by its hash, proposed by the leader
+	UserRehashPassword UserAction = "rehash_password"
 )
 
 // Admin-specific actions
 type AdminAction string
 
 const (
-	AdminCreateAccount AdminAction = "create_admin_account"
-	AdminValidateUser  AdminAction = "validate_user"
+	AdminCreateAccount    AdminAction = "create_admin_account"
+	AdminValidateUser     AdminAction = "validate_user"
+	AdminSetFeeSchedule   AdminAction = "set_fee_schedule"
+	AdminSetSpendingLimit AdminAction = "set_spending_limit"
+	AdminSetUserTier      AdminAction = "set_user_tier"
+	AdminFreezeUser       AdminAction = "freeze_user"
+	AdminUnfreezeUser     AdminAction = "unfreeze_user"
+	AdminFreezeWallet     AdminAction = "freeze_wallet"
+	AdminUnfreezeWallet   AdminAction = "unfreeze_wallet"
+	AdminSetRole          AdminAction = "set_admin_role"
+	// replaces a password stored in clear by its hash, proposed by the leader
+	AdminRehashPassword AdminAction = "rehash_admin_password"
+)
+
+// What an admin is allowed to do, checked when admin operations are applied
+type AdminRole string
+
+const (
+	RoleSuperAdmin AdminRole = "super_admin"
+	RoleKYCOfficer AdminRole = "kyc_officer"
+	RoleFinance    AdminRole = "finance"
+	RoleReadOnly   AdminRole = "read_only"
+)
+
+// Lifecycle of users and wallets, only active accounts can move funds
+type AccountStatus string
+
+const (
+	AccountPendingKYC AccountStatus = "pending_kyc"
+	AccountActive     AccountStatus = "active"
+	AccountFrozen     AccountStatus = "frozen"
+	AccountClosed     AccountStatus = "closed"
+)
+
+// User tiers, spending limits are configured per tier
+type UserTier string
+
+const (
+	TierUnverified UserTier = "unverified"
+	TierStandard   UserTier = "standard"
+	TierPremium    UserTier = "premium"
+)
+
+// What a spending limit applies to
+type LimitScope string
+
+const (
+	LimitScopeTier   LimitScope = "tier"
+	LimitScopeUser   LimitScope = "user"
+	LimitScopeWallet LimitScope = "wallet"
+)
+
+// Fee schedule kinds
+type FeeKind string
+
+const (
+	FeeFlat       FeeKind = "flat"
+	FeePercentage FeeKind = "percentage"
+	FeeTiered     FeeKind = "tiered"
 )
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Stable codes describing why the state machine rejected an operation, clients can rely on them
+type ErrorCode string
+
+const (
+	CodeInternal             ErrorCode = "INTERNAL_ERROR"
+	CodeInvalidRequest       ErrorCode = "INVALID_REQUEST"
+	CodeUnsupportedOperation ErrorCode = "UNSUPPORTED_OPERATION"
+	CodeUserNotFound         ErrorCode = "USER_NOT_FOUND"
+	CodeAdminNotFound        ErrorCode = "ADMIN_NOT_FOUND"
+	CodeWalletNotFound       ErrorCode = "WALLET_NOT_FOUND"
+	CodeAlreadyExists        ErrorCode = "ALREADY_EXISTS"
+	CodeInsufficientFunds    ErrorCode = "INSUFFICIENT_FUNDS"
+	CodeLimitExceeded        ErrorCode = "LIMIT_EXCEEDED"
+	CodeAccountPendingKYC    ErrorCode = "ACCOUNT_PENDING_KYC"
+	CodeAccountFrozen        ErrorCode = "ACCOUNT_FROZEN"
+	CodeAccountClosed        ErrorCode = "ACCOUNT_CLOSED"
+	CodeWalletFrozen         ErrorCode = "WALLET_FROZEN"
+	CodeWalletClosed         ErrorCode = "WALLET_CLOSED"
+	CodeBalanceRemaining     ErrorCode = "BALANCE_REMAINING"
+	CodeInvalidCredentials   ErrorCode = "INVALID_CREDENTIALS"
+	CodeScheduleNotFound     ErrorCode = "SCHEDULE_NOT_FOUND"
+	CodeScheduleStale        ErrorCode = "SCHEDULE_STALE"
+	CodeForbidden            ErrorCode = "FORBIDDEN"
+	CodeAccountErased        ErrorCode = "ACCOUNT_ERASED"
+)
+
+// ErrorCatalog documents every error code, it is served as is by the API
+var ErrorCatalog = map[ErrorCode]string{
+	CodeInternal:             "the node failed to apply the operation, it is safe to retry with a new poll id",
+	CodeInvalidRequest:       "the operation carries invalid or missing parameters",
+	CodeUnsupportedOperation: "the action is not known to the state machine",
+	CodeUserNotFound:         "the referenced user does not exist",
+	CodeAdminNotFound:        "the referenced admin does not exist",
+	CodeWalletNotFound:       "the referenced wallet does not exist",
+	CodeAlreadyExists:        "an account with the same unique attributes already exists",
+	CodeInsufficientFunds:    "the wallet balance does not cover the amount and its fees",
+	CodeLimitExceeded:        "a per transaction, daily or monthly spending limit would be exceeded",
+	CodeAccountPendingKYC:    "the account has not been validated by an admin yet",
+	CodeAccountFrozen:        "the account has been frozen by an admin",
+	CodeAccountClosed:        "the account has been closed",
+	CodeWalletFrozen:         "the wallet has been frozen by an admin",
+	CodeWalletClosed:         "the wallet belongs to a closed account",
+	CodeBalanceRemaining:     "the account still holds funds and no wallet was given to sweep them to",
+	CodeInvalidCredentials:   "the supplied password does not match",
+	CodeScheduleNotFound:     "the referenced scheduled transfer does not exist",
+	CodeScheduleStale:        "the attempt of the scheduled transfer was already applied or the schedule is not active",
+	CodeForbidden:            "the admin role does not allow this action",
+	CodeAccountErased:        "the personal data of the account has already been erased",
+}
+
+// TxError is an error carrying the code recorded on failed log entries
+type TxError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *TxError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TxError) Unwrap() error {
+	return errors.Unwrap(e.Err)
+}
+
+// NewTxError formats an error like fmt.Errorf and attaches a code to it
+func NewTxError(code ErrorCode, format string, args ...interface{}) error {
+	return &TxError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorCodeOf returns the code attached to err, errors without one are internal errors
+func ErrorCodeOf(err error) ErrorCode {
+	var txErr *TxError
+	if errors.As(err, &txErr) {
+		return txErr.Code
+	}
+	return CodeInternal
+}
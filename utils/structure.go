@@ -8,6 +8,8 @@ import (
 
 type Payload interface {
 	GetRefTable() RefTable
+	GetPollID() string
+	GetTerm() int32
 }
 
 // payloads
@@ -17,39 +19,118 @@ type UserPayload struct {
 	DateOfBirth                                                                            time.Time
 	IdentificationNumber, IdentificationImageFront, IdentificationImageBack, PrevPW, NewPW string
 	UserID                                                                                 int
+	SweepWalletID                                                                          int // where the balances go when the account is closed
 	PollID                                                                                 string
 	Action                                                                                 UserAction
 	Term                                                                                   int32
+	// Time is stamped by the leader appending the operation, every node applies it at that time
+	Time time.Time
 }
 
 func (up UserPayload) GetRefTable() RefTable {
 	return RefUser
 }
 
+func (up UserPayload) GetPollID() string {
+	return up.PollID
+}
+
+func (up UserPayload) GetTerm() int32 {
+	return up.Term
+}
+
 type AdminPayload struct {
 	FirstName, LastName, HashedPassword, Email string
-	AdminID, UserId                            int
+	AdminID, UserId, WalletID                  int
+	FeeAction                                  WalletAction
+	FeeKind                                    FeeKind
+	FeeFlat, FeeBasisPoints                    int64
+	FeeTiers                                   []FeeTier
+	Tier                                       UserTier
+	LimitScope                                 LimitScope
+	LimitPerTransaction, LimitDaily            int64
+	LimitMonthly                               int64
+	Role                                       AdminRole
+	TargetAdminID                              int
 	PollID                                     string
 	Action                                     AdminAction
 	Term                                       int32
+	// Time is stamped by the leader appending the operation, every node applies it at that time
+	Time time.Time
+}
+
+// FeeTier is one bracket of a tiered fee schedule, it applies to amounts up to UpTo (0 means no upper bound)
+type FeeTier struct {
+	UpTo        int64 `json:"up_to"`
+	Flat        int64 `json:"flat"`
+	BasisPoints int64 `json:"basis_points"`
 }
 
 func (ap AdminPayload) GetRefTable() RefTable {
 	return RefAdmin
 }
 
+func (ap AdminPayload) GetPollID() string {
+	return ap.PollID
+}
+
+func (ap AdminPayload) GetTerm() int32 {
+	return ap.Term
+}
+
 type WalletOperationPayload struct {
 	Wallet1, Wallet2 int
 	Amount           int64
 	PollID           string
 	Action           WalletAction
 	Term             int32
+	// scheduled transfers, ScheduleID is 0 for one off operations
+	ScheduleID, Occurrence, Attempt int
+	Interval                        ScheduleInterval
+	StartAt                         time.Time
+	MaxRetries                      int
+	RetryDelaySeconds               int64
+	// Time is stamped by the leader appending the operation, every node applies it at that time
+	Time time.Time
 }
 
 func (wp WalletOperationPayload) GetRefTable() RefTable {
 	return RefWallet
 }
 
+func (wp WalletOperationPayload) GetPollID() string {
+	return wp.PollID
+}
+
+func (wp WalletOperationPayload) GetTerm() int32 {
+	return wp.Term
+}
+
+// ConfigurationPayload lists the members of the cluster, it is never proposed through the api
+type ConfigurationPayload struct {
+	Members []Member
+	PollID  string
+	Term    int32
+}
+
+// Member is a node of the cluster, known by an id that stays the same when its address changes
+type Member struct {
+	NodeID  string
+	Address string
+}
+
+func (cp ConfigurationPayload) GetRefTable() RefTable {
+	return RefConfiguration
+}
+
+func (cp ConfigurationPayload) GetPollID() string {
+	return cp.PollID
+}
+
+func (cp ConfigurationPayload) GetTerm() int32 {
+	return cp.Term
+}
+
 type PayloadWrapper struct {
 	Ref  string          `json:"type"`
 	Data json.RawMessage `json:"data"`
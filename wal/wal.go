@@ -0,0 +1,612 @@
+// Package wal is an append only log store for the raft log. Entries are written to segment files of
+// bounded size, each record framed by its length and a crc32 of its content, and the log keeps the
+// position of every entry in memory. A record torn by a crash in the middle of an append can only be
+// at the end of the last segment, it is cut off when the log is opened. Appends never rewrite a record,
+// Rewrite writes segments whole again, as the snapshot does to reseal and scrub personal data
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "raft/raft"
+	"raft/state"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	segmentSuffix = ".wal"
+	// holds the index and term of the last compacted entry
+	compactedFile = "compacted"
+	// length and crc32 of a record
+	headerSize = 8
+	// DefaultSegmentSize is the size past which a new segment is started
+	DefaultSegmentSize = 64 << 20
+)
+
+var (
+	// ErrCorrupt is returned for a record whose checksum does not match, or out of sequence
+	ErrCorrupt = errors.New("wal: corrupt record")
+	// ErrCompacted is returned for entries dropped by CompactPrefix
+	ErrCompacted = fmt.Errorf("wal: %w", state.ErrCompacted)
+	ErrClosed    = errors.New("wal: log closed")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options tunes a log, the zero value is a safe default
+type Options struct {
+	// a segment is closed once it grows past this size, DefaultSegmentSize when zero
+	SegmentSize int64
+	// NoSync skips the fsync ending each append, a crash may then lose entries already acknowledged
+	NoSync bool
+}
+
+type segment struct {
+	first int32 // index of its first entry
+	path  string
+	file  *os.File
+	size  int64
+}
+
+// position locates the record of an entry
+type position struct {
+	seg    *segment
+	offset int64
+	length int64 // of the record, header included
+	term   int32
+}
+
+// Log is a segmented write ahead log, safe for concurrent use
+type Log struct {
+	mu       sync.Mutex
+	dir      string
+	opts     Options
+	segments []*segment // by first index, the last one takes the appends
+	// last entry dropped by CompactPrefix, the log holds the entries that follow it
+	prevIndex, prevTerm int32
+	positions           []position // positions[i] is the one of entry prevIndex+1+i
+	closed              bool
+}
+
+var (
+	_ state.LogStore    = (*Log)(nil)
+	_ state.LogRewriter = (*Log)(nil)
+)
+
+// Open opens the log kept in dir, creating it if needed
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create wal %s: %w", dir, err)
+	}
+	l := &Log{dir: dir, opts: opts}
+	if err := l.readCompacted(); err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		first, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), segmentSuffix), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("wal: unexpected file %s", name)
+		}
+		l.segments = append(l.segments, &segment{first: int32(first), path: name})
+	}
+	sort.Slice(l.segments, func(a, b int) bool { return l.segments[a].first < l.segments[b].first })
+	for i, seg := range l.segments {
+		if err := l.load(seg, i == len(l.segments)-1); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// load opens a segment and indexes its records. A torn record ends the last segment, anywhere else it
+// is corruption
+func (l *Log) load(seg *segment, last bool) error {
+	file, err := os.OpenFile(seg.path, os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open segment %s: %w", seg.path, err)
+	}
+	seg.file = file
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, info.Size())
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return fmt.Errorf("failed to read segment %s: %w", seg.path, err)
+	}
+	next := seg.first
+	var offset int64
+	for offset < int64(len(data)) {
+		entry, length, err := decode(data[offset:])
+		if err == nil && int32(entry.GetIndex()) != next {
+			err = fmt.Errorf("%w: entry %d where %d was expected", ErrCorrupt, entry.GetIndex(), next)
+		}
+		if err != nil {
+			if !last || !torn(data[offset:]) {
+				return fmt.Errorf("segment %s at offset %d: %w", seg.path, offset, err)
+			}
+			// the append that wrote it never returned, nothing after it was acknowledged
+			if err := file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to cut torn record of %s: %w", seg.path, err)
+			}
+			break
+		}
+		if next > l.prevIndex {
+			if want := l.lastIndex() + 1; next != want {
+				return fmt.Errorf("%w: segment %s starts at %d where %d was expected", ErrCorrupt, seg.path, next, want)
+			}
+			l.positions = append(l.positions, position{seg: seg, offset: offset, length: length, term: entry.GetTerm()})
+		}
+		offset += length
+		next++
+	}
+	seg.size = offset
+	return nil
+}
+
+// decode reads the record at the start of data, it returns the entry and the length of the record
+func decode(data []byte) (*pb.LogEntry, int64, error) {
+	if len(data) < headerSize {
+		return nil, 0, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	size := int64(binary.LittleEndian.Uint32(data))
+	if int64(len(data)-headerSize) < size {
+		return nil, 0, fmt.Errorf("%w: truncated record", ErrCorrupt)
+	}
+	body := data[headerSize : headerSize+size]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(data[4:]) {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	var entry pb.LogEntry
+	if err := proto.Unmarshal(body, &entry); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return &entry, headerSize + size, nil
+}
+
+// torn reports whether the invalid record at the start of data is the last one, as an append cut by a
+// crash leaves it. An invalid record followed by others is corruption
+func torn(data []byte) bool {
+	if len(data) < headerSize {
+		return true
+	}
+	return int64(len(data)) <= headerSize+int64(binary.LittleEndian.Uint32(data))
+}
+
+func encode(buf []byte, entry *pb.LogEntry) ([]byte, error) {
+	body, err := proto.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(body)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(body, crcTable))
+	return append(buf, body...), nil
+}
+
+func (l *Log) lastIndex() int32 {
+	return l.prevIndex + int32(len(l.positions))
+}
+
+func (l *Log) Append(entries []*pb.LogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	last := l.lastIndex()
+	for i, entry := range entries {
+		if entry.GetIndex() != int64(last)+int64(i)+1 {
+			return fmt.Errorf("%w: entry %d after %d", state.ErrLogGap, entry.GetIndex(), int64(last)+int64(i))
+		}
+	}
+	var (
+		buf     []byte
+		seg     *segment
+		written []position
+		touched []*segment
+	)
+	// a failed append leaves the log as it was, without records it does not know of
+	segments, size := len(l.segments), int64(0)
+	if active := l.active(); active != nil {
+		size = active.size
+	}
+	rollback := func(err error) error {
+		for len(l.segments) > segments {
+			if rmErr := l.remove(l.active()); rmErr != nil {
+				return errors.Join(err, rmErr)
+			}
+		}
+		// a partial write may have gone past the size the segment had
+		if active := l.active(); active != nil {
+			active.size = size
+			if truncErr := active.file.Truncate(size); truncErr != nil {
+				return errors.Join(err, truncErr)
+			}
+		}
+		return err
+	}
+	// writes what is buffered for seg
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+			return fmt.Errorf("failed to write to %s: %w", seg.path, err)
+		}
+		seg.size += int64(len(buf))
+		touched = append(touched, seg)
+		buf = buf[:0]
+		return nil
+	}
+	for _, entry := range entries {
+		if seg == nil {
+			seg = l.active()
+		}
+		if seg == nil || seg.size+int64(len(buf)) >= l.opts.SegmentSize {
+			if err := flush(); err != nil {
+				return rollback(err)
+			}
+			next, err := l.create(int32(entry.GetIndex()))
+			if err != nil {
+				return rollback(err)
+			}
+			seg = next
+		}
+		start := len(buf)
+		var err error
+		if buf, err = encode(buf, entry); err != nil {
+			return rollback(err)
+		}
+		written = append(written, position{seg: seg, offset: seg.size + int64(start), length: int64(len(buf) - start),
+			term: entry.GetTerm()})
+	}
+	if err := flush(); err != nil {
+		return rollback(err)
+	}
+	if !l.opts.NoSync {
+		for _, seg := range touched {
+			if err := seg.file.Sync(); err != nil {
+				return rollback(fmt.Errorf("failed to sync %s: %w", seg.path, err))
+			}
+		}
+	}
+	l.positions = append(l.positions, written...)
+	return nil
+}
+
+// active returns the segment taking the appends, nil when there is none
+func (l *Log) active() *segment {
+	if len(l.segments) == 0 {
+		return nil
+	}
+	return l.segments[len(l.segments)-1]
+}
+
+// create starts a segment whose first entry is first
+func (l *Log) create(first int32) (*segment, error) {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", first, segmentSuffix))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment %s: %w", path, err)
+	}
+	if err := l.syncDir(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	seg := &segment{first: first, path: path, file: file}
+	l.segments = append(l.segments, seg)
+	return seg, nil
+}
+
+func (l *Log) TruncateSuffix(index int32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if index > l.lastIndex() {
+		return nil
+	}
+	if index <= l.prevIndex {
+		return fmt.Errorf("%w: cannot truncate from %d", ErrCompacted, index)
+	}
+	pos := l.positions[index-l.prevIndex-1]
+	for len(l.segments) > 0 {
+		seg := l.active()
+		if seg != pos.seg || pos.offset == 0 {
+			if err := l.remove(seg); err != nil {
+				return err
+			}
+			if seg == pos.seg {
+				break
+			}
+			continue
+		}
+		if err := seg.file.Truncate(pos.offset); err != nil {
+			return fmt.Errorf("failed to truncate %s: %w", seg.path, err)
+		}
+		if err := seg.file.Sync(); err != nil {
+			return err
+		}
+		seg.size = pos.offset
+		break
+	}
+	l.positions = l.positions[:index-l.prevIndex-1]
+	return nil
+}
+
+// remove deletes the last segment
+func (l *Log) remove(seg *segment) error {
+	seg.file.Close()
+	if err := os.Remove(seg.path); err != nil {
+		return fmt.Errorf("failed to remove segment %s: %w", seg.path, err)
+	}
+	l.segments = l.segments[:len(l.segments)-1]
+	return l.syncDir()
+}
+
+func (l *Log) Entries(lo, hi int32) ([]*pb.LogEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if lo <= l.prevIndex {
+		return nil, fmt.Errorf("%w: entry %d and before", ErrCompacted, l.prevIndex)
+	}
+	hi = min(hi, l.lastIndex())
+	if lo > hi {
+		return nil, nil
+	}
+	entries := make([]*pb.LogEntry, 0, hi-lo+1)
+	positions := l.positions[lo-l.prevIndex-1 : hi-l.prevIndex]
+	// one read per segment for the records it holds, they are contiguous
+	for len(positions) > 0 {
+		n := 1
+		for n < len(positions) && positions[n].seg == positions[0].seg {
+			n++
+		}
+		first, end := positions[0], positions[n-1]
+		data := make([]byte, end.offset+end.length-first.offset)
+		if _, err := first.seg.file.ReadAt(data, first.offset); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", first.seg.path, err)
+		}
+		for _, pos := range positions[:n] {
+			entry, _, err := decode(data[pos.offset-first.offset:])
+			if err != nil {
+				return nil, fmt.Errorf("segment %s at offset %d: %w", pos.seg.path, pos.offset, err)
+			}
+			entries = append(entries, entry)
+		}
+		positions = positions[n:]
+	}
+	return entries, nil
+}
+
+func (l *Log) LastIndexAndTerm() (int32, int32, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.positions) == 0 {
+		return l.prevIndex, l.prevTerm, nil
+	}
+	return l.lastIndex(), l.positions[len(l.positions)-1].term, nil
+}
+
+// CompactPrefix drops the entries up to index. The segments holding only such entries are removed,
+// the others keep them on disk until they go too
+func (l *Log) CompactPrefix(index int32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if index <= l.prevIndex {
+		return nil
+	}
+	if index > l.lastIndex() {
+		return fmt.Errorf("wal: cannot compact up to %d, the log ends at %d", index, l.lastIndex())
+	}
+	term := l.positions[index-l.prevIndex-1].term
+	// recorded first, a crash before the segments are removed leaves files the next open skips
+	if err := l.writeCompacted(index, term); err != nil {
+		return err
+	}
+	kept := l.segments[:0]
+	for i, seg := range l.segments {
+		end := l.lastIndex()
+		if i+1 < len(l.segments) {
+			end = l.segments[i+1].first - 1
+		}
+		if end > index {
+			kept = append(kept, seg)
+			continue
+		}
+		seg.file.Close()
+		if err := os.Remove(seg.path); err != nil {
+			return fmt.Errorf("failed to remove segment %s: %w", seg.path, err)
+		}
+	}
+	l.segments = kept
+	l.positions = append([]position(nil), l.positions[index-l.prevIndex:]...)
+	l.prevIndex, l.prevTerm = index, term
+	return l.syncDir()
+}
+
+// Rewrite passes every entry the segments hold to rewrite, the compacted ones still on disk included, and
+// writes back the segments of the entries it reports changed. rewrite may change the command of an entry,
+// not its index nor its term. A segment is written whole to a new file renamed over it, a crash leaves
+// either of them. It returns the number of entries changed
+func (l *Log) Rewrite(rewrite func(entry *pb.LogEntry) (bool, error)) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	changed := 0
+	for _, seg := range l.segments {
+		n, err := l.rewriteSegment(seg, rewrite)
+		if err != nil {
+			return changed, err
+		}
+		changed += n
+	}
+	return changed, nil
+}
+
+// rewriteSegment rewrites the records of seg, it is left as it was when no entry changed
+func (l *Log) rewriteSegment(seg *segment, rewrite func(entry *pb.LogEntry) (bool, error)) (int, error) {
+	data := make([]byte, seg.size)
+	if _, err := seg.file.ReadAt(data, 0); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", seg.path, err)
+	}
+	var (
+		buf     []byte
+		written []position
+	)
+	changed := 0
+	for offset := int64(0); offset < seg.size; {
+		entry, length, err := decode(data[offset:])
+		if err != nil {
+			return 0, fmt.Errorf("segment %s at offset %d: %w", seg.path, offset, err)
+		}
+		offset += length
+		index, term := entry.GetIndex(), entry.GetTerm()
+		ok, err := rewrite(entry)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			changed++
+		}
+		entry.Index, entry.Term = index, term
+		start := len(buf)
+		if buf, err = encode(buf, entry); err != nil {
+			return 0, err
+		}
+		written = append(written, position{seg: seg, offset: int64(start), length: int64(len(buf) - start), term: term})
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	tmp, err := os.CreateTemp(l.dir, filepath.Base(seg.path)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to rewrite %s: %w", seg.path, err)
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := tmp.Write(buf); err != nil {
+		return 0, fmt.Errorf("failed to rewrite %s: %w", seg.path, err)
+	}
+	if !l.opts.NoSync {
+		if err := tmp.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync %s: %w", tmp.Name(), err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), seg.path); err != nil {
+		return 0, fmt.Errorf("failed to rewrite %s: %w", seg.path, err)
+	}
+	renamed = true
+	seg.file.Close()
+	seg.file, seg.size = tmp, int64(len(buf))
+	// the positions of the entries the log still holds
+	for i, pos := range written {
+		if index := seg.first + int32(i); index > l.prevIndex {
+			l.positions[index-l.prevIndex-1] = pos
+		}
+	}
+	return changed, l.syncDir()
+}
+
+func (l *Log) readCompacted() error {
+	data, err := os.ReadFile(filepath.Join(l.dir, compactedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Sscanf(string(data), "%d %d", &l.prevIndex, &l.prevTerm); err != nil {
+		return fmt.Errorf("wal: invalid %s file: %w", compactedFile, err)
+	}
+	return nil
+}
+
+// writeCompacted replaces the compacted file at once, through a rename
+func (l *Log) writeCompacted(index, term int32) error {
+	path := filepath.Join(l.dir, compactedFile)
+	tmp, err := os.CreateTemp(l.dir, compactedFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintf(tmp, "%d %d\n", index, term); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return l.syncDir()
+}
+
+// syncDir makes the creation, removal or renaming of a file in the log durable
+func (l *Log) syncDir() error {
+	if l.opts.NoSync {
+		return nil
+	}
+	dir, err := os.Open(l.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	var errs []error
+	for _, seg := range l.segments {
+		if seg.file != nil {
+			errs = append(errs, seg.file.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
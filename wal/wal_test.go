@@ -0,0 +1,415 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"raft/pii"
+	pb "raft/raft"
+	"raft/state"
+	"raft/utils"
+
+	"gorm.io/gorm/logger"
+)
+
+func entry(t testing.TB, index int64, term int32) *pb.LogEntry {
+	t.Helper()
+	command, err := state.EncodeCommand(utils.WalletOperationPayload{
+		Wallet1: int(index%100 + 1),
+		Wallet2: int(index%97 + 1),
+		Amount:  index % 1000,
+		Action:  utils.WalletTransfer,
+		PollID:  fmt.Sprintf("poll-%d", index),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pb.LogEntry{Index: index, Term: term, Command: command}
+}
+
+// entries returns the entries lo to hi, all of term
+func entries(t testing.TB, lo, hi int64, term int32) []*pb.LogEntry {
+	t.Helper()
+	var out []*pb.LogEntry
+	for i := lo; i <= hi; i++ {
+		out = append(out, entry(t, i, term))
+	}
+	return out
+}
+
+func open(t *testing.T, dir string, opts Options) *Log {
+	t.Helper()
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func checkLast(t *testing.T, l *Log, index, term int32) {
+	t.Helper()
+	gotIndex, gotTerm, err := l.LastIndexAndTerm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotIndex != index || gotTerm != term {
+		t.Fatalf("last entry %d of term %d, want %d of term %d", gotIndex, gotTerm, index, term)
+	}
+}
+
+// checkEntries reads the entries lo to hi and checks their indexes and poll ids
+func checkEntries(t *testing.T, l *Log, lo, hi int32) {
+	t.Helper()
+	got, err := l.Entries(lo, hi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != int(hi-lo+1) {
+		t.Fatalf("read %d entries from %d to %d", len(got), lo, hi)
+	}
+	for i, e := range got {
+		want := int64(lo) + int64(i)
+		if e.GetIndex() != want || e.GetCommand().GetPollID() != fmt.Sprintf("poll-%d", want) {
+			t.Fatalf("entry %d read where %d was expected", e.GetIndex(), want)
+		}
+	}
+}
+
+// segments returns the segment files of dir in order
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestAppendAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, Options{})
+	checkLast(t, l, 0, 0)
+	if err := l.Append(entries(t, 1, 5, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(entries(t, 6, 10, 2)); err != nil {
+		t.Fatal(err)
+	}
+	checkLast(t, l, 10, 2)
+	checkEntries(t, l, 3, 8)
+	// a range past the end stops at the last entry
+	got, err := l.Entries(9, 20)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("read %d entries from 9, %v", len(got), err)
+	}
+	l.Close()
+
+	l = open(t, dir, Options{})
+	checkLast(t, l, 10, 2)
+	checkEntries(t, l, 1, 10)
+}
+
+func TestAppendRefusesGap(t *testing.T) {
+	l := open(t, t.TempDir(), Options{})
+	if err := l.Append(entries(t, 2, 3, 1)); !errors.Is(err, state.ErrLogGap) {
+		t.Fatalf("append of entry 2 to an empty log: %v, want a gap", err)
+	}
+	if err := l.Append(entries(t, 1, 3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append([]*pb.LogEntry{entry(t, 4, 1), entry(t, 6, 1)}); !errors.Is(err, state.ErrLogGap) {
+		t.Fatalf("append of entries 4 and 6: %v, want a gap", err)
+	}
+	// the refused batch left nothing behind
+	checkLast(t, l, 3, 1)
+}
+
+func TestTruncateSuffix(t *testing.T) {
+	dir := t.TempDir()
+	// a few entries per segment, so the truncation removes whole segments too
+	l := open(t, dir, Options{SegmentSize: 256})
+	if err := l.Append(entries(t, 1, 20, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.TruncateSuffix(6); err != nil {
+		t.Fatal(err)
+	}
+	checkLast(t, l, 5, 1)
+	if err := l.Append(entries(t, 6, 8, 2)); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l = open(t, dir, Options{SegmentSize: 256})
+	checkLast(t, l, 8, 2)
+	checkEntries(t, l, 1, 8)
+}
+
+func TestCompactPrefix(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, Options{SegmentSize: 256})
+	if err := l.Append(entries(t, 1, 20, 3)); err != nil {
+		t.Fatal(err)
+	}
+	before := len(segments(t, dir))
+	if err := l.CompactPrefix(12); err != nil {
+		t.Fatal(err)
+	}
+	if after := len(segments(t, dir)); after >= before {
+		t.Fatalf("%d segments after compaction, %d before", after, before)
+	}
+	if _, err := l.Entries(12, 20); !errors.Is(err, state.ErrCompacted) {
+		t.Fatalf("read of a compacted entry: %v", err)
+	}
+	checkEntries(t, l, 13, 20)
+	if err := l.TruncateSuffix(10); !errors.Is(err, state.ErrCompacted) {
+		t.Fatalf("truncation of compacted entries: %v", err)
+	}
+	l.Close()
+
+	// the base survives a restart, and the whole log may go
+	l = open(t, dir, Options{SegmentSize: 256})
+	checkLast(t, l, 20, 3)
+	checkEntries(t, l, 13, 20)
+	if err := l.CompactPrefix(20); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l = open(t, dir, Options{SegmentSize: 256})
+	checkLast(t, l, 20, 3)
+	if err := l.Append(entries(t, 1, 1, 4)); !errors.Is(err, state.ErrLogGap) {
+		t.Fatalf("append of entry 1 after the base: %v, want a gap", err)
+	}
+	if err := l.Append(entries(t, 21, 22, 4)); err != nil {
+		t.Fatal(err)
+	}
+	checkLast(t, l, 22, 4)
+	checkEntries(t, l, 21, 22)
+}
+
+func TestSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, Options{SegmentSize: 128})
+	// one batch spanning segments, then single appends
+	if err := l.Append(entries(t, 1, 10, 1)); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(11); i <= 20; i++ {
+		if err := l.Append(entries(t, i, i, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(segments(t, dir)); n < 2 {
+		t.Fatalf("%d segment for 20 entries of segments of 128 bytes", n)
+	}
+	checkEntries(t, l, 1, 20)
+	l.Close()
+
+	l = open(t, dir, Options{SegmentSize: 128})
+	checkLast(t, l, 20, 1)
+	checkEntries(t, l, 1, 20)
+}
+
+func TestTornRecordIsCut(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, Options{})
+	if err := l.Append(entries(t, 1, 3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	// a crash in the middle of the append of entry 3
+	names := segments(t, dir)
+	path := names[len(names)-1]
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir, Options{})
+	checkLast(t, l, 2, 1)
+	if err := l.Append(entries(t, 3, 4, 2)); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l = open(t, dir, Options{})
+	checkLast(t, l, 4, 2)
+	checkEntries(t, l, 1, 4)
+}
+
+func TestCorruptRecordIsRefused(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, Options{})
+	if err := l.Append(entries(t, 1, 3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	// flip a byte of the body of entry 2, the record of entry 3 follows it
+	path := segments(t, dir)[0]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := headerSize + int(binary.LittleEndian.Uint32(data))
+	data[first+headerSize+1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, Options{}); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("open of a log with a corrupt record: %v", err)
+	}
+}
+
+func TestClosedLog(t *testing.T) {
+	l := open(t, t.TempDir(), Options{})
+	l.Close()
+	if err := l.Append(entries(t, 1, 1, 1)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("append to a closed log: %v", err)
+	}
+	if _, err := l.Entries(1, 1); !errors.Is(err, ErrClosed) {
+		t.Fatalf("read of a closed log: %v", err)
+	}
+}
+
+func TestRewriteScrubsErasedUser(t *testing.T) {
+	ps, err := state.InitPersistentState(filepath.Join(t.TempDir(), "log.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	dir := t.TempDir()
+	l := open(t, dir, Options{SegmentSize: 256})
+	user := func(index int64, p utils.UserPayload) *pb.LogEntry {
+		command, err := state.EncodeCommand(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pb.LogEntry{Index: index, Term: 1, Command: command}
+	}
+	log := []*pb.LogEntry{
+		user(1, utils.UserPayload{FirstName: "Alice", LastName: "Doe", Email: "alice@test.invalid",
+			IdentificationNumber: "alice-id-1", Action: utils.UserCreateAccount, PollID: "signup-alice"}),
+		user(2, utils.UserPayload{FirstName: "Bob", LastName: "Roe", Email: "bob@test.invalid",
+			IdentificationNumber: "bob-id-1", Action: utils.UserCreateAccount, PollID: "signup-bob"}),
+		user(3, utils.UserPayload{UserID: 1, PrevPW: "alice-old-pw", NewPW: "alice-new-pw",
+			Action: utils.UserUpdatePassword, PollID: "password-alice"}),
+	}
+	log = append(log, entries(t, 4, 10, 1)...)
+	log = append(log, user(11, utils.UserPayload{UserID: 1, Action: utils.UserEraseAccount, PollID: "erase-alice"}))
+	if err := l.Append(log); err != nil {
+		t.Fatal(err)
+	}
+	// the signup is compacted but its segment stays on disk
+	if err := l.CompactPrefix(1); err != nil {
+		t.Fatal(err)
+	}
+	// an erasure whose email digest is the blind index, the salted one is only written by the node
+	erasure := state.Erasure{UserID: 1, EmailHash: pii.BlindIndex("alice@test.invalid"), LogIndex: 11}
+	if err := ps.DB.Create(&erasure).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, scrubbed, err := ps.RewritePII(l, 10); err != nil || scrubbed != 0 {
+		t.Fatalf("scrubbed %d commands before the erase entry was snapshotted, %v", scrubbed, err)
+	}
+	_, scrubbed, err := ps.RewritePII(l, 11)
+	if err != nil || scrubbed != 3 {
+		t.Fatalf("scrubbed %d commands, %v, want the signup, the password change and the erasure", scrubbed, err)
+	}
+	var disk []byte
+	for _, name := range segments(t, dir) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		disk = append(disk, data...)
+	}
+	for _, personal := range []string{"Alice", "alice@test.invalid", "alice-id-1", "alice-old-pw", "alice-new-pw"} {
+		if bytes.Contains(disk, []byte(personal)) {
+			t.Fatalf("the segments still hold %q", personal)
+		}
+	}
+	if !bytes.Contains(disk, []byte("bob@test.invalid")) {
+		t.Fatal("the signup of another user was scrubbed")
+	}
+	if _, scrubbed, err := ps.RewritePII(l, 20); err != nil || scrubbed != 0 {
+		t.Fatalf("scrubbed %d commands again, %v", scrubbed, err)
+	}
+
+	// the rewritten segments are read back, and appended to, after a restart
+	checkEntries(t, l, 4, 10)
+	l.Close()
+	l = open(t, dir, Options{SegmentSize: 256})
+	checkLast(t, l, 11, 1)
+	checkEntries(t, l, 4, 10)
+	if err := l.Append(entries(t, 12, 13, 2)); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, l, 12, 13)
+}
+
+// BenchmarkLogStore runs the appends of a leader, in batches as at every heartbeat, and the reads of
+// its replication against each store the nodes can run on
+func BenchmarkLogStore(b *testing.B) {
+	logger.Default = logger.Discard
+	const batch, readRange = 16, 64
+	stores := []struct {
+		name string
+		open func(dir string) (state.LogStore, error)
+	}{
+		{"sqlite", func(dir string) (state.LogStore, error) {
+			return state.InitPersistentState(filepath.Join(dir, "log.db"))
+		}},
+		{"wal", func(dir string) (state.LogStore, error) {
+			return Open(filepath.Join(dir, "wal"), Options{})
+		}},
+		{"wal-nosync", func(dir string) (state.LogStore, error) {
+			return Open(filepath.Join(dir, "wal"), Options{NoSync: true})
+		}},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			store, err := s.open(b.TempDir())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+			var last int64
+			appendBatch := func(b *testing.B) {
+				if err := store.Append(entries(b, last+1, last+batch, 1)); err != nil {
+					b.Fatal(err)
+				}
+				last += batch
+			}
+			b.Run("append", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					appendBatch(b)
+				}
+			})
+			for last < readRange {
+				appendBatch(b)
+			}
+			b.Run("read", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					lo := 1 + (int64(i)*readRange)%(last-readRange+1)
+					got, err := store.Entries(int32(lo), int32(lo+readRange-1))
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(got) != readRange {
+						b.Fatalf("read %d entries from %d", len(got), lo)
+					}
+				}
+			})
+		})
+	}
+}